@@ -0,0 +1,129 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/olawolu/zk-pass/zk"
+)
+
+const usage = `usage: ceremony <command> [flags]
+
+commands:
+  init        start the ceremony of a circuit, or its phase 2 once phase 1 has contributions
+  contribute  add a contribution to the current phase
+  verify      verify the contribution chain
+  finalize    extract the keys into the server's key directory`
+
+func main() {
+	if err := run(os.Args[1:], os.Getenv); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(
+	args []string,
+	getenv func(string) string,
+) error {
+	if len(args) == 0 {
+		return errors.New(usage)
+	}
+	flags := flag.NewFlagSet(args[0], flag.ContinueOnError)
+	dir := flags.String("dir", "ceremony", "directory holding the ceremony transcript and states")
+
+	switch args[0] {
+	case "init":
+		circuitID := flags.String("circuit", "", "ID of the circuit to set up")
+		if err := flags.Parse(args[1:]); err != nil {
+			return err
+		}
+		def, err := circuit(*circuitID)
+		if err != nil {
+			return err
+		}
+		c, err := zk.InitCeremony(*dir, def)
+		if err != nil {
+			return err
+		}
+		transcript := c.Transcript()
+		fmt.Printf("initialized phase %d of the ceremony of %s (2^%d constraints)\n", c.Phase(), transcript.CircuitID, transcript.Power)
+		return nil
+
+	case "contribute":
+		name := flags.String("name", "", "name of the participant, recorded in the transcript")
+		if err := flags.Parse(args[1:]); err != nil {
+			return err
+		}
+		c, err := zk.OpenCeremony(*dir)
+		if err != nil {
+			return err
+		}
+		contribution, err := c.Contribute(*name)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("contribution %d to phase %d\ntranscript hash: %s\n", contribution.Index, contribution.Phase, contribution.Hash)
+		return nil
+
+	case "verify":
+		if err := flags.Parse(args[1:]); err != nil {
+			return err
+		}
+		c, def, err := open(*dir)
+		if err != nil {
+			return err
+		}
+		if err := c.Verify(def); err != nil {
+			return err
+		}
+		transcript := c.Transcript()
+		for _, contributions := range [][]zk.Contribution{transcript.Phase1, transcript.Phase2} {
+			for _, contribution := range contributions {
+				fmt.Printf("phase %d #%d %s %s\n", contribution.Phase, contribution.Index, contribution.Hash, contribution.Participant)
+			}
+		}
+		fmt.Println("ceremony verified")
+		return nil
+
+	case "finalize":
+		keysDir := flags.String("keys", getenv("ZK_KEYS_DIR"), "directory holding the circuit keys")
+		if err := flags.Parse(args[1:]); err != nil {
+			return err
+		}
+		if *keysDir == "" {
+			*keysDir = "keys"
+		}
+		c, def, err := open(*dir)
+		if err != nil {
+			return err
+		}
+		keys, err := c.Finalize(def, zk.NewKeyStore(*keysDir))
+		if err != nil {
+			return err
+		}
+		fmt.Printf("wrote keys of %s from contribution %s\n", def.ID, keys.Manifest.Ceremony)
+		return nil
+	}
+	return errors.New(usage)
+}
+
+func open(dir string) (*zk.Ceremony, zk.CircuitDefinition, error) {
+	c, err := zk.OpenCeremony(dir)
+	if err != nil {
+		return nil, zk.CircuitDefinition{}, err
+	}
+	def, err := circuit(c.Transcript().CircuitID)
+	return c, def, err
+}
+
+func circuit(id string) (zk.CircuitDefinition, error) {
+	for _, def := range zk.Circuits() {
+		if def.ID == id {
+			return def, nil
+		}
+	}
+	return zk.CircuitDefinition{}, fmt.Errorf("unknown circuit %q", id)
+}
@@ -5,10 +5,13 @@ import (
 	"fmt"
 	"log"
 	"log/slog"
+	"maps"
 	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -18,6 +21,7 @@ import (
 	data "github.com/olawolu/zk-pass/database"
 	"github.com/olawolu/zk-pass/logger"
 	"github.com/olawolu/zk-pass/server"
+	"github.com/olawolu/zk-pass/zk"
 )
 
 func main() {
@@ -43,9 +47,118 @@ func run(
 	rpName := getenv("RP_DISPLAY_NAME")
 	rpId := getenv("RP_ID")
 	rpOrigins := strings.Split(getenv("RP_ORIGINS"), ",")
+	keysDir := getenv("ZK_KEYS_DIR")
+	if keysDir == "" {
+		keysDir = "keys"
+	}
+	backend, err := zk.ParseBackend(getenv("ZK_BACKEND"))
+	if err != nil {
+		return err
+	}
+	srsFile := getenv("ZK_SRS_FILE")
+	// proofs are made for gnark's verifier unless another verifier is selected
+	target, err := zk.ParseTarget(getenv("ZK_PROOF_TARGET"))
+	if err != nil {
+		return fmt.Errorf("invalid ZK_PROOF_TARGET: %w", err)
+	}
+	// challenges are derived with MiMC unless another hash is selected
+	challengeHash := zk.HashMiMC
+	if v := getenv("ZK_HASH"); v != "" {
+		if challengeHash, err = zk.ParseHash(v); err != nil {
+			return fmt.Errorf("invalid ZK_HASH: %w", err)
+		}
+	}
+	// suspected clones are flagged unless another policy is selected
+	clonePolicy, err := data.ParseClonePolicy(getenv("WEBAUTHN_CLONE_POLICY"))
+	if err != nil {
+		return fmt.Errorf("invalid WEBAUTHN_CLONE_POLICY: %w", err)
+	}
+	// circuits are active unless listed, e.g. during a migration
+	statuses, err := zk.ParseCircuitStatuses(getenv("ZK_CIRCUIT_STATUS"))
+	if err != nil {
+		return fmt.Errorf("invalid ZK_CIRCUIT_STATUS: %w", err)
+	}
+	// batching is disabled unless a batch size is set
+	var batchSize int
+	if v := getenv("ZK_BATCH_SIZE"); v != "" {
+		if batchSize, err = strconv.Atoi(v); err != nil || batchSize < 1 {
+			return fmt.Errorf("invalid ZK_BATCH_SIZE %q", v)
+		}
+	}
+	// proofs run on a few workers, each using every core
+	proverWorkers := 2
+	if v := getenv("ZK_PROVER_WORKERS"); v != "" {
+		if proverWorkers, err = strconv.Atoi(v); err != nil || proverWorkers < 1 {
+			return fmt.Errorf("invalid ZK_PROVER_WORKERS %q", v)
+		}
+	}
+	proverQueueSize := 16
+	if v := getenv("ZK_PROVER_QUEUE_SIZE"); v != "" {
+		if proverQueueSize, err = strconv.Atoi(v); err != nil || proverQueueSize < 0 {
+			return fmt.Errorf("invalid ZK_PROVER_QUEUE_SIZE %q", v)
+		}
+	}
+	proverTimeout := 2 * time.Minute
+	if v := getenv("ZK_PROVER_TIMEOUT"); v != "" {
+		if proverTimeout, err = time.ParseDuration(v); err != nil {
+			return fmt.Errorf("invalid ZK_PROVER_TIMEOUT: %w", err)
+		}
+	}
+	batchMaxDelay := time.Minute
+	if v := getenv("ZK_BATCH_MAX_DELAY"); v != "" {
+		if batchMaxDelay, err = time.ParseDuration(v); err != nil {
+			return fmt.Errorf("invalid ZK_BATCH_MAX_DELAY: %w", err)
+		}
+	}
+	var conditionalLoginTimeout time.Duration
+	if v := getenv("WEBAUTHN_CONDITIONAL_TIMEOUT"); v != "" {
+		if conditionalLoginTimeout, err = time.ParseDuration(v); err != nil {
+			return fmt.Errorf("invalid WEBAUTHN_CONDITIONAL_TIMEOUT: %w", err)
+		}
+	}
 
 	database := data.NewDB(dbUrl)
 	logger := logger.NewLogger()
+
+	// credentials registered before the trees of credentials, or before their
+	// leaves committed to a secret, join them
+	if n, err := database.AddMissingLeaves(); err != nil {
+		return err
+	} else if n > 0 {
+		slog.Info(fmt.Sprintf("added %d credentials to the merkle tree", n))
+	}
+
+	keyStore := zk.NewKeyStore(keysDir)
+	if backend == zk.BackendPlonk {
+		keyStore = zk.NewPlonkKeyStore(keysDir, srsFile)
+	}
+	keyStore.SetTarget(target)
+	circuits := zk.Circuits()
+	if batchSize > 0 {
+		circuits = append(circuits, zk.BatchCircuitDefinition(batchSize))
+	}
+	registry := zk.NewRegistry(keyStore)
+	for _, def := range circuits {
+		status, ok := statuses[def.ID]
+		if !ok {
+			status = zk.StatusActive
+		}
+		delete(statuses, def.ID)
+		start := time.Now()
+		keys, err := registry.Register(def, status)
+		if err != nil {
+			return fmt.Errorf("error loading keys for circuit %s: %w", def.ID, err)
+		}
+		slog.Info(fmt.Sprintf("loaded %s keys for %s circuit %s (%d constraints) in %s", backend, status, def.ID, keys.Manifest.NbConstraints, time.Since(start)))
+	}
+	// the proofs of circuit versions this build no longer compiles still verify
+	for _, id := range slices.Sorted(maps.Keys(statuses)) {
+		if _, err := registry.RegisterVerifyingKey(id, statuses[id]); err != nil {
+			return fmt.Errorf("error loading verifying key for circuit %s: %w", id, err)
+		}
+		slog.Info(fmt.Sprintf("loaded %s verifying key for %s circuit %s", backend, statuses[id], id))
+	}
+
 	config := server.ServerConfig(
 		host,
 		port,
@@ -53,12 +166,31 @@ func run(
 		rpId,
 		rpOrigins,
 	)
+	config.ChallengeHash = challengeHash
+	config.ClonePolicy = clonePolicy
+	// the admin routes are disabled unless a token is set
+	config.AdminToken = getenv("ADMIN_TOKEN")
+	if conditionalLoginTimeout > 0 {
+		config.ConditionalLoginTimeout = conditionalLoginTimeout
+	}
 	store, err := pgstore.NewPGStore(dbUrl)
 	sessionStore := server.NewSessionManager(store)
 	if err != nil {
 		log.Fatalf(err.Error())
 	}
-	serverInstance := server.NewServer(config, logger, database, sessionStore)
+	// login requests and background jobs share the prover's workers
+	prover := zk.NewProver(proverWorkers, proverQueueSize, proverTimeout)
+	defer prover.Close()
+	// proofs are generated in the background, outside of the login requests
+	proofQueue := server.NewProofQueue(database, registry, prover, logger)
+	if batchSize > 0 {
+		if err := proofQueue.EnableBatching(batchSize, batchMaxDelay); err != nil {
+			return err
+		}
+	}
+	go proofQueue.Run(ctx)
+
+	serverInstance := server.NewServer(config, logger, database, sessionStore, registry, prover, proofQueue)
 	httpServer := &http.Server{
 		Addr:    net.JoinHostPort(config.Host, config.Port),
 		Handler: serverInstance,
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"time"
+
+	kzgbn254 "github.com/consensys/gnark-crypto/ecc/bn254/kzg"
+	"github.com/consensys/gnark/logger"
+	"github.com/olawolu/zk-pass/zk"
+)
+
+func main() {
+	if err := run(os.Args[1:], os.Getenv); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+}
+
+// report is the JSON written by zkbench, so that runs can be compared across
+// commits and machines.
+type report struct {
+	Revision  string                `json:"revision,omitempty"`
+	GoVersion string                `json:"goVersion"`
+	OS        string                `json:"os"`
+	Arch      string                `json:"arch"`
+	NumCPU    int                   `json:"numCpu"`
+	CreatedAt time.Time             `json:"createdAt"`
+	Results   []*zk.BenchmarkResult `json:"results"`
+}
+
+// run benchmarks the setup, proving and verification of each circuit and
+// writes the report as JSON.
+func run(
+	args []string,
+	getenv func(string) string,
+) error {
+	flags := flag.NewFlagSet("zkbench", flag.ContinueOnError)
+	backendName := flags.String("backend", getenv("ZK_BACKEND"), "proving backend: groth16 or plonk")
+	srsFile := flags.String("srs", getenv("ZK_SRS_FILE"), "KZG SRS file, for plonk")
+	circuitID := flags.String("circuit", "", "only benchmark the circuit with this ID")
+	batchSize := flags.Int("batch-size", 0, "also benchmark the batch circuit of this size")
+	out := flags.String("out", "", "file the report is written to, instead of stdout")
+	revision := flags.String("revision", vcsRevision(), "revision recorded in the report")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	backend, err := zk.ParseBackend(*backendName)
+	if err != nil {
+		return err
+	}
+	var srs *kzgbn254.SRS
+	if backend == zk.BackendPlonk {
+		if srs, err = zk.ReadSRS(*srsFile); err != nil {
+			return err
+		}
+	}
+
+	// gnark logs to stdout, where the report goes
+	logger.Disable()
+
+	circuits := zk.Circuits()
+	if *batchSize > 0 {
+		circuits = append(circuits, zk.BatchCircuitDefinition(*batchSize))
+	}
+	r := report{
+		Revision:  *revision,
+		GoVersion: runtime.Version(),
+		OS:        runtime.GOOS,
+		Arch:      runtime.GOARCH,
+		NumCPU:    runtime.NumCPU(),
+		CreatedAt: time.Now().UTC(),
+	}
+	for _, def := range circuits {
+		if *circuitID != "" && def.ID != *circuitID {
+			continue
+		}
+		fmt.Fprintf(os.Stderr, "benchmarking %s with %s\n", def.ID, backend)
+		result, err := zk.RunBenchmark(backend, srs, def)
+		if err != nil {
+			return err
+		}
+		r.Results = append(r.Results, result)
+	}
+	if len(r.Results) == 0 {
+		return fmt.Errorf("no circuit with ID %q", *circuitID)
+	}
+
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			return fmt.Errorf("error creating %s: %w", *out, err)
+		}
+		defer f.Close()
+		w = f
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// vcsRevision returns the commit the binary was built from, if recorded.
+func vcsRevision() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ""
+	}
+	for _, setting := range info.Settings {
+		if setting.Key == "vcs.revision" {
+			return setting.Value
+		}
+	}
+	return ""
+}
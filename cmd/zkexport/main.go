@@ -0,0 +1,120 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/olawolu/zk-pass/zk"
+)
+
+func main() {
+	if err := run(os.Args[1:], os.Getenv); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+}
+
+// run exports the verifying key of each active circuit, as a Rust constants
+// file for groth16-solana verifiers or as a Solidity verifier contract.
+func run(
+	args []string,
+	getenv func(string) string,
+) error {
+	flags := flag.NewFlagSet("zkexport", flag.ContinueOnError)
+	keysDir := flags.String("keys", getenv("ZK_KEYS_DIR"), "directory holding the circuit keys")
+	outDir := flags.String("out", ".", "directory the exported files are written to")
+	format := flags.String("format", "solana", "export format: solana or solidity")
+	backendName := flags.String("backend", getenv("ZK_BACKEND"), "proving backend of the keys: groth16 or plonk")
+	statusList := flags.String("status", getenv("ZK_CIRCUIT_STATUS"), "statuses of the circuits, as in ZK_CIRCUIT_STATUS; only active circuits are exported")
+	circuitID := flags.String("circuit", "", "only export the circuit with this ID")
+	batchSize := flags.Int("batch-size", 0, "also export the batch circuit of this size")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *keysDir == "" {
+		*keysDir = "keys"
+	}
+	backend, err := zk.ParseBackend(*backendName)
+	if err != nil {
+		return err
+	}
+	statuses, err := zk.ParseCircuitStatuses(*statusList)
+	if err != nil {
+		return fmt.Errorf("invalid circuit statuses: %w", err)
+	}
+	var export func(outDir, circuitID string, keys *zk.Keys) error
+	switch *format {
+	case "solana":
+		export = exportSolana
+	case "solidity":
+		export = exportSolidity
+	default:
+		return fmt.Errorf("unknown export format %q", *format)
+	}
+
+	// keys are only read, so the PLONK key store needs no SRS
+	keyStore := zk.NewKeyStore(*keysDir)
+	if backend == zk.BackendPlonk {
+		keyStore = zk.NewPlonkKeyStore(*keysDir, "")
+	}
+	circuits := zk.Circuits()
+	if *batchSize > 0 {
+		circuits = append(circuits, zk.BatchCircuitDefinition(*batchSize))
+	}
+	for _, def := range circuits {
+		if *circuitID != "" && def.ID != *circuitID {
+			continue
+		}
+		if status, ok := statuses[def.ID]; ok && status != zk.StatusActive {
+			fmt.Printf("skipped %s circuit %s\n", status, def.ID)
+			continue
+		}
+		keys, err := keyStore.Load(def)
+		if err != nil {
+			return fmt.Errorf("error loading keys for circuit %s: %w", def.ID, err)
+		}
+		if err := export(*outDir, def.ID, keys); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func exportSolana(outDir, circuitID string, keys *zk.Keys) error {
+	vk, err := zk.NewSolanaVerifyingKey(keys.VerifyingKey)
+	if err != nil {
+		return fmt.Errorf("error exporting verifying key of %s: %w", circuitID, err)
+	}
+
+	name := strings.ReplaceAll(circuitID, "-", "_")
+	path := filepath.Join(outDir, name+".rs")
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("error creating %s: %w", path, err)
+	}
+	defer f.Close()
+
+	if err := vk.WriteRust(f, circuitID, "VERIFYINGKEY"); err != nil {
+		return fmt.Errorf("error writing %s: %w", path, err)
+	}
+	fmt.Printf("wrote %s\n", path)
+	return nil
+}
+
+func exportSolidity(outDir, circuitID string, keys *zk.Keys) error {
+	path := filepath.Join(outDir, circuitID+".sol")
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("error creating %s: %w", path, err)
+	}
+	defer f.Close()
+
+	if err := zk.ExportSolidity(f, keys.VerifyingKey); err != nil {
+		return fmt.Errorf("error exporting verifier of %s: %w", circuitID, err)
+	}
+	fmt.Printf("wrote %s\n", path)
+	return nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/olawolu/zk-pass/zk"
+)
+
+func main() {
+	if err := run(os.Args[1:], os.Getenv, os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+}
+
+// run writes a corpus of fixtures derived from a seed as JSON, optionally
+// with proofs made with the circuits' keys.
+func run(
+	args []string,
+	getenv func(string) string,
+	stdout io.Writer,
+) error {
+	flags := flag.NewFlagSet("zkfixtures", flag.ContinueOnError)
+	seed := flags.String("seed", "zk-pass fixtures", "seed the fixtures are derived from")
+	count := flags.Int("count", 4, "number of fixtures")
+	hashName := flags.String("hash", getenv("ZK_HASH"), "hash of the challenge derivation: mimc or poseidon2")
+	outPath := flags.String("out", "", "file the fixtures are written to, instead of stdout")
+	prove := flags.Bool("prove", false, "also prove the fixtures' witnesses with the keys")
+	keysDir := flags.String("keys", getenv("ZK_KEYS_DIR"), "directory holding the circuit keys")
+	backendName := flags.String("backend", getenv("ZK_BACKEND"), "proving backend of the keys: groth16 or plonk")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *count < 1 {
+		return fmt.Errorf("invalid fixture count %d", *count)
+	}
+	h := zk.HashMiMC
+	if *hashName != "" {
+		var err error
+		if h, err = zk.ParseHash(*hashName); err != nil {
+			return err
+		}
+	}
+
+	corpus, err := zk.NewFixtureCorpus([]byte(*seed), h, *count)
+	if err != nil {
+		return fmt.Errorf("error generating fixtures: %w", err)
+	}
+	if *prove {
+		if err := proveFixtures(corpus, h, *keysDir, *backendName); err != nil {
+			return err
+		}
+	}
+
+	w := stdout
+	if *outPath != "" {
+		f, err := os.Create(*outPath)
+		if err != nil {
+			return fmt.Errorf("error creating %s: %w", *outPath, err)
+		}
+		defer f.Close()
+		w = f
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(corpus); err != nil {
+		return fmt.Errorf("error writing fixtures: %w", err)
+	}
+	return nil
+}
+
+// proveFixtures proves the witnesses of each fixture with the keys in
+// keysDir, which must have been set up already: proofs only help another
+// implementation if they verify against the published verifying keys.
+func proveFixtures(corpus *zk.FixtureCorpus, h zk.Hash, keysDir, backendName string) error {
+	if keysDir == "" {
+		keysDir = "keys"
+	}
+	backend, err := zk.ParseBackend(backendName)
+	if err != nil {
+		return err
+	}
+	keyStore := zk.NewKeyStore(keysDir)
+	if backend == zk.BackendPlonk {
+		keyStore = zk.NewPlonkKeyStore(keysDir, "")
+	}
+	challengeDef, err := zk.ChallengeCircuitDefinitionFor(h)
+	if err != nil {
+		return err
+	}
+	assertionDef, err := zk.AssertionCircuitDefinitionFor(h)
+	if err != nil {
+		return err
+	}
+	var keys []*zk.Keys
+	for _, def := range []zk.CircuitDefinition{challengeDef, assertionDef} {
+		k, err := keyStore.Load(def)
+		if err != nil {
+			return fmt.Errorf("error loading keys for circuit %s: %w", def.ID, err)
+		}
+		keys = append(keys, k)
+	}
+	for i := range corpus.Fixtures {
+		if err := corpus.Fixtures[i].Prove(keys...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
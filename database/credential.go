@@ -0,0 +1,116 @@
+package database
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/olawolu/zk-pass/database/models"
+	"gorm.io/gorm"
+)
+
+// ClonePolicy is what a login does when the authenticator's signature counter
+// did not increase, which suggests that the credential was cloned.
+type ClonePolicy string
+
+const (
+	// ClonePolicyAllow accepts the login and leaves the credential as is.
+	ClonePolicyAllow ClonePolicy = "allow"
+
+	// ClonePolicyFlag accepts the login and records the clone warning on the
+	// credential, for review.
+	ClonePolicyFlag ClonePolicy = "flag"
+
+	// ClonePolicyLock rejects the login and locks the credential, so that it
+	// can't log in again.
+	ClonePolicyLock ClonePolicy = "lock"
+)
+
+// ErrInvalidClonePolicy is returned for unknown clone policies.
+var ErrInvalidClonePolicy = errors.New("invalid clone policy")
+
+// ParseClonePolicy returns the clone policy with the given name. The empty
+// name is ClonePolicyFlag.
+func ParseClonePolicy(name string) (ClonePolicy, error) {
+	switch policy := ClonePolicy(strings.ToLower(strings.TrimSpace(name))); policy {
+	case "":
+		return ClonePolicyFlag, nil
+	case ClonePolicyAllow, ClonePolicyFlag, ClonePolicyLock:
+		return policy, nil
+	}
+	return "", fmt.Errorf("%w: %q", ErrInvalidClonePolicy, name)
+}
+
+// UpdateCredential records a login with the credential returned by
+// webauthn.ValidateLogin: its sign count, flags and time of use, or its clone
+// warning as the policy says. It returns models.ErrCredentialLocked if the
+// credential is locked, including when the policy just locked it, in which
+// case its leaf is cleared from the trees of credentials too.
+func (db *DB) UpdateCredential(credential *webauthn.Credential, policy ClonePolicy) error {
+	var locked bool
+	err := db.Transaction(func(tx *gorm.DB) error {
+		record, err := models.FetchCredentialForUpdate(tx, base64.RawURLEncoding.EncodeToString(credential.ID))
+		if err != nil {
+			return err
+		}
+		if record.LockedAt != nil {
+			locked = true
+			return nil
+		}
+		useCredential(record, credential, policy, time.Now())
+		if err := models.SaveCredentialUse(tx, record); err != nil {
+			return err
+		}
+		locked = record.LockedAt != nil
+		if !locked {
+			return nil
+		}
+		// a locked credential can't prove membership of the trees either
+		trees, err := lockMerkleTrees(tx)
+		if err != nil {
+			return err
+		}
+		return clearLeaf(tx, trees, record)
+	})
+	if err != nil {
+		return fmt.Errorf("error updating credential: %w", err)
+	}
+	if locked {
+		return models.ErrCredentialLocked
+	}
+	return nil
+}
+
+// useCredential updates the record of a credential for a login at now. The
+// sign count is compared with the record's again, rather than relying on the
+// credential's clone warning: a concurrent login may have raised the count
+// since the credential was validated, and the warning of a flagged credential
+// is set for every later login. ValidateLogin leaves the count as it was when
+// it warns, so such logins fail the comparison as well.
+func useCredential(record *models.PublicKeyCredential, credential *webauthn.Credential, policy ClonePolicy, now time.Time) {
+	signCount := credential.Authenticator.SignCount
+	cloneWarning := signCount <= record.Authenticator.SignCount && (signCount != 0 || record.Authenticator.SignCount != 0)
+
+	record.CredentialFlags.UserPresent = credential.Flags.UserPresent
+	record.CredentialFlags.UserVerified = credential.Flags.UserVerified
+	record.CredentialFlags.BackupEligible = credential.Flags.BackupEligible
+	record.CredentialFlags.BackupState = credential.Flags.BackupState
+	if !cloneWarning {
+		record.Authenticator.SignCount = signCount
+		record.LastUsedAt = &now
+		return
+	}
+	switch policy {
+	case ClonePolicyAllow:
+		record.LastUsedAt = &now
+	case ClonePolicyLock:
+		record.Authenticator.CloneWarning = true
+		record.LockedAt = &now
+	default:
+		record.Authenticator.CloneWarning = true
+		record.LastUsedAt = &now
+	}
+}
@@ -0,0 +1,98 @@
+package database
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/olawolu/zk-pass/database/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseClonePolicy(t *testing.T) {
+	policy, err := ParseClonePolicy("")
+	require.NoError(t, err)
+	assert.Equal(t, ClonePolicyFlag, policy)
+	policy, err = ParseClonePolicy(" Lock ")
+	require.NoError(t, err)
+	assert.Equal(t, ClonePolicyLock, policy)
+	_, err = ParseClonePolicy("ignore")
+	assert.ErrorIs(t, err, ErrInvalidClonePolicy)
+}
+
+func TestUseCredential(t *testing.T) {
+	now := time.Now()
+	record := func(signCount uint32) *models.PublicKeyCredential {
+		return &models.PublicKeyCredential{
+			Authenticator: models.Authenticator{SignCount: signCount},
+		}
+	}
+	login := func(signCount uint32, cloneWarning bool) *webauthn.Credential {
+		return &webauthn.Credential{
+			Flags: webauthn.CredentialFlags{UserPresent: true, UserVerified: true, BackupState: true},
+			Authenticator: webauthn.Authenticator{
+				SignCount:    signCount,
+				CloneWarning: cloneWarning,
+			},
+		}
+	}
+
+	t.Run("counter increased", func(t *testing.T) {
+		r := record(4)
+		useCredential(r, login(5, false), ClonePolicyLock, now)
+		assert.EqualValues(t, 5, r.Authenticator.SignCount)
+		assert.False(t, r.Authenticator.CloneWarning)
+		assert.True(t, r.CredentialFlags.UserVerified)
+		assert.True(t, r.CredentialFlags.BackupState)
+		assert.Equal(t, &now, r.LastUsedAt)
+		assert.Nil(t, r.LockedAt)
+	})
+
+	t.Run("no counter", func(t *testing.T) {
+		r := record(0)
+		useCredential(r, login(0, false), ClonePolicyLock, now)
+		assert.False(t, r.Authenticator.CloneWarning)
+		assert.Nil(t, r.LockedAt)
+	})
+
+	t.Run("flagged credential", func(t *testing.T) {
+		// the warning stays set on the credential once flagged
+		r := record(4)
+		r.Authenticator.CloneWarning = true
+		useCredential(r, login(5, true), ClonePolicyFlag, now)
+		assert.EqualValues(t, 5, r.Authenticator.SignCount)
+		assert.True(t, r.Authenticator.CloneWarning)
+	})
+
+	for _, tc := range []struct {
+		name        string
+		credential  *webauthn.Credential
+		storedCount uint32
+	}{
+		{"counter did not increase", login(4, true), 4},
+		{"concurrent login", login(5, false), 5},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := record(tc.storedCount)
+			useCredential(r, tc.credential, ClonePolicyAllow, now)
+			assert.Equal(t, tc.storedCount, r.Authenticator.SignCount)
+			assert.False(t, r.Authenticator.CloneWarning)
+			assert.Equal(t, &now, r.LastUsedAt)
+			assert.Nil(t, r.LockedAt)
+
+			r = record(tc.storedCount)
+			useCredential(r, tc.credential, ClonePolicyFlag, now)
+			assert.Equal(t, tc.storedCount, r.Authenticator.SignCount)
+			assert.True(t, r.Authenticator.CloneWarning)
+			assert.Equal(t, &now, r.LastUsedAt)
+			assert.Nil(t, r.LockedAt)
+
+			r = record(tc.storedCount)
+			useCredential(r, tc.credential, ClonePolicyLock, now)
+			assert.True(t, r.Authenticator.CloneWarning)
+			assert.Nil(t, r.LastUsedAt)
+			assert.Equal(t, &now, r.LockedAt)
+		})
+	}
+}
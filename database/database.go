@@ -3,11 +3,15 @@ package database
 import (
 	"crypto/rand"
 	"encoding/base64"
+	"encoding/hex"
 	"fmt"
+	"math/big"
+	"time"
 
 	"github.com/go-webauthn/webauthn/webauthn"
 	"github.com/google/uuid"
 	"github.com/olawolu/zk-pass/database/models"
+	"github.com/olawolu/zk-pass/zk"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 )
@@ -28,9 +32,18 @@ func NewDB(dbUrl string) *DB {
 		&models.CredentialFlags{},
 		&models.CredentialAttestation{},
 		&models.Authenticator{},
+		&models.Challenge{},
+		&models.ProofJob{},
+		&models.Commitment{},
+		&models.Nullifier{},
 	); err != nil {
 		panic(fmt.Errorf("error migrating db: %v", err))
 	}
+	for _, h := range merkleTreeHashes {
+		if err := models.MigrateMerkleTree(db, string(h)); err != nil {
+			panic(fmt.Errorf("error migrating db: %v", err))
+		}
+	}
 	return &DB{db}
 }
 
@@ -74,6 +87,20 @@ func (db *DB) GetUser(id string) (*models.User, error) {
 	return u, nil
 }
 
+// GetUserByWebAuthnID returns the user with the given WebAuthn user handle,
+// as returned in the userHandle of a discoverable credential's assertion.
+func (db *DB) GetUserByWebAuthnID(userHandle []byte) (*models.User, error) {
+	if len(userHandle) == 0 {
+		return nil, fmt.Errorf("error fetching user: empty user handle")
+	}
+	u, err := models.FetchUserByPasskeyUserID(db.DB, base64.RawURLEncoding.EncodeToString(userHandle))
+	if err != nil {
+		return nil, fmt.Errorf("error fetching user with handle %x from db: %w", userHandle, err)
+	}
+
+	return u, nil
+}
+
 func (db *DB) SaveUser(user models.User) error {
 	_, err := models.UpdateUser(db.DB, user)
 	if err != nil {
@@ -83,49 +110,181 @@ func (db *DB) SaveUser(user models.User) error {
 }
 
 func (db *DB) AddCredential(credential *webauthn.Credential, userId uuid.UUID, name string) error {
-	var transports []string
-	credentialUid := uuid.New()
-	credentialId := base64.RawURLEncoding.EncodeToString(credential.ID)
-	publicKey := base64.RawURLEncoding.EncodeToString(credential.PublicKey)
-
-	for _, v := range credential.Transport {
-		transports = append(transports, string(v))
-	}
-
-	newCredential := models.PublicKeyCredential{
-		ID:              credentialUid,
-		Name:            name,
-		UserID:          userId,
-		PasskeyUserID:   credentialId,
-		PublicKey:       publicKey,
-		AttestationType: credential.AttestationType,
-		Transports:      transports,
-		CredentialFlags: models.CredentialFlags{
-			PublicKeyCredentialId: credentialUid,
-			UserPresent:           credential.Flags.UserPresent,
-			UserVerified:          credential.Flags.UserVerified,
-			BackupEligible:        credential.Flags.BackupEligible,
-			BackupState:           credential.Flags.BackupState,
-		},
-		Authenticator: models.Authenticator{
-			PublicKeyCredentialId: credentialUid,
-			AAGUID:                credential.Authenticator.AAGUID,
-			SignCount:             credential.Authenticator.SignCount,
-			CloneWarning:          credential.Authenticator.CloneWarning,
-			Attachment:            credential.Authenticator.Attachment,
-		},
-		CredentialAttestation: models.CredentialAttestation{
-			PublicKeyCredentialId: credentialUid,
-			ClientDataJSON:        credential.Attestation.ClientDataJSON,
-			ClientDataHash:        credential.Attestation.ClientDataHash,
-			AuthenticatorData:     credential.Attestation.AuthenticatorData,
-			PublicKeyAlgorithm:    credential.Attestation.PublicKeyAlgorithm,
-			Object:                credential.Attestation.Object,
-		},
-	}
-
-	if err := models.CreateNewCredentials(db.DB, newCredential); err != nil {
-		return fmt.Errorf("error saving credentials: %v", err)
+	newCredential := models.NewPublicKeyCredential(userId, name, credential)
+	secret, err := zk.NewCredentialSecret()
+	if err != nil {
+		return err
+	}
+	newCredential.Secret = zk.EncodeCredentialSecret(secret)
+
+	// the credential joins the trees of credentials with its registration
+	return db.withMerkleTrees(func(tx *gorm.DB, trees []*zk.MerkleTree) error {
+		if err := models.CreateNewCredentials(tx, newCredential); err != nil {
+			return fmt.Errorf("error saving credentials: %v", err)
+		}
+		if _, err := addLeaf(tx, trees, credential.ID, credential.PublicKey, secret); err != nil {
+			return fmt.Errorf("error adding credential to merkle tree: %w", err)
+		}
+		return nil
+	})
+}
+
+// AddChallenge records the intent a login challenge was derived for, with the
+// hash it was derived with and the Pedersen commitment to the challenge.
+func (db *DB) AddChallenge(userId uuid.UUID, challenge, intentHash, commitment []byte, nonce uint64, hash, challengeCommitment string) error {
+	newChallenge := models.Challenge{
+		ID:                  uuid.New(),
+		UserID:              userId,
+		Challenge:           base64.RawURLEncoding.EncodeToString(challenge),
+		IntentHash:          hex.EncodeToString(intentHash),
+		Nonce:               nonce,
+		Commitment:          hex.EncodeToString(commitment),
+		Hash:                hash,
+		ChallengeCommitment: challengeCommitment,
+	}
+	if err := models.CreateChallenge(db.DB, newChallenge); err != nil {
+		return fmt.Errorf("error saving challenge: %v", err)
 	}
 	return nil
 }
+
+// GetChallenge returns the intent mapping of a base64url encoded challenge.
+func (db *DB) GetChallenge(challenge string) (*models.Challenge, error) {
+	c, err := models.FetchChallenge(db.DB, challenge)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// AssignChallenge records the user a discoverable login's challenge was
+// signed by, once the assertion's user handle is resolved: the challenge and
+// its commitment are stored under uuid.Nil until then.
+func (db *DB) AssignChallenge(challenge string, userId uuid.UUID) error {
+	return models.AssignChallenge(db.DB, challenge, userId)
+}
+
+// AddProofJob queues a proving job for the binary encoded witness. If the
+// proof exposes a nullifier, it is recorded with the job, and the error wraps
+// models.ErrNullifierExists if it was already issued.
+func (db *DB) AddProofJob(userId uuid.UUID, circuitId string, witness []byte, nullifier *models.Nullifier) (*models.ProofJob, error) {
+	job := models.ProofJob{
+		ID:        uuid.New(),
+		UserID:    userId,
+		CircuitID: circuitId,
+		Status:    models.ProofJobQueued,
+		Witness:   witness,
+	}
+	var err error
+	if nullifier != nil {
+		err = models.CreateProofJobWithNullifier(db.DB, job, *nullifier)
+	} else {
+		err = models.CreateProofJob(db.DB, job)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error saving proof job: %w", err)
+	}
+	return &job, nil
+}
+
+// GetNullifier returns the record of an issued nullifier.
+func (db *DB) GetNullifier(nullifier string) (*models.Nullifier, error) {
+	return models.FetchNullifier(db.DB, nullifier)
+}
+
+// GetIntentNullifier returns the record of the nullifier issued for the
+// user's intent with the given hex encoded hash and nonce, whichever
+// credential and circuit version proved it.
+func (db *DB) GetIntentNullifier(userId uuid.UUID, intentHash string, nonce uint64) (*models.Nullifier, error) {
+	return models.FetchIntentNullifier(db.DB, userId, intentHash, nonce)
+}
+
+// GetProofJob returns the proving job with the given ID.
+func (db *DB) GetProofJob(id string) (*models.ProofJob, error) {
+	jobId, err := uuid.Parse(id)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing proof job id: %v", err)
+	}
+	return models.FetchProofJob(db.DB, jobId)
+}
+
+// NextProofJob claims the oldest queued proving job of the given circuits on
+// behalf of the worker, if any.
+func (db *DB) NextProofJob(worker string, circuitIds []string) (*models.ProofJob, error) {
+	return models.ClaimProofJob(db.DB, worker, circuitIds)
+}
+
+// QueuedProofJobs returns the number of queued proving jobs of a circuit and
+// the creation time of the oldest one.
+func (db *DB) QueuedProofJobs(circuitId string) (int64, time.Time, error) {
+	return models.CountQueuedProofJobs(db.DB, circuitId)
+}
+
+// NextProofJobBatch claims up to size of the oldest queued proving jobs of a
+// circuit on behalf of the worker, to be proven together.
+func (db *DB) NextProofJobBatch(worker, circuitId string, size int) ([]models.ProofJob, error) {
+	return models.ClaimProofJobBatch(db.DB, worker, circuitId, size)
+}
+
+// ReindexProofJobBatch sets the position of the jobs of the worker in their
+// batch to their position in ids.
+func (db *DB) ReindexProofJobBatch(worker string, ids []uuid.UUID) error {
+	return models.ReindexProofJobBatch(db.DB, worker, ids)
+}
+
+// RenewProofJobs renews the lease of the worker on the jobs it is proving.
+func (db *DB) RenewProofJobs(worker string, ids []uuid.UUID) error {
+	return models.RenewProofJobs(db.DB, worker, ids)
+}
+
+// FinishProofJob records the proof of a job held by the worker, or the error
+// it failed with.
+func (db *DB) FinishProofJob(worker string, id uuid.UUID, proof []byte, err error) error {
+	return models.FinishProofJob(db.DB, worker, id, proof, err)
+}
+
+// RequeueProofJobs puts back in the queue the running jobs whose lease was
+// last renewed before staleBefore, i.e. whose worker is gone.
+func (db *DB) RequeueProofJobs(staleBefore time.Time) (int64, error) {
+	return models.RequeueProofJobs(db.DB, staleBefore)
+}
+
+// AddCommitment keeps the opening of a Pedersen commitment and returns the
+// hex encoded commitment.
+func (db *DB) AddCommitment(userId uuid.UUID, kind string, opening *zk.Opening) (string, error) {
+	commitment := models.Commitment{
+		ID:         uuid.New(),
+		UserID:     userId,
+		Kind:       kind,
+		Commitment: zk.EncodeCommitment(opening.Commit()),
+		Blinding:   opening.Blinding.Text(16),
+	}
+	for _, limb := range opening.Limbs {
+		commitment.Limbs = append(commitment.Limbs, limb.Text(16))
+	}
+	if err := models.CreateCommitment(db.DB, commitment); err != nil {
+		return "", fmt.Errorf("error saving commitment: %v", err)
+	}
+	return commitment.Commitment, nil
+}
+
+// GetOpening returns the opening of a hex encoded Pedersen commitment.
+func (db *DB) GetOpening(commitment string) (*zk.Opening, error) {
+	c, err := models.FetchCommitment(db.DB, commitment)
+	if err != nil {
+		return nil, err
+	}
+	opening := &zk.Opening{}
+	var ok bool
+	if opening.Blinding, ok = new(big.Int).SetString(c.Blinding, 16); !ok {
+		return nil, fmt.Errorf("error decoding blinding factor of commitment %s", commitment)
+	}
+	for i, limb := range c.Limbs {
+		v, ok := new(big.Int).SetString(limb, 16)
+		if !ok {
+			return nil, fmt.Errorf("error decoding limb %d of commitment %s", i, commitment)
+		}
+		opening.Limbs = append(opening.Limbs, v)
+	}
+	return opening, nil
+}
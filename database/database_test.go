@@ -0,0 +1,285 @@
+package database
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/google/uuid"
+	"github.com/olawolu/zk-pass/database/models"
+	"github.com/olawolu/zk-pass/zk"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+// addTestCredential adds a P-256 credential to a fresh user, which joins the
+// trees of credentials.
+func addTestCredential(t *testing.T, db *DB) *webauthn.Credential {
+	user, err := db.RegisterNewUser("test-" + uuid.NewString())
+	require.NoError(t, err)
+	return addUserCredential(t, db, user.ID)
+}
+
+// addUserCredential adds a P-256 credential to the user.
+func addUserCredential(t *testing.T, db *DB, userId uuid.UUID) *webauthn.Credential {
+	f, err := zk.NewFixture([]byte(uuid.NewString()), zk.HashMiMC, 0)
+	require.NoError(t, err)
+	publicKey, err := hex.DecodeString(strings.TrimPrefix(f.Credential.PublicKey, "0x"))
+	require.NoError(t, err)
+	credential := &webauthn.Credential{
+		ID:              []byte(uuid.NewString()),
+		PublicKey:       publicKey,
+		AttestationType: "none",
+		Flags:           webauthn.CredentialFlags{UserPresent: true, UserVerified: true},
+		Authenticator: webauthn.Authenticator{
+			SignCount: 1,
+		},
+		Attestation: webauthn.CredentialAttestation{
+			ClientDataJSON:     []byte(`{"type":"webauthn.create"}`),
+			PublicKeyAlgorithm: -7,
+		},
+	}
+	require.NoError(t, db.AddCredential(credential, userId, "test"))
+	return credential
+}
+
+// newTestDB connects to the postgres database of TEST_DATABASE_URL, and skips
+// the test if it is not set. Tests share the database, so they must not
+// depend on rows they didn't create.
+func newTestDB(t *testing.T) *DB {
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	return NewDB(url)
+}
+
+func TestProofJobLease(t *testing.T) {
+	db := newTestDB(t)
+	// a circuit of its own keeps the jobs of other tests out of the way
+	circuitId := "test-" + uuid.NewString()
+	queued, err := db.AddProofJob(uuid.New(), circuitId, []byte("witness"), nil)
+	require.NoError(t, err)
+
+	job, err := db.NextProofJob("worker a", []string{circuitId})
+	require.NoError(t, err)
+	require.NotNil(t, job)
+	assert.Equal(t, queued.ID, job.ID)
+	assert.Equal(t, models.ProofJobRunning, job.Status)
+	assert.Equal(t, "worker a", job.ClaimedBy)
+	require.NotNil(t, job.ClaimedAt)
+
+	next, err := db.NextProofJob("worker b", []string{circuitId})
+	require.NoError(t, err)
+	assert.Nil(t, next, "a running job is not claimed twice")
+
+	// a live lease is left alone
+	_, err = db.RequeueProofJobs(time.Now().Add(-time.Minute))
+	require.NoError(t, err)
+	job, err = db.GetProofJob(queued.ID.String())
+	require.NoError(t, err)
+	assert.Equal(t, models.ProofJobRunning, job.Status)
+	assert.Equal(t, "worker a", job.ClaimedBy)
+	require.NoError(t, db.RenewProofJobs("worker a", []uuid.UUID{job.ID}))
+	assert.ErrorIs(t, db.RenewProofJobs("worker b", []uuid.UUID{job.ID}), models.ErrProofJobLost)
+
+	// a stale lease is requeued, and its worker can no longer finish the job
+	_, err = db.RequeueProofJobs(time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	job, err = db.GetProofJob(queued.ID.String())
+	require.NoError(t, err)
+	assert.Equal(t, models.ProofJobQueued, job.Status)
+	assert.Empty(t, job.ClaimedBy)
+	assert.Nil(t, job.ClaimedAt)
+	assert.ErrorIs(t, db.FinishProofJob("worker a", job.ID, []byte("proof"), nil), models.ErrProofJobLost)
+
+	jobs, err := db.NextProofJobBatch("worker b", circuitId, 2)
+	require.NoError(t, err)
+	require.Len(t, jobs, 1)
+	assert.Equal(t, "worker b", jobs[0].ClaimedBy)
+	require.NotNil(t, jobs[0].BatchID)
+	jobErr := errors.New("unsatisfied constraint")
+	require.NoError(t, db.FinishProofJob("worker b", job.ID, nil, jobErr))
+	job, err = db.GetProofJob(queued.ID.String())
+	require.NoError(t, err)
+	assert.Equal(t, models.ProofJobFailed, job.Status)
+	assert.Equal(t, jobErr.Error(), job.Error)
+	assert.Nil(t, job.Witness)
+	assert.ErrorIs(t, db.FinishProofJob("worker b", job.ID, nil, nil), models.ErrProofJobLost, "a job finishes once")
+}
+
+func TestNullifier(t *testing.T) {
+	db := newTestDB(t)
+	userId := uuid.New()
+	nullifier := models.Nullifier{
+		Nullifier:  "0x" + uuid.NewString(),
+		UserID:     userId,
+		IntentHash: "intent",
+		Nonce:      1,
+	}
+	_, err := db.GetNullifier(nullifier.Nullifier)
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+	_, err = db.GetIntentNullifier(userId, nullifier.IntentHash, nullifier.Nonce)
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+
+	job, err := db.AddProofJob(userId, "test-"+uuid.NewString(), []byte("witness"), &nullifier)
+	require.NoError(t, err)
+	record, err := db.GetNullifier(nullifier.Nullifier)
+	require.NoError(t, err)
+	assert.Equal(t, job.ID, record.ProofJobID)
+	record, err = db.GetIntentNullifier(userId, nullifier.IntentHash, nullifier.Nonce)
+	require.NoError(t, err)
+	assert.Equal(t, nullifier.Nullifier, record.Nullifier)
+
+	_, err = db.AddProofJob(userId, job.CircuitID, []byte("witness"), &nullifier)
+	assert.ErrorIs(t, err, models.ErrNullifierExists)
+
+	// another credential or circuit version derives another nullifier for
+	// the intent, which the user already proved
+	other := nullifier
+	other.Nullifier = "0x" + uuid.NewString()
+	_, err = db.AddProofJob(userId, job.CircuitID, []byte("witness"), &other)
+	assert.ErrorIs(t, err, models.ErrNullifierExists)
+
+	// another user's intent may have the same hash and nonce
+	other.UserID = uuid.New()
+	_, err = db.AddProofJob(other.UserID, job.CircuitID, []byte("witness"), &other)
+	assert.NoError(t, err)
+}
+
+func TestCredentialSecret(t *testing.T) {
+	db := newTestDB(t)
+	credential := addTestCredential(t, db)
+	secret, err := db.GetCredentialSecret(credential.ID)
+	require.NoError(t, err)
+	other, err := db.GetCredentialSecret(addTestCredential(t, db).ID)
+	require.NoError(t, err)
+	assert.NotEqual(t, secret, other)
+
+	// the credential's leaf commits to its secret
+	publicKey, err := zk.ParseCredentialPublicKey(credential.PublicKey)
+	require.NoError(t, err)
+	for _, h := range merkleTreeHashes {
+		proof, err := db.GetMerkleProof(h, credential.ID)
+		require.NoError(t, err)
+		leaf, err := zk.CredentialLeaf(h, publicKey, secret)
+		require.NoError(t, err)
+		assert.Equal(t, leaf, proof.Leaf)
+	}
+
+	_, err = db.GetCredentialSecret([]byte(uuid.NewString()))
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+}
+
+func TestRevokeCredential(t *testing.T) {
+	db := newTestDB(t)
+
+	t.Run("revoked", func(t *testing.T) {
+		credential := addTestCredential(t, db)
+		before, err := db.GetMerkleRoot(zk.HashMiMC)
+		require.NoError(t, err)
+		_, err = db.GetMerkleProof(zk.HashMiMC, credential.ID)
+		require.NoError(t, err)
+
+		require.NoError(t, db.RevokeCredential(credential.ID))
+		for _, h := range merkleTreeHashes {
+			_, err = db.GetMerkleProof(h, credential.ID)
+			assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+		}
+		after, err := db.GetMerkleRoot(zk.HashMiMC)
+		require.NoError(t, err)
+		assert.NotEqual(t, before.Root, after.Root)
+
+		assert.ErrorIs(t, db.RevokeCredential(credential.ID), gorm.ErrRecordNotFound)
+	})
+
+	t.Run("locked", func(t *testing.T) {
+		credential := addTestCredential(t, db)
+		before, err := db.GetMerkleRoot(zk.HashMiMC)
+		require.NoError(t, err)
+
+		// a counter that did not increase locks the credential
+		assert.ErrorIs(t, db.UpdateCredential(credential, ClonePolicyLock), models.ErrCredentialLocked)
+		for _, h := range merkleTreeHashes {
+			_, err = db.GetMerkleProof(h, credential.ID)
+			assert.ErrorIs(t, err, models.ErrCredentialLocked)
+		}
+		after, err := db.GetMerkleRoot(zk.HashMiMC)
+		require.NoError(t, err)
+		assert.NotEqual(t, before.Root, after.Root)
+	})
+}
+
+func TestGetUser(t *testing.T) {
+	db := newTestDB(t)
+	user, err := db.RegisterNewUser("test-" + uuid.NewString())
+	require.NoError(t, err)
+	credentials := []*webauthn.Credential{addUserCredential(t, db, user.ID), addUserCredential(t, db, user.ID)}
+	// a credential that doesn't decode leaves the others usable
+	corrupt := models.NewPublicKeyCredential(user.ID, "corrupt", &webauthn.Credential{ID: []byte(uuid.NewString())})
+	corrupt.PublicKey = "not base64!"
+	require.NoError(t, models.CreateNewCredentials(db.DB, corrupt))
+
+	check := func(t *testing.T, got *models.User) {
+		assert.Equal(t, user.ID, got.ID)
+		assert.Equal(t, user.Username, got.Username)
+		// the credentials are the user's, found with the foreignKey:UserID
+		// tag, with their flags, authenticator and attestation preloaded
+		webauthnCredentials := got.WebAuthnCredentials()
+		require.Len(t, got.PublicKeyCredentials, len(credentials))
+		require.Len(t, webauthnCredentials, len(credentials))
+		for _, want := range credentials {
+			i := slices.IndexFunc(webauthnCredentials, func(c webauthn.Credential) bool { return bytes.Equal(c.ID, want.ID) })
+			require.NotEqual(t, -1, i, "credential %s not found", want.ID)
+			c := webauthnCredentials[i]
+			assert.Equal(t, want.PublicKey, c.PublicKey)
+			assert.Equal(t, want.Flags, c.Flags)
+			assert.Equal(t, want.Authenticator.SignCount, c.Authenticator.SignCount)
+			assert.Equal(t, want.Attestation.ClientDataJSON, c.Attestation.ClientDataJSON)
+			assert.Equal(t, want.Attestation.PublicKeyAlgorithm, c.Attestation.PublicKeyAlgorithm)
+		}
+	}
+
+	got, err := db.GetUser(base64.RawURLEncoding.EncodeToString([]byte(user.ID.String())))
+	require.NoError(t, err)
+	check(t, got)
+
+	got, err = db.GetUserByWebAuthnID(user.WebAuthnID())
+	require.NoError(t, err)
+	check(t, got)
+}
+
+func TestAssignChallenge(t *testing.T) {
+	db := newTestDB(t)
+	userId := uuid.New()
+	opening, err := zk.NewChallenge(zk.HashMiMC, make([]byte, zk.IntentHashLen), 1)
+	require.NoError(t, err)
+	// a discoverable login stores its challenge before the user is known
+	commitment, err := db.AddCommitment(uuid.Nil, models.CommitmentChallenge, opening.Opening)
+	require.NoError(t, err)
+	challenge := []byte(uuid.NewString())
+	require.NoError(t, db.AddChallenge(uuid.Nil, challenge, make([]byte, zk.IntentHashLen), []byte("commitment"), 1, string(zk.HashMiMC), commitment))
+
+	encoded := base64.RawURLEncoding.EncodeToString(challenge)
+	require.NoError(t, db.AssignChallenge(encoded, userId))
+	c, err := db.GetChallenge(encoded)
+	require.NoError(t, err)
+	assert.Equal(t, userId, c.UserID)
+	var stored models.Commitment
+	require.NoError(t, db.Where("commitment = ?", commitment).First(&stored).Error)
+	assert.Equal(t, userId, stored.UserID)
+
+	// the challenge keeps the user it was assigned to
+	require.NoError(t, db.AssignChallenge(encoded, uuid.New()))
+	c, err = db.GetChallenge(encoded)
+	require.NoError(t, err)
+	assert.Equal(t, userId, c.UserID)
+}
@@ -0,0 +1,322 @@
+package database
+
+import (
+	"crypto/ecdsa"
+	"encoding/base64"
+	"errors"
+	"fmt"
+
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	"github.com/olawolu/zk-pass/database/models"
+	"github.com/olawolu/zk-pass/zk"
+	"gorm.io/gorm"
+)
+
+// merkleTreeHashes are the hashes the trees of credentials are built with,
+// one for each hash of the membership circuit versions.
+var merkleTreeHashes = []zk.Hash{zk.HashMiMC, zk.HashPoseidon2}
+
+// merkleStore keeps the nodes of the tree of credentials built with a hash in
+// the database, within a transaction holding the trees' lock.
+type merkleStore struct {
+	tx   *gorm.DB
+	hash zk.Hash
+}
+
+func (s merkleStore) Node(level int, index uint64) (fr.Element, bool, error) {
+	var node fr.Element
+	n, err := models.FetchMerkleNode(s.tx, string(s.hash), level, int64(index))
+	if err != nil || n == nil {
+		return node, false, err
+	}
+	if _, err := node.SetString("0x" + n.Node); err != nil {
+		return node, false, fmt.Errorf("error decoding merkle node %d at level %d: %v", index, level, err)
+	}
+	return node, true, nil
+}
+
+func (s merkleStore) SetNode(level int, index uint64, node fr.Element) error {
+	return models.SaveMerkleNode(s.tx, string(s.hash), models.MerkleNode{
+		Level: level,
+		Index: int64(index),
+		Node:  node.Text(16),
+	})
+}
+
+// withMerkleTrees runs fn in a transaction holding the lock of the trees of
+// credentials, so that it reads and updates consistent trees. The trees are
+// in the order of merkleTreeHashes.
+func (db *DB) withMerkleTrees(fn func(tx *gorm.DB, trees []*zk.MerkleTree) error) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		trees, err := lockMerkleTrees(tx)
+		if err != nil {
+			return err
+		}
+		return fn(tx, trees)
+	})
+}
+
+// lockMerkleTrees takes the lock of the trees of credentials until the end of
+// the transaction and returns them, in the order of merkleTreeHashes.
+func lockMerkleTrees(tx *gorm.DB) ([]*zk.MerkleTree, error) {
+	if err := models.LockMerkleTree(tx); err != nil {
+		return nil, err
+	}
+	trees := make([]*zk.MerkleTree, len(merkleTreeHashes))
+	for i, h := range merkleTreeHashes {
+		trees[i] = zk.NewMerkleTree(h, merkleStore{tx, h})
+	}
+	return trees, nil
+}
+
+// merkleTree returns the tree built with h among the trees.
+func merkleTree(trees []*zk.MerkleTree, h zk.Hash) (*zk.MerkleTree, error) {
+	for _, tree := range trees {
+		if tree.Hash() == h {
+			return tree, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: no merkle tree is built with %q", zk.ErrUnknownHash, string(h))
+}
+
+// setLeaf sets the leaf of a credential, committing to its public key and
+// secret, at the index of the tree and records the new root.
+func setLeaf(tx *gorm.DB, tree *zk.MerkleTree, index int64, publicKey *ecdsa.PublicKey, secret fr.Element) error {
+	leaf, err := zk.CredentialLeaf(tree.Hash(), publicKey, secret)
+	if err != nil {
+		return err
+	}
+	root, err := tree.SetLeaf(uint64(index), leaf)
+	if err != nil {
+		return err
+	}
+	leafCount, err := models.NextLeafIndex(tx)
+	if err != nil {
+		return err
+	}
+	return models.CreateMerkleRoot(tx, string(tree.Hash()), models.MerkleRoot{
+		Root:      zk.EncodeMerkleRoot(root),
+		LeafCount: leafCount,
+	})
+}
+
+// addLeaf adds the leaf of a credential to the trees at the next index and
+// records their new roots. Credentials whose key can't be proven, i.e. other
+// than ES256, are left out of the trees.
+func addLeaf(tx *gorm.DB, trees []*zk.MerkleTree, credentialId, credentialPublicKey []byte, secret fr.Element) (bool, error) {
+	publicKey, err := zk.ParseCredentialPublicKey(credentialPublicKey)
+	if errors.Is(err, zk.ErrUnsupportedKey) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	index, err := models.NextLeafIndex(tx)
+	if err != nil {
+		return false, err
+	}
+	if err := models.SetLeafIndex(tx, base64.RawURLEncoding.EncodeToString(credentialId), index); err != nil {
+		return false, err
+	}
+	for _, tree := range trees {
+		if err := setLeaf(tx, tree, index, publicKey, secret); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// RevokeCredential deletes a credential and clears its leaf from the trees of
+// credentials, so that membership proofs against later roots exclude it. The
+// error wraps gorm.ErrRecordNotFound if there is no such credential.
+func (db *DB) RevokeCredential(credentialId []byte) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		// the credential is locked before the trees, as a login locking it
+		// does
+		id := base64.RawURLEncoding.EncodeToString(credentialId)
+		if _, err := models.FetchCredentialForUpdate(tx, id); err != nil {
+			return err
+		}
+		trees, err := lockMerkleTrees(tx)
+		if err != nil {
+			return err
+		}
+		credential, err := models.RevokeCredential(tx, id)
+		if err != nil {
+			return err
+		}
+		return clearLeaf(tx, trees, credential)
+	})
+}
+
+// clearLeaf clears the leaf of a credential from the trees of credentials and
+// records their new roots. The index of the leaf is not reused.
+func clearLeaf(tx *gorm.DB, trees []*zk.MerkleTree, credential *models.PublicKeyCredential) error {
+	if credential.LeafIndex == nil {
+		return nil
+	}
+	leafCount, err := models.NextLeafIndex(tx)
+	if err != nil {
+		return err
+	}
+	for _, tree := range trees {
+		root, err := tree.SetLeaf(uint64(*credential.LeafIndex), fr.Element{})
+		if err != nil {
+			return err
+		}
+		err = models.CreateMerkleRoot(tx, string(tree.Hash()), models.MerkleRoot{
+			Root:      zk.EncodeMerkleRoot(root),
+			LeafCount: leafCount,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AddMissingLeaves adds the credentials registered before the trees of
+// credentials were introduced to them, and returns how many leaves were
+// added. A tree introduced after the others, or for a new version of the
+// leaves, gets the leaves of their credentials, at the same indices.
+// Credentials registered before credentials had a secret are given one.
+func (db *DB) AddMissingLeaves() (int, error) {
+	var added int
+	err := db.withMerkleTrees(func(tx *gorm.DB, trees []*zk.MerkleTree) error {
+		for _, tree := range trees {
+			credentials, err := models.FetchCredentialsMissingLeaf(tx, string(tree.Hash()))
+			if err != nil {
+				return err
+			}
+			for _, credential := range credentials {
+				publicKey, err := credentialPublicKey(credential)
+				if err != nil {
+					return err
+				}
+				secret, err := credentialSecret(tx, &credential)
+				if err != nil {
+					return err
+				}
+				if err := setLeaf(tx, tree, *credential.LeafIndex, publicKey, secret); err != nil {
+					return err
+				}
+				added++
+			}
+		}
+
+		credentials, err := models.FetchCredentialsWithoutLeaf(tx)
+		if err != nil {
+			return err
+		}
+		for _, credential := range credentials {
+			credentialId, err := base64.RawURLEncoding.DecodeString(credential.PasskeyUserID)
+			if err != nil {
+				return fmt.Errorf("error decoding id of credential %s: %v", credential.ID, err)
+			}
+			publicKey, err := base64.RawURLEncoding.DecodeString(credential.PublicKey)
+			if err != nil {
+				return fmt.Errorf("error decoding public key of credential %s: %v", credential.ID, err)
+			}
+			secret, err := credentialSecret(tx, &credential)
+			if err != nil {
+				return err
+			}
+			ok, err := addLeaf(tx, trees, credentialId, publicKey, secret)
+			if err != nil {
+				return err
+			}
+			if ok {
+				added += len(trees)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("error adding credentials to merkle trees: %w", err)
+	}
+	return added, nil
+}
+
+// credentialPublicKey decodes the public key of a credential in the trees.
+func credentialPublicKey(credential models.PublicKeyCredential) (*ecdsa.PublicKey, error) {
+	publicKey, err := base64.RawURLEncoding.DecodeString(credential.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding public key of credential %s: %v", credential.ID, err)
+	}
+	return zk.ParseCredentialPublicKey(publicKey)
+}
+
+// credentialSecret decodes the secret of a credential, drawing and saving one
+// for credentials registered before credentials had a secret.
+func credentialSecret(tx *gorm.DB, credential *models.PublicKeyCredential) (fr.Element, error) {
+	if credential.Secret != "" {
+		return zk.DecodeCredentialSecret(credential.Secret)
+	}
+	secret, err := zk.NewCredentialSecret()
+	if err != nil {
+		return secret, err
+	}
+	credential.Secret = zk.EncodeCredentialSecret(secret)
+	return secret, models.SetCredentialSecret(tx, credential.PasskeyUserID, credential.Secret)
+}
+
+// GetCredentialSecret returns the secret of a credential, which its leaf in
+// the trees of credentials commits to and its nullifiers are derived from.
+func (db *DB) GetCredentialSecret(credentialId []byte) (fr.Element, error) {
+	credential, err := models.FetchCredential(db.DB, base64.RawURLEncoding.EncodeToString(credentialId))
+	if err != nil {
+		return fr.Element{}, err
+	}
+	if credential.Secret == "" {
+		return fr.Element{}, fmt.Errorf("credential %s has no secret", credential.ID)
+	}
+	return zk.DecodeCredentialSecret(credential.Secret)
+}
+
+// GetMerkleProof returns the proof of membership of a credential in the
+// current tree of credentials built with h.
+func (db *DB) GetMerkleProof(h zk.Hash, credentialId []byte) (*zk.MerkleProof, error) {
+	var proof *zk.MerkleProof
+	err := db.withMerkleTrees(func(tx *gorm.DB, trees []*zk.MerkleTree) error {
+		tree, err := merkleTree(trees, h)
+		if err != nil {
+			return err
+		}
+		credential, err := models.FetchCredential(tx, base64.RawURLEncoding.EncodeToString(credentialId))
+		if err != nil {
+			return err
+		}
+		if credential.LeafIndex == nil {
+			return fmt.Errorf("credential %s is not in the merkle tree", credential.ID)
+		}
+		if credential.LockedAt != nil {
+			return models.ErrCredentialLocked
+		}
+		proof, err = tree.Proof(uint64(*credential.LeafIndex))
+		return err
+	})
+	return proof, err
+}
+
+// GetMerkleRoots returns up to limit of the latest roots of the tree of
+// credentials built with h, newest first.
+func (db *DB) GetMerkleRoots(h zk.Hash, limit int) ([]models.MerkleRoot, error) {
+	return models.FetchMerkleRoots(db.DB, string(h), limit)
+}
+
+// GetMerkleRoot returns the current root of the tree of credentials built with
+// h, which is the root of the empty tree until a credential is added.
+func (db *DB) GetMerkleRoot(h zk.Hash) (*models.MerkleRoot, error) {
+	roots, err := models.FetchMerkleRoots(db.DB, string(h), 1)
+	if err != nil {
+		return nil, err
+	}
+	if len(roots) > 0 {
+		return &roots[0], nil
+	}
+	empty, err := zk.NewMerkleTree(h, zk.NewMemoryMerkleStore()).Root()
+	if err != nil {
+		return nil, err
+	}
+	return &models.MerkleRoot{Root: zk.EncodeMerkleRoot(empty)}, nil
+}
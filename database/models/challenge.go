@@ -0,0 +1,62 @@
+package models
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Challenge maps an authentication challenge to the transaction intent it was
+// derived for.
+type Challenge struct {
+	ID         uuid.UUID `gorm:"primaryKey"`
+	UserID     uuid.UUID `gorm:"index"`
+	Challenge  string    `gorm:"uniqueIndex"` // base64url encoded, as in clientDataJSON
+	IntentHash string    // hex encoded hash of the transaction intent
+	Nonce      uint64
+	Commitment string // hex encoded commitment to the server's randomness
+	Hash       string `gorm:"default:mimc"` // hash the challenge was derived with
+
+	// ChallengeCommitment is the hex encoded Pedersen commitment Cc to the
+	// challenge, whose opening is kept as a Commitment.
+	ChallengeCommitment string
+	CreatedAt           time.Time
+}
+
+func CreateChallenge(db *gorm.DB, challenge Challenge) error {
+	if err := db.Create(&challenge).Error; err != nil {
+		return err
+	}
+	return nil
+}
+
+// AssignChallenge records the user of a challenge issued without one, for a
+// discoverable login, on the challenge and on the commitment to it. The
+// challenges of other users are left as they are.
+func AssignChallenge(db *gorm.DB, challenge string, userId uuid.UUID) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&Commitment{}).
+			Where("commitment IN (?) AND user_id = ?", tx.Model(&Challenge{}).Select("challenge_commitment").Where("challenge = ?", challenge), uuid.Nil).
+			Update("user_id", userId).Error
+		if err != nil {
+			return fmt.Errorf("error assigning challenge commitment: %v", err)
+		}
+		err = tx.Model(&Challenge{}).
+			Where("challenge = ? AND user_id = ?", challenge, uuid.Nil).
+			Update("user_id", userId).Error
+		if err != nil {
+			return fmt.Errorf("error assigning challenge: %v", err)
+		}
+		return nil
+	})
+}
+
+func FetchChallenge(db *gorm.DB, challenge string) (*Challenge, error) {
+	var c Challenge
+	if err := db.Where("challenge = ?", challenge).First(&c).Error; err != nil {
+		return nil, fmt.Errorf("error fetching challenge: %v", err)
+	}
+	return &c, nil
+}
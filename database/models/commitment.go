@@ -0,0 +1,43 @@
+package models
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"gorm.io/gorm"
+)
+
+// Values committed to in the proofs.
+const (
+	CommitmentSignature = "signature"
+	CommitmentChallenge = "challenge"
+)
+
+// Commitment keeps the opening of a Pedersen commitment exposed by a proof,
+// so that the committed value can be revealed later.
+type Commitment struct {
+	ID         uuid.UUID      `gorm:"primaryKey"`
+	UserID     uuid.UUID      `gorm:"index"`
+	Kind       string         // CommitmentSignature or CommitmentChallenge
+	Commitment string         `gorm:"uniqueIndex"` // hex encoded compressed point
+	Limbs      pq.StringArray `gorm:"type:text[]"` // hex encoded limbs of the value, least significant first
+	Blinding   string         // hex encoded blinding factor
+	CreatedAt  time.Time
+}
+
+func CreateCommitment(db *gorm.DB, commitment Commitment) error {
+	if err := db.Create(&commitment).Error; err != nil {
+		return err
+	}
+	return nil
+}
+
+func FetchCommitment(db *gorm.DB, commitment string) (*Commitment, error) {
+	var c Commitment
+	if err := db.Where("commitment = ?", commitment).First(&c).Error; err != nil {
+		return nil, fmt.Errorf("error fetching commitment: %v", err)
+	}
+	return &c, nil
+}
@@ -0,0 +1,185 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// merkleTreeLock is the key of the advisory lock serializing the updates of
+// the trees of credentials across server instances.
+const merkleTreeLock = 0x7a6b2d6d65726b6c
+
+// merkleLeafVersion is the version of the leaves of the trees of
+// credentials. Each version has its own tables, which are filled with the
+// leaves of the registered credentials at startup: version 2 leaves commit to
+// the credential secret. The tables of earlier versions are left as they are.
+const merkleLeafVersion = 2
+
+// Each hash the circuits are built with has its own tree of credentials, in
+// its own tables.
+func merkleNodeTable(hash string) string {
+	return fmt.Sprintf("merkle_nodes_%s_v%d", hash, merkleLeafVersion)
+}
+
+func merkleRootTable(hash string) string {
+	return fmt.Sprintf("merkle_roots_%s_v%d", hash, merkleLeafVersion)
+}
+
+// MerkleNode is a non-empty node of a tree of credentials. Level
+// 0 holds the leaves and the last level the root.
+type MerkleNode struct {
+	Level int    `gorm:"primaryKey;autoIncrement:false"`
+	Index int64  `gorm:"primaryKey;autoIncrement:false"`
+	Node  string // hex encoded field element
+}
+
+// MerkleRoot is a root of a tree of credentials, recorded each
+// time a credential is added to or revoked from the tree.
+type MerkleRoot struct {
+	ID        uint   `gorm:"primaryKey"`
+	Root      string `gorm:"index"` // 0x prefixed hex, as in the proof's public inputs
+	LeafCount int64  // number of leaves assigned so far, revoked ones included
+	CreatedAt time.Time
+}
+
+// MigrateMerkleTree creates or updates the tables of the tree built with the
+// hash.
+func MigrateMerkleTree(db *gorm.DB, hash string) error {
+	if err := db.Table(merkleNodeTable(hash)).AutoMigrate(&MerkleNode{}); err != nil {
+		return fmt.Errorf("error migrating %s merkle nodes: %v", hash, err)
+	}
+	if err := db.Table(merkleRootTable(hash)).AutoMigrate(&MerkleRoot{}); err != nil {
+		return fmt.Errorf("error migrating %s merkle roots: %v", hash, err)
+	}
+	return nil
+}
+
+// LockMerkleTree takes the lock on the trees until the end of the transaction.
+func LockMerkleTree(tx *gorm.DB) error {
+	if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", merkleTreeLock).Error; err != nil {
+		return fmt.Errorf("error locking merkle tree: %v", err)
+	}
+	return nil
+}
+
+// NextLeafIndex returns the index of the leaf of the next credential added to
+// the tree. Leaves of revoked credentials are not reused.
+func NextLeafIndex(tx *gorm.DB) (int64, error) {
+	var next int64
+	err := tx.Unscoped().Model(&PublicKeyCredential{}).
+		Select("COALESCE(MAX(leaf_index) + 1, 0)").
+		Scan(&next).Error
+	if err != nil {
+		return 0, fmt.Errorf("error fetching next leaf index: %v", err)
+	}
+	return next, nil
+}
+
+// FetchMerkleNode returns the node at the index of the level of the tree built
+// with the hash, or nil if it is empty.
+func FetchMerkleNode(db *gorm.DB, hash string, level int, index int64) (*MerkleNode, error) {
+	var node MerkleNode
+	err := db.Table(merkleNodeTable(hash)).Where(map[string]any{"level": level, "index": index}).First(&node).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error fetching merkle node: %v", err)
+	}
+	return &node, nil
+}
+
+func SaveMerkleNode(db *gorm.DB, hash string, node MerkleNode) error {
+	err := db.Table(merkleNodeTable(hash)).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "level"}, {Name: "index"}},
+		DoUpdates: clause.AssignmentColumns([]string{"node"}),
+	}).Create(&node).Error
+	if err != nil {
+		return fmt.Errorf("error saving merkle node: %v", err)
+	}
+	return nil
+}
+
+func CreateMerkleRoot(db *gorm.DB, hash string, root MerkleRoot) error {
+	if err := db.Table(merkleRootTable(hash)).Create(&root).Error; err != nil {
+		return fmt.Errorf("error saving merkle root: %v", err)
+	}
+	return nil
+}
+
+// FetchMerkleRoots returns up to limit of the latest roots of the tree built
+// with the hash, newest first.
+func FetchMerkleRoots(db *gorm.DB, hash string, limit int) ([]MerkleRoot, error) {
+	var roots []MerkleRoot
+	if err := db.Table(merkleRootTable(hash)).Order("id DESC").Limit(limit).Find(&roots).Error; err != nil {
+		return nil, fmt.Errorf("error fetching merkle roots: %v", err)
+	}
+	return roots, nil
+}
+
+// SetLeafIndex sets the index of the leaf of a credential in the tree.
+func SetLeafIndex(db *gorm.DB, credentialId string, index int64) error {
+	err := db.Model(&PublicKeyCredential{}).
+		Where("passkey_user_id = ?", credentialId).
+		Update("leaf_index", index).Error
+	if err != nil {
+		return fmt.Errorf("error saving leaf index: %v", err)
+	}
+	return nil
+}
+
+// SetCredentialSecret sets the secret of a credential, e.g. registered before
+// credentials had one.
+func SetCredentialSecret(db *gorm.DB, credentialId, secret string) error {
+	err := db.Model(&PublicKeyCredential{}).
+		Where("passkey_user_id = ?", credentialId).
+		Update("secret", secret).Error
+	if err != nil {
+		return fmt.Errorf("error saving credential secret: %v", err)
+	}
+	return nil
+}
+
+// FetchCredentialsWithoutLeaf returns the credentials that are not in the
+// tree, e.g. registered before it was introduced, oldest first. Locked
+// credentials are left out.
+func FetchCredentialsWithoutLeaf(db *gorm.DB) ([]PublicKeyCredential, error) {
+	var credentials []PublicKeyCredential
+	if err := db.Where("leaf_index IS NULL AND locked_at IS NULL").Order("created_at").Find(&credentials).Error; err != nil {
+		return nil, fmt.Errorf("error fetching credentials: %v", err)
+	}
+	return credentials, nil
+}
+
+// FetchCredentialsMissingLeaf returns the credentials that have a leaf index
+// but no leaf in the tree built with the hash, e.g. registered before the tree
+// was introduced, in the order of their leaves. Locked credentials are left
+// out.
+func FetchCredentialsMissingLeaf(db *gorm.DB, hash string) ([]PublicKeyCredential, error) {
+	var credentials []PublicKeyCredential
+	err := db.Where("leaf_index IS NOT NULL AND locked_at IS NULL").
+		Where(fmt.Sprintf(`NOT EXISTS (SELECT 1 FROM %s n WHERE n.level = 0 AND n."index" = leaf_index)`, merkleNodeTable(hash))).
+		Order("leaf_index").
+		Find(&credentials).Error
+	if err != nil {
+		return nil, fmt.Errorf("error fetching credentials: %v", err)
+	}
+	return credentials, nil
+}
+
+// RevokeCredential deletes the credential with the given base64url encoded
+// credential ID and returns it.
+func RevokeCredential(db *gorm.DB, credentialId string) (*PublicKeyCredential, error) {
+	credential, err := FetchCredential(db, credentialId)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Delete(credential).Error; err != nil {
+		return nil, fmt.Errorf("error revoking credential: %v", err)
+	}
+	return credential, nil
+}
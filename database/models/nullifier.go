@@ -0,0 +1,67 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrNullifierExists is returned when recording a nullifier that was already
+// issued, or when the user already proved the intent it was derived for, e.g.
+// with another credential or circuit version.
+var ErrNullifierExists = errors.New("nullifier already issued")
+
+// Nullifier records a nullifier exposed by a signature proof, so that each
+// intent is proven once. The nullifiers of an intent differ from one
+// credential and circuit version to the next, so the intent is unique for
+// the user too.
+type Nullifier struct {
+	Nullifier    string    `gorm:"primaryKey"` // 0x prefixed hex, as in the proof's public inputs
+	UserID       uuid.UUID `gorm:"uniqueIndex:idx_nullifiers_intent"`
+	CredentialID string    // base64url encoded credential the assertion was made with
+	IntentHash   string    `gorm:"uniqueIndex:idx_nullifiers_intent"` // hex encoded hash of the transaction intent
+	Nonce        uint64    `gorm:"uniqueIndex:idx_nullifiers_intent"`
+	ProofJobID   uuid.UUID
+	CreatedAt    time.Time
+}
+
+// CreateProofJobWithNullifier queues the job and records the nullifier its
+// proof exposes in a single transaction. It returns ErrNullifierExists, and
+// queues nothing, if the nullifier was already issued or the user already
+// proved its intent.
+func CreateProofJobWithNullifier(db *gorm.DB, job ProofJob, nullifier Nullifier) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		nullifier.ProofJobID = job.ID
+		res := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&nullifier)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return ErrNullifierExists
+		}
+		return tx.Create(&job).Error
+	})
+}
+
+func FetchNullifier(db *gorm.DB, nullifier string) (*Nullifier, error) {
+	var n Nullifier
+	if err := db.Where("nullifier = ?", nullifier).First(&n).Error; err != nil {
+		return nil, fmt.Errorf("error fetching nullifier: %w", err)
+	}
+	return &n, nil
+}
+
+// FetchIntentNullifier returns the nullifier issued for the user's intent with
+// the given hex encoded hash and nonce.
+func FetchIntentNullifier(db *gorm.DB, userId uuid.UUID, intentHash string, nonce uint64) (*Nullifier, error) {
+	var n Nullifier
+	err := db.Where("user_id = ? AND intent_hash = ? AND nonce = ?", userId, intentHash, nonce).First(&n).Error
+	if err != nil {
+		return nil, fmt.Errorf("error fetching nullifier: %w", err)
+	}
+	return &n, nil
+}
@@ -0,0 +1,250 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Status of a proving job.
+const (
+	ProofJobQueued  = "queued"
+	ProofJobRunning = "running"
+	ProofJobDone    = "done"
+	ProofJobFailed  = "failed"
+)
+
+// ProofJob is a proof generated in the background.
+type ProofJob struct {
+	ID        uuid.UUID `gorm:"primaryKey"`
+	UserID    uuid.UUID `gorm:"index"`
+	CircuitID string
+	Status    string `gorm:"index"`
+
+	// Witness is the binary encoded full witness. It holds private inputs and
+	// is cleared once the job is over.
+	Witness []byte
+
+	Proof      []byte // json encoded proof, once done
+	Error      string // reason of the failure, if any
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+	StartedAt  *time.Time
+	FinishedAt *time.Time
+
+	// BatchID is set when the job is proven in a batch with other jobs, whose
+	// proof it shares. BatchIndex is its position in the batch.
+	BatchID    *uuid.UUID `gorm:"index"`
+	BatchIndex int
+
+	// ClaimedBy is the worker proving a running job, which holds it for as
+	// long as it renews ClaimedAt. Jobs whose lease went stale, e.g. because
+	// their worker crashed, are requeued.
+	ClaimedBy string
+	ClaimedAt *time.Time `gorm:"index"`
+}
+
+func CreateProofJob(db *gorm.DB, job ProofJob) error {
+	if err := db.Create(&job).Error; err != nil {
+		return err
+	}
+	return nil
+}
+
+func FetchProofJob(db *gorm.DB, id uuid.UUID) (*ProofJob, error) {
+	var job ProofJob
+	if err := db.First(&job, id).Error; err != nil {
+		return nil, fmt.Errorf("error fetching proof job: %v", err)
+	}
+	return &job, nil
+}
+
+// ErrProofJobLost is returned when a worker finishes or renews a job it no
+// longer holds, because its lease went stale and the job was requeued.
+var ErrProofJobLost = errors.New("proof job is no longer held by the worker")
+
+// ClaimProofJob marks the oldest queued job of the given circuits as running
+// on behalf of the worker and returns it, or nil if none is queued. Jobs of
+// other circuits are left in the queue.
+// Locked rows are skipped so that concurrent workers never claim the same job.
+func ClaimProofJob(db *gorm.DB, worker string, circuitIds []string) (*ProofJob, error) {
+	if len(circuitIds) == 0 {
+		return nil, nil
+	}
+	var job ProofJob
+	err := db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status = ? AND circuit_id IN ?", ProofJobQueued, circuitIds).
+			Order("created_at").
+			First(&job).Error
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		job.Status = ProofJobRunning
+		job.StartedAt = &now
+		job.ClaimedBy = worker
+		job.ClaimedAt = &now
+		return tx.Model(&job).Updates(map[string]any{
+			"status":     job.Status,
+			"started_at": now,
+			"claimed_by": worker,
+			"claimed_at": now,
+		}).Error
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error claiming proof job: %v", err)
+	}
+	return &job, nil
+}
+
+// CountQueuedProofJobs returns the number of queued jobs of a circuit and the
+// creation time of the oldest one.
+func CountQueuedProofJobs(db *gorm.DB, circuitId string) (int64, time.Time, error) {
+	var res struct {
+		Count  int64
+		Oldest *time.Time
+	}
+	err := db.Model(&ProofJob{}).
+		Select("count(*) AS count, min(created_at) AS oldest").
+		Where("status = ? AND circuit_id = ?", ProofJobQueued, circuitId).
+		Scan(&res).Error
+	if err != nil {
+		return 0, time.Time{}, fmt.Errorf("error counting proof jobs: %v", err)
+	}
+	if res.Oldest == nil {
+		return 0, time.Time{}, nil
+	}
+	return res.Count, *res.Oldest, nil
+}
+
+// ClaimProofJobBatch marks up to limit of the oldest queued jobs of a circuit
+// as running in a new batch on behalf of the worker, and returns them in
+// batch order.
+func ClaimProofJobBatch(db *gorm.DB, worker, circuitId string, limit int) ([]ProofJob, error) {
+	var jobs []ProofJob
+	err := db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status = ? AND circuit_id = ?", ProofJobQueued, circuitId).
+			Order("created_at").
+			Limit(limit).
+			Find(&jobs).Error
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		batchId := uuid.New()
+		for i := range jobs {
+			jobs[i].Status = ProofJobRunning
+			jobs[i].StartedAt = &now
+			jobs[i].BatchID = &batchId
+			jobs[i].BatchIndex = i
+			jobs[i].ClaimedBy = worker
+			jobs[i].ClaimedAt = &now
+			err := tx.Model(&jobs[i]).Updates(map[string]any{
+				"status":      ProofJobRunning,
+				"started_at":  now,
+				"batch_id":    batchId,
+				"batch_index": i,
+				"claimed_by":  worker,
+				"claimed_at":  now,
+			}).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error claiming proof job batch: %v", err)
+	}
+	return jobs, nil
+}
+
+// ReindexProofJobBatch sets the position of the running jobs of the worker in
+// their batch to their position in ids, e.g. once a job was dropped from the
+// batch. It fails with ErrProofJobLost if any of them was requeued.
+func ReindexProofJobBatch(db *gorm.DB, worker string, ids []uuid.UUID) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		for i, id := range ids {
+			res := tx.Model(&ProofJob{}).
+				Where("id = ? AND status = ? AND claimed_by = ?", id, ProofJobRunning, worker).
+				Update("batch_index", i)
+			if res.Error != nil {
+				return fmt.Errorf("error reindexing proof job batch: %v", res.Error)
+			}
+			if res.RowsAffected == 0 {
+				return fmt.Errorf("error reindexing proof job %s: %w", id, ErrProofJobLost)
+			}
+		}
+		return nil
+	})
+}
+
+// RenewProofJobs renews the lease of the worker on its running jobs. It fails
+// with ErrProofJobLost if any of them was requeued.
+func RenewProofJobs(db *gorm.DB, worker string, ids []uuid.UUID) error {
+	res := db.Model(&ProofJob{}).
+		Where("id IN ? AND status = ? AND claimed_by = ?", ids, ProofJobRunning, worker).
+		Update("claimed_at", time.Now())
+	if res.Error != nil {
+		return fmt.Errorf("error renewing proof jobs: %v", res.Error)
+	}
+	if res.RowsAffected < int64(len(ids)) {
+		return ErrProofJobLost
+	}
+	return nil
+}
+
+// FinishProofJob records the outcome of a job held by the worker and drops
+// its witness. It fails with ErrProofJobLost if the job was requeued, in
+// which case the outcome is discarded.
+func FinishProofJob(db *gorm.DB, worker string, id uuid.UUID, proof []byte, jobErr error) error {
+	updates := map[string]any{
+		"status":      ProofJobDone,
+		"proof":       proof,
+		"witness":     nil,
+		"finished_at": time.Now(),
+	}
+	if jobErr != nil {
+		updates["status"] = ProofJobFailed
+		updates["error"] = jobErr.Error()
+	}
+	res := db.Model(&ProofJob{}).
+		Where("id = ? AND status = ? AND claimed_by = ?", id, ProofJobRunning, worker).
+		Updates(updates)
+	if res.Error != nil {
+		return fmt.Errorf("error finishing proof job: %v", res.Error)
+	}
+	if res.RowsAffected == 0 {
+		return fmt.Errorf("error finishing proof job %s: %w", id, ErrProofJobLost)
+	}
+	return nil
+}
+
+// RequeueProofJobs puts the running jobs whose lease was last renewed before
+// staleBefore, e.g. because their worker crashed, back in the queue. Jobs
+// held by live workers are left alone.
+func RequeueProofJobs(db *gorm.DB, staleBefore time.Time) (int64, error) {
+	res := db.Model(&ProofJob{}).
+		Where("status = ? AND (claimed_at IS NULL OR claimed_at < ?)", ProofJobRunning, staleBefore).
+		Updates(map[string]any{
+			"status":      ProofJobQueued,
+			"started_at":  nil,
+			"batch_id":    nil,
+			"batch_index": 0,
+			"claimed_by":  "",
+			"claimed_at":  nil,
+		})
+	if res.Error != nil {
+		return 0, fmt.Errorf("error requeuing proof jobs: %v", res.Error)
+	}
+	return res.RowsAffected, nil
+}
@@ -1,23 +1,34 @@
 package models
 
 import (
+	"encoding/base64"
+	"errors"
 	"fmt"
 	"time"
 
 	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
 	"github.com/google/uuid"
 	"github.com/lib/pq"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
+// ErrCredentialLocked is returned when logging in with a locked credential.
+var ErrCredentialLocked = errors.New("credential is locked")
+
 type PublicKeyCredential struct {
 	ID                    uuid.UUID `gorm:"primaryKey"`
 	UserID                uuid.UUID
 	Name                  string
 	PasskeyUserID         string // represented by user.id in registration options
 	PublicKey             string
+	Secret                string // 0x prefixed hex secret committed into the credential's leaf, which its nullifiers are derived from
 	AttestationType       string
 	Transports            pq.StringArray        `gorm:"type:text[]"`
+	LeafIndex             *int64                `gorm:"uniqueIndex"`      // index of the credential's leaf in the tree of credentials, unless its key can't be proven
+	LastUsedAt            *time.Time            `gorm:"type:timestamptz"` // time of the credential's last login
+	LockedAt              *time.Time            `gorm:"type:timestamptz"` // time the credential was locked, e.g. as a suspected clone; locked credentials can't log in
 	CredentialFlags       CredentialFlags       `gorm:"foreignKey:PublicKeyCredentialId;constraint:OnUpdate:CASCADE,OnDelete:SET NULL;"`
 	Authenticator         Authenticator         `gorm:"foreignKey:PublicKeyCredentialId;constraint:OnUpdate:CASCADE,OnDelete:SET NULL;"`
 	CredentialAttestation CredentialAttestation `gorm:"foreignKey:PublicKeyCredentialId;constraint:OnUpdate:CASCADE,OnDelete:SET NULL;"`
@@ -81,6 +92,84 @@ type CredentialAttestation struct {
 	Object                []byte `json:"object"`
 }
 
+// NewPublicKeyCredential maps a credential registered by the user to its
+// record. The record's ID is set when it is created.
+func NewPublicKeyCredential(userId uuid.UUID, name string, credential *webauthn.Credential) PublicKeyCredential {
+	var transports []string
+	for _, v := range credential.Transport {
+		transports = append(transports, string(v))
+	}
+	return PublicKeyCredential{
+		Name:            name,
+		UserID:          userId,
+		PasskeyUserID:   base64.RawURLEncoding.EncodeToString(credential.ID),
+		PublicKey:       base64.RawURLEncoding.EncodeToString(credential.PublicKey),
+		AttestationType: credential.AttestationType,
+		Transports:      transports,
+		CredentialFlags: CredentialFlags{
+			UserPresent:    credential.Flags.UserPresent,
+			UserVerified:   credential.Flags.UserVerified,
+			BackupEligible: credential.Flags.BackupEligible,
+			BackupState:    credential.Flags.BackupState,
+		},
+		Authenticator: Authenticator{
+			AAGUID:       credential.Authenticator.AAGUID,
+			SignCount:    credential.Authenticator.SignCount,
+			CloneWarning: credential.Authenticator.CloneWarning,
+			Attachment:   credential.Authenticator.Attachment,
+		},
+		CredentialAttestation: CredentialAttestation{
+			ClientDataJSON:     credential.Attestation.ClientDataJSON,
+			ClientDataHash:     credential.Attestation.ClientDataHash,
+			AuthenticatorData:  credential.Attestation.AuthenticatorData,
+			PublicKeyAlgorithm: credential.Attestation.PublicKeyAlgorithm,
+			Object:             credential.Attestation.Object,
+		},
+	}
+}
+
+// WebAuthnCredential maps the record back to the credential it was created
+// from. Its flags, authenticator and attestation must have been preloaded.
+func (p PublicKeyCredential) WebAuthnCredential() (webauthn.Credential, error) {
+	id, err := base64.RawURLEncoding.DecodeString(p.PasskeyUserID)
+	if err != nil {
+		return webauthn.Credential{}, fmt.Errorf("error decoding id of credential %s: %v", p.ID, err)
+	}
+	publicKey, err := base64.RawURLEncoding.DecodeString(p.PublicKey)
+	if err != nil {
+		return webauthn.Credential{}, fmt.Errorf("error decoding public key of credential %s: %v", p.ID, err)
+	}
+	var transports []protocol.AuthenticatorTransport
+	for _, v := range p.Transports {
+		transports = append(transports, protocol.AuthenticatorTransport(v))
+	}
+	return webauthn.Credential{
+		ID:              id,
+		PublicKey:       publicKey,
+		AttestationType: p.AttestationType,
+		Transport:       transports,
+		Flags: webauthn.CredentialFlags{
+			UserPresent:    p.CredentialFlags.UserPresent,
+			UserVerified:   p.CredentialFlags.UserVerified,
+			BackupEligible: p.CredentialFlags.BackupEligible,
+			BackupState:    p.CredentialFlags.BackupState,
+		},
+		Authenticator: webauthn.Authenticator{
+			AAGUID:       p.Authenticator.AAGUID,
+			SignCount:    p.Authenticator.SignCount,
+			CloneWarning: p.Authenticator.CloneWarning,
+			Attachment:   p.Authenticator.Attachment,
+		},
+		Attestation: webauthn.CredentialAttestation{
+			ClientDataJSON:     p.CredentialAttestation.ClientDataJSON,
+			ClientDataHash:     p.CredentialAttestation.ClientDataHash,
+			AuthenticatorData:  p.CredentialAttestation.AuthenticatorData,
+			PublicKeyAlgorithm: p.CredentialAttestation.PublicKeyAlgorithm,
+			Object:             p.CredentialAttestation.Object,
+		},
+	}, nil
+}
+
 func CreateNewCredentials(db *gorm.DB, creds PublicKeyCredential) error {
 	if err := db.Create(&creds).Error; err != nil {
 		return err
@@ -104,6 +193,53 @@ func FetchUserCredential(db *gorm.DB, userId, credId uuid.UUID) (*PublicKeyCrede
 	return &credential, nil
 }
 
+// FetchCredential returns the credential with the given base64url encoded
+// credential ID.
+func FetchCredential(db *gorm.DB, credentialId string) (*PublicKeyCredential, error) {
+	var credential PublicKeyCredential
+	if err := db.Where("passkey_user_id = ?", credentialId).First(&credential).Error; err != nil {
+		return nil, fmt.Errorf("error fetching credential: %w", err)
+	}
+	return &credential, nil
+}
+
+// FetchCredentialForUpdate returns the credential with the given base64url
+// encoded credential ID, with its flags and authenticator, and locks it until
+// the end of the transaction.
+func FetchCredentialForUpdate(tx *gorm.DB, credentialId string) (*PublicKeyCredential, error) {
+	var credential PublicKeyCredential
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Preload("CredentialFlags").
+		Preload("Authenticator").
+		Where("passkey_user_id = ?", credentialId).
+		First(&credential).Error
+	if err != nil {
+		return nil, fmt.Errorf("error fetching credential: %w", err)
+	}
+	return &credential, nil
+}
+
+// SaveCredentialUse saves the sign count, clone warning and flags of the
+// credential with its last use and lock.
+func SaveCredentialUse(tx *gorm.DB, credential *PublicKeyCredential) error {
+	err := tx.Model(credential).Updates(map[string]any{
+		"last_used_at": credential.LastUsedAt,
+		"locked_at":    credential.LockedAt,
+	}).Error
+	if err != nil {
+		return fmt.Errorf("error saving credential: %v", err)
+	}
+	credential.Authenticator.PublicKeyCredentialId = credential.ID
+	if err := tx.Save(&credential.Authenticator).Error; err != nil {
+		return fmt.Errorf("error saving authenticator: %v", err)
+	}
+	credential.CredentialFlags.PublicKeyCredentialId = credential.ID
+	if err := tx.Save(&credential.CredentialFlags).Error; err != nil {
+		return fmt.Errorf("error saving credential flags: %v", err)
+	}
+	return nil
+}
+
 func UpdateCredentials(db *gorm.DB, id uuid.UUID, cred PublicKeyCredential) (*PublicKeyCredential, error) {
 	if err := db.Save(&cred).Error; err != nil {
 		return nil, fmt.Errorf("error updating credentials: %v", err)
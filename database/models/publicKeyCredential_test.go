@@ -0,0 +1,66 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPublicKeyCredential(t *testing.T) {
+	credential := webauthn.Credential{
+		ID:              []byte("credential id"),
+		PublicKey:       []byte("public key"),
+		AttestationType: "packed",
+		Transport:       []protocol.AuthenticatorTransport{protocol.USB, protocol.Internal},
+		Flags: webauthn.CredentialFlags{
+			UserPresent:    true,
+			UserVerified:   true,
+			BackupEligible: true,
+			BackupState:    true,
+		},
+		Authenticator: webauthn.Authenticator{
+			AAGUID:       []byte("0123456789abcdef"),
+			SignCount:    42,
+			CloneWarning: true,
+			Attachment:   protocol.CrossPlatform,
+		},
+		Attestation: webauthn.CredentialAttestation{
+			ClientDataJSON:     []byte(`{"type":"webauthn.create"}`),
+			ClientDataHash:     []byte("client data hash"),
+			AuthenticatorData:  []byte("authenticator data"),
+			PublicKeyAlgorithm: -7,
+			Object:             []byte("attestation object"),
+		},
+	}
+	userId := uuid.New()
+
+	record := NewPublicKeyCredential(userId, "laptop", &credential)
+	assert.Equal(t, userId, record.UserID)
+	assert.Equal(t, "laptop", record.Name)
+	mapped, err := record.WebAuthnCredential()
+	require.NoError(t, err)
+	assert.Equal(t, credential, mapped)
+
+	t.Run("user credentials", func(t *testing.T) {
+		malformed := NewPublicKeyCredential(userId, "phone", &credential)
+		malformed.PublicKey = "not base64!"
+		_, err := malformed.WebAuthnCredential()
+		assert.Error(t, err)
+
+		locked := NewPublicKeyCredential(userId, "key", &credential)
+		lockedAt := time.Now()
+		locked.LockedAt = &lockedAt
+
+		user := User{ID: userId, PublicKeyCredentials: []PublicKeyCredential{record, malformed, locked}}
+		assert.Equal(t, []webauthn.Credential{credential}, user.WebAuthnCredentials())
+		assert.Empty(t, User{}.WebAuthnCredentials())
+		assert.True(t, User{PublicKeyCredentials: []PublicKeyCredential{locked}}.CredentialLocked(credential.ID))
+		assert.False(t, User{PublicKeyCredentials: []PublicKeyCredential{record}}.CredentialLocked(credential.ID))
+		assert.False(t, user.CredentialLocked([]byte("other credential")))
+	})
+}
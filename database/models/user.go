@@ -2,6 +2,8 @@ package models
 
 import (
 	"encoding/base64"
+	"fmt"
+	"log/slog"
 	"time"
 
 	"github.com/go-webauthn/webauthn/webauthn"
@@ -16,11 +18,15 @@ type User struct {
 	CreatedAt            time.Time             `gorm:"index;type:timestamptz;not null;default:NOW()"`
 	UpdatedAt            time.Time             `gorm:"index;type:timestamptz"`
 	DeletedAt            gorm.DeletedAt        `gorm:"index"`
-	PublicKeyCredentials []PublicKeyCredential `gorm:"foreignKey:PasskeyUserID"`
+	PublicKeyCredentials []PublicKeyCredential `gorm:"foreignKey:UserID"`
 }
 
+// BeforeCreate gives the user an ID, unless it already has one: RegisterNewUser
+// returns the ID it created the user with.
 func (u *User) BeforeCreate(tx *gorm.DB) (err error) {
-	u.ID = uuid.New()
+	if u.ID == uuid.Nil {
+		u.ID = uuid.New()
+	}
 	return
 }
 // PublicKeyCredential []PublicKeyCredential `gorm:"foreignKey:PasskeyUserID"`
@@ -32,11 +38,37 @@ func CreateNewUser(db *gorm.DB, user User) error {
 	return nil
 }
 
+// FetchUser returns the user with its credentials. Credentials that don't
+// decode as WebAuthn credentials are logged and left out, so that they don't
+// lock the user out of the others.
 func FetchUser(db *gorm.DB, id uuid.UUID) (*User, error) {
+	return fetchUser(db.Where("id = ?", id))
+}
+
+// FetchUserByPasskeyUserID returns the user whose WebAuthn user handle is
+// passkeyUserId, as FetchUser does.
+func FetchUserByPasskeyUserID(db *gorm.DB, passkeyUserId string) (*User, error) {
+	return fetchUser(db.Where("passkey_user_id = ?", passkeyUserId))
+}
+
+func fetchUser(db *gorm.DB) (*User, error) {
 	var user User
-	if err := db.First(&user, id).Error; err != nil {
+	err := db.Preload("PublicKeyCredentials.CredentialFlags").
+		Preload("PublicKeyCredentials.Authenticator").
+		Preload("PublicKeyCredentials.CredentialAttestation").
+		First(&user).Error
+	if err != nil {
 		return nil, err
 	}
+	credentials := user.PublicKeyCredentials[:0]
+	for _, credential := range user.PublicKeyCredentials {
+		if _, err := credential.WebAuthnCredential(); err != nil {
+			slog.Warn(fmt.Sprintf("skipping credential %s of user %s: %s", credential.ID, user.ID, err))
+			continue
+		}
+		credentials = append(credentials, credential)
+	}
+	user.PublicKeyCredentials = credentials
 	return &user, nil
 }
 
@@ -79,14 +111,34 @@ func (u User) WebAuthnDisplayName() string {
 	return u.Username
 }
 
+// CredentialLocked reports whether the credential with the given ID is one of
+// the user's locked credentials, which WebAuthnCredentials leaves out.
+func (u User) CredentialLocked(credentialId []byte) bool {
+	id := base64.RawURLEncoding.EncodeToString(credentialId)
+	for _, c := range u.PublicKeyCredentials {
+		if c.PasskeyUserID == id {
+			return c.LockedAt != nil
+		}
+	}
+	return false
+}
+
 // WebAuthnCredentials provides the list of Credential objects owned by the user.
+// The credentials must have been preloaded, as FetchUser does, which also
+// leaves out credentials that can't be decoded: those are left out here too,
+// as are locked credentials, which can't log in.
 func (u User) WebAuthnCredentials() []webauthn.Credential {
-	return nil
+	credentials := make([]webauthn.Credential, 0, len(u.PublicKeyCredentials))
+	for _, c := range u.PublicKeyCredentials {
+		if c.LockedAt != nil {
+			continue
+		}
+		credential, err := c.WebAuthnCredential()
+		if err != nil {
+			continue
+		}
+		credentials = append(credentials, credential)
+	}
+	return credentials
 }
 
-// func (u *User) AddCredential(credential *webauthn.Credential) {
-
-// }
-func (u *User) UpdateCredential(credential *webauthn.Credential) {
-
-}
@@ -0,0 +1,369 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark/backend/witness"
+	"github.com/google/uuid"
+	"github.com/olawolu/zk-pass/database"
+	"github.com/olawolu/zk-pass/database/models"
+	"github.com/olawolu/zk-pass/logger"
+	"github.com/olawolu/zk-pass/zk"
+)
+
+// pollInterval bounds how long a job queued by another instance waits before
+// it is picked up.
+const pollInterval = 5 * time.Second
+
+// leaseTimeout is how long a running job stays with its worker without being
+// renewed. Workers renew their jobs every third of it while proving, so only
+// the jobs of a stopped or crashed instance go stale and are requeued.
+const leaseTimeout = time.Minute
+
+// jobStore keeps the proving jobs, see database.DB.
+type jobStore interface {
+	AddProofJob(userId uuid.UUID, circuitId string, witness []byte, nullifier *models.Nullifier) (*models.ProofJob, error)
+	NextProofJob(worker string, circuitIds []string) (*models.ProofJob, error)
+	QueuedProofJobs(circuitId string) (int64, time.Time, error)
+	NextProofJobBatch(worker, circuitId string, size int) ([]models.ProofJob, error)
+	ReindexProofJobBatch(worker string, ids []uuid.UUID) error
+	RenewProofJobs(worker string, ids []uuid.UUID) error
+	FinishProofJob(worker string, id uuid.UUID, proof []byte, err error) error
+	RequeueProofJobs(staleBefore time.Time) (int64, error)
+}
+
+// ProofQueue proves jobs in the background, outside of the request that
+// queued them. Jobs and their witness are stored in the database, so queued
+// jobs survive a restart. Proofs run on the prover shared with the requests
+// proving inline, which bounds the proofs running at once.
+//
+// Several instances may share the queue. Each claims jobs under its own
+// worker ID and holds them with a lease, so that an instance only requeues
+// the jobs of workers that stopped renewing theirs.
+type ProofQueue struct {
+	datastore jobStore
+	circuits  *zk.Registry
+	prover    *zk.Prover
+	log       *logger.Logger
+	notify    chan struct{}
+	batch     *batchConfig
+	worker    string
+	lease     time.Duration
+}
+
+// batchConfig aggregates the jobs of the assertion circuit into proofs of the
+// batch circuit.
+type batchConfig struct {
+	size     int
+	maxDelay time.Duration
+	circuit  zk.CircuitDefinition
+}
+
+func NewProofQueue(
+	datastore *database.DB,
+	circuits *zk.Registry,
+	prover *zk.Prover,
+	log *logger.Logger,
+) *ProofQueue {
+	return &ProofQueue{
+		datastore: datastore,
+		circuits:  circuits,
+		prover:    prover,
+		log:       log,
+		notify:    make(chan struct{}, 1),
+		worker:    uuid.NewString(),
+		lease:     leaseTimeout,
+	}
+}
+
+// EnableBatching proves the assertion jobs in batches of size, with the keys
+// of zk.BatchCircuitDefinition(size). A batch is proven as soon as size jobs
+// are queued, or once the oldest queued job has waited for maxDelay.
+func (q *ProofQueue) EnableBatching(size int, maxDelay time.Duration) error {
+	def := zk.BatchCircuitDefinition(size)
+	if _, err := q.circuits.ProvingKeys(def.ID); err != nil {
+		return err
+	}
+	q.batch = &batchConfig{size: size, maxDelay: maxDelay, circuit: def}
+	return nil
+}
+
+// Enqueue stores a job proving the full witness with the keys of the circuit.
+// Retired circuits are refused. The nullifier exposed by the proof, if any, is
+// recorded with the job, and the error wraps models.ErrNullifierExists if it
+// was already issued.
+func (q *ProofQueue) Enqueue(userId uuid.UUID, circuitId string, w witness.Witness, nullifier *models.Nullifier) (*models.ProofJob, error) {
+	if _, err := q.circuits.ProvingKeys(circuitId); err != nil {
+		return nil, err
+	}
+	data, err := w.MarshalBinary()
+	if err != nil {
+		return nil, fmt.Errorf("error encoding witness: %w", err)
+	}
+	job, err := q.datastore.AddProofJob(userId, circuitId, data, nullifier)
+	if err != nil {
+		return nil, err
+	}
+
+	// wake the worker up, unless it already has a pending notification
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+	return job, nil
+}
+
+// Run proves the queued jobs one at a time until the context is cancelled.
+// Jobs whose lease went stale, e.g. left running when an instance stopped,
+// are queued again on start and then periodically.
+func (q *ProofQueue) Run(ctx context.Context) {
+	var requeued time.Time
+	for {
+		if time.Since(requeued) >= q.lease {
+			q.requeue(ctx)
+			requeued = time.Now()
+		}
+
+		wait := pollInterval
+		if q.batch != nil {
+			proved, due := q.runBatch(ctx)
+			if proved {
+				continue
+			}
+			if due > 0 && due < wait {
+				wait = due
+			}
+		}
+
+		job, err := q.datastore.NextProofJob(q.worker, q.provable())
+		if err != nil {
+			q.log.Logger.ErrorContext(ctx, err.Error())
+		}
+		if job != nil {
+			q.run(ctx, job)
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-q.notify:
+		case <-time.After(wait):
+		}
+	}
+}
+
+// requeue puts the jobs whose lease went stale back in the queue.
+func (q *ProofQueue) requeue(ctx context.Context) {
+	n, err := q.datastore.RequeueProofJobs(time.Now().Add(-q.lease))
+	if err != nil {
+		q.log.Logger.ErrorContext(ctx, err.Error())
+	} else if n > 0 {
+		q.log.Logger.InfoContext(ctx, fmt.Sprintf("requeued %d interrupted proof jobs", n))
+	}
+}
+
+// hold renews the lease of the worker on the jobs until release is called.
+func (q *ProofQueue) hold(ctx context.Context, jobs ...models.ProofJob) (release func()) {
+	ids := make([]uuid.UUID, len(jobs))
+	for i, job := range jobs {
+		ids[i] = job.ID
+	}
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(q.lease / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := q.datastore.RenewProofJobs(q.worker, ids); err != nil {
+					q.log.Logger.ErrorContext(ctx, err.Error())
+				}
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		wg.Wait()
+	}
+}
+
+// runBatch proves a batch of assertion jobs if one is due. Otherwise it
+// returns how long until the queued jobs are due, or 0 if none is queued.
+func (q *ProofQueue) runBatch(ctx context.Context) (bool, time.Duration) {
+	n, oldest, err := q.datastore.QueuedProofJobs(zk.AssertionCircuitDefinition.ID)
+	if err != nil {
+		q.log.Logger.ErrorContext(ctx, err.Error())
+		return false, 0
+	}
+	if n == 0 {
+		return false, 0
+	}
+	if due := q.batch.maxDelay - time.Since(oldest); n < int64(q.batch.size) && due > 0 {
+		return false, due
+	}
+
+	jobs, err := q.datastore.NextProofJobBatch(q.worker, zk.AssertionCircuitDefinition.ID, q.batch.size)
+	if err != nil {
+		q.log.Logger.ErrorContext(ctx, err.Error())
+		return false, 0
+	}
+	if len(jobs) == 0 {
+		return false, 0
+	}
+
+	// a job whose witness can't be rebuilt fails on its own, the others are
+	// still proven
+	proven := make([]models.ProofJob, 0, len(jobs))
+	assertions := make([]*zk.AssertionCircuit, 0, len(jobs))
+	for _, job := range jobs {
+		assertion, err := q.decodeAssertion(job)
+		if err != nil {
+			q.log.Logger.ErrorContext(ctx, fmt.Sprintf("proof job %s dropped from batch %s: %s", job.ID, job.BatchID, err))
+			if err := q.datastore.FinishProofJob(q.worker, job.ID, nil, err); err != nil {
+				q.log.Logger.ErrorContext(ctx, err.Error())
+			}
+			continue
+		}
+		proven = append(proven, job)
+		assertions = append(assertions, assertion)
+	}
+	if len(proven) == 0 {
+		return true, 0
+	}
+	if len(proven) < len(jobs) {
+		// each job's position in the batch is that of its assertion in the
+		// proof
+		ids := make([]uuid.UUID, len(proven))
+		for i, job := range proven {
+			ids[i] = job.ID
+		}
+		if err := q.datastore.ReindexProofJobBatch(q.worker, ids); err != nil {
+			q.log.Logger.ErrorContext(ctx, err.Error())
+			return true, 0
+		}
+	}
+
+	start := time.Now()
+	release := q.hold(ctx, proven...)
+	proof, err := q.proveBatch(ctx, assertions)
+	release()
+	if ctx.Err() != nil {
+		// the jobs stay running until their lease goes stale, and are then
+		// requeued
+		q.log.Logger.InfoContext(ctx, fmt.Sprintf("proof batch %s abandoned: %s", jobs[0].BatchID, err))
+		return true, 0
+	}
+	if err != nil {
+		q.log.Logger.ErrorContext(ctx, fmt.Sprintf("proof batch %s failed: %s", jobs[0].BatchID, err))
+	} else {
+		q.log.Logger.InfoContext(ctx, fmt.Sprintf("proof batch %s of %d jobs done in %s", jobs[0].BatchID, len(proven), time.Since(start)))
+	}
+	for _, job := range proven {
+		if err := q.datastore.FinishProofJob(q.worker, job.ID, proof, err); err != nil {
+			q.log.Logger.ErrorContext(ctx, err.Error())
+		}
+	}
+	return true, 0
+}
+
+// decodeAssertion rebuilds the assignment of the assertion circuit from the
+// witness of a job.
+func (q *ProofQueue) decodeAssertion(job models.ProofJob) (*zk.AssertionCircuit, error) {
+	w, err := witness.New(ecc.BN254.ScalarField())
+	if err != nil {
+		return nil, err
+	}
+	if err := w.UnmarshalBinary(job.Witness); err != nil {
+		return nil, fmt.Errorf("error decoding witness of job %s: %w", job.ID, err)
+	}
+	assertion, err := zk.AssertionFromWitness(q.batch.circuit.Hash, w)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding witness of job %s: %w", job.ID, err)
+	}
+	return assertion, nil
+}
+
+func (q *ProofQueue) proveBatch(ctx context.Context, assertions []*zk.AssertionCircuit) ([]byte, error) {
+	keys, err := q.circuits.ProvingKeys(q.batch.circuit.ID)
+	if err != nil {
+		return nil, err
+	}
+	var proof *zk.BatchProof
+	err = q.prover.SubmitWait(ctx, func() (err error) {
+		proof, err = zk.ProveBatch(keys, q.batch.size, assertions)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(proof)
+}
+
+// provable returns the circuits whose jobs this instance proves one at a
+// time. Jobs of other circuits are left to instances running other versions,
+// e.g. during a migration. Assertions are left to runBatch when batching.
+func (q *ProofQueue) provable() []string {
+	var ids []string
+	for _, id := range q.circuits.Provable() {
+		if q.batch != nil && (id == zk.AssertionCircuitDefinition.ID || id == q.batch.circuit.ID) {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func (q *ProofQueue) run(ctx context.Context, job *models.ProofJob) {
+	start := time.Now()
+	release := q.hold(ctx, *job)
+	proof, err := q.prove(ctx, job)
+	release()
+	if ctx.Err() != nil {
+		// the job stays running until its lease goes stale, and is then
+		// requeued
+		q.log.Logger.InfoContext(ctx, fmt.Sprintf("proof job %s abandoned: %s", job.ID, err))
+		return
+	}
+	if err != nil {
+		q.log.Logger.ErrorContext(ctx, fmt.Sprintf("proof job %s failed: %s", job.ID, err))
+	} else {
+		q.log.Logger.InfoContext(ctx, fmt.Sprintf("proof job %s done in %s", job.ID, time.Since(start)))
+	}
+	if err := q.datastore.FinishProofJob(q.worker, job.ID, proof, err); err != nil {
+		q.log.Logger.ErrorContext(ctx, err.Error())
+	}
+}
+
+func (q *ProofQueue) prove(ctx context.Context, job *models.ProofJob) ([]byte, error) {
+	keys, err := q.circuits.ProvingKeys(job.CircuitID)
+	if err != nil {
+		return nil, err
+	}
+	w, err := witness.New(ecc.BN254.ScalarField())
+	if err != nil {
+		return nil, err
+	}
+	if err := w.UnmarshalBinary(job.Witness); err != nil {
+		return nil, fmt.Errorf("error decoding witness: %w", err)
+	}
+	var proof *zk.Proof
+	err = q.prover.SubmitWait(ctx, func() (err error) {
+		proof, err = zk.ProveWitness(keys, w)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(proof)
+}
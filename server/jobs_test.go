@@ -0,0 +1,366 @@
+package server
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	"github.com/consensys/gnark/frontend"
+	"github.com/google/uuid"
+	"github.com/olawolu/zk-pass/database/models"
+	"github.com/olawolu/zk-pass/logger"
+	"github.com/olawolu/zk-pass/zk"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memoryJobStore keeps proving jobs in memory, with the claim and lease
+// semantics of database.DB.
+type memoryJobStore struct {
+	mu   sync.Mutex
+	jobs []*models.ProofJob // in creation order
+}
+
+func (s *memoryJobStore) AddProofJob(userId uuid.UUID, circuitId string, witness []byte, nullifier *models.Nullifier) (*models.ProofJob, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job := &models.ProofJob{
+		ID:        uuid.New(),
+		UserID:    userId,
+		CircuitID: circuitId,
+		Status:    models.ProofJobQueued,
+		Witness:   witness,
+		CreatedAt: time.Now(),
+	}
+	s.jobs = append(s.jobs, job)
+	return job, nil
+}
+
+func (s *memoryJobStore) claim(job *models.ProofJob, worker string) {
+	now := time.Now()
+	job.Status = models.ProofJobRunning
+	job.StartedAt = &now
+	job.ClaimedBy = worker
+	job.ClaimedAt = &now
+}
+
+func (s *memoryJobStore) NextProofJob(worker string, circuitIds []string) (*models.ProofJob, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, job := range s.jobs {
+		for _, id := range circuitIds {
+			if job.Status == models.ProofJobQueued && job.CircuitID == id {
+				s.claim(job, worker)
+				claimed := *job
+				return &claimed, nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+func (s *memoryJobStore) QueuedProofJobs(circuitId string) (int64, time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var n int64
+	var oldest time.Time
+	for _, job := range s.jobs {
+		if job.Status == models.ProofJobQueued && job.CircuitID == circuitId {
+			if n == 0 {
+				oldest = job.CreatedAt
+			}
+			n++
+		}
+	}
+	return n, oldest, nil
+}
+
+func (s *memoryJobStore) NextProofJobBatch(worker, circuitId string, size int) ([]models.ProofJob, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var jobs []models.ProofJob
+	batchId := uuid.New()
+	for _, job := range s.jobs {
+		if len(jobs) < size && job.Status == models.ProofJobQueued && job.CircuitID == circuitId {
+			s.claim(job, worker)
+			job.BatchID = &batchId
+			job.BatchIndex = len(jobs)
+			jobs = append(jobs, *job)
+		}
+	}
+	return jobs, nil
+}
+
+// held returns the job if it is running on behalf of the worker.
+func (s *memoryJobStore) held(worker string, id uuid.UUID) *models.ProofJob {
+	for _, job := range s.jobs {
+		if job.ID == id && job.Status == models.ProofJobRunning && job.ClaimedBy == worker {
+			return job
+		}
+	}
+	return nil
+}
+
+func (s *memoryJobStore) ReindexProofJobBatch(worker string, ids []uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, id := range ids {
+		job := s.held(worker, id)
+		if job == nil {
+			return models.ErrProofJobLost
+		}
+		job.BatchIndex = i
+	}
+	return nil
+}
+
+func (s *memoryJobStore) RenewProofJobs(worker string, ids []uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for _, id := range ids {
+		job := s.held(worker, id)
+		if job == nil {
+			return models.ErrProofJobLost
+		}
+		job.ClaimedAt = &now
+	}
+	return nil
+}
+
+func (s *memoryJobStore) FinishProofJob(worker string, id uuid.UUID, proof []byte, err error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job := s.held(worker, id)
+	if job == nil {
+		return models.ErrProofJobLost
+	}
+	now := time.Now()
+	job.Status = models.ProofJobDone
+	job.Proof = proof
+	job.Witness = nil
+	job.FinishedAt = &now
+	if err != nil {
+		job.Status = models.ProofJobFailed
+		job.Error = err.Error()
+	}
+	return nil
+}
+
+func (s *memoryJobStore) RequeueProofJobs(staleBefore time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var n int64
+	for _, job := range s.jobs {
+		if job.Status == models.ProofJobRunning && (job.ClaimedAt == nil || job.ClaimedAt.Before(staleBefore)) {
+			job.Status = models.ProofJobQueued
+			job.StartedAt = nil
+			job.BatchID = nil
+			job.BatchIndex = 0
+			job.ClaimedBy = ""
+			job.ClaimedAt = nil
+			n++
+		}
+	}
+	return n, nil
+}
+
+// job returns a copy of the job with the given ID.
+func (s *memoryJobStore) job(id uuid.UUID) models.ProofJob {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, job := range s.jobs {
+		if job.ID == id {
+			return *job
+		}
+	}
+	return models.ProofJob{}
+}
+
+// preimageCircuitDefinition is a small circuit to prove jobs with.
+var preimageCircuitDefinition = zk.CircuitDefinition{
+	ID:  "test-preimage",
+	New: func() frontend.Circuit { return zk.NewCircuit() },
+}
+
+// newTestProofQueue returns a queue proving the jobs of the preimage circuit
+// from an in-memory store, and the circuit's keys.
+func newTestProofQueue(t *testing.T) (*ProofQueue, *memoryJobStore, *zk.Keys) {
+	registry := zk.NewRegistry(zk.NewKeyStore(t.TempDir()))
+	keys, err := registry.Register(preimageCircuitDefinition, zk.StatusActive)
+	require.NoError(t, err)
+	prover := zk.NewProver(1, 1, time.Minute)
+	t.Cleanup(prover.Close)
+	store := &memoryJobStore{}
+	q := NewProofQueue(nil, registry, prover, logger.NewLogger())
+	q.datastore = store
+	return q, store, keys
+}
+
+// preimageWitness returns the binary encoded witness of a preimage.
+func preimageWitness(t *testing.T, preimage uint64) []byte {
+	var x fr.Element
+	x.SetUint64(preimage)
+	h, err := zk.HashMiMC.Sum(x)
+	require.NoError(t, err)
+	w, err := frontend.NewWitness(&zk.Circuit{PreImage: x, Hash: h}, ecc.BN254.ScalarField())
+	require.NoError(t, err)
+	data, err := w.MarshalBinary()
+	require.NoError(t, err)
+	return data
+}
+
+// assertionWitness returns the binary encoded witness of the assertion of a
+// fixture.
+func assertionWitness(t *testing.T, index int) []byte {
+	f, err := zk.NewFixture([]byte("proof queue"), zk.HashMiMC, index)
+	require.NoError(t, err)
+	data, err := hex.DecodeString(strings.TrimPrefix(f.AssertionWitness.Witness, "0x"))
+	require.NoError(t, err)
+	return data
+}
+
+// runProofQueue runs the queue until the test ends.
+func runProofQueue(t *testing.T, q *ProofQueue) {
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		q.Run(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-stopped
+	})
+}
+
+func TestProofQueue(t *testing.T) {
+	t.Run("proves queued jobs", func(t *testing.T) {
+		q, store, keys := newTestProofQueue(t)
+		runProofQueue(t, q)
+		job, err := store.AddProofJob(uuid.New(), preimageCircuitDefinition.ID, preimageWitness(t, 3), nil)
+		require.NoError(t, err)
+		q.notify <- struct{}{}
+
+		require.Eventually(t, func() bool { return store.job(job.ID).Status == models.ProofJobDone }, 10*time.Second, 10*time.Millisecond)
+		done := store.job(job.ID)
+		assert.Equal(t, q.worker, done.ClaimedBy)
+		assert.Nil(t, done.Witness)
+		var proof zk.Proof
+		require.NoError(t, json.Unmarshal(done.Proof, &proof))
+		assert.NoError(t, zk.Verify(keys, &proof))
+	})
+
+	t.Run("requeues stale jobs only", func(t *testing.T) {
+		q, store, _ := newTestProofQueue(t)
+		live, err := store.AddProofJob(uuid.New(), preimageCircuitDefinition.ID, preimageWitness(t, 3), nil)
+		require.NoError(t, err)
+		stale, err := store.AddProofJob(uuid.New(), preimageCircuitDefinition.ID, preimageWitness(t, 4), nil)
+		require.NoError(t, err)
+		store.claim(store.jobs[0], "live instance")
+		store.claim(store.jobs[1], "crashed instance")
+		claimedAt := time.Now().Add(-2 * q.lease)
+		store.jobs[1].ClaimedAt = &claimedAt
+
+		runProofQueue(t, q)
+		require.Eventually(t, func() bool { return store.job(stale.ID).Status == models.ProofJobDone }, 10*time.Second, 10*time.Millisecond)
+		assert.Equal(t, q.worker, store.job(stale.ID).ClaimedBy)
+		assert.Equal(t, models.ProofJobRunning, store.job(live.ID).Status)
+		assert.Equal(t, "live instance", store.job(live.ID).ClaimedBy)
+	})
+
+	t.Run("renews held jobs", func(t *testing.T) {
+		q, store, _ := newTestProofQueue(t)
+		q.lease = 30 * time.Millisecond
+		_, err := store.AddProofJob(uuid.New(), preimageCircuitDefinition.ID, preimageWitness(t, 3), nil)
+		require.NoError(t, err)
+		job, err := store.NextProofJob(q.worker, []string{preimageCircuitDefinition.ID})
+		require.NoError(t, err)
+
+		release := q.hold(context.Background(), *job)
+		require.Eventually(t, func() bool { return store.job(job.ID).ClaimedAt.After(*job.ClaimedAt) }, time.Second, time.Millisecond)
+		release()
+		renewed := *store.job(job.ID).ClaimedAt
+
+		n, err := store.RequeueProofJobs(renewed.Add(-time.Nanosecond))
+		require.NoError(t, err)
+		assert.Zero(t, n, "a renewed job is not stale")
+		time.Sleep(q.lease)
+		assert.Equal(t, renewed, *store.job(job.ID).ClaimedAt, "released jobs are no longer renewed")
+	})
+}
+
+func TestProofQueueBatch(t *testing.T) {
+	// the keys of the batch circuit take too long to set up in a test, so
+	// batches that get to proving fail for want of keys
+	newBatchQueue := func(t *testing.T, size int, maxDelay time.Duration) (*ProofQueue, *memoryJobStore) {
+		q, store, _ := newTestProofQueue(t)
+		q.batch = &batchConfig{size: size, maxDelay: maxDelay, circuit: zk.BatchCircuitDefinition(size)}
+		return q, store
+	}
+	addJobs := func(t *testing.T, store *memoryJobStore, witnesses ...[]byte) []uuid.UUID {
+		var ids []uuid.UUID
+		for _, w := range witnesses {
+			job, err := store.AddProofJob(uuid.New(), zk.AssertionCircuitDefinition.ID, w, nil)
+			require.NoError(t, err)
+			ids = append(ids, job.ID)
+		}
+		return ids
+	}
+	ctx := context.Background()
+
+	t.Run("flushes a full batch", func(t *testing.T) {
+		q, store := newBatchQueue(t, 2, time.Hour)
+		ids := addJobs(t, store, []byte("first"))
+		proved, due := q.runBatch(ctx)
+		assert.False(t, proved)
+		assert.Greater(t, due, 59*time.Minute)
+		assert.Equal(t, models.ProofJobQueued, store.job(ids[0]).Status)
+
+		ids = append(ids, addJobs(t, store, []byte("second"))...)
+		proved, _ = q.runBatch(ctx)
+		assert.True(t, proved)
+		for _, id := range ids {
+			assert.Equal(t, models.ProofJobFailed, store.job(id).Status)
+		}
+	})
+
+	t.Run("flushes a late batch", func(t *testing.T) {
+		q, store := newBatchQueue(t, 10, 20*time.Millisecond)
+		ids := addJobs(t, store, []byte("first"))
+		proved, due := q.runBatch(ctx)
+		assert.False(t, proved)
+		assert.Positive(t, due)
+		assert.LessOrEqual(t, due, 20*time.Millisecond)
+		assert.Equal(t, models.ProofJobQueued, store.job(ids[0]).Status)
+
+		time.Sleep(due)
+		proved, _ = q.runBatch(ctx)
+		assert.True(t, proved)
+		assert.Equal(t, models.ProofJobFailed, store.job(ids[0]).Status)
+	})
+
+	t.Run("drops jobs whose witness doesn't decode", func(t *testing.T) {
+		q, store := newBatchQueue(t, 3, time.Hour)
+		ids := addJobs(t, store, assertionWitness(t, 0), []byte("corrupt"), assertionWitness(t, 1))
+		proved, _ := q.runBatch(ctx)
+		require.True(t, proved)
+
+		dropped := store.job(ids[1])
+		assert.Equal(t, models.ProofJobFailed, dropped.Status)
+		assert.Contains(t, dropped.Error, "error decoding witness")
+		for i, id := range []uuid.UUID{ids[0], ids[2]} {
+			job := store.job(id)
+			assert.Equal(t, i, job.BatchIndex, "positions follow the assertions of the proof")
+			// the rest of the batch got to proving
+			assert.Equal(t, models.ProofJobFailed, job.Status)
+			assert.Contains(t, job.Error, zk.ErrUnknownKey.Error())
+		}
+	})
+}
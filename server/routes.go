@@ -1,17 +1,29 @@
 package server
 
 import (
-	"crypto/rand"
-	"encoding/binary"
+	"bytes"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/go-webauthn/webauthn/protocol"
 	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/google/uuid"
 	"github.com/gorilla/mux"
 	"github.com/olawolu/zk-pass/database"
+	"github.com/olawolu/zk-pass/database/models"
 	"github.com/olawolu/zk-pass/logger"
+	"github.com/olawolu/zk-pass/zk"
+	"github.com/olawolu/zk-pass/zk/witness"
+	"gorm.io/gorm"
 )
 
 type RouteDoc struct {
@@ -34,12 +46,72 @@ var apiDocs = []RouteDoc{
     {
         Path:        "/login/initiate/{userId}",
         Method:      "POST",
-        Description: "Begin WebAuthn authentication. Returns assertion options.",
+        Description: "Begin WebAuthn authentication for a transaction intent. Returns assertion options and a proof that the challenge was derived from committed randomness and the intent. Returns 503 with Retry-After when the prover is busy.",
     },
     {
         Path:        "/login/finish/{userId}",
         Method:      "POST",
-        Description: "Complete authentication with assertion from authenticator.",
+        Description: "Complete authentication with assertion from authenticator. Queues the proof that the passkey signed the intent-bound challenge and returns its job ID. With ?anonymous=true the proof hides the passkey, proving instead that it is in the tree of registered credentials. Returns 403 when the credential is locked as a suspected clone.",
+    },
+    {
+        Path:        "/login/discoverable/initiate",
+        Method:      "POST",
+        Description: "Begin a usernameless login with a discoverable passkey, for the same intent body as /login/initiate/{userId}. The options allow any passkey of the relying party, so no user ID is needed.",
+    },
+    {
+        Path:        "/login/discoverable/finish",
+        Method:      "POST",
+        Description: "Complete a usernameless login. The user is the one whose WebAuthn user ID is the assertion's userHandle, then the login completes as /login/finish/{userId} does. Returns 401 when no user has the userHandle.",
+    },
+    {
+        Path:        "/login/conditional/initiate",
+        Method:      "POST",
+        Description: "Begin a usernameless login for passkey autofill, as /login/discoverable/initiate does. The options carry mediation \"conditional\" and stay valid for longer, while the login form is open.",
+    },
+    {
+        Path:        "/login/conditional/finish",
+        Method:      "POST",
+        Description: "Complete a login begun with /login/conditional/initiate, as /login/discoverable/finish does.",
+    },
+    {
+        Path:        "/proofs/verify",
+        Method:      "POST",
+        Description: "Verify a proof against the loaded verifying keys. Returns whether it is valid and, if not, why: unknown_key, malformed_inputs or pairing_failed.",
+    },
+    {
+        Path:        "/proofs/{jobId}",
+        Method:      "GET",
+        Description: "Returns the status of a proof job (queued, running, done or failed) and the proof once done. Jobs proven in a batch share the batch proof and return the public inputs of every assertion of the batch.",
+    },
+    {
+        Path:        "/nullifiers/{nullifier}",
+        Method:      "GET",
+        Description: "Returns the intent and proof job of a nullifier issued by the server, or 404 if it was not issued. Relayers check it before submitting a proof.",
+    },
+    {
+        Path:        "/credentials/{credentialId}",
+        Method:      "DELETE",
+        Description: "Revoke a credential, given its base64url encoded ID: it can no longer log in and its leaf is cleared from the trees of credentials. Requires the ADMIN_TOKEN bearer token.",
+    },
+    {
+        Path:        "/merkle/root",
+        Method:      "GET",
+        Description: "Returns the current root of the Merkle tree of registered credential public keys, which anonymous login proofs are proven against. Each hash has its own tree: hash selects it (default: the server's challenge hash).",
+    },
+    {
+        Path:        "/merkle/roots",
+        Method:      "GET",
+        Description: "Returns the history of roots of the tree of credentials built with hash (default: the server's challenge hash), newest first, up to limit (default 100). A verifier accepts a membership proof against any root it trusts.",
+    },
+    {
+        Path:        "/zk/circuits",
+        Method:      "GET",
+        Description: "Lists the loaded circuit versions with their status (active, deprecated or retired), manifest and whether this server proves with them.",
+    },
+    {
+        Path:        "/zk/circuits/{circuitId}/verifying-key",
+        Method:      "GET",
+        Description: "Returns the verifying key of a circuit and the manifest with its fingerprints.",
     },
 }
 
@@ -62,6 +134,9 @@ func initRoutes(
 	config *Config,
 	datastore *database.DB,
 	sessionStore *SessionManager,
+	circuitRegistry *zk.Registry,
+	prover *zk.Prover,
+	proofQueue *ProofQueue,
 	logger *logger.Logger,
 ) {
 	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
@@ -104,8 +179,40 @@ func initRoutes(
 
 	// authenticate registered passkeys
 	auth := mux.PathPrefix("/login").Subrouter()
-	auth.HandleFunc("/initiate/{userId}", beginLogin(config, datastore, sessionStore, logger))
-	auth.HandleFunc("/finish/{userId}", finishLogin(config, datastore, sessionStore, logger))
+	auth.HandleFunc("/initiate/{userId}", beginLogin(config, datastore, sessionStore, circuitRegistry, prover, logger))
+	auth.HandleFunc("/finish/{userId}", finishLogin(config, datastore, sessionStore, proofQueue, logger))
+
+	// authenticate with a discoverable passkey, the user is found from the
+	// assertion's user handle
+	auth.HandleFunc("/discoverable/initiate", beginDiscoverableLogin(config, datastore, sessionStore, circuitRegistry, prover, logger))
+	auth.HandleFunc("/discoverable/finish", finishDiscoverableLogin(config, datastore, sessionStore, proofQueue, logger, discoverableLoginSession))
+
+	// authenticate with a passkey offered in the autofill of the login form
+	auth.HandleFunc("/conditional/initiate", beginConditionalLogin(config, datastore, sessionStore, circuitRegistry, prover, logger))
+	auth.HandleFunc("/conditional/finish", finishDiscoverableLogin(config, datastore, sessionStore, proofQueue, logger, conditionalLoginSession))
+
+	// poll the proofs generated in the background
+	proofs := mux.PathPrefix("/proofs").Subrouter()
+	proofs.HandleFunc("/verify", verifyProof(circuitRegistry, logger)).Methods(http.MethodPost)
+	proofs.HandleFunc("/{jobId}", getProofJob(datastore, logger)).Methods(http.MethodGet)
+
+	// let relayers check a nullifier before submitting a proof
+	nullifiers := mux.PathPrefix("/nullifiers").Subrouter()
+	nullifiers.HandleFunc("/{nullifier}", getNullifier(datastore, logger)).Methods(http.MethodGet)
+
+	// revoke lost or compromised credentials
+	credentials := mux.PathPrefix("/credentials").Subrouter()
+	credentials.HandleFunc("/{credentialId}", revokeCredential(config, datastore, logger)).Methods(http.MethodDelete)
+
+	// publish the roots of the tree of credentials anonymous logins are
+	// proven against
+	mux.HandleFunc("/merkle/root", getMerkleRoot(config, datastore, logger)).Methods(http.MethodGet)
+	mux.HandleFunc("/merkle/roots", listMerkleRoots(config, datastore, logger)).Methods(http.MethodGet)
+
+	// publish the zk circuit versions and their verifying keys
+	circuits := mux.PathPrefix("/zk").Subrouter()
+	circuits.HandleFunc("/circuits/{circuitId}/verifying-key", getVerifyingKey(circuitRegistry, logger)).Methods(http.MethodGet)
+	circuits.HandleFunc("/circuits", listCircuits(circuitRegistry)).Methods(http.MethodGet)
 }
 
 func beginRegistration(
@@ -208,23 +315,42 @@ func finishRegistration(
 	}
 }
 
+// loginOptions is the intent a login is for.
+type loginOptions struct {
+	IntentHash string `json:"intentHash"` // hex encoded hash of the transaction intent
+	Nonce      uint64 `json:"nonce"`
+}
+
+// loginChallenge is the assertion options of a login with the proof of the
+// derivation of their challenge.
+type loginChallenge struct {
+	*protocol.CredentialAssertion
+	ChallengeProof *zk.Proof `json:"challengeProof"`
+	Mediation      string    `json:"mediation,omitempty"` // mediation the client requests the credential with
+}
+
+// loginBeginner begins the WebAuthn ceremony of a login.
+type loginBeginner func(webAuthn *webauthn.WebAuthn, opts ...webauthn.LoginOption) (*protocol.CredentialAssertion, *webauthn.SessionData, error)
+
 func beginLogin(
 	config *Config,
 	datastore *database.DB,
 	sessionStore *SessionManager,
+	circuitRegistry *zk.Registry,
+	prover *zk.Prover,
 	log *logger.Logger,
 ) http.HandlerFunc {
-	type loginOptions struct {
-		hashedTxIntent string
-	}
 	return func(w http.ResponseWriter, r *http.Request) {
 		params := mux.Vars(r)
 		userId := params["userId"]
 
-		// loginOpts, err := decodeRequestBody[loginOptions](r)
-		// if err != nil {
-		// 	log.Logger.ErrorContext(r.Context(),err.Error())
-		// }
+		loginOpts, err := decodeRequestBody[loginOptions](r)
+		if err != nil {
+			log.Logger.ErrorContext(r.Context(), err.Error())
+			response := fmtResponse(http.StatusInternalServerError, err.Error(), nil)
+			encodeJsonValue[Response](w, http.StatusInternalServerError, response)
+			return
+		}
 
 		user, err := datastore.GetUser(userId) // Find the user
 		if err != nil {
@@ -234,7 +360,16 @@ func beginLogin(
 			return
 		}
 
-		webAuthn, err := webauthn.New(config.webauthn)
+		challenge, session, ok := beginLoginCeremony(w, r, config, datastore, circuitRegistry, prover, log, user.ID, loginOpts,
+			func(webAuthn *webauthn.WebAuthn, opts ...webauthn.LoginOption) (*protocol.CredentialAssertion, *webauthn.SessionData, error) {
+				return webAuthn.BeginLogin(user, opts...)
+			})
+		if !ok {
+			return
+		}
+
+		// store the session values
+		err = sessionStore.SaveSession(w, r, session, fmt.Sprintf("%s-%s", user.ID, user.PasskeyUserID))
 		if err != nil {
 			log.Logger.ErrorContext(r.Context(), err.Error())
 			response := fmtResponse(http.StatusInternalServerError, err.Error(), nil)
@@ -242,37 +377,71 @@ func beginLogin(
 			return
 		}
 
-		// challengeModifier := func(opt *protocol.PublicKeyCredentialRequestOptions) {
-		// 	// opt.Challenge = loginOpts.hashedTxIntent{
+		// return the options generated with the proof of the challenge
+		encodeJsonValue(w, http.StatusOK, challenge)
+	}
+}
+
+// discoverableLoginSession is the session of discoverable logins, which are
+// bound to a user only once the assertion's user handle names one.
+const discoverableLoginSession = "discoverable-login"
 
-		// }
-		// webAuthn.G
-		options, session, err := webAuthn.BeginLogin(user)
+func beginDiscoverableLogin(
+	config *Config,
+	datastore *database.DB,
+	sessionStore *SessionManager,
+	circuitRegistry *zk.Registry,
+	prover *zk.Prover,
+	log *logger.Logger,
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		loginOpts, err := decodeRequestBody[loginOptions](r)
 		if err != nil {
-			// Handle Error and return.
 			log.Logger.ErrorContext(r.Context(), err.Error())
 			response := fmtResponse(http.StatusInternalServerError, err.Error(), nil)
 			encodeJsonValue[Response](w, http.StatusInternalServerError, response)
 			return
 		}
-		// store the session values
-		sessionStore.SaveSession(w, r, session, fmt.Sprintf("%s-%s", user.ID, user.PasskeyUserID))
 
-		encodeJsonValue(w, http.StatusOK, options) // return the options generated
-		// optionpublicKey contain our registration options
+		// the challenge and its commitment belong to no user until the login
+		// completes
+		challenge, session, ok := beginLoginCeremony(w, r, config, datastore, circuitRegistry, prover, log, uuid.Nil, loginOpts,
+			func(webAuthn *webauthn.WebAuthn, opts ...webauthn.LoginOption) (*protocol.CredentialAssertion, *webauthn.SessionData, error) {
+				return webAuthn.BeginDiscoverableLogin(opts...)
+			})
+		if !ok {
+			return
+		}
+
+		err = sessionStore.SaveSession(w, r, session, discoverableLoginSession)
+		if err != nil {
+			log.Logger.ErrorContext(r.Context(), err.Error())
+			response := fmtResponse(http.StatusInternalServerError, err.Error(), nil)
+			encodeJsonValue[Response](w, http.StatusInternalServerError, response)
+			return
+		}
+		encodeJsonValue(w, http.StatusOK, challenge)
 	}
 }
 
-func finishLogin(
+// conditionalLoginSession is the session of conditional logins. It is kept
+// apart from the discoverable login session, as the autofill request of a page
+// stays pending while the user may log in otherwise.
+const conditionalLoginSession = "conditional-login"
+
+// beginConditionalLogin begins a discoverable login for conditional
+// mediation, where the browser offers the passkeys in the autofill of the
+// username field. The request is open until the user picks a passkey, so it
+// times out after config.ConditionalLoginTimeout rather than the WebAuthn
+// login timeout.
+func beginConditionalLogin(
 	config *Config,
 	datastore *database.DB,
 	sessionStore *SessionManager,
+	circuitRegistry *zk.Registry,
+	prover *zk.Prover,
 	log *logger.Logger,
 ) http.HandlerFunc {
-	type loginOptions struct {
-		userId  string
-		passkey string
-	}
 	return func(w http.ResponseWriter, r *http.Request) {
 		loginOpts, err := decodeRequestBody[loginOptions](r)
 		if err != nil {
@@ -281,15 +450,270 @@ func finishLogin(
 			encodeJsonValue[Response](w, http.StatusInternalServerError, response)
 			return
 		}
-		user, err := datastore.GetUser(loginOpts.userId)
+
+		challenge, session, ok := beginLoginCeremony(w, r, config, datastore, circuitRegistry, prover, log, uuid.Nil, loginOpts,
+			func(webAuthn *webauthn.WebAuthn, opts ...webauthn.LoginOption) (*protocol.CredentialAssertion, *webauthn.SessionData, error) {
+				options, session, err := webAuthn.BeginDiscoverableLogin(append(opts, withTimeout(config.ConditionalLoginTimeout))...)
+				if err != nil {
+					return nil, nil, err
+				}
+				session.Expires = time.Now().Add(config.ConditionalLoginTimeout)
+				return options, session, nil
+			})
+		if !ok {
+			return
+		}
+		// the library has no notion of mediation, the client passes it to
+		// navigator.credentials.get with the options
+		challenge.Mediation = "conditional"
+
+		err = sessionStore.SaveSession(w, r, session, conditionalLoginSession)
 		if err != nil {
 			log.Logger.ErrorContext(r.Context(), err.Error())
 			response := fmtResponse(http.StatusInternalServerError, err.Error(), nil)
 			encodeJsonValue[Response](w, http.StatusInternalServerError, response)
 			return
 		}
+		encodeJsonValue(w, http.StatusOK, challenge)
+	}
+}
+
+// beginLoginCeremony derives the challenge of a login from fresh randomness
+// and the intent, proves the derivation and begins the ceremony with it. The
+// challenge and the opening of its commitment are recorded for the user. It
+// writes the error response and returns false if the login can't begin.
+func beginLoginCeremony(
+	w http.ResponseWriter,
+	r *http.Request,
+	config *Config,
+	datastore *database.DB,
+	circuitRegistry *zk.Registry,
+	prover *zk.Prover,
+	log *logger.Logger,
+	userId uuid.UUID,
+	loginOpts loginOptions,
+	begin loginBeginner,
+) (*loginChallenge, *webauthn.SessionData, bool) {
+	intentHash, err := hex.DecodeString(strings.TrimPrefix(loginOpts.IntentHash, "0x"))
+	if err != nil {
+		log.Logger.ErrorContext(r.Context(), err.Error())
+		response := fmtResponse(http.StatusInternalServerError, err.Error(), nil)
+		encodeJsonValue[Response](w, http.StatusInternalServerError, response)
+		return nil, nil, false
+	}
+	nonce := loginOpts.Nonce
+
+	// derive the challenge from fresh randomness and the intent, and prove
+	// the derivation
+	challengeCircuit, err := zk.ChallengeCircuitDefinitionFor(config.ChallengeHash)
+	if err != nil {
+		log.Logger.ErrorContext(r.Context(), err.Error())
+		response := fmtResponse(http.StatusInternalServerError, err.Error(), nil)
+		encodeJsonValue[Response](w, http.StatusInternalServerError, response)
+		return nil, nil, false
+	}
+	challenge, err := zk.NewChallenge(challengeCircuit.Hash, intentHash, nonce)
+	if err != nil {
+		log.Logger.ErrorContext(r.Context(), err.Error())
+		response := fmtResponse(http.StatusInternalServerError, err.Error(), nil)
+		encodeJsonValue[Response](w, http.StatusInternalServerError, response)
+		return nil, nil, false
+	}
+	keys, err := circuitRegistry.ProvingKeys(challengeCircuit.ID)
+	if err != nil {
+		log.Logger.ErrorContext(r.Context(), err.Error())
+		response := fmtResponse(http.StatusInternalServerError, err.Error(), nil)
+		encodeJsonValue[Response](w, http.StatusInternalServerError, response)
+		return nil, nil, false
+	}
+	// the proof is abandoned if the client goes away
+	challengeProof, err := prover.Prove(r.Context(), keys, challenge.Assignment())
+	if errors.Is(err, zk.ErrProverBusy) || errors.Is(err, zk.ErrProverTimeout) {
+		log.Logger.ErrorContext(r.Context(), err.Error())
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(prover.RetryAfter().Seconds()))))
+		response := fmtResponse(http.StatusServiceUnavailable, err.Error(), nil)
+		encodeJsonValue[Response](w, http.StatusServiceUnavailable, response)
+		return nil, nil, false
+	}
+	if r.Context().Err() != nil {
+		log.Logger.InfoContext(r.Context(), fmt.Sprintf("challenge proof abandoned: %s", err))
+		return nil, nil, false
+	}
+	if err != nil {
+		log.Logger.ErrorContext(r.Context(), err.Error())
+		response := fmtResponse(http.StatusInternalServerError, err.Error(), nil)
+		encodeJsonValue[Response](w, http.StatusInternalServerError, response)
+		return nil, nil, false
+	}
+
+	webAuthn, err := webauthn.New(config.webauthn)
+	if err != nil {
+		log.Logger.ErrorContext(r.Context(), err.Error())
+		response := fmtResponse(http.StatusInternalServerError, err.Error(), nil)
+		encodeJsonValue[Response](w, http.StatusInternalServerError, response)
+		return nil, nil, false
+	}
+
+	options, session, err := begin(webAuthn, withChallenge(challenge.Bytes()))
+	if err != nil {
+		log.Logger.ErrorContext(r.Context(), err.Error())
+		response := fmtResponse(http.StatusInternalServerError, err.Error(), nil)
+		encodeJsonValue[Response](w, http.StatusInternalServerError, response)
+		return nil, nil, false
+	}
+	// BeginLogin records its own random challenge in the session
+	session.Challenge = options.Response.Challenge.String()
+
+	// keep the opening of the commitment to the challenge, the signature
+	// proof commits to the same challenge
+	challengeCommitment, err := datastore.AddCommitment(userId, models.CommitmentChallenge, challenge.Opening)
+	if err != nil {
+		log.Logger.ErrorContext(r.Context(), err.Error())
+		response := fmtResponse(http.StatusInternalServerError, err.Error(), nil)
+		encodeJsonValue[Response](w, http.StatusInternalServerError, response)
+		return nil, nil, false
+	}
 
-		session, err := sessionStore.GetSession(r, loginOpts.passkey)
+	commitment := challenge.Commitment.Bytes()
+	err = datastore.AddChallenge(userId, challenge.Bytes(), intentHash, commitment[:], nonce, string(challenge.Hash), challengeCommitment)
+	if err != nil {
+		log.Logger.ErrorContext(r.Context(), err.Error())
+		response := fmtResponse(http.StatusInternalServerError, err.Error(), nil)
+		encodeJsonValue[Response](w, http.StatusInternalServerError, response)
+		return nil, nil, false
+	}
+
+	return &loginChallenge{CredentialAssertion: options, ChallengeProof: challengeProof}, session, true
+}
+
+func listCircuits(circuitRegistry *zk.Registry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		encodeJsonValue(w, http.StatusOK, circuitRegistry.Circuits())
+	}
+}
+
+func getVerifyingKey(
+	circuitRegistry *zk.Registry,
+	log *logger.Logger,
+) http.HandlerFunc {
+	type verifyingKey struct {
+		Manifest     zk.Manifest `json:"manifest"`
+		VerifyingKey []byte      `json:"verifyingKey"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		params := mux.Vars(r)
+		circuitId := params["circuitId"]
+
+		keys, ok := circuitRegistry.Get(circuitId)
+		if !ok {
+			err := fmt.Errorf("unknown circuit %s", circuitId)
+			log.Logger.ErrorContext(r.Context(), err.Error())
+			response := fmtResponse(http.StatusNotFound, err.Error(), nil)
+			encodeJsonValue[Response](w, http.StatusNotFound, response)
+			return
+		}
+
+		var buf bytes.Buffer
+		if _, err := keys.VerifyingKey.WriteTo(&buf); err != nil {
+			log.Logger.ErrorContext(r.Context(), err.Error())
+			response := fmtResponse(http.StatusInternalServerError, err.Error(), nil)
+			encodeJsonValue[Response](w, http.StatusInternalServerError, response)
+			return
+		}
+		encodeJsonValue(w, http.StatusOK, verifyingKey{keys.Manifest, buf.Bytes()})
+	}
+}
+
+// loginResult is the proof job of a completed login.
+type loginResult struct {
+	JobID               string                    `json:"jobId"`
+	Status              string                    `json:"status"`
+	ClientDataJSON      protocol.URLEncodedBase64 `json:"clientDataJSON"`
+	IntentHash          string                    `json:"intentHash"`
+	Nonce               uint64                    `json:"nonce"`
+	Nullifier           string                    `json:"nullifier"`
+	SignatureCommitment string                    `json:"signatureCommitment"`
+	ChallengeCommitment string                    `json:"challengeCommitment"`
+	CircuitID           string                    `json:"circuitId"`
+	MerkleRoot          string                    `json:"merkleRoot,omitempty"` // root the membership of anonymous logins is proven against
+}
+
+func finishLogin(
+	config *Config,
+	datastore *database.DB,
+	sessionStore *SessionManager,
+	proofQueue *ProofQueue,
+	log *logger.Logger,
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		params := mux.Vars(r)
+		userId := params["userId"]
+
+		user, err := datastore.GetUser(userId)
+		if err != nil {
+			log.Logger.ErrorContext(r.Context(), err.Error())
+			response := fmtResponse(http.StatusInternalServerError, err.Error(), nil)
+			encodeJsonValue[Response](w, http.StatusInternalServerError, response)
+			return
+		}
+
+		session, err := sessionStore.GetSession(r, fmt.Sprintf("%s-%s", user.ID, user.PasskeyUserID))
+		if err != nil {
+			log.Logger.ErrorContext(r.Context(), err.Error())
+			response := fmtResponse(http.StatusInternalServerError, err.Error(), nil)
+			encodeJsonValue[Response](w, http.StatusInternalServerError, response)
+			return
+		}
+
+		webAuthn, err := webauthn.New(config.webauthn)
+		if err != nil {
+			log.Logger.ErrorContext(r.Context(), err.Error())
+			response := fmtResponse(http.StatusInternalServerError, err.Error(), nil)
+			encodeJsonValue[Response](w, http.StatusInternalServerError, response)
+			return
+		}
+
+		// the parsed assertion is kept to build the witness of the signature proof
+		assertion, err := protocol.ParseCredentialRequestResponse(r)
+		if err != nil {
+			log.Logger.ErrorContext(r.Context(), err.Error())
+			response := fmtResponse(http.StatusInternalServerError, err.Error(), nil)
+			encodeJsonValue[Response](w, http.StatusInternalServerError, response)
+			return
+		}
+		// locked credentials are not among the user's credentials to validate
+		// the login with
+		if user.CredentialLocked(assertion.RawID) {
+			log.Logger.ErrorContext(r.Context(), models.ErrCredentialLocked.Error())
+			response := fmtResponse(http.StatusForbidden, "credential is locked", nil)
+			encodeJsonValue[Response](w, http.StatusForbidden, response)
+			return
+		}
+		credential, err := webAuthn.ValidateLogin(user, *session, assertion)
+		if err != nil {
+			log.Logger.ErrorContext(r.Context(), err.Error())
+			response := fmtResponse(http.StatusInternalServerError, err.Error(), nil)
+			encodeJsonValue[Response](w, http.StatusInternalServerError, response)
+			return
+		}
+
+		completeLogin(w, r, config, datastore, proofQueue, log, user, session, assertion, credential)
+	}
+}
+
+// finishDiscoverableLogin completes a login begun without a user, with the
+// session of the given name: the user is the one whose WebAuthn user ID is
+// the assertion's user handle.
+func finishDiscoverableLogin(
+	config *Config,
+	datastore *database.DB,
+	sessionStore *SessionManager,
+	proofQueue *ProofQueue,
+	log *logger.Logger,
+	sessionName string,
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session, err := sessionStore.GetSession(r, sessionName)
 		if err != nil {
 			log.Logger.ErrorContext(r.Context(), err.Error())
 			response := fmtResponse(http.StatusInternalServerError, err.Error(), nil)
@@ -304,20 +728,548 @@ func finishLogin(
 			encodeJsonValue[Response](w, http.StatusInternalServerError, response)
 			return
 		}
-		credential, err := webAuthn.FinishLogin(user, *session, r)
+
+		assertion, err := protocol.ParseCredentialRequestResponse(r)
+		if err != nil {
+			log.Logger.ErrorContext(r.Context(), err.Error())
+			response := fmtResponse(http.StatusInternalServerError, err.Error(), nil)
+			encodeJsonValue[Response](w, http.StatusInternalServerError, response)
+			return
+		}
+		// the user is the one the authenticator returned the credential for.
+		// ValidatePasskeyLogin doesn't wrap the lookup error, so it is kept
+		// to tell an unknown user or a locked credential from a failure.
+		var lookupErr error
+		findUser := func(rawID, userHandle []byte) (webauthn.User, error) {
+			user, err := datastore.GetUserByWebAuthnID(userHandle)
+			if err != nil {
+				lookupErr = err
+				return nil, err
+			}
+			if user.CredentialLocked(rawID) {
+				lookupErr = models.ErrCredentialLocked
+				return nil, lookupErr
+			}
+			return user, nil
+		}
+		webAuthnUser, credential, err := webAuthn.ValidatePasskeyLogin(findUser, *session, assertion)
+		if errors.Is(lookupErr, gorm.ErrRecordNotFound) {
+			log.Logger.ErrorContext(r.Context(), err.Error())
+			response := fmtResponse(http.StatusUnauthorized, "unknown user handle", nil)
+			encodeJsonValue[Response](w, http.StatusUnauthorized, response)
+			return
+		}
+		if errors.Is(lookupErr, models.ErrCredentialLocked) {
+			log.Logger.ErrorContext(r.Context(), err.Error())
+			response := fmtResponse(http.StatusForbidden, "credential is locked", nil)
+			encodeJsonValue[Response](w, http.StatusForbidden, response)
+			return
+		}
+		if err != nil {
+			log.Logger.ErrorContext(r.Context(), err.Error())
+			response := fmtResponse(http.StatusInternalServerError, err.Error(), nil)
+			encodeJsonValue[Response](w, http.StatusInternalServerError, response)
+			return
+		}
+		user := webAuthnUser.(*models.User)
+
+		// the challenge and its commitment were stored before the user was
+		// known
+		if err := datastore.AssignChallenge(session.Challenge, user.ID); err != nil {
+			log.Logger.ErrorContext(r.Context(), err.Error())
+			response := fmtResponse(http.StatusInternalServerError, err.Error(), nil)
+			encodeJsonValue[Response](w, http.StatusInternalServerError, response)
+			return
+		}
+
+		completeLogin(w, r, config, datastore, proofQueue, log, user, session, assertion, credential)
+	}
+}
+
+// completeLogin records the use of the validated credential, and queues the
+// proof that it signed the challenge derived for the intent of the session.
+func completeLogin(
+	w http.ResponseWriter,
+	r *http.Request,
+	config *Config,
+	datastore *database.DB,
+	proofQueue *ProofQueue,
+	log *logger.Logger,
+	user *models.User,
+	session *webauthn.SessionData,
+	assertion *protocol.ParsedCredentialAssertionData,
+	credential *webauthn.Credential,
+) {
+	// the sign count and flags are recorded, and a suspected clone handled
+	// as the clone policy says, before anything is proven
+	if err := datastore.UpdateCredential(credential, config.ClonePolicy); err != nil {
+		log.Logger.ErrorContext(r.Context(), err.Error())
+		if errors.Is(err, models.ErrCredentialLocked) {
+			response := fmtResponse(http.StatusForbidden, "credential is locked", nil)
+			encodeJsonValue[Response](w, http.StatusForbidden, response)
+			return
+		}
+		response := fmtResponse(http.StatusInternalServerError, err.Error(), nil)
+		encodeJsonValue[Response](w, http.StatusInternalServerError, response)
+		return
+	}
+
+	// the challenge was derived for an intent when the login began
+	intent, err := datastore.GetChallenge(session.Challenge)
+	if err != nil {
+		log.Logger.ErrorContext(r.Context(), err.Error())
+		response := fmtResponse(http.StatusInternalServerError, err.Error(), nil)
+		encodeJsonValue[Response](w, http.StatusInternalServerError, response)
+		return
+	}
+
+	challengeOpening, err := datastore.GetOpening(intent.ChallengeCommitment)
+	if err != nil {
+		log.Logger.ErrorContext(r.Context(), err.Error())
+		response := fmtResponse(http.StatusInternalServerError, err.Error(), nil)
+		encodeJsonValue[Response](w, http.StatusInternalServerError, response)
+		return
+	}
+
+	intentHash, err := hex.DecodeString(intent.IntentHash)
+	if err != nil {
+		log.Logger.ErrorContext(r.Context(), err.Error())
+		response := fmtResponse(http.StatusInternalServerError, err.Error(), nil)
+		encodeJsonValue[Response](w, http.StatusInternalServerError, response)
+		return
+	}
+	commitment, err := hex.DecodeString(intent.Commitment)
+	if err != nil {
+		log.Logger.ErrorContext(r.Context(), err.Error())
+		response := fmtResponse(http.StatusInternalServerError, err.Error(), nil)
+		encodeJsonValue[Response](w, http.StatusInternalServerError, response)
+		return
+	}
+	// each intent is proven once, whichever of the user's credentials and
+	// circuit versions proves it, so that it is refused before keeping the
+	// opening of the signature commitment of a proof that would not be queued
+	_, err = datastore.GetIntentNullifier(user.ID, intent.IntentHash, intent.Nonce)
+	if err == nil {
+		err = fmt.Errorf("intent %s with nonce %d: %w", intent.IntentHash, intent.Nonce, models.ErrNullifierExists)
+		log.Logger.ErrorContext(r.Context(), err.Error())
+		response := fmtResponse(http.StatusConflict, fmt.Sprintf("intent with nonce %d was already proven", intent.Nonce), nil)
+		encodeJsonValue[Response](w, http.StatusConflict, response)
+		return
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		log.Logger.ErrorContext(r.Context(), err.Error())
+		response := fmtResponse(http.StatusInternalServerError, err.Error(), nil)
+		encodeJsonValue[Response](w, http.StatusInternalServerError, response)
+		return
+	}
+
+	// the nullifier is derived from the credential's secret
+	secret, err := datastore.GetCredentialSecret(credential.ID)
+	if err != nil {
+		log.Logger.ErrorContext(r.Context(), err.Error())
+		response := fmtResponse(http.StatusInternalServerError, err.Error(), nil)
+		encodeJsonValue[Response](w, http.StatusInternalServerError, response)
+		return
+	}
+	assertionWitness, err := witness.New(credential, secret, assertion, witness.Intent{
+		Hash:       zk.Hash(intent.Hash),
+		IntentHash: intentHash,
+		Nonce:      intent.Nonce,
+		Commitment: commitment,
+		Challenge:  challengeOpening,
+	}, config.webauthn.RPOrigins)
+	if err != nil {
+		log.Logger.ErrorContext(r.Context(), err.Error())
+		response := fmtResponse(http.StatusInternalServerError, err.Error(), nil)
+		encodeJsonValue[Response](w, http.StatusInternalServerError, response)
+		return
+	}
+	nullifier := zk.EncodeNullifier(assertionWitness.Nullifier)
+	// the circuits hash as the challenge was derived, and anonymous logins
+	// prove that the credential is in the tree of credentials instead of
+	// disclosing its public key
+	assertionCircuit, err := zk.AssertionCircuitDefinitionFor(zk.Hash(intent.Hash))
+	if err != nil {
+		log.Logger.ErrorContext(r.Context(), err.Error())
+		response := fmtResponse(http.StatusInternalServerError, err.Error(), nil)
+		encodeJsonValue[Response](w, http.StatusInternalServerError, response)
+		return
+	}
+	circuitId := assertionCircuit.ID
+	proofWitness := assertionWitness.Full
+	var merkleRoot string
+	if r.URL.Query().Get("anonymous") == "true" {
+		membershipCircuit, err := zk.MembershipCircuitDefinitionFor(zk.Hash(intent.Hash))
+		if err != nil {
+			log.Logger.ErrorContext(r.Context(), err.Error())
+			response := fmtResponse(http.StatusInternalServerError, err.Error(), nil)
+			encodeJsonValue[Response](w, http.StatusInternalServerError, response)
+			return
+		}
+		merkleProof, err := datastore.GetMerkleProof(membershipCircuit.Hash, credential.ID)
 		if err != nil {
 			log.Logger.ErrorContext(r.Context(), err.Error())
 			response := fmtResponse(http.StatusInternalServerError, err.Error(), nil)
 			encodeJsonValue[Response](w, http.StatusInternalServerError, response)
 			return
 		}
+		membershipWitness, err := assertionWitness.Membership(credential, merkleProof)
+		if err != nil {
+			log.Logger.ErrorContext(r.Context(), err.Error())
+			response := fmtResponse(http.StatusInternalServerError, err.Error(), nil)
+			encodeJsonValue[Response](w, http.StatusInternalServerError, response)
+			return
+		}
+		circuitId = membershipCircuit.ID
+		proofWitness = membershipWitness.Full
+		merkleRoot = zk.EncodeMerkleRoot(membershipWitness.Root)
+	}
+	signatureCommitment, err := datastore.AddCommitment(user.ID, models.CommitmentSignature, assertionWitness.SignatureOpening)
+	if err != nil {
+		log.Logger.ErrorContext(r.Context(), err.Error())
+		response := fmtResponse(http.StatusInternalServerError, err.Error(), nil)
+		encodeJsonValue[Response](w, http.StatusInternalServerError, response)
+		return
+	}
+	// proving takes too long to hold the request, the client polls the job.
+	// The nullifier is still refused if a concurrent login issued it, or
+	// proved the intent, meanwhile.
+	job, err := proofQueue.Enqueue(user.ID, circuitId, proofWitness, &models.Nullifier{
+		Nullifier:    nullifier,
+		UserID:       user.ID,
+		CredentialID: base64.RawURLEncoding.EncodeToString(credential.ID),
+		IntentHash:   intent.IntentHash,
+		Nonce:        intent.Nonce,
+	})
+	if errors.Is(err, models.ErrNullifierExists) {
+		log.Logger.ErrorContext(r.Context(), err.Error())
+		response := fmtResponse(http.StatusConflict, fmt.Sprintf("intent with nonce %d was already proven", intent.Nonce), nil)
+		encodeJsonValue[Response](w, http.StatusConflict, response)
+		return
+	}
+	if err != nil {
+		log.Logger.ErrorContext(r.Context(), err.Error())
+		response := fmtResponse(http.StatusInternalServerError, err.Error(), nil)
+		encodeJsonValue[Response](w, http.StatusInternalServerError, response)
+		return
+	}
+
+	encodeJsonValue(w, http.StatusAccepted, loginResult{
+		JobID:          job.ID.String(),
+		Status:         job.Status,
+		ClientDataJSON: assertion.Raw.AssertionResponse.ClientDataJSON,
+		IntentHash:     intent.IntentHash,
+		Nonce:          intent.Nonce,
+		Nullifier:      nullifier,
+
+		SignatureCommitment: signatureCommitment,
+		ChallengeCommitment: intent.ChallengeCommitment,
+		CircuitID:           circuitId,
+		MerkleRoot:          merkleRoot,
+	})
+}
+
+func getNullifier(
+	datastore *database.DB,
+	log *logger.Logger,
+) http.HandlerFunc {
+	type nullifierRecord struct {
+		Nullifier  string    `json:"nullifier"`
+		IntentHash string    `json:"intentHash"`
+		Nonce      uint64    `json:"nonce"`
+		JobID      string    `json:"jobId"`
+		IssuedAt   time.Time `json:"issuedAt"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		params := mux.Vars(r)
+
+		// nullifiers are recorded in their canonical encoding
+		n, err := zk.DecodeNullifier(params["nullifier"])
+		if err != nil {
+			log.Logger.ErrorContext(r.Context(), err.Error())
+			response := fmtResponse(http.StatusBadRequest, err.Error(), nil)
+			encodeJsonValue[Response](w, http.StatusBadRequest, response)
+			return
+		}
+		nullifier := zk.EncodeNullifier(n)
 
-		// Handle credential.Authenticator.CloneWarning
+		record, err := datastore.GetNullifier(nullifier)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			log.Logger.ErrorContext(r.Context(), err.Error())
+			response := fmtResponse(http.StatusNotFound, fmt.Sprintf("nullifier %s was not issued", nullifier), nil)
+			encodeJsonValue[Response](w, http.StatusNotFound, response)
+			return
+		}
+		if err != nil {
+			log.Logger.ErrorContext(r.Context(), err.Error())
+			response := fmtResponse(http.StatusInternalServerError, err.Error(), nil)
+			encodeJsonValue[Response](w, http.StatusInternalServerError, response)
+			return
+		}
+		encodeJsonValue(w, http.StatusOK, nullifierRecord{
+			Nullifier:  record.Nullifier,
+			IntentHash: record.IntentHash,
+			Nonce:      record.Nonce,
+			JobID:      record.ProofJobID.String(),
+			IssuedAt:   record.CreatedAt,
+		})
+	}
+}
 
-		// If login was successful, update the credential object
-		// Pseudocode to update the user credential.
-		user.UpdateCredential(credential)
-		encodeJsonValue(w, http.StatusOK, "Login Success")
+// authorizeAdmin checks that the request carries the admin token, and writes
+// the error response if not.
+func authorizeAdmin(w http.ResponseWriter, r *http.Request, config *Config, log *logger.Logger) bool {
+	if config.AdminToken == "" {
+		log.Logger.ErrorContext(r.Context(), "admin routes are disabled")
+		response := fmtResponse(http.StatusForbidden, "admin routes are disabled", nil)
+		encodeJsonValue[Response](w, http.StatusForbidden, response)
+		return false
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(config.AdminToken)) != 1 {
+		log.Logger.ErrorContext(r.Context(), "invalid admin token")
+		response := fmtResponse(http.StatusUnauthorized, "invalid admin token", nil)
+		encodeJsonValue[Response](w, http.StatusUnauthorized, response)
+		return false
+	}
+	return true
+}
+
+func revokeCredential(
+	config *Config,
+	datastore *database.DB,
+	log *logger.Logger,
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !authorizeAdmin(w, r, config, log) {
+			return
+		}
+		params := mux.Vars(r)
+
+		credentialId, err := base64.RawURLEncoding.DecodeString(params["credentialId"])
+		if err != nil {
+			log.Logger.ErrorContext(r.Context(), err.Error())
+			response := fmtResponse(http.StatusBadRequest, fmt.Sprintf("invalid credential id: %s", err), nil)
+			encodeJsonValue[Response](w, http.StatusBadRequest, response)
+			return
+		}
+		err = datastore.RevokeCredential(credentialId)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			log.Logger.ErrorContext(r.Context(), err.Error())
+			response := fmtResponse(http.StatusNotFound, fmt.Sprintf("unknown credential %s", params["credentialId"]), nil)
+			encodeJsonValue[Response](w, http.StatusNotFound, response)
+			return
+		}
+		if err != nil {
+			log.Logger.ErrorContext(r.Context(), err.Error())
+			response := fmtResponse(http.StatusInternalServerError, err.Error(), nil)
+			encodeJsonValue[Response](w, http.StatusInternalServerError, response)
+			return
+		}
+		response := fmtResponse(http.StatusOK, "credential revoked", nil)
+		encodeJsonValue[Response](w, http.StatusOK, response)
+	}
+}
+
+// merkleRoot is a root of the tree of credentials.
+type merkleRoot struct {
+	Root      string     `json:"root"`
+	LeafCount int64      `json:"leafCount"`
+	CreatedAt *time.Time `json:"createdAt,omitempty"`
+}
+
+func newMerkleRoot(root models.MerkleRoot) merkleRoot {
+	r := merkleRoot{Root: root.Root, LeafCount: root.LeafCount}
+	if !root.CreatedAt.IsZero() {
+		r.CreatedAt = &root.CreatedAt
+	}
+	return r
+}
+
+// maxMerkleRoots bounds the number of roots returned by listMerkleRoots.
+const maxMerkleRoots = 1000
+
+// merkleTreeHash returns the hash of the tree of credentials a request is for,
+// which defaults to the hash challenges are derived with.
+func merkleTreeHash(r *http.Request, config *Config) (zk.Hash, error) {
+	v := r.URL.Query().Get("hash")
+	if v == "" {
+		return config.ChallengeHash, nil
+	}
+	return zk.ParseHash(v)
+}
+
+func getMerkleRoot(
+	config *Config,
+	datastore *database.DB,
+	log *logger.Logger,
+) http.HandlerFunc {
+	type currentRoot struct {
+		merkleRoot
+		Hash  zk.Hash `json:"hash"`
+		Depth int     `json:"depth"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		h, err := merkleTreeHash(r, config)
+		if err != nil {
+			log.Logger.ErrorContext(r.Context(), err.Error())
+			response := fmtResponse(http.StatusBadRequest, err.Error(), nil)
+			encodeJsonValue[Response](w, http.StatusBadRequest, response)
+			return
+		}
+		root, err := datastore.GetMerkleRoot(h)
+		if err != nil {
+			log.Logger.ErrorContext(r.Context(), err.Error())
+			response := fmtResponse(http.StatusInternalServerError, err.Error(), nil)
+			encodeJsonValue[Response](w, http.StatusInternalServerError, response)
+			return
+		}
+		encodeJsonValue(w, http.StatusOK, currentRoot{newMerkleRoot(*root), h, zk.MerkleDepth})
+	}
+}
+
+func listMerkleRoots(
+	config *Config,
+	datastore *database.DB,
+	log *logger.Logger,
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		h, err := merkleTreeHash(r, config)
+		if err != nil {
+			log.Logger.ErrorContext(r.Context(), err.Error())
+			response := fmtResponse(http.StatusBadRequest, err.Error(), nil)
+			encodeJsonValue[Response](w, http.StatusBadRequest, response)
+			return
+		}
+		limit := 100
+		if v := r.URL.Query().Get("limit"); v != "" {
+			var err error
+			if limit, err = strconv.Atoi(v); err != nil || limit < 1 || limit > maxMerkleRoots {
+				err := fmt.Errorf("invalid limit %q, expected 1 to %d", v, maxMerkleRoots)
+				log.Logger.ErrorContext(r.Context(), err.Error())
+				response := fmtResponse(http.StatusBadRequest, err.Error(), nil)
+				encodeJsonValue[Response](w, http.StatusBadRequest, response)
+				return
+			}
+		}
+
+		roots, err := datastore.GetMerkleRoots(h, limit)
+		if err != nil {
+			log.Logger.ErrorContext(r.Context(), err.Error())
+			response := fmtResponse(http.StatusInternalServerError, err.Error(), nil)
+			encodeJsonValue[Response](w, http.StatusInternalServerError, response)
+			return
+		}
+		history := make([]merkleRoot, 0, len(roots))
+		for _, root := range roots {
+			history = append(history, newMerkleRoot(root))
+		}
+		encodeJsonValue(w, http.StatusOK, history)
+	}
+}
+
+func getProofJob(
+	datastore *database.DB,
+	log *logger.Logger,
+) http.HandlerFunc {
+	type proofJob struct {
+		ID         string     `json:"id"`
+		CircuitID  string     `json:"circuitId"`
+		Status     string     `json:"status"`
+		Proof      *zk.Proof  `json:"proof,omitempty"`
+		Error      string     `json:"error,omitempty"`
+		CreatedAt  time.Time  `json:"createdAt"`
+		StartedAt  *time.Time `json:"startedAt,omitempty"`
+		FinishedAt *time.Time `json:"finishedAt,omitempty"`
+
+		// set when the job was proven in a batch: the public inputs of every
+		// assertion of the batch, and the position of this job's
+		BatchAssertions [][]string `json:"batchAssertions,omitempty"`
+		BatchIndex      *int       `json:"batchIndex,omitempty"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		params := mux.Vars(r)
+		jobId := params["jobId"]
+
+		job, err := datastore.GetProofJob(jobId)
+		if err != nil {
+			log.Logger.ErrorContext(r.Context(), err.Error())
+			response := fmtResponse(http.StatusNotFound, fmt.Sprintf("unknown proof job %s", jobId), nil)
+			encodeJsonValue[Response](w, http.StatusNotFound, response)
+			return
+		}
+
+		result := proofJob{
+			ID:         job.ID.String(),
+			CircuitID:  job.CircuitID,
+			Status:     job.Status,
+			Error:      job.Error,
+			CreatedAt:  job.CreatedAt,
+			StartedAt:  job.StartedAt,
+			FinishedAt: job.FinishedAt,
+		}
+		if job.Status == models.ProofJobDone {
+			var proof zk.BatchProof
+			if err := json.Unmarshal(job.Proof, &proof); err != nil {
+				log.Logger.ErrorContext(r.Context(), err.Error())
+				response := fmtResponse(http.StatusInternalServerError, err.Error(), nil)
+				encodeJsonValue[Response](w, http.StatusInternalServerError, response)
+				return
+			}
+			result.Proof = &proof.Proof
+			if job.BatchID != nil {
+				result.BatchAssertions = proof.Assertions
+				result.BatchIndex = &job.BatchIndex
+			}
+		}
+		encodeJsonValue(w, http.StatusOK, result)
+	}
+}
+
+// Reasons a proof is rejected by verifyProof.
+const (
+	reasonUnknownKey      = "unknown_key"
+	reasonMalformedInputs = "malformed_inputs"
+	reasonPairingFailed   = "pairing_failed"
+)
+
+func verifyProof(
+	circuitRegistry *zk.Registry,
+	log *logger.Logger,
+) http.HandlerFunc {
+	type verification struct {
+		Valid     bool   `json:"valid"`
+		CircuitID string `json:"circuitId"`
+		Reason    string `json:"reason,omitempty"`
+		Message   string `json:"message,omitempty"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		proof, err := decodeRequestBody[zk.Proof](r)
+		if err != nil {
+			log.Logger.ErrorContext(r.Context(), err.Error())
+			response := fmtResponse(http.StatusBadRequest, err.Error(), nil)
+			encodeJsonValue[Response](w, http.StatusBadRequest, response)
+			return
+		}
+
+		result := verification{Valid: true, CircuitID: proof.CircuitID}
+		if err := circuitRegistry.Verify(&proof); err != nil {
+			result = verification{CircuitID: proof.CircuitID, Message: err.Error()}
+			switch {
+			case errors.Is(err, zk.ErrUnknownKey):
+				result.Reason = reasonUnknownKey
+			case errors.Is(err, zk.ErrMalformedProof):
+				result.Reason = reasonMalformedInputs
+			case errors.Is(err, zk.ErrVerificationFailed):
+				result.Reason = reasonPairingFailed
+			default:
+				log.Logger.ErrorContext(r.Context(), err.Error())
+				response := fmtResponse(http.StatusInternalServerError, err.Error(), nil)
+				encodeJsonValue[Response](w, http.StatusInternalServerError, response)
+				return
+			}
+		}
+		encodeJsonValue(w, http.StatusOK, result)
 	}
 }
 
@@ -338,26 +1290,16 @@ func decodeRequestBody[T any](r *http.Request) (T, error) {
 	return v, nil
 }
 
-func createChallenge(hashedTx []byte, nonce int) (challenge protocol.URLEncodedBase64, err error) {
-	challenge = make([]byte, protocol.ChallengeLength)
-	copy(challenge, hashedTx)
-	nonceBytes := make([]byte, 4)
-	binary.BigEndian.PutUint32(nonceBytes, uint32(nonce))
-	copy(challenge[len(hashedTx):], nonceBytes)
-	if _, err = rand.Read(challenge); err != nil {
-		return nil, err
+// withTimeout sets the time the client waits for the user to complete a login.
+func withTimeout(timeout time.Duration) webauthn.LoginOption {
+	return func(opts *protocol.PublicKeyCredentialRequestOptions) {
+		opts.Timeout = int(timeout.Milliseconds())
 	}
-
-	return challenge, nil
 }
-func createSecureChallenge(hashedTx []byte, nonce int) (protocol.URLEncodedBase64, error) {
-	challenge := make([]byte, protocol.ChallengeLength)
-	copy(challenge, hashedTx)
-	nonceBytes := make([]byte, 4)
-	binary.BigEndian.PutUint32(nonceBytes, uint32(nonce))
-	copy(challenge[len(hashedTx):], nonceBytes)
-	if _, err := rand.Read(challenge[len(hashedTx)+len(nonceBytes):]); err != nil {
-		return nil, err
+
+// withChallenge replaces the random challenge generated by BeginLogin.
+func withChallenge(challenge []byte) webauthn.LoginOption {
+	return func(opts *protocol.PublicKeyCredentialRequestOptions) {
+		opts.Challenge = challenge
 	}
-	return challenge, nil
 }
@@ -2,17 +2,35 @@ package server
 
 import (
 	"net/http"
+	"time"
 
 	"github.com/go-webauthn/webauthn/webauthn"
 	"github.com/gorilla/mux"
 	data "github.com/olawolu/zk-pass/database"
 	"github.com/olawolu/zk-pass/logger"
+	"github.com/olawolu/zk-pass/zk"
 )
 
 type Config struct {
 	Host     string
 	Port     string
 	webauthn *webauthn.Config
+
+	// ChallengeHash selects the challenge derivation circuit of new logins.
+	// It defaults to MiMC.
+	ChallengeHash zk.Hash
+
+	// ClonePolicy is what a login does when the credential may have been
+	// cloned. It defaults to flagging the credential.
+	ClonePolicy data.ClonePolicy
+
+	// ConditionalLoginTimeout is how long a conditional mediation login stays
+	// open for the user to pick a passkey. It defaults to 10 minutes.
+	ConditionalLoginTimeout time.Duration
+
+	// AdminToken is the bearer token of the administration routes, such as
+	// revoking a credential. They are disabled when it is empty.
+	AdminToken string
 }
 
 // ServerConfig creates a new server configuration with the provided parameters.
@@ -39,9 +57,13 @@ func ServerConfig(
 		RPOrigins:     rpOrigins,
 	}
 	return &Config{
-		Host:     host,
-		Port:     port,
-		webauthn: wconfig,
+		Host:          host,
+		Port:          port,
+		webauthn:      wconfig,
+		ChallengeHash: zk.HashMiMC,
+		ClonePolicy:   data.ClonePolicyFlag,
+
+		ConditionalLoginTimeout: 10 * time.Minute,
 	}
 }
 
@@ -50,9 +72,12 @@ func NewServer(
 	logger *logger.Logger,
 	datastore *data.DB,
 	sessionStore *SessionManager,
+	circuitRegistry *zk.Registry,
+	prover *zk.Prover,
+	proofQueue *ProofQueue,
 ) http.Handler {
 	mux := mux.NewRouter()
-	initRoutes(mux, config, datastore, sessionStore, logger)
+	initRoutes(mux, config, datastore, sessionStore, circuitRegistry, prover, proofQueue, logger)
 
 	var handler http.Handler = mux
 	// add some middleware
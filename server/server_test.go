@@ -1,16 +1,23 @@
 package server
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
+	"github.com/go-webauthn/webauthn/protocol"
 	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/gorilla/mux"
 	"github.com/gorilla/sessions"
 	"github.com/olawolu/zk-pass/database"
 	"github.com/olawolu/zk-pass/logger"
+	"github.com/olawolu/zk-pass/zk"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestServerConfig(t *testing.T) {
@@ -89,12 +96,26 @@ func TestNewServer(t *testing.T) {
 				"/register/finish/{userId}",
 				"/login/initiate/{userId}",
 				"/login/finish/{userId}",
+				"/login/discoverable/initiate",
+				"/login/discoverable/finish",
+				"/login/conditional/initiate",
+				"/login/conditional/finish",
+				"/proofs/{jobId}",
+				"/proofs/verify",
+				"/nullifiers/{nullifier}",
+				"/credentials/{credentialId}",
+				"/merkle/root",
+				"/merkle/roots",
+				"/zk/circuits",
 			},
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			handler := NewServer(tt.config, tt.logger, tt.db, tt.sessionStore)
+			registry := zk.NewRegistry(zk.NewKeyStore(t.TempDir()))
+			prover := zk.NewProver(1, 1, time.Minute)
+			defer prover.Close()
+			handler := NewServer(tt.config, tt.logger, tt.db, tt.sessionStore, registry, prover, NewProofQueue(tt.db, registry, prover, tt.logger))
 
 			// Test that handler is created
 			assert.NotNil(t, handler)
@@ -112,6 +133,169 @@ func TestNewServer(t *testing.T) {
 	}
 }
 
+func TestVerifyProof(t *testing.T) {
+	registry := zk.NewRegistry(zk.NewKeyStore(t.TempDir()))
+	keys, err := registry.Register(zk.ChallengeCircuitDefinition, zk.StatusActive)
+	require.NoError(t, err)
+	challenge, err := zk.NewChallenge(zk.HashMiMC, make([]byte, zk.IntentHashLen), 1)
+	require.NoError(t, err)
+	proof, err := zk.Prove(keys, challenge.Assignment())
+	require.NoError(t, err)
+
+	_, testLogger, _ := createTestServer()
+	handler := verifyProof(registry, testLogger)
+
+	tests := []struct {
+		name   string
+		tamper func(p *zk.Proof)
+		valid  bool
+		reason string
+	}{
+		{
+			name:   "valid proof",
+			tamper: func(p *zk.Proof) {},
+			valid:  true,
+		},
+		{
+			name:   "unknown circuit",
+			tamper: func(p *zk.Proof) { p.CircuitID = "unknown" },
+			reason: reasonUnknownKey,
+		},
+		{
+			name:   "malformed public inputs",
+			tamper: func(p *zk.Proof) { p.PublicInputs = p.PublicInputs[1:] },
+			reason: reasonMalformedInputs,
+		},
+		{
+			name:   "wrong seed commitment",
+			tamper: func(p *zk.Proof) { p.PublicInputs = append([]string{fmt.Sprintf("0x%064x", 1)}, p.PublicInputs[1:]...) },
+			reason: reasonPairingFailed,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tampered := *proof
+			tampered.PublicInputs = append([]string(nil), proof.PublicInputs...)
+			tt.tamper(&tampered)
+			body, err := json.Marshal(tampered)
+			require.NoError(t, err)
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/proofs/verify", bytes.NewReader(body)))
+			require.Equal(t, http.StatusOK, w.Code)
+
+			var result struct {
+				Valid  bool   `json:"valid"`
+				Reason string `json:"reason"`
+			}
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&result))
+			assert.Equal(t, tt.valid, result.Valid)
+			assert.Equal(t, tt.reason, result.Reason)
+		})
+	}
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/proofs/verify", bytes.NewReader([]byte("{"))))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestRevokeCredentialAuthorization(t *testing.T) {
+	config, testLogger, testDB := createTestServer()
+	tests := []struct {
+		name          string
+		adminToken    string
+		authorization string
+		code          int
+	}{
+		{
+			name:          "disabled",
+			authorization: "Bearer ",
+			code:          http.StatusForbidden,
+		},
+		{
+			name:       "missing token",
+			adminToken: "secret",
+			code:       http.StatusUnauthorized,
+		},
+		{
+			name:          "wrong token",
+			adminToken:    "secret",
+			authorization: "Bearer guess",
+			code:          http.StatusUnauthorized,
+		},
+		{
+			name:          "invalid credential id",
+			adminToken:    "secret",
+			authorization: "Bearer secret",
+			code:          http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := *config
+			c.AdminToken = tt.adminToken
+			handler := revokeCredential(&c, testDB, testLogger)
+
+			r := httptest.NewRequest(http.MethodDelete, "/credentials/not+base64url", nil)
+			r = mux.SetURLVars(r, map[string]string{"credentialId": "not+base64url"})
+			if tt.authorization != "" {
+				r.Header.Set("Authorization", tt.authorization)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			assert.Equal(t, tt.code, w.Code)
+		})
+	}
+}
+
+func TestSessionManager(t *testing.T) {
+	sessionStore := NewSessionManager(sessions.NewCookieStore([]byte("session hash key")))
+	session := &webauthn.SessionData{
+		Challenge:        "challenge",
+		RelyingPartyID:   "localhost",
+		Expires:          time.Now().Add(time.Minute).Round(0),
+		UserVerification: protocol.VerificationPreferred,
+	}
+
+	w := httptest.NewRecorder()
+	require.NoError(t, sessionStore.SaveSession(w, httptest.NewRequest(http.MethodPost, "/login/discoverable/initiate", nil), session, discoverableLoginSession))
+
+	r := httptest.NewRequest(http.MethodPost, "/login/discoverable/finish", nil)
+	for _, cookie := range w.Result().Cookies() {
+		r.AddCookie(cookie)
+	}
+	got, err := sessionStore.GetSession(r, discoverableLoginSession)
+	require.NoError(t, err)
+	assert.Equal(t, session.Challenge, got.Challenge)
+	assert.Equal(t, session.Expires, got.Expires)
+	assert.Equal(t, session.UserVerification, got.UserVerification)
+	// discoverable logins must not be bound to a user
+	assert.Nil(t, got.UserID)
+
+	_, err = sessionStore.GetSession(httptest.NewRequest(http.MethodPost, "/login/discoverable/finish", nil), discoverableLoginSession)
+	assert.Error(t, err)
+}
+
+func TestConditionalLoginOptions(t *testing.T) {
+	config, _, _ := createTestServer()
+	assert.Equal(t, 10*time.Minute, config.ConditionalLoginTimeout)
+	webAuthn, err := webauthn.New(config.webauthn)
+	require.NoError(t, err)
+
+	options, session, err := webAuthn.BeginDiscoverableLogin(withChallenge([]byte("challenge")), withTimeout(config.ConditionalLoginTimeout))
+	require.NoError(t, err)
+	assert.Equal(t, 600000, options.Response.Timeout)
+	assert.Empty(t, options.Response.AllowedCredentials)
+	assert.Nil(t, session.UserID)
+
+	b, err := json.Marshal(loginChallenge{CredentialAssertion: options, Mediation: "conditional"})
+	require.NoError(t, err)
+	var got map[string]any
+	require.NoError(t, json.Unmarshal(b, &got))
+	assert.Equal(t, "conditional", got["mediation"])
+	assert.Contains(t, got, "publicKey")
+}
+
 // Test helpers
 func createTestServer() (*Config, *logger.Logger, *database.DB) {
 	config := ServerConfig(
@@ -1,6 +1,7 @@
 package server
 
 import (
+	"encoding/gob"
 	"fmt"
 	"net/http"
 	"time"
@@ -10,6 +11,15 @@ import (
 	"github.com/gorilla/sessions"
 )
 
+// the session values are stored gob encoded, which needs the concrete types
+// held in them registered
+func init() {
+	gob.Register([][]byte{})
+	gob.Register(time.Time{})
+	gob.Register(protocol.UserVerificationRequirement(""))
+	gob.Register(protocol.AuthenticationExtensions{})
+}
+
 type SessionManager struct {
 	store sessions.Store
 }
@@ -25,6 +35,9 @@ func (sm *SessionManager) GetSession(r *http.Request, key string) (*webauthn.Ses
 		err = fmt.Errorf("failed to get session: %w", err)
 		return nil, err
 	}
+	if session.IsNew {
+		return nil, fmt.Errorf("no ceremony in progress for session %s", key)
+	}
 
 	return storeValueToSessionData(session.Values), nil
 }
@@ -54,14 +67,25 @@ func (sm *SessionManager) SaveSession(w http.ResponseWriter, r *http.Request, se
 
 // func sessionDataToStoreValues(sessionData *webauthn.SessionData) values map[interface{}]interface{}
 func storeValueToSessionData(values map[interface{}]interface{}) *webauthn.SessionData {
-	// userVerification, _ :=
+	challenge, _ := values["challenge"].(string)
+	rp, _ := values["rp"].(string)
+	userId, _ := values["user_id"].([]byte)
+	allowedCredentials, _ := values["allowed_creds"].([][]byte)
+	expires, _ := values["expires"].(time.Time)
+	userVerification, _ := values["user_ver"].(protocol.UserVerificationRequirement)
+	extensions, _ := values["extensions"].(protocol.AuthenticationExtensions)
+	// discoverable logins have no user, which the store may decode as an
+	// empty ID
+	if len(userId) == 0 {
+		userId = nil
+	}
 	return &webauthn.SessionData{
-		Challenge:            values["challenge"].(string),
-		RelyingPartyID:       values["rp"].(string),
-		UserID:               values["user_id"].([]byte),
-		AllowedCredentialIDs: values["allowed_creds"].([][]byte),
-		Expires:              values["expires"].(time.Time),
-		UserVerification:     values["user_ver"].(protocol.UserVerificationRequirement),
-		Extensions:           values["extensions"].(protocol.AuthenticationExtensions),
+		Challenge:            challenge,
+		RelyingPartyID:       rp,
+		UserID:               userId,
+		AllowedCredentialIDs: allowedCredentials,
+		Expires:              expires,
+		UserVerification:     userVerification,
+		Extensions:           extensions,
 	}
 }
@@ -0,0 +1,318 @@
+package zk
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"slices"
+
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/std/algebra/emulated/sw_emulated"
+	"github.com/consensys/gnark/std/hash/sha2"
+	"github.com/consensys/gnark/std/math/emulated"
+	"github.com/consensys/gnark/std/math/uints"
+	stdecdsa "github.com/consensys/gnark/std/signature/ecdsa"
+	"github.com/go-webauthn/webauthn/protocol/webauthncose"
+)
+
+const (
+	// AuthenticatorDataLen is the size of authenticatorData without attested
+	// credential data or extensions: rpIdHash (32) || flags (1) || signCount (4).
+	AuthenticatorDataLen = 37
+
+	// ClientDataHashLen is the size of SHA256(clientDataJSON).
+	ClientDataHashLen = 32
+)
+
+var (
+	// ErrUnsupportedKey is returned for credential public keys other than ES256 on P-256.
+	ErrUnsupportedKey = errors.New("zk: unsupported credential public key")
+
+	// ErrUnsupportedAuthenticatorData is returned when authenticatorData is not
+	// AuthenticatorDataLen bytes, e.g. because it carries extensions.
+	ErrUnsupportedAuthenticatorData = errors.New("zk: unsupported authenticator data")
+
+	// ErrInvalidSignature is returned when the assertion signature is not a
+	// DER encoded ECDSA signature.
+	ErrInvalidSignature = errors.New("zk: invalid signature encoding")
+)
+
+// P256PublicKey is a P-256 public key emulated over the BN254 scalar field.
+type P256PublicKey = stdecdsa.PublicKey[emulated.P256Fp, emulated.P256Fr]
+
+// P256Signature is an ES256 signature (r, s) emulated over the BN254 scalar field.
+type P256Signature = stdecdsa.Signature[emulated.P256Fr]
+
+// AssertionCircuit proves that an ES256 signature over a WebAuthn assertion is
+// valid for the stored credential public key, i.e. that
+//
+//	ECDSA-P256.Verify(PublicKey, SHA256(AuthenticatorData || ClientDataHash), Signature) = 1
+//
+// where ClientDataHash is the SHA-256 of the private ClientData, which must be
+// a webauthn.get assertion for the challenge c from one of AllowedOrigins (see
+// AssertClientData).
+//
+// The signature stays private behind the Pedersen commitment Cσ = Commit(σ, rσ).
+// The challenge is opened from Cc = Commit(c, rc), which the challenge circuit
+// exposes together with c: Cc binds this proof to the challenge derived from the
+// intent, it doesn't hide c.
+//
+// The circuit also exposes the hash and nonce of the intent, and the nullifier
+// derived from them and the private secret of the credential (see Nullifier),
+// so that each intent is proven once. A verifier checks the intent hash and
+// the nonce against the challenge proof. The secret is bound to the credential
+// by the public CredentialLeaf, the leaf of the public key and the secret in
+// the tree of credentials (see CredentialLeaf).
+//
+// The origin digests, the credential leaf and the nullifier are hashed with
+// the hash of the circuit version.
+type AssertionCircuit struct {
+	hash Hash
+
+	// private inputs (witnesses)
+	Signature         P256Signature
+	SignatureBlinding frontend.Variable
+	Challenge         frontend.Variable
+	ChallengeBlinding frontend.Variable
+	ClientData        ClientData
+	CredentialSecret  frontend.Variable
+
+	// public inputs
+	PublicKey         P256PublicKey                  `gnark:",public"`
+	AuthenticatorData [AuthenticatorDataLen]uints.U8 `gnark:",public"`
+	ClientDataHash    [ClientDataHashLen]uints.U8    `gnark:",public"`
+
+	SignatureCommitment PedersenCommitment `gnark:",public"`
+	ChallengeCommitment PedersenCommitment `gnark:",public"`
+
+	// AllowedOrigins are the digests of the allowed origins, see OriginDigest.
+	AllowedOrigins [MaxAllowedOrigins]frontend.Variable `gnark:",public"`
+
+	IntentHash     frontend.Variable `gnark:",public"`
+	Nonce          frontend.Variable `gnark:",public"`
+	Nullifier      frontend.Variable `gnark:",public"`
+	CredentialLeaf frontend.Variable `gnark:",public"`
+}
+
+// Define declares the circuit's constraints
+func (circuit *AssertionCircuit) Define(api frontend.API) error {
+	leaf, err := circuit.define(api)
+	if err != nil {
+		return err
+	}
+	api.AssertIsEqual(leaf, circuit.CredentialLeaf)
+	return nil
+}
+
+// define declares the circuit's constraints, but for the public leaf, and
+// returns the leaf of the credential, which the membership circuit proves is
+// in the tree instead.
+func (circuit *AssertionCircuit) define(api frontend.API) (frontend.Variable, error) {
+	scalars, err := emulated.NewField[emulated.P256Fr](api)
+	if err != nil {
+		return nil, fmt.Errorf("new scalar field: %w", err)
+	}
+	bf, err := uints.New[uints.U32](api)
+	if err != nil {
+		return nil, fmt.Errorf("new binary field: %w", err)
+	}
+	h, err := sha2.New(api)
+	if err != nil {
+		return nil, fmt.Errorf("new sha256: %w", err)
+	}
+
+	clientDataHash, err := AssertClientData(api, circuit.hash, circuit.ClientData, circuit.Challenge, circuit.AllowedOrigins[:])
+	if err != nil {
+		return nil, err
+	}
+	for i := range circuit.ClientDataHash {
+		bf.ByteAssertEq(clientDataHash[i], circuit.ClientDataHash[i])
+	}
+
+	// the authenticator signs authenticatorData || SHA256(clientDataJSON)
+	h.Write(circuit.AuthenticatorData[:])
+	h.Write(circuit.ClientDataHash[:])
+	digest := h.Sum()
+
+	msg := digestToScalar(api, scalars, digest)
+	circuit.PublicKey.Verify(api, sw_emulated.GetP256Params(), msg, &circuit.Signature)
+
+	// the signature is committed in its canonical form, so that it has a
+	// single opening
+	sigLimbs := append(
+		packLimbs(api, scalars.ReduceStrict(&circuit.Signature.R)),
+		packLimbs(api, scalars.ReduceStrict(&circuit.Signature.S))...,
+	)
+	if err := AssertPedersenCommitment(api, circuit.SignatureCommitment, sigLimbs, circuit.SignatureBlinding); err != nil {
+		return nil, err
+	}
+	if err := AssertPedersenCommitment(api, circuit.ChallengeCommitment, splitLimbs(api, circuit.Challenge, 2), circuit.ChallengeBlinding); err != nil {
+		return nil, err
+	}
+
+	// the nullifier is bound to the credential, by the secret committed into
+	// its leaf, and to the intent
+	leaf, err := credentialLeaf(api, circuit.hash, &circuit.PublicKey, circuit.CredentialSecret)
+	if err != nil {
+		return nil, err
+	}
+	return leaf, assertNullifier(api, circuit.hash, circuit.Nullifier, circuit.CredentialSecret, circuit.IntentHash, circuit.Nonce)
+}
+
+// digestToScalar interprets a big-endian SHA-256 digest as an element of the
+// P-256 scalar field, as ES256 does before signing.
+func digestToScalar(api frontend.API, scalars *emulated.Field[emulated.P256Fr], digest []uints.U8) *emulated.Element[emulated.P256Fr] {
+	// FromBits expects the least significant bit first
+	bits := make([]frontend.Variable, 0, 8*len(digest))
+	for i := len(digest) - 1; i >= 0; i-- {
+		bits = append(bits, api.ToBinary(digest[i].Val, 8)...)
+	}
+	return scalars.FromBits(bits...)
+}
+
+// packLimbs packs the 64-bit limbs of a reduced P-256 scalar or coordinate
+// into two limbBits-bit limbs, least significant first.
+func packLimbs[T emulated.FieldParams](api frontend.API, e *emulated.Element[T]) []frontend.Variable {
+	var fp T
+	shift := new(big.Int).Lsh(big.NewInt(1), uint(fp.BitsPerLimb()))
+	limbs := make([]frontend.Variable, 0, len(e.Limbs)/2)
+	for i := 0; i < len(e.Limbs); i += 2 {
+		limbs = append(limbs, api.Add(e.Limbs[i], api.Mul(e.Limbs[i+1], shift)))
+	}
+	return limbs
+}
+
+// NewAssertionCircuit returns a new WebAuthn assertion circuit hashing with h
+func NewAssertionCircuit(h Hash) *AssertionCircuit {
+	return &AssertionCircuit{hash: h}
+}
+
+// NewAssertionAssignment returns the witness of the assertion circuit hashing
+// with h for a WebAuthn assertion. The credential public key is COSE encoded, as stored at
+// registration, and the signature is the DER encoded signature returned by the
+// authenticator. The challenge is opened with the opening of its commitment,
+// and the signature is committed with a fresh opening, which is returned. The
+// origin of clientDataJSON must be one of the allowed origins. The nullifier
+// is derived from the credential secret and the hash and nonce of the intent
+// the challenge was derived for.
+func NewAssertionAssignment(h Hash, credentialPublicKey []byte, secret fr.Element, authenticatorData, clientDataJSON, signature []byte, challenge *Opening, origins []string, intentHash []byte, nonce uint64) (*AssertionCircuit, *Opening, error) {
+	return newAssertionAssignment(rand.Reader, h, credentialPublicKey, secret, authenticatorData, clientDataJSON, signature, challenge, origins, intentHash, nonce)
+}
+
+// newAssertionAssignment is NewAssertionAssignment with the opening of the
+// signature commitment read from rng.
+func newAssertionAssignment(rng io.Reader, h Hash, credentialPublicKey []byte, secret fr.Element, authenticatorData, clientDataJSON, signature []byte, challenge *Opening, origins []string, intentHash []byte, nonce uint64) (*AssertionCircuit, *Opening, error) {
+	publicKey, err := ParseCredentialPublicKey(credentialPublicKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(authenticatorData) != AuthenticatorDataLen {
+		return nil, nil, fmt.Errorf("%w: expected %d bytes, got %d", ErrUnsupportedAuthenticatorData, AuthenticatorDataLen, len(authenticatorData))
+	}
+	var sig struct {
+		R, S *big.Int
+	}
+	if rest, err := asn1.Unmarshal(signature, &sig); err != nil || len(rest) != 0 {
+		return nil, nil, ErrInvalidSignature
+	}
+	if challenge == nil {
+		return nil, nil, fmt.Errorf("%w: missing challenge opening", ErrInvalidOpening)
+	}
+	if len(challenge.Limbs) != 2 {
+		return nil, nil, fmt.Errorf("%w: challenge has %d limbs, expected 2", ErrInvalidOpening, len(challenge.Limbs))
+	}
+	clientData, signedChallenge, origin, err := NewClientData(clientDataJSON)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !bytes.Equal(signedChallenge, challenge.Value().FillBytes(make([]byte, 32))) {
+		return nil, nil, fmt.Errorf("%w: challenge does not match its commitment", ErrInvalidClientData)
+	}
+	if !slices.Contains(origins, origin) {
+		return nil, nil, fmt.Errorf("%w: %q is not allowed", ErrInvalidOrigin, origin)
+	}
+	allowedOrigins, err := allowedOriginDigests(h, origins)
+	if err != nil {
+		return nil, nil, err
+	}
+	sigOpening, err := newSignatureOpening(rng, sig.R, sig.S)
+	if err != nil {
+		return nil, nil, err
+	}
+	leaf, err := CredentialLeaf(h, publicKey, secret)
+	if err != nil {
+		return nil, nil, err
+	}
+	nullifier, err := Nullifier(h, secret, intentHash, nonce)
+	if err != nil {
+		return nil, nil, err
+	}
+	var intent fr.Element
+	intent.SetBytes(intentHash)
+	clientDataHash := sha256.Sum256(clientDataJSON)
+
+	assignment := &AssertionCircuit{
+		hash: h,
+		Signature: P256Signature{
+			R: emulated.ValueOf[emulated.P256Fr](sig.R),
+			S: emulated.ValueOf[emulated.P256Fr](sig.S),
+		},
+		PublicKey: P256PublicKey{
+			X: emulated.ValueOf[emulated.P256Fp](publicKey.X),
+			Y: emulated.ValueOf[emulated.P256Fp](publicKey.Y),
+		},
+		SignatureBlinding:   sigOpening.Blinding,
+		Challenge:           challenge.Value(),
+		ChallengeBlinding:   challenge.Blinding,
+		ClientData:          clientData,
+		CredentialSecret:    secret,
+		SignatureCommitment: sigOpening.Assignment(),
+		ChallengeCommitment: challenge.Assignment(),
+		AllowedOrigins:      allowedOrigins,
+		IntentHash:          intent,
+		Nonce:               nonce,
+		Nullifier:           nullifier,
+		CredentialLeaf:      leaf,
+	}
+	copy(assignment.AuthenticatorData[:], uints.NewU8Array(authenticatorData))
+	copy(assignment.ClientDataHash[:], uints.NewU8Array(clientDataHash[:]))
+	return assignment, sigOpening, nil
+}
+
+// ParseCredentialPublicKey decodes a COSE encoded ES256 credential public key.
+func ParseCredentialPublicKey(credentialPublicKey []byte) (*ecdsa.PublicKey, error) {
+	key, err := webauthncose.ParsePublicKey(credentialPublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnsupportedKey, err)
+	}
+	ec2, ok := key.(webauthncose.EC2PublicKeyData)
+	if !ok ||
+		webauthncose.COSEAlgorithmIdentifier(ec2.Algorithm) != webauthncose.AlgES256 ||
+		webauthncose.COSEEllipticCurve(ec2.Curve) != webauthncose.P256 {
+		return nil, ErrUnsupportedKey
+	}
+
+	if len(ec2.XCoord) != 32 || len(ec2.YCoord) != 32 {
+		return nil, fmt.Errorf("%w: invalid coordinate length", ErrUnsupportedKey)
+	}
+	// reject points that are not on the curve
+	uncompressed := append(append([]byte{4}, ec2.XCoord...), ec2.YCoord...)
+	if _, err := ecdh.P256().NewPublicKey(uncompressed); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnsupportedKey, err)
+	}
+
+	return &ecdsa.PublicKey{
+		Curve: elliptic.P256(),
+		X:     new(big.Int).SetBytes(ec2.XCoord),
+		Y:     new(big.Int).SetBytes(ec2.YCoord),
+	}, nil
+}
@@ -0,0 +1,237 @@
+package zk
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/asn1"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	"github.com/consensys/gnark/std/math/uints"
+	"github.com/consensys/gnark/test"
+	"github.com/go-webauthn/webauthn/protocol/webauthncbor"
+	"github.com/go-webauthn/webauthn/protocol/webauthncose"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAssertionCircuit(t *testing.T) {
+	privKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	authData := make([]byte, AuthenticatorDataLen)
+	_, err = rand.Read(authData)
+	require.NoError(t, err)
+	challenge, err := NewChallenge(HashMiMC, make([]byte, IntentHashLen), 1)
+	require.NoError(t, err)
+	clientDataJSON := testClientDataJSON(challenge, "http://localhost:8080")
+	clientDataHash := sha256.Sum256(clientDataJSON)
+
+	msg := sha256.Sum256(append(authData, clientDataHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, privKey, msg[:])
+	require.NoError(t, err)
+
+	credentialPublicKey := encodeCOSEKey(t, &privKey.PublicKey)
+
+	assignment, sigOpening, err := NewAssertionAssignment(HashMiMC, credentialPublicKey, testSecret, authData, clientDataJSON, signature, challenge.Opening, testOrigins, testIntentHash, 1)
+	require.NoError(t, err)
+
+	t.Run("valid signature", func(t *testing.T) {
+		err := test.IsSolved(NewAssertionCircuit(HashMiMC), assignment, ecc.BN254.ScalarField())
+		assert.NoError(t, err)
+	})
+
+	t.Run("tampered authenticator data", func(t *testing.T) {
+		tampered := *assignment
+		tampered.AuthenticatorData[AuthenticatorDataLen-1] = uints.NewU8(authData[AuthenticatorDataLen-1] ^ 1)
+		err := test.IsSolved(NewAssertionCircuit(HashMiMC), &tampered, ecc.BN254.ScalarField())
+		assert.Error(t, err)
+	})
+
+	t.Run("commitment to another signature", func(t *testing.T) {
+		other, err := NewSignatureOpening(big.NewInt(1), big.NewInt(2))
+		require.NoError(t, err)
+		tampered := *assignment
+		tampered.SignatureBlinding = other.Blinding
+		tampered.SignatureCommitment = other.Assignment()
+		err = test.IsSolved(NewAssertionCircuit(HashMiMC), &tampered, ecc.BN254.ScalarField())
+		assert.Error(t, err)
+	})
+
+	t.Run("commitment to another challenge", func(t *testing.T) {
+		other, err := NewChallenge(HashMiMC, make([]byte, IntentHashLen), 2)
+		require.NoError(t, err)
+		tampered := *assignment
+		tampered.ChallengeBlinding = other.Opening.Blinding
+		tampered.ChallengeCommitment = other.Opening.Assignment()
+		err = test.IsSolved(NewAssertionCircuit(HashMiMC), &tampered, ecc.BN254.ScalarField())
+		assert.Error(t, err)
+	})
+
+	t.Run("client data of another challenge", func(t *testing.T) {
+		other, err := NewChallenge(HashMiMC, make([]byte, IntentHashLen), 2)
+		require.NoError(t, err)
+		tampered := *assignment
+		tampered.Challenge = other.Opening.Value()
+		tampered.ChallengeBlinding = other.Opening.Blinding
+		tampered.ChallengeCommitment = other.Opening.Assignment()
+		err = test.IsSolved(NewAssertionCircuit(HashMiMC), &tampered, ecc.BN254.ScalarField())
+		assert.Error(t, err)
+
+		_, _, err = NewAssertionAssignment(HashMiMC, credentialPublicKey, testSecret, authData, clientDataJSON, signature, other.Opening, testOrigins, testIntentHash, 1)
+		assert.ErrorIs(t, err, ErrInvalidClientData)
+	})
+
+	t.Run("origin not allowed", func(t *testing.T) {
+		tampered := *assignment
+		tampered.AllowedOrigins, err = allowedOriginDigests(HashMiMC, []string{"https://example.com"})
+		require.NoError(t, err)
+		err = test.IsSolved(NewAssertionCircuit(HashMiMC), &tampered, ecc.BN254.ScalarField())
+		assert.Error(t, err)
+
+		_, _, err = NewAssertionAssignment(HashMiMC, credentialPublicKey, testSecret, authData, clientDataJSON, signature, challenge.Opening, []string{"https://example.com"}, testIntentHash, 1)
+		assert.ErrorIs(t, err, ErrInvalidOrigin)
+	})
+
+	t.Run("nullifier", func(t *testing.T) {
+		nullifier, err := Nullifier(HashMiMC, testSecret, testIntentHash, 1)
+		require.NoError(t, err)
+		assert.Equal(t, nullifier, assignment.Nullifier)
+		leaf, err := CredentialLeaf(HashMiMC, &privKey.PublicKey, testSecret)
+		require.NoError(t, err)
+		assert.Equal(t, leaf, assignment.CredentialLeaf)
+
+		tampered := *assignment
+		tampered.Nonce = 2
+		assert.Error(t, test.IsSolved(NewAssertionCircuit(HashMiMC), &tampered, ecc.BN254.ScalarField()))
+
+		tampered = *assignment
+		tampered.IntentHash = 1
+		assert.Error(t, test.IsSolved(NewAssertionCircuit(HashMiMC), &tampered, ecc.BN254.ScalarField()))
+
+		// the prover can't pick the nullifier, e.g. one derived from another
+		// secret, to prove an intent twice
+		otherSecret := fr.NewElement(8)
+		other, err := Nullifier(HashMiMC, otherSecret, testIntentHash, 1)
+		require.NoError(t, err)
+		for _, wrong := range []fr.Element{other, fr.NewElement(42)} {
+			tampered = *assignment
+			tampered.Nullifier = wrong
+			assert.Error(t, test.IsSolved(NewAssertionCircuit(HashMiMC), &tampered, ecc.BN254.ScalarField()))
+		}
+
+		// nor the secret, which the credential's leaf commits to
+		tampered = *assignment
+		tampered.CredentialSecret = otherSecret
+		tampered.Nullifier = other
+		assert.Error(t, test.IsSolved(NewAssertionCircuit(HashMiMC), &tampered, ecc.BN254.ScalarField()))
+
+		tampered = *assignment
+		tampered.CredentialLeaf = fr.NewElement(42)
+		assert.Error(t, test.IsSolved(NewAssertionCircuit(HashMiMC), &tampered, ecc.BN254.ScalarField()))
+	})
+
+	t.Run("poseidon2 circuit version", func(t *testing.T) {
+		poseidon2, err := sampleAssertion(HashPoseidon2)
+		require.NoError(t, err)
+		assert.NoError(t, test.IsSolved(NewAssertionCircuit(HashPoseidon2), poseidon2, ecc.BN254.ScalarField()))
+		assert.Error(t, test.IsSolved(NewAssertionCircuit(HashMiMC), poseidon2, ecc.BN254.ScalarField()))
+		assert.Error(t, test.IsSolved(NewAssertionCircuit(HashPoseidon2), assignment, ecc.BN254.ScalarField()))
+	})
+
+	t.Run("signature opening", func(t *testing.T) {
+		var sig struct {
+			R, S *big.Int
+		}
+		_, err := asn1.Unmarshal(signature, &sig)
+		require.NoError(t, err)
+		want := new(big.Int).Lsh(sig.S, 256)
+		assert.Equal(t, want.Or(want, sig.R), sigOpening.Value())
+	})
+}
+
+func TestNewAssertionAssignment(t *testing.T) {
+	privKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	credentialPublicKey := encodeCOSEKey(t, &privKey.PublicKey)
+	signature, err := ecdsa.SignASN1(rand.Reader, privKey, make([]byte, 32))
+	require.NoError(t, err)
+
+	challenge, err := NewChallenge(HashMiMC, make([]byte, IntentHashLen), 1)
+	require.NoError(t, err)
+
+	_, _, err = NewAssertionAssignment(HashMiMC, credentialPublicKey, testSecret, make([]byte, AuthenticatorDataLen+1), nil, signature, challenge.Opening, testOrigins, testIntentHash, 1)
+	assert.ErrorIs(t, err, ErrUnsupportedAuthenticatorData)
+
+	_, _, err = NewAssertionAssignment(HashMiMC, credentialPublicKey, testSecret, make([]byte, AuthenticatorDataLen), nil, signature[1:], challenge.Opening, testOrigins, testIntentHash, 1)
+	assert.ErrorIs(t, err, ErrInvalidSignature)
+
+	_, _, err = NewAssertionAssignment(HashMiMC, []byte{0xa0}, testSecret, make([]byte, AuthenticatorDataLen), nil, signature, challenge.Opening, testOrigins, testIntentHash, 1)
+	assert.ErrorIs(t, err, ErrUnsupportedKey)
+
+	sigOpening, err := NewSignatureOpening(big.NewInt(1), big.NewInt(2))
+	require.NoError(t, err)
+	_, _, err = NewAssertionAssignment(HashMiMC, credentialPublicKey, testSecret, make([]byte, AuthenticatorDataLen), nil, signature, sigOpening, testOrigins, testIntentHash, 1)
+	assert.ErrorIs(t, err, ErrInvalidOpening)
+
+	_, _, err = NewAssertionAssignment(HashMiMC, credentialPublicKey, testSecret, make([]byte, AuthenticatorDataLen), nil, signature, nil, testOrigins, testIntentHash, 1)
+	assert.ErrorIs(t, err, ErrInvalidOpening)
+}
+
+// encodeCOSEKey encodes an ES256 public key as stored at registration.
+func encodeCOSEKey(t *testing.T, publicKey *ecdsa.PublicKey) []byte {
+	key, err := webauthncbor.Marshal(webauthncose.EC2PublicKeyData{
+		PublicKeyData: webauthncose.PublicKeyData{
+			KeyType:   int64(webauthncose.EllipticKey),
+			Algorithm: int64(webauthncose.AlgES256),
+		},
+		Curve:  int64(webauthncose.P256),
+		XCoord: publicKey.X.FillBytes(make([]byte, 32)),
+		YCoord: publicKey.Y.FillBytes(make([]byte, 32)),
+	})
+	require.NoError(t, err)
+	return key
+}
+
+// newTestAssertion signs a random assertion with a fresh key and returns the
+// witness of the assertion circuit.
+func newTestAssertion(t *testing.T) *AssertionCircuit {
+	privKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	authData := make([]byte, AuthenticatorDataLen)
+	_, err = rand.Read(authData)
+	require.NoError(t, err)
+	challenge, err := NewChallenge(HashMiMC, make([]byte, IntentHashLen), 1)
+	require.NoError(t, err)
+	clientDataJSON := testClientDataJSON(challenge, "http://localhost:8080")
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	msg := sha256.Sum256(append(authData, clientDataHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, privKey, msg[:])
+	require.NoError(t, err)
+
+	assignment, _, err := NewAssertionAssignment(HashMiMC, encodeCOSEKey(t, &privKey.PublicKey), testSecret, authData, clientDataJSON, signature, challenge.Opening, testOrigins, testIntentHash, 1)
+	require.NoError(t, err)
+	return assignment
+}
+
+// testOrigins are the origins allowed in tests.
+var testOrigins = []string{"http://localhost:8080", "https://localhost:8443"}
+
+// testIntentHash is the intent hash the test challenges are derived for.
+var testIntentHash = make([]byte, IntentHashLen)
+
+// testSecret is the credential secret of the test assertions.
+var testSecret = fr.NewElement(7)
+
+// testClientDataJSON returns the clientDataJSON of an assertion for the
+// challenge, as serialized by browsers.
+func testClientDataJSON(challenge *Challenge, origin string) []byte {
+	return []byte(fmt.Sprintf(`{"type":"webauthn.get","challenge":"%s","origin":"%s","crossOrigin":false}`,
+		base64.RawURLEncoding.EncodeToString(challenge.Bytes()), origin))
+}
@@ -0,0 +1,262 @@
+package zk
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	kzgbn254 "github.com/consensys/gnark-crypto/ecc/bn254/kzg"
+	gnarkbackend "github.com/consensys/gnark/backend"
+	"github.com/consensys/gnark/backend/groth16"
+	groth16bn254 "github.com/consensys/gnark/backend/groth16/bn254"
+	"github.com/consensys/gnark/backend/plonk"
+	"github.com/consensys/gnark/backend/solidity"
+	"github.com/consensys/gnark/backend/witness"
+	"github.com/consensys/gnark/constraint"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/frontend/cs/r1cs"
+	"github.com/consensys/gnark/frontend/cs/scs"
+	gnarkio "github.com/consensys/gnark/io"
+)
+
+// Backend is the proving system used for a circuit.
+type Backend string
+
+const (
+	// BackendGroth16 needs a circuit specific setup, but has the smallest
+	// proofs and the cheapest verification.
+	BackendGroth16 Backend = "groth16"
+
+	// BackendPlonk uses a universal KZG SRS, so circuits can change without a
+	// new trusted setup.
+	BackendPlonk Backend = "plonk"
+)
+
+// ErrUnsupportedBackend is returned for unknown proving backends.
+var ErrUnsupportedBackend = errors.New("zk: unsupported backend")
+
+// ParseBackend returns the backend with the given name. An empty name selects
+// Groth16.
+func ParseBackend(name string) (Backend, error) {
+	switch Backend(name) {
+	case "", BackendGroth16:
+		return BackendGroth16, nil
+	case BackendPlonk:
+		return BackendPlonk, nil
+	}
+	return "", fmt.Errorf("%w: %s", ErrUnsupportedBackend, name)
+}
+
+// Target is the verifier a proof is made for. The Groth16 proofs of circuits
+// with BSB22 commitments, such as the P-256 assertion circuit, hash their
+// commitments to the field with a function that depends on the verifier.
+type Target string
+
+const (
+	// TargetGnark is gnark's verifier, used by the server and the Solana
+	// export, which hashes to the field as in RFC 9380.
+	TargetGnark Target = "gnark"
+
+	// TargetEVM is the Solidity verifier written by ExportSolidity, which
+	// hashes to the field with Keccak-256.
+	TargetEVM Target = "evm"
+)
+
+// ErrUnsupportedTarget is returned for unknown proof targets.
+var ErrUnsupportedTarget = errors.New("zk: unsupported proof target")
+
+// ParseTarget returns the target with the given name. An empty name selects
+// gnark's verifier.
+func ParseTarget(name string) (Target, error) {
+	switch Target(name) {
+	case "", TargetGnark:
+		return TargetGnark, nil
+	case TargetEVM:
+		return TargetEVM, nil
+	}
+	return "", fmt.Errorf("%w: %s", ErrUnsupportedTarget, name)
+}
+
+// ProvingKey is a Groth16 or PLONK proving key.
+type ProvingKey interface {
+	io.WriterTo
+	gnarkio.WriterRawTo
+	gnarkio.UnsafeReaderFrom
+}
+
+// VerifyingKey is a Groth16 or PLONK verifying key.
+type VerifyingKey interface {
+	io.WriterTo
+	gnarkio.WriterRawTo
+	gnarkio.UnsafeReaderFrom
+}
+
+// compile compiles the circuit to the constraint system of the backend: an
+// R1CS for Groth16 and a sparse R1CS for PLONK.
+func (b Backend) compile(circuit frontend.Circuit) (constraint.ConstraintSystem, error) {
+	switch b {
+	case BackendGroth16:
+		return frontend.Compile(ecc.BN254.ScalarField(), r1cs.NewBuilder, circuit)
+	case BackendPlonk:
+		return frontend.Compile(ecc.BN254.ScalarField(), scs.NewBuilder, circuit)
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnsupportedBackend, b)
+}
+
+func (b Backend) setup(ccs constraint.ConstraintSystem, srs *kzgbn254.SRS) (ProvingKey, VerifyingKey, error) {
+	switch b {
+	case BackendGroth16:
+		return groth16.Setup(ccs)
+	case BackendPlonk:
+		if srs == nil {
+			return nil, nil, errors.New("plonk setup requires a KZG SRS")
+		}
+		canonical, lagrange, err := srsFor(ccs, srs)
+		if err != nil {
+			return nil, nil, err
+		}
+		return plonk.Setup(ccs, canonical, lagrange)
+	}
+	return nil, nil, fmt.Errorf("%w: %s", ErrUnsupportedBackend, b)
+}
+
+func (b Backend) newKeys() (ProvingKey, VerifyingKey, error) {
+	switch b {
+	case BackendGroth16:
+		return groth16.NewProvingKey(ecc.BN254), groth16.NewVerifyingKey(ecc.BN254), nil
+	case BackendPlonk:
+		return plonk.NewProvingKey(ecc.BN254), plonk.NewVerifyingKey(ecc.BN254), nil
+	}
+	return nil, nil, fmt.Errorf("%w: %s", ErrUnsupportedBackend, b)
+}
+
+// id returns gnark's identifier of the backend.
+func (b Backend) id() gnarkbackend.ID {
+	if b == BackendPlonk {
+		return gnarkbackend.PLONK
+	}
+	return gnarkbackend.GROTH16
+}
+
+// nbPublic returns the number of public inputs of the constraint system; the
+// R1CS counts the constant wire as a public variable.
+func (b Backend) nbPublic(ccs constraint.ConstraintSystem) int {
+	if b == BackendGroth16 {
+		return ccs.GetNbPublicVariables() - 1
+	}
+	return ccs.GetNbPublicVariables()
+}
+
+// nbPublicWitness returns the number of public inputs the verifying key
+// expects. Groth16 keys also count an input per BSB22 commitment, which the
+// verifier derives from the proof.
+func nbPublicWitness(vk VerifyingKey) int {
+	switch vk := vk.(type) {
+	case *groth16bn254.VerifyingKey:
+		return vk.NbPublicWitness() - len(vk.PublicAndCommitmentCommitted)
+	case interface{ NbPublicWitness() int }:
+		return vk.NbPublicWitness()
+	}
+	return -1
+}
+
+// prove proves the full witness for the target verifier and returns the
+// serialized proof.
+func (b Backend) prove(ccs constraint.ConstraintSystem, pk ProvingKey, w witness.Witness, target Target) ([]byte, error) {
+	var opts []gnarkbackend.ProverOption
+	if target == TargetEVM {
+		opts = append(opts, solidity.WithProverTargetSolidityVerifier(b.id()))
+	}
+
+	var proof io.WriterTo
+	var err error
+	switch b {
+	case BackendGroth16:
+		proof, err = groth16.Prove(ccs, pk.(groth16.ProvingKey), w, opts...)
+	case BackendPlonk:
+		proof, err = plonk.Prove(ccs, pk.(plonk.ProvingKey), w, opts...)
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedBackend, b)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if _, err := proof.WriteTo(&buf); err != nil {
+		return nil, fmt.Errorf("error serializing proof: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// verify checks a serialized proof made for the target verifier against the
+// verifying key.
+func (b Backend) verify(vk VerifyingKey, data []byte, public witness.Witness, target Target) error {
+	var opts []gnarkbackend.VerifierOption
+	if target == TargetEVM {
+		opts = append(opts, solidity.WithVerifierTargetSolidityVerifier(b.id()))
+	}
+
+	switch b {
+	case BackendGroth16:
+		proof := groth16.NewProof(ecc.BN254)
+		if _, err := proof.ReadFrom(bytes.NewReader(data)); err != nil {
+			return fmt.Errorf("%w: error decoding proof: %w", ErrMalformedProof, err)
+		}
+		if err := groth16.Verify(proof, vk.(groth16.VerifyingKey), public, opts...); err != nil {
+			return fmt.Errorf("%w: %w", ErrVerificationFailed, err)
+		}
+		return nil
+	case BackendPlonk:
+		proof := plonk.NewProof(ecc.BN254)
+		if _, err := proof.ReadFrom(bytes.NewReader(data)); err != nil {
+			return fmt.Errorf("%w: error decoding proof: %w", ErrMalformedProof, err)
+		}
+		if err := plonk.Verify(proof, vk.(plonk.VerifyingKey), public, opts...); err != nil {
+			return fmt.Errorf("%w: %w", ErrVerificationFailed, err)
+		}
+		return nil
+	}
+	return fmt.Errorf("%w: %s", ErrUnsupportedBackend, b)
+}
+
+// ReadSRS reads a BN254 KZG SRS in canonical form, as written by gnark-crypto.
+// The same SRS can be used for the PLONK setup of every circuit whose size it
+// covers.
+func ReadSRS(path string) (*kzgbn254.SRS, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open srs: %w", err)
+	}
+	defer f.Close()
+
+	var srs kzgbn254.SRS
+	if _, err := srs.ReadFrom(f); err != nil {
+		return nil, fmt.Errorf("decode srs %s: %w", path, err)
+	}
+	return &srs, nil
+}
+
+// srsFor truncates the SRS to the size of the circuit and computes its
+// Lagrange form.
+func srsFor(ccs constraint.ConstraintSystem, srs *kzgbn254.SRS) (*kzgbn254.SRS, *kzgbn254.SRS, error) {
+	// the PLONK domain covers the constraints and a placeholder constraint per
+	// public input, and the opening proofs need 3 more points
+	size := ecc.NextPowerOfTwo(uint64(ccs.GetNbConstraints() + ccs.GetNbPublicVariables()))
+	if uint64(len(srs.Pk.G1)) < size+3 {
+		return nil, nil, fmt.Errorf("srs is too small: got %d points, need %d", len(srs.Pk.G1), size+3)
+	}
+
+	canonical := &kzgbn254.SRS{Vk: srs.Vk}
+	canonical.Pk.G1 = srs.Pk.G1[:size+3]
+	lagrangeG1, err := kzgbn254.ToLagrangeG1(srs.Pk.G1[:size])
+	if err != nil {
+		return nil, nil, fmt.Errorf("compute lagrange srs: %w", err)
+	}
+	lagrange := &kzgbn254.SRS{Vk: srs.Vk}
+	lagrange.Pk.G1 = lagrangeG1
+	return canonical, lagrange, nil
+}
@@ -0,0 +1,373 @@
+package zk
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math/big"
+	"reflect"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	"github.com/consensys/gnark/backend/witness"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/frontend/schema"
+	"github.com/consensys/gnark/std/hash/sha2"
+	"github.com/consensys/gnark/std/math/emulated"
+	"github.com/consensys/gnark/std/math/uints"
+)
+
+const (
+	// assertionPublicDataLen is the size of the public data of an assertion in
+	// the batch digest: the public key coordinates, authenticatorData,
+	// SHA256(clientDataJSON), the coordinates of Cσ and Cc, the allowed
+	// origin digests, the intent hash, the nonce, the nullifier and the
+	// credential leaf.
+	assertionPublicDataLen = 2*32 + AuthenticatorDataLen + ClientDataHashLen + 4*32 + MaxAllowedOrigins*32 + 4*32
+
+	// nbAssertionPublic is the number of public inputs of the assertion
+	// circuit: 4 limbs per public key coordinate, a byte per element of
+	// authenticatorData and SHA256(clientDataJSON), the commitment
+	// coordinates, the allowed origin digests, the intent hash, the nonce, the
+	// nullifier and the credential leaf.
+	nbAssertionPublic = 2*4 + AuthenticatorDataLen + ClientDataHashLen + 4 + MaxAllowedOrigins + 4
+
+	// batchHash is the hash of the assertions the batch circuit proves.
+	batchHash = HashMiMC
+)
+
+// ErrInvalidBatch is returned for batches that are empty, too large, or whose
+// digest does not match their assertions.
+var ErrInvalidBatch = errors.New("zk: invalid batch")
+
+// BatchCircuit proves a batch of assertions at once, so that verifying a
+// single proof covers every login of the batch. Each assertion is proven as
+// by AssertionCircuit, but its public inputs are private to the batch circuit.
+// Only their SHA-256 digest is public, so that the cost of verifying a batch
+// does not grow with its size:
+//
+//	Digest = SHA256(data₀ || … || dataₙ)
+//	dataᵢ  = PublicKey.X || PublicKey.Y || AuthenticatorData || ClientDataHash || Cσ.X || Cσ.Y || Cc.X || Cc.Y || AllowedOrigins || IntentHash || Nonce || Nullifier || Leaf
+//
+// with every coordinate in 32 big-endian bytes. The digest is split in two
+// 128-bit halves, most significant first. Batches smaller than the circuit
+// repeat their last assertion. The assertions are those of the assertion
+// circuit hashing with MiMC.
+type BatchCircuit struct {
+	// private inputs (witnesses)
+	Assertions []BatchAssertion
+
+	// public inputs
+	Digest [2]frontend.Variable `gnark:",public"`
+}
+
+// BatchAssertion is an assertion of a batch. It has the same fields as
+// AssertionCircuit, without visibility tags, so that one converts to the
+// other.
+type BatchAssertion struct {
+	hash Hash
+
+	Signature         P256Signature
+	SignatureBlinding frontend.Variable
+	Challenge         frontend.Variable
+	ChallengeBlinding frontend.Variable
+	ClientData        ClientData
+	CredentialSecret  frontend.Variable
+
+	PublicKey         P256PublicKey
+	AuthenticatorData [AuthenticatorDataLen]uints.U8
+	ClientDataHash    [ClientDataHashLen]uints.U8
+
+	SignatureCommitment PedersenCommitment
+	ChallengeCommitment PedersenCommitment
+
+	AllowedOrigins [MaxAllowedOrigins]frontend.Variable
+
+	IntentHash     frontend.Variable
+	Nonce          frontend.Variable
+	Nullifier      frontend.Variable
+	CredentialLeaf frontend.Variable
+}
+
+// Define declares the circuit's constraints
+func (circuit *BatchCircuit) Define(api frontend.API) error {
+	bf, err := uints.New[uints.U32](api)
+	if err != nil {
+		return fmt.Errorf("new binary field: %w", err)
+	}
+	h, err := sha2.New(api)
+	if err != nil {
+		return fmt.Errorf("new sha256: %w", err)
+	}
+
+	for i := range circuit.Assertions {
+		assertion := AssertionCircuit(circuit.Assertions[i])
+		if err := assertion.Define(api); err != nil {
+			return err
+		}
+
+		for _, coord := range []*emulated.Element[emulated.P256Fp]{&assertion.PublicKey.X, &assertion.PublicKey.Y} {
+			h.Write(limbBytes(api, bf, coord.Limbs))
+		}
+		h.Write(assertion.AuthenticatorData[:])
+		h.Write(assertion.ClientDataHash[:])
+		for _, v := range append([]frontend.Variable{
+			assertion.SignatureCommitment.X, assertion.SignatureCommitment.Y,
+			assertion.ChallengeCommitment.X, assertion.ChallengeCommitment.Y,
+		}, append(assertion.AllowedOrigins[:], assertion.IntentHash, assertion.Nonce, assertion.Nullifier, assertion.CredentialLeaf)...) {
+			h.Write(fieldBytes(api, bf, v))
+		}
+	}
+
+	digest := h.Sum()
+	for i := range circuit.Digest {
+		var bits []frontend.Variable
+		for j := 16*i + 15; j >= 16*i; j-- {
+			bits = append(bits, api.ToBinary(digest[j].Val, 8)...)
+		}
+		api.AssertIsEqual(api.FromBinary(bits...), circuit.Digest[i])
+	}
+	return nil
+}
+
+// limbBytes returns the 32 big-endian bytes of an emulated P-256 coordinate
+// from its 64-bit limbs, least significant first.
+func limbBytes(api frontend.API, bf *uints.BinaryField[uints.U32], limbs []frontend.Variable) []uints.U8 {
+	var fp emulated.P256Fp
+	bytes := make([]uints.U8, 0, 32)
+	for i := len(limbs) - 1; i >= 0; i-- {
+		bytes = append(bytes, bitsToBytes(api, bf, api.ToBinary(limbs[i], int(fp.BitsPerLimb())))...)
+	}
+	return bytes
+}
+
+// fieldBytes returns the 32 big-endian bytes of the canonical representation
+// of a native field element.
+func fieldBytes(api frontend.API, bf *uints.BinaryField[uints.U32], v frontend.Variable) []uints.U8 {
+	return bitsToBytes(api, bf, api.ToBinary(v, 256))
+}
+
+// bitsToBytes packs bits, least significant first, into big-endian bytes.
+func bitsToBytes(api frontend.API, bf *uints.BinaryField[uints.U32], bits []frontend.Variable) []uints.U8 {
+	bytes := make([]uints.U8, len(bits)/8)
+	for i := range bytes {
+		bytes[len(bytes)-1-i] = bf.ByteValueOf(api.FromBinary(bits[8*i : 8*i+8]...))
+	}
+	return bytes
+}
+
+// NewBatchCircuit returns a new batch circuit proving size assertions.
+func NewBatchCircuit(size int) *BatchCircuit {
+	circuit := &BatchCircuit{Assertions: make([]BatchAssertion, size)}
+	for i := range circuit.Assertions {
+		circuit.Assertions[i].hash = batchHash
+	}
+	return circuit
+}
+
+// BatchCircuitDefinition returns the definition of the batch circuit proving
+// size assertions. Each size is a separate circuit with its own keys.
+func BatchCircuitDefinition(size int) CircuitDefinition {
+	return CircuitDefinition{
+		ID:  fmt.Sprintf("webauthn-p256-batch-v5-%d", size),
+		New: func() frontend.Circuit { return NewBatchCircuit(size) },
+		Sample: func() (frontend.Circuit, error) {
+			assertion, err := sampleAssertion(batchHash)
+			if err != nil {
+				return nil, err
+			}
+			batch, _, err := NewBatchAssignment(size, []*AssertionCircuit{assertion})
+			return batch, err
+		},
+		Hash: batchHash,
+	}
+}
+
+// BatchProof is the proof of a batch of assertions, with the public inputs of
+// each assertion as they would appear in a proof of the assertion circuit.
+// Its public inputs are the halves of the digest of the assertions.
+type BatchProof struct {
+	Proof
+	Assertions [][]string `json:"assertions"`
+}
+
+// NewBatchAssignment returns the witness of the batch circuit of the given
+// size for the assertions, and the public inputs of each assertion. Batches
+// smaller than size repeat their last assertion. The assertions must hash
+// with the batch definition's hash.
+func NewBatchAssignment(size int, assertions []*AssertionCircuit) (*BatchCircuit, [][]string, error) {
+	if len(assertions) == 0 || len(assertions) > size {
+		return nil, nil, fmt.Errorf("%w: got %d assertions for a batch of %d", ErrInvalidBatch, len(assertions), size)
+	}
+	for i, a := range assertions {
+		if a.hash != batchHash {
+			return nil, nil, fmt.Errorf("%w: assertion %d hashes with %q, expected %q", ErrInvalidBatch, i, string(a.hash), string(batchHash))
+		}
+	}
+
+	batch := NewBatchCircuit(size)
+	public := make([][]string, len(assertions))
+	for i := range batch.Assertions {
+		a := assertions[min(i, len(assertions)-1)]
+		batch.Assertions[i] = BatchAssertion(*a)
+		if i >= len(assertions) {
+			continue
+		}
+		w, err := frontend.NewWitness(a, ecc.BN254.ScalarField(), frontend.PublicOnly())
+		if err != nil {
+			return nil, nil, fmt.Errorf("error building witness of assertion %d: %w", i, err)
+		}
+		inputs, err := encodePublicInputs(w)
+		if err != nil {
+			return nil, nil, err
+		}
+		public[i] = inputs
+	}
+
+	digest, err := BatchDigest(size, public)
+	if err != nil {
+		return nil, nil, err
+	}
+	batch.Digest[0] = new(big.Int).SetBytes(digest[:16])
+	batch.Digest[1] = new(big.Int).SetBytes(digest[16:])
+	return batch, public, nil
+}
+
+// ProveBatch proves the assertions with the keys of the batch circuit of the
+// given size.
+func ProveBatch(keys *Keys, size int, assertions []*AssertionCircuit) (*BatchProof, error) {
+	assignment, public, err := NewBatchAssignment(size, assertions)
+	if err != nil {
+		return nil, err
+	}
+	proof, err := Prove(keys, assignment)
+	if err != nil {
+		return nil, err
+	}
+	return &BatchProof{Proof: *proof, Assertions: public}, nil
+}
+
+// VerifyBatch checks that the batch proof is valid and that its digest covers
+// the public inputs of its assertions.
+func VerifyBatch(keys *Keys, size int, p *BatchProof) error {
+	digest, err := BatchDigest(size, p.Assertions)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrMalformedProof, err)
+	}
+	if len(p.PublicInputs) != len(BatchCircuit{}.Digest) {
+		return fmt.Errorf("%w: got %d public inputs, expected the 2 halves of the digest", ErrMalformedProof, len(p.PublicInputs))
+	}
+	for i, half := range [][]byte{digest[:16], digest[16:]} {
+		v, err := decodePublicInput(p.PublicInputs[i])
+		if err != nil {
+			return fmt.Errorf("%w: error decoding public input %d: %w", ErrMalformedProof, i, err)
+		}
+		var want fr.Element
+		if want.SetBytes(half); !v.Equal(&want) {
+			return fmt.Errorf("%w: digest does not match the assertions", ErrInvalidBatch)
+		}
+	}
+	return Verify(keys, &p.Proof)
+}
+
+// BatchDigest computes the digest of a batch of the given size from the
+// public inputs of its assertions, padding with the last one.
+func BatchDigest(size int, assertions [][]string) ([32]byte, error) {
+	if len(assertions) == 0 || len(assertions) > size {
+		return [32]byte{}, fmt.Errorf("%w: got %d assertions for a batch of %d", ErrInvalidBatch, len(assertions), size)
+	}
+	h := sha256.New()
+	for i := 0; i < size; i++ {
+		data, err := assertionPublicData(assertions[min(i, len(assertions)-1)])
+		if err != nil {
+			return [32]byte{}, fmt.Errorf("assertion %d: %w", i, err)
+		}
+		h.Write(data)
+	}
+	var digest [32]byte
+	h.Sum(digest[:0])
+	return digest, nil
+}
+
+// assertionPublicData encodes the public inputs of an assertion as hashed in
+// the batch digest.
+func assertionPublicData(inputs []string) ([]byte, error) {
+	if len(inputs) != nbAssertionPublic {
+		return nil, fmt.Errorf("%w: got %d public inputs, expected %d", ErrInvalidBatch, len(inputs), nbAssertionPublic)
+	}
+	values := make([]*big.Int, len(inputs))
+	for i, input := range inputs {
+		e, err := decodePublicInput(input)
+		if err != nil {
+			return nil, fmt.Errorf("error decoding public input %d: %w", i, err)
+		}
+		values[i] = e.BigInt(new(big.Int))
+	}
+
+	data := make([]byte, 0, assertionPublicDataLen)
+	// the public key coordinates are 4 limbs of 64 bits, least significant first
+	for _, limbs := range [][]*big.Int{values[0:4], values[4:8]} {
+		coord := new(big.Int)
+		for i := len(limbs) - 1; i >= 0; i-- {
+			coord.Lsh(coord, 64).Add(coord, limbs[i])
+		}
+		if coord.BitLen() > 256 {
+			return nil, fmt.Errorf("%w: public key coordinate out of range", ErrInvalidBatch)
+		}
+		data = append(data, coord.FillBytes(make([]byte, 32))...)
+	}
+	for _, v := range values[8 : 8+AuthenticatorDataLen+ClientDataHashLen] {
+		if !v.IsUint64() || v.Uint64() > 0xff {
+			return nil, fmt.Errorf("%w: byte out of range", ErrInvalidBatch)
+		}
+		data = append(data, byte(v.Uint64()))
+	}
+	for _, v := range values[8+AuthenticatorDataLen+ClientDataHashLen:] {
+		data = append(data, v.FillBytes(make([]byte, 32))...)
+	}
+	return data, nil
+}
+
+// tVariable is the type of circuit variables, used to walk a circuit.
+var tVariable = reflect.TypeOf((*frontend.Variable)(nil)).Elem()
+
+// AssertionFromWitness rebuilds the assignment of the assertion circuit
+// hashing with h from its full witness, e.g. as stored by a proof job, to
+// prove it in a batch.
+func AssertionFromWitness(h Hash, w witness.Witness) (*AssertionCircuit, error) {
+	values, ok := w.Vector().(fr.Vector)
+	if !ok {
+		return nil, fmt.Errorf("witness is not over %s", ecc.BN254)
+	}
+	assignment := NewAssertionCircuit(h)
+	s, err := schema.Walk(assignment, tVariable, nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(values) != s.Public+s.Secret {
+		return nil, fmt.Errorf("%w: witness has %d values, assertion circuit has %d", ErrMalformedProof, len(values), s.Public+s.Secret)
+	}
+
+	// the witness holds the public values, then the secret ones, each in the
+	// order of the circuit's fields
+	public, secret := values[:s.Public], values[s.Public:]
+	for _, visibility := range []schema.Visibility{schema.Public, schema.Secret} {
+		_, err := schema.Walk(assignment, tVariable, func(leaf schema.LeafInfo, tValue reflect.Value) error {
+			if leaf.Visibility != visibility {
+				return nil
+			}
+			var v fr.Element
+			if visibility == schema.Public {
+				v, public = public[0], public[1:]
+			} else {
+				v, secret = secret[0], secret[1:]
+			}
+			tValue.Set(reflect.ValueOf(v))
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return assignment, nil
+}
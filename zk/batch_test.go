@@ -0,0 +1,82 @@
+package zk
+
+import (
+	"fmt"
+	"math/big"
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBatchCircuit(t *testing.T) {
+	assertions := []*AssertionCircuit{newTestAssertion(t), newTestAssertion(t)}
+
+	t.Run("full batch", func(t *testing.T) {
+		assignment, public, err := NewBatchAssignment(2, assertions)
+		require.NoError(t, err)
+		require.Len(t, public, 2)
+		assert.NoError(t, test.IsSolved(NewBatchCircuit(2), assignment, ecc.BN254.ScalarField()))
+	})
+
+	t.Run("padded batch", func(t *testing.T) {
+		assignment, public, err := NewBatchAssignment(2, assertions[:1])
+		require.NoError(t, err)
+		require.Len(t, public, 1)
+		assert.NoError(t, test.IsSolved(NewBatchCircuit(2), assignment, ecc.BN254.ScalarField()))
+	})
+
+	t.Run("tampered digest", func(t *testing.T) {
+		assignment, _, err := NewBatchAssignment(2, assertions)
+		require.NoError(t, err)
+		assignment.Digest[1] = new(big.Int).Add(assignment.Digest[1].(*big.Int), big.NewInt(1))
+		assert.Error(t, test.IsSolved(NewBatchCircuit(2), assignment, ecc.BN254.ScalarField()))
+	})
+
+	t.Run("digest of the assertions", func(t *testing.T) {
+		_, public, err := NewBatchAssignment(2, assertions)
+		require.NoError(t, err)
+		proof := &BatchProof{
+			Proof:      Proof{PublicInputs: []string{fmt.Sprintf("0x%064x", 1), fmt.Sprintf("0x%064x", 2)}},
+			Assertions: public,
+		}
+		assert.ErrorIs(t, VerifyBatch(nil, 2, proof), ErrInvalidBatch)
+
+		proof.Assertions = [][]string{public[0][1:]}
+		assert.ErrorIs(t, VerifyBatch(nil, 2, proof), ErrMalformedProof)
+	})
+
+	t.Run("invalid batch size", func(t *testing.T) {
+		_, _, err := NewBatchAssignment(1, assertions)
+		assert.ErrorIs(t, err, ErrInvalidBatch)
+		_, _, err = NewBatchAssignment(2, nil)
+		assert.ErrorIs(t, err, ErrInvalidBatch)
+	})
+
+	t.Run("assertion of another hash", func(t *testing.T) {
+		other, err := sampleAssertion(HashPoseidon2)
+		require.NoError(t, err)
+		_, _, err = NewBatchAssignment(2, []*AssertionCircuit{assertions[0], other})
+		assert.ErrorIs(t, err, ErrInvalidBatch)
+	})
+}
+
+func TestAssertionFromWitness(t *testing.T) {
+	assignment := newTestAssertion(t)
+	w, err := frontend.NewWitness(assignment, ecc.BN254.ScalarField())
+	require.NoError(t, err)
+
+	rebuilt, err := AssertionFromWitness(HashMiMC, w)
+	require.NoError(t, err)
+	got, err := frontend.NewWitness(rebuilt, ecc.BN254.ScalarField())
+	require.NoError(t, err)
+	assert.Equal(t, w.Vector(), got.Vector())
+
+	public, err := w.Public()
+	require.NoError(t, err)
+	_, err = AssertionFromWitness(HashMiMC, public)
+	assert.ErrorIs(t, err, ErrMalformedProof)
+}
@@ -0,0 +1,142 @@
+package zk
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	kzgbn254 "github.com/consensys/gnark-crypto/ecc/bn254/kzg"
+	"github.com/consensys/gnark/constraint"
+	"github.com/consensys/gnark/frontend"
+)
+
+// memorySampleInterval is how often the heap is sampled while measuring the
+// peak memory of a step.
+const memorySampleInterval = 10 * time.Millisecond
+
+// BenchmarkResult is the cost of a circuit with a backend, as measured by
+// RunBenchmark. Durations are in nanoseconds and sizes in bytes.
+type BenchmarkResult struct {
+	CircuitID     string  `json:"circuitId"`
+	Backend       Backend `json:"backend"`
+	NbConstraints int     `json:"nbConstraints"`
+	NbPublic      int     `json:"nbPublic"`
+	NbSecret      int     `json:"nbSecret"`
+
+	// WitnessSize is the size of the binary encoded full witness.
+	WitnessSize int `json:"witnessSize"`
+	ProofSize   int `json:"proofSize"`
+
+	CompileTime time.Duration `json:"compileTime"`
+	SetupTime   time.Duration `json:"setupTime"`
+	ProveTime   time.Duration `json:"proveTime"`
+	VerifyTime  time.Duration `json:"verifyTime"`
+
+	// PeakMemory is the largest heap in use during any of the steps.
+	PeakMemory uint64 `json:"peakMemory"`
+}
+
+// RunBenchmark compiles the circuit, runs its setup, and proves and verifies
+// its sample assignment with the backend. The keys are kept in memory only.
+// srs is only used by PLONK.
+func RunBenchmark(backend Backend, srs *kzgbn254.SRS, def CircuitDefinition) (*BenchmarkResult, error) {
+	if def.Sample == nil {
+		return nil, fmt.Errorf("circuit %s has no sample assignment", def.ID)
+	}
+	assignment, err := def.Sample()
+	if err != nil {
+		return nil, fmt.Errorf("error sampling assignment of %s: %w", def.ID, err)
+	}
+	w, err := frontend.NewWitness(assignment, ecc.BN254.ScalarField())
+	if err != nil {
+		return nil, fmt.Errorf("error building witness: %w", err)
+	}
+	encoded, err := w.MarshalBinary()
+	if err != nil {
+		return nil, fmt.Errorf("error encoding witness: %w", err)
+	}
+	result := &BenchmarkResult{
+		CircuitID:   def.ID,
+		Backend:     backend,
+		WitnessSize: len(encoded),
+	}
+
+	var ccs constraint.ConstraintSystem
+	result.CompileTime, err = result.measure(func() (err error) {
+		ccs, err = def.Compile(backend)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	result.NbConstraints = ccs.GetNbConstraints()
+	result.NbPublic = backend.nbPublic(ccs)
+	result.NbSecret = ccs.GetNbSecretVariables()
+
+	var pk ProvingKey
+	var vk VerifyingKey
+	result.SetupTime, err = result.measure(func() (err error) {
+		pk, vk, err = backend.setup(ccs, srs)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("setup circuit %s: %w", def.ID, err)
+	}
+
+	var proof []byte
+	result.ProveTime, err = result.measure(func() (err error) {
+		proof, err = backend.prove(ccs, pk, w, TargetGnark)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error proving circuit %s: %w", def.ID, err)
+	}
+	result.ProofSize = len(proof)
+
+	public, err := w.Public()
+	if err != nil {
+		return nil, fmt.Errorf("error extracting public witness: %w", err)
+	}
+	result.VerifyTime, err = result.measure(func() error {
+		return backend.verify(vk, proof, public, TargetGnark)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// measure runs a step, returning its duration and raising the peak memory to
+// the largest heap sampled while it ran.
+func (r *BenchmarkResult) measure(step func() error) (time.Duration, error) {
+	runtime.GC()
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	var peak uint64
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(memorySampleInterval)
+		defer ticker.Stop()
+		var stats runtime.MemStats
+		for {
+			runtime.ReadMemStats(&stats)
+			peak = max(peak, stats.HeapInuse)
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	start := time.Now()
+	err := step()
+	elapsed := time.Since(start)
+	close(done)
+	wg.Wait()
+	r.PeakMemory = max(r.PeakMemory, peak)
+	return elapsed, err
+}
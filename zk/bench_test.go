@@ -0,0 +1,60 @@
+package zk
+
+import (
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark/frontend"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunBenchmark(t *testing.T) {
+	result, err := RunBenchmark(BackendGroth16, nil, ChallengeCircuitDefinition)
+	require.NoError(t, err)
+	assert.Equal(t, ChallengeCircuitDefinition.ID, result.CircuitID)
+	assert.Equal(t, BackendGroth16, result.Backend)
+	assert.Positive(t, result.NbConstraints)
+	assert.Equal(t, 6, result.NbPublic)
+	assert.Positive(t, result.WitnessSize)
+	assert.Positive(t, result.ProofSize)
+	assert.Positive(t, result.ProveTime)
+	assert.Positive(t, result.PeakMemory)
+
+	_, err = RunBenchmark(BackendGroth16, nil, preimageCircuitDefinition)
+	assert.Error(t, err)
+}
+
+// BenchmarkCircuits measures proving and verification of every circuit, and
+// reports its constraint count. The setup runs once per circuit, outside of
+// the measurements.
+func BenchmarkCircuits(b *testing.B) {
+	for _, def := range append(Circuits(), BatchCircuitDefinition(2)) {
+		b.Run(def.ID, func(b *testing.B) {
+			ccs, err := def.Compile(BackendGroth16)
+			require.NoError(b, err)
+			pk, vk, err := BackendGroth16.setup(ccs, nil)
+			require.NoError(b, err)
+			assignment, err := def.Sample()
+			require.NoError(b, err)
+			w, err := frontend.NewWitness(assignment, ecc.BN254.ScalarField())
+			require.NoError(b, err)
+			public, err := w.Public()
+			require.NoError(b, err)
+
+			var proof []byte
+			b.Run("prove", func(b *testing.B) {
+				b.ReportMetric(float64(ccs.GetNbConstraints()), "constraints")
+				for i := 0; i < b.N; i++ {
+					proof, err = BackendGroth16.prove(ccs, pk, w, TargetGnark)
+					require.NoError(b, err)
+				}
+			})
+			b.Run("verify", func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					require.NoError(b, BackendGroth16.verify(vk, proof, public, TargetGnark))
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,381 @@
+package zk
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"reflect"
+	"time"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark/backend/groth16/bn254/mpcsetup"
+	"github.com/consensys/gnark/constraint"
+	cs "github.com/consensys/gnark/constraint/bn254"
+)
+
+const transcriptFile = "transcript.json"
+
+var (
+	// ErrCeremonyUnsupported is returned for circuits the Groth16 ceremony
+	// cannot set up.
+	ErrCeremonyUnsupported = errors.New("zk: circuit is not supported by the setup ceremony")
+
+	// ErrCeremonyPhase is returned when a step does not apply to the current
+	// phase of the ceremony.
+	ErrCeremonyPhase = errors.New("zk: invalid ceremony phase")
+
+	// ErrInvalidContribution is returned when the contribution chain does not
+	// verify.
+	ErrInvalidContribution = errors.New("zk: invalid ceremony contribution")
+)
+
+// Contribution is an entry of the ceremony transcript. The first entry of
+// each phase is its initial state rather than a contribution.
+type Contribution struct {
+	Phase       int       `json:"phase"`
+	Index       int       `json:"index"`
+	Participant string    `json:"participant,omitempty"`
+	Hash        string    `json:"hash"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+// Transcript records every state of a ceremony. Participants publish the
+// hash of their contribution so that anyone can check it is part of the
+// chain the keys were extracted from.
+type Transcript struct {
+	CircuitID string         `json:"circuitId"`
+	Power     int            `json:"power"`
+	Phase1    []Contribution `json:"phase1"`
+	Phase2    []Contribution `json:"phase2,omitempty"`
+}
+
+// Ceremony is a multi-party Groth16 setup of a circuit, following
+// https://eprint.iacr.org/2017/1050. Phase 1 computes powers of tau sized for
+// the circuit and phase 2 specializes them to its constraints. The keys are
+// sound as long as a single participant discarded their randomness.
+//
+// The ceremony lives in a directory that is passed from one participant to
+// the next, holding the transcript and the state after each contribution.
+type Ceremony struct {
+	dir        string
+	transcript Transcript
+}
+
+// InitCeremony starts the next phase of the ceremony of the circuit in dir.
+// On an empty directory it sizes and starts phase 1; once phase 1 has
+// contributions, it starts phase 2 from its last state. No phase 1
+// contributions are accepted after that.
+func InitCeremony(dir string, def CircuitDefinition) (*Ceremony, error) {
+	ccs, err := ceremonyConstraintSystem(def)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := OpenCeremony(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		c = &Ceremony{
+			dir: dir,
+			transcript: Transcript{
+				CircuitID: def.ID,
+				Power:     ceremonyPower(ccs),
+			},
+		}
+		phase1 := mpcsetup.InitPhase1(c.transcript.Power)
+		if err := c.append(1, "", &phase1, phase1.Hash); err != nil {
+			return nil, err
+		}
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if c.transcript.CircuitID != def.ID {
+		return nil, fmt.Errorf("%w: ceremony is for circuit %s, not %s", ErrCeremonyPhase, c.transcript.CircuitID, def.ID)
+	}
+	if c.Phase() != 1 {
+		return nil, fmt.Errorf("%w: phase 2 was already initialized", ErrCeremonyPhase)
+	}
+	if len(c.transcript.Phase1) < 2 {
+		return nil, fmt.Errorf("%w: phase 1 has no contributions", ErrCeremonyPhase)
+	}
+
+	var phase1 mpcsetup.Phase1
+	if err := c.read(c.last(), &phase1); err != nil {
+		return nil, err
+	}
+	phase2, _ := mpcsetup.InitPhase2(ccs, &phase1)
+	if err := c.append(2, "", &phase2, phase2.Hash); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// OpenCeremony opens the ceremony in dir.
+func OpenCeremony(dir string) (*Ceremony, error) {
+	data, err := os.ReadFile(filepath.Join(dir, transcriptFile))
+	if err != nil {
+		return nil, fmt.Errorf("read transcript: %w", err)
+	}
+	c := &Ceremony{dir: dir}
+	if err := json.Unmarshal(data, &c.transcript); err != nil {
+		return nil, fmt.Errorf("decode transcript: %w", err)
+	}
+	return c, nil
+}
+
+// Transcript returns the transcript of the ceremony.
+func (c *Ceremony) Transcript() Transcript {
+	return c.transcript
+}
+
+// Phase returns the current phase of the ceremony.
+func (c *Ceremony) Phase() int {
+	if len(c.transcript.Phase2) > 0 {
+		return 2
+	}
+	return 1
+}
+
+// Contribute adds fresh randomness to the last state of the current phase.
+// The randomness is sampled from crypto/rand and never leaves this function.
+func (c *Ceremony) Contribute(participant string) (*Contribution, error) {
+	if c.Phase() == 1 {
+		var phase1 mpcsetup.Phase1
+		if err := c.read(c.last(), &phase1); err != nil {
+			return nil, err
+		}
+		phase1.Contribute()
+		if err := c.append(1, participant, &phase1, phase1.Hash); err != nil {
+			return nil, err
+		}
+	} else {
+		var phase2 mpcsetup.Phase2
+		if err := c.read(c.last(), &phase2); err != nil {
+			return nil, err
+		}
+		phase2.Contribute()
+		if err := c.append(2, participant, &phase2, phase2.Hash); err != nil {
+			return nil, err
+		}
+	}
+	last := c.last()
+	return &last, nil
+}
+
+// Verify checks the whole contribution chain: that each phase starts from
+// the expected initial state, that every contribution builds on the previous
+// state with knowledge of its randomness, and that the hashes match the
+// transcript.
+func (c *Ceremony) Verify(def CircuitDefinition) error {
+	_, err := c.verify(def)
+	return err
+}
+
+// Finalize verifies the ceremony and writes the keys extracted from its last
+// state to the key store. At least one phase 2 contribution is required.
+func (c *Ceremony) Finalize(def CircuitDefinition, ks *KeyStore) (*Keys, error) {
+	if c.Phase() != 2 || len(c.transcript.Phase2) < 2 {
+		return nil, fmt.Errorf("%w: phase 2 has no contributions", ErrCeremonyPhase)
+	}
+	state, err := c.verify(def)
+	if err != nil {
+		return nil, err
+	}
+	pk, vk := mpcsetup.ExtractKeys(state.phase1, state.phase2, state.evals, state.nbConstraints)
+	return ks.Import(def, &pk, &vk, c.last().Hash)
+}
+
+// ceremonyState is the last state of a verified ceremony.
+type ceremonyState struct {
+	phase1        *mpcsetup.Phase1
+	phase2        *mpcsetup.Phase2
+	evals         *mpcsetup.Phase2Evaluations
+	nbConstraints int
+}
+
+func (c *Ceremony) verify(def CircuitDefinition) (*ceremonyState, error) {
+	if c.transcript.CircuitID != def.ID {
+		return nil, fmt.Errorf("%w: ceremony is for circuit %s, not %s", ErrCeremonyPhase, c.transcript.CircuitID, def.ID)
+	}
+	ccs, err := ceremonyConstraintSystem(def)
+	if err != nil {
+		return nil, err
+	}
+	if power := ceremonyPower(ccs); power != c.transcript.Power {
+		return nil, fmt.Errorf("%w: phase 1 has size 2^%d, circuit needs 2^%d", ErrInvalidContribution, c.transcript.Power, power)
+	}
+	state := &ceremonyState{nbConstraints: ccs.GetNbConstraints()}
+
+	// the initial states carry a random public key, so only their
+	// parameters are compared with the expected ones
+	initial1 := mpcsetup.InitPhase1(c.transcript.Power)
+	state.phase1, err = verifyChain(c, c.transcript.Phase1, func(initial *mpcsetup.Phase1) bool {
+		return reflect.DeepEqual(initial.Parameters, initial1.Parameters)
+	}, func(prev, next *mpcsetup.Phase1) error {
+		return mpcsetup.VerifyPhase1(prev, next)
+	})
+	if err != nil || len(c.transcript.Phase2) == 0 {
+		return state, err
+	}
+
+	// the evaluations are not covered by the phase 2 contributions and are
+	// recomputed from the last phase 1 state
+	initial2, evals := mpcsetup.InitPhase2(ccs, state.phase1)
+	state.evals = &evals
+	state.phase2, err = verifyChain(c, c.transcript.Phase2, func(initial *mpcsetup.Phase2) bool {
+		return reflect.DeepEqual(initial.Parameters, initial2.Parameters)
+	}, func(prev, next *mpcsetup.Phase2) error {
+		return mpcsetup.VerifyPhase2(prev, next)
+	})
+	return state, err
+}
+
+// verifyChain reads every state of a phase, checking it against the previous
+// one and the transcript, and returns the last state.
+func verifyChain[T any, P interface {
+	*T
+	io.ReaderFrom
+}](c *Ceremony, contributions []Contribution, isInitial func(P) bool, verify func(prev, next P) error) (P, error) {
+	if len(contributions) == 0 {
+		return nil, fmt.Errorf("%w: missing initial state", ErrInvalidContribution)
+	}
+
+	var prev P
+	for i := range contributions {
+		next := P(new(T))
+		if err := c.read(contributions[i], next); err != nil {
+			return nil, err
+		}
+		if hash := stateHash(next); hash != contributions[i].Hash {
+			return nil, fmt.Errorf("%w: phase %d state %d has hash %s, transcript records %s", ErrInvalidContribution, contributions[i].Phase, i, hash, contributions[i].Hash)
+		}
+		if prev == nil && !isInitial(next) {
+			return nil, fmt.Errorf("%w: phase %d does not start from the expected initial state", ErrInvalidContribution, contributions[i].Phase)
+		}
+		if prev != nil {
+			if err := verify(prev, next); err != nil {
+				return nil, fmt.Errorf("%w: phase %d contribution %d: %s", ErrInvalidContribution, contributions[i].Phase, i, err)
+			}
+		}
+		prev = next
+	}
+	return prev, nil
+}
+
+// append writes the next state of a phase and records it in the transcript.
+func (c *Ceremony) append(phase int, participant string, state io.WriterTo, hash []byte) error {
+	contributions := &c.transcript.Phase1
+	if phase == 2 {
+		contributions = &c.transcript.Phase2
+	}
+	contribution := Contribution{
+		Phase:       phase,
+		Index:       len(*contributions),
+		Participant: participant,
+		Hash:        hex.EncodeToString(hash),
+		CreatedAt:   time.Now().UTC(),
+	}
+
+	if err := os.MkdirAll(filepath.Join(c.dir, fmt.Sprintf("phase%d", phase)), 0o755); err != nil {
+		return fmt.Errorf("create ceremony directory: %w", err)
+	}
+	if err := writeTo(c.statePath(contribution), state); err != nil {
+		return err
+	}
+
+	// the transcript is written last, so an interrupted contribution is
+	// simply overwritten by the next one
+	*contributions = append(*contributions, contribution)
+	data, err := json.MarshalIndent(c.transcript, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode transcript: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(c.dir, transcriptFile), data, 0o644); err != nil {
+		return fmt.Errorf("write transcript: %w", err)
+	}
+	return nil
+}
+
+func (c *Ceremony) read(contribution Contribution, state io.ReaderFrom) error {
+	return readFrom(c.statePath(contribution), state)
+}
+
+// last returns the last entry of the current phase.
+func (c *Ceremony) last() Contribution {
+	if c.Phase() == 2 {
+		return c.transcript.Phase2[len(c.transcript.Phase2)-1]
+	}
+	return c.transcript.Phase1[len(c.transcript.Phase1)-1]
+}
+
+func (c *Ceremony) statePath(contribution Contribution) string {
+	return filepath.Join(c.dir, fmt.Sprintf("phase%d", contribution.Phase), fmt.Sprintf("%04d.bin", contribution.Index))
+}
+
+// ceremonyConstraintSystem compiles the circuit for the ceremony, which does
+// not support the commitments used e.g. by emulated arithmetic.
+func ceremonyConstraintSystem(def CircuitDefinition) (*cs.R1CS, error) {
+	ccs, err := def.Compile(BackendGroth16)
+	if err != nil {
+		return nil, err
+	}
+	if len(ccs.GetCommitments().CommitmentIndexes()) > 0 {
+		return nil, fmt.Errorf("%w: %s uses commitments", ErrCeremonyUnsupported, def.ID)
+	}
+	return ccs.(*cs.R1CS), nil
+}
+
+// ceremonyPower returns the log size of the circuit's evaluation domain,
+// which phase 1 must match exactly.
+func ceremonyPower(ccs constraint.ConstraintSystem) int {
+	return bits.TrailingZeros64(ecc.NextPowerOfTwo(uint64(ccs.GetNbConstraints())))
+}
+
+// stateHash returns the hash a contribution state carries.
+func stateHash(state any) string {
+	switch state := state.(type) {
+	case *mpcsetup.Phase1:
+		return hex.EncodeToString(state.Hash)
+	case *mpcsetup.Phase2:
+		return hex.EncodeToString(state.Hash)
+	}
+	return ""
+}
+
+func writeTo(path string, v io.WriterTo) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("create %s: %w", path, err)
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	if _, err := v.WriteTo(w); err != nil {
+		return fmt.Errorf("write %s: %w", path, err)
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("write %s: %w", path, err)
+	}
+	return f.Sync()
+}
+
+// readFrom decodes the file at path. The file is read at once since the
+// mpcsetup decoders expect reads to fill their buffers.
+func readFrom(path string, v io.ReaderFrom) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read %s: %w", path, err)
+	}
+	if _, err := v.ReadFrom(bytes.NewReader(data)); err != nil {
+		return fmt.Errorf("decode %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package zk
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr/mimc"
+	"github.com/consensys/gnark/frontend"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCeremony(t *testing.T) {
+	dir := t.TempDir()
+
+	c, err := InitCeremony(dir, preimageCircuitDefinition)
+	require.NoError(t, err)
+	assert.Equal(t, 1, c.Phase())
+
+	_, err = c.Finalize(preimageCircuitDefinition, NewKeyStore(t.TempDir()))
+	assert.ErrorIs(t, err, ErrCeremonyPhase)
+	_, err = InitCeremony(dir, preimageCircuitDefinition)
+	assert.ErrorIs(t, err, ErrCeremonyPhase, "phase 2 requires phase 1 contributions")
+
+	for _, participant := range []string{"alice", "bob"} {
+		c, err := OpenCeremony(dir)
+		require.NoError(t, err)
+		contribution, err := c.Contribute(participant)
+		require.NoError(t, err)
+		assert.Equal(t, 1, contribution.Phase)
+		assert.Len(t, contribution.Hash, 64)
+	}
+
+	c, err = InitCeremony(dir, preimageCircuitDefinition)
+	require.NoError(t, err)
+	assert.Equal(t, 2, c.Phase())
+
+	var hashes []string
+	for _, participant := range []string{"alice", "carol"} {
+		c, err := OpenCeremony(dir)
+		require.NoError(t, err)
+		contribution, err := c.Contribute(participant)
+		require.NoError(t, err)
+		assert.Equal(t, 2, contribution.Phase)
+		hashes = append(hashes, contribution.Hash)
+	}
+
+	c, err = OpenCeremony(dir)
+	require.NoError(t, err)
+	transcript := c.Transcript()
+	assert.Len(t, transcript.Phase1, 3)
+	assert.Len(t, transcript.Phase2, 3)
+	assert.Equal(t, hashes[1], transcript.Phase2[2].Hash)
+	require.NoError(t, c.Verify(preimageCircuitDefinition))
+
+	t.Run("finalized keys prove and verify", func(t *testing.T) {
+		keysDir := t.TempDir()
+		keys, err := c.Finalize(preimageCircuitDefinition, NewKeyStore(keysDir))
+		require.NoError(t, err)
+		assert.Equal(t, hashes[1], keys.Manifest.Ceremony)
+
+		loaded, err := NewKeyStore(keysDir).Load(preimageCircuitDefinition)
+		require.NoError(t, err)
+
+		var preImage fr.Element
+		preImage.SetUint64(42)
+		h := mimc.NewMiMC()
+		b := preImage.Bytes()
+		h.Write(b[:])
+		proof, err := Prove(loaded, &Circuit{PreImage: preImage, Hash: h.Sum(nil)})
+		require.NoError(t, err)
+		assert.NoError(t, Verify(loaded, proof))
+	})
+
+	t.Run("tampered contribution", func(t *testing.T) {
+		tampered := t.TempDir()
+		require.NoError(t, os.CopyFS(tampered, os.DirFS(dir)))
+		// replace the last phase 2 state with the previous one
+		data, err := os.ReadFile(filepath.Join(tampered, "phase2", "0001.bin"))
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(filepath.Join(tampered, "phase2", "0002.bin"), data, 0o644))
+
+		c, err := OpenCeremony(tampered)
+		require.NoError(t, err)
+		assert.ErrorIs(t, c.Verify(preimageCircuitDefinition), ErrInvalidContribution)
+		_, err = c.Finalize(preimageCircuitDefinition, NewKeyStore(t.TempDir()))
+		assert.ErrorIs(t, err, ErrInvalidContribution)
+	})
+
+	t.Run("other circuit", func(t *testing.T) {
+		def := CircuitDefinition{
+			ID:  preimageCircuitDefinition.ID,
+			New: func() frontend.Circuit { return &doublePreimageCircuit{} },
+		}
+		assert.Error(t, c.Verify(def))
+	})
+
+	t.Run("circuit with commitments", func(t *testing.T) {
+		def := CircuitDefinition{
+			ID:  "test-commit",
+			New: func() frontend.Circuit { return &commitCircuit{} },
+		}
+		_, err := InitCeremony(t.TempDir(), def)
+		assert.ErrorIs(t, err, ErrCeremonyUnsupported)
+	})
+}
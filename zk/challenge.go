@@ -0,0 +1,158 @@
+package zk
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	"github.com/consensys/gnark/frontend"
+)
+
+// IntentHashLen is the size of the hashed transaction intent a challenge is
+// bound to.
+const IntentHashLen = 32
+
+// ErrInvalidIntentHash is returned when the intent hash is not IntentHashLen bytes.
+var ErrInvalidIntentHash = errors.New("zk: invalid intent hash")
+
+// ChallengeCircuit proves that a WebAuthn challenge was derived from
+// randomness the server committed to, together with the intent and its nonce:
+//
+//	Commitment = H(Seed)
+//	Challenge  = H(Commitment, IntentHash, Nonce)
+//
+// where H is the hash of the circuit version. The challenge is public: anyone
+// can recompute it from the commitment, the intent hash and the nonce. The
+// circuit also exposes the Pedersen commitment Cc to the challenge, which the
+// assertion circuit opens to the challenge it proves a signature for. Cc ties
+// the two proofs together; it doesn't hide the challenge.
+type ChallengeCircuit struct {
+	// private inputs (witnesses)
+	Seed              frontend.Variable
+	ChallengeBlinding frontend.Variable
+
+	// public inputs
+	Commitment frontend.Variable `gnark:",public"`
+	IntentHash frontend.Variable `gnark:",public"`
+	Nonce      frontend.Variable `gnark:",public"`
+	Challenge  frontend.Variable `gnark:",public"`
+
+	ChallengeCommitment PedersenCommitment `gnark:",public"`
+
+	hash Hash
+}
+
+// Define declares the circuit's constraints
+func (circuit *ChallengeCircuit) Define(api frontend.API) error {
+	h, err := circuit.hash.New(api)
+	if err != nil {
+		return err
+	}
+
+	// the commitment opens to the server's randomness
+	h.Write(circuit.Seed)
+	api.AssertIsEqual(h.Sum(), circuit.Commitment)
+
+	// the challenge is bound to the commitment and the intent
+	h.Reset()
+	h.Write(circuit.Commitment, circuit.IntentHash, circuit.Nonce)
+	api.AssertIsEqual(h.Sum(), circuit.Challenge)
+
+	return AssertPedersenCommitment(api, circuit.ChallengeCommitment, splitLimbs(api, circuit.Challenge, 2), circuit.ChallengeBlinding)
+}
+
+// NewChallengeCircuit returns a new challenge derivation circuit hashing
+// with h
+func NewChallengeCircuit(h Hash) *ChallengeCircuit {
+	return &ChallengeCircuit{hash: h}
+}
+
+// Challenge is a WebAuthn challenge derived as described by ChallengeCircuit.
+type Challenge struct {
+	Hash       Hash
+	Seed       fr.Element
+	Commitment fr.Element
+	IntentHash fr.Element
+	Nonce      fr.Element
+	Challenge  fr.Element
+
+	// Opening opens the Pedersen commitment to the challenge.
+	Opening *Opening
+}
+
+// NewChallenge samples fresh randomness and derives the challenge for the
+// given intent hash and nonce with the hash h.
+func NewChallenge(h Hash, intentHash []byte, nonce uint64) (*Challenge, error) {
+	return newChallenge(rand.Reader, h, intentHash, nonce)
+}
+
+// newChallenge is NewChallenge with the randomness read from rng.
+func newChallenge(rng io.Reader, h Hash, intentHash []byte, nonce uint64) (*Challenge, error) {
+	if len(intentHash) != IntentHashLen {
+		return nil, fmt.Errorf("%w: expected %d bytes, got %d", ErrInvalidIntentHash, IntentHashLen, len(intentHash))
+	}
+
+	c := Challenge{Hash: h}
+	seed, err := rand.Int(rng, fr.Modulus())
+	if err != nil {
+		return nil, fmt.Errorf("error generating challenge seed: %w", err)
+	}
+	c.Seed.SetBigInt(seed)
+	// the intent hash is reduced into the scalar field
+	c.IntentHash.SetBytes(intentHash)
+	c.Nonce.SetUint64(nonce)
+	if c.Commitment, err = h.Sum(c.Seed); err != nil {
+		return nil, err
+	}
+	if c.Challenge, err = h.Sum(c.Commitment, c.IntentHash, c.Nonce); err != nil {
+		return nil, err
+	}
+
+	opening, err := newChallengeOpening(rng, c.Challenge)
+	if err != nil {
+		return nil, err
+	}
+	c.Opening = opening
+	return &c, nil
+}
+
+// DeriveChallenge recomputes the challenge derived for an intent hash and
+// nonce from the commitment to the server's randomness with the hash h, e.g.
+// to check a stored challenge against its intent.
+func DeriveChallenge(h Hash, commitment, intentHash []byte, nonce uint64) (fr.Element, error) {
+	if len(intentHash) != IntentHashLen {
+		return fr.Element{}, fmt.Errorf("%w: expected %d bytes, got %d", ErrInvalidIntentHash, IntentHashLen, len(intentHash))
+	}
+	var c, i, n fr.Element
+	if err := c.SetBytesCanonical(commitment); err != nil {
+		return fr.Element{}, fmt.Errorf("invalid commitment: %w", err)
+	}
+	i.SetBytes(intentHash)
+	n.SetUint64(nonce)
+	return h.Sum(c, i, n)
+}
+
+// Bytes returns the challenge as sent to the authenticator: the big-endian
+// encoding of the derived field element.
+func (c *Challenge) Bytes() []byte {
+	b := c.Challenge.Bytes()
+	return b[:]
+}
+
+// Assignment returns the witness proving the challenge derivation.
+func (c *Challenge) Assignment() *ChallengeCircuit {
+	return &ChallengeCircuit{
+		Seed:       c.Seed,
+		Commitment: c.Commitment,
+		IntentHash: c.IntentHash,
+		Nonce:      c.Nonce,
+		Challenge:  c.Challenge,
+
+		ChallengeBlinding:   c.Opening.Blinding,
+		ChallengeCommitment: c.Opening.Assignment(),
+
+		hash: c.Hash,
+	}
+}
@@ -0,0 +1,65 @@
+package zk
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChallengeProof(t *testing.T) {
+	for _, def := range []CircuitDefinition{ChallengeCircuitDefinition, Poseidon2ChallengeCircuitDefinition} {
+		t.Run(def.ID, func(t *testing.T) {
+			keys, err := NewKeyStore(t.TempDir()).Setup(def)
+			require.NoError(t, err)
+
+			intentHash := sha256.Sum256([]byte("transfer 1 SOL"))
+			challenge, err := NewChallenge(def.Hash, intentHash[:], 7)
+			require.NoError(t, err)
+			assert.Len(t, challenge.Bytes(), 32)
+
+			commitment := challenge.Commitment.Bytes()
+			derived, err := DeriveChallenge(def.Hash, commitment[:], intentHash[:], 7)
+			require.NoError(t, err)
+			assert.Equal(t, challenge.Challenge, derived)
+
+			proof, err := Prove(keys, challenge.Assignment())
+			require.NoError(t, err)
+			assert.Equal(t, def.ID, proof.CircuitID)
+			require.Len(t, proof.PublicInputs, 6)
+			assert.Equal(t, fmt.Sprintf("0x%064x", challenge.Bytes()), proof.PublicInputs[3])
+			assert.NoError(t, Verify(keys, proof))
+
+			t.Run("tampered nonce", func(t *testing.T) {
+				tampered := *proof
+				tampered.PublicInputs = append([]string{}, proof.PublicInputs...)
+				tampered.PublicInputs[2] = fmt.Sprintf("0x%064x", 8)
+				assert.Error(t, Verify(keys, &tampered))
+			})
+		})
+	}
+
+	t.Run("hashes derive different challenges", func(t *testing.T) {
+		commitment := make([]byte, 32)
+		mimc, err := DeriveChallenge(HashMiMC, commitment, make([]byte, IntentHashLen), 1)
+		require.NoError(t, err)
+		poseidon2, err := DeriveChallenge(HashPoseidon2, commitment, make([]byte, IntentHashLen), 1)
+		require.NoError(t, err)
+		assert.NotEqual(t, mimc, poseidon2)
+	})
+
+	t.Run("invalid intent hash", func(t *testing.T) {
+		intentHash := sha256.Sum256([]byte("transfer 1 SOL"))
+		_, err := NewChallenge(HashMiMC, intentHash[:16], 7)
+		assert.ErrorIs(t, err, ErrInvalidIntentHash)
+	})
+
+	t.Run("unknown hash", func(t *testing.T) {
+		_, err := NewChallenge("sha256", make([]byte, IntentHashLen), 7)
+		assert.ErrorIs(t, err, ErrUnknownHash)
+		_, err = ChallengeCircuitDefinitionFor("sha256")
+		assert.ErrorIs(t, err, ErrUnknownHash)
+	})
+}
@@ -0,0 +1,281 @@
+package zk
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/std/hash/sha2"
+	"github.com/consensys/gnark/std/lookup/logderivlookup"
+	"github.com/consensys/gnark/std/math/cmp"
+	"github.com/consensys/gnark/std/math/uints"
+)
+
+const (
+	// MaxClientDataJSONLen is the size of the longest clientDataJSON the
+	// assertion circuit accepts. Browsers may add fields to the ones defined
+	// by WebAuthn, so it leaves room for them.
+	MaxClientDataJSONLen = 384
+
+	// MaxOriginLen is the size of the longest origin the assertion circuit
+	// accepts.
+	MaxOriginLen = 64
+
+	// MaxAllowedOrigins is the number of allowed origins the assertion circuit
+	// takes as public inputs.
+	MaxAllowedOrigins = 4
+
+	// challengeB64Len is the length of a base64url encoded 32 bytes challenge,
+	// without padding.
+	challengeB64Len = 43
+
+	// originChunkLen is the number of origin bytes packed in a field element
+	// of the origin digest.
+	originChunkLen = 16
+)
+
+var (
+	typeKey      = []byte(`"type":"webauthn.get"`)
+	challengeKey = []byte(`"challenge":"`)
+	originKey    = []byte(`"origin":"`)
+)
+
+var (
+	// ErrInvalidClientData is returned when clientDataJSON is too long, is not
+	// a webauthn.get assertion, or does not carry the expected challenge.
+	ErrInvalidClientData = errors.New("zk: invalid client data")
+
+	// ErrInvalidOrigin is returned when an origin is too long to be proven, or
+	// when the origin of clientDataJSON is not allowed.
+	ErrInvalidOrigin = errors.New("zk: invalid origin")
+)
+
+// ClientData is the clientDataJSON of an assertion, padded with zeros to
+// MaxClientDataJSONLen bytes, with the positions of the fields the assertion
+// circuit checks. The positions are found out of circuit and checked in
+// circuit.
+type ClientData struct {
+	JSON [MaxClientDataJSONLen]uints.U8
+	Len  frontend.Variable
+
+	// TypeIndex, ChallengeIndex and OriginIndex are the positions of the
+	// opening quote of the "type", "challenge" and "origin" keys.
+	TypeIndex      frontend.Variable
+	ChallengeIndex frontend.Variable
+	OriginIndex    frontend.Variable
+	OriginLen      frontend.Variable
+}
+
+// AssertClientData checks that the clientDataJSON is a webauthn.get assertion
+// for the challenge, from an origin whose digest with h is one of
+// allowedOrigins, and returns its SHA-256 digest. Each checked field must be a key of the JSON
+// object, i.e. follow '{' or ','.
+func AssertClientData(api frontend.API, h Hash, cd ClientData, challenge frontend.Variable, allowedOrigins []frontend.Variable) ([]uints.U8, error) {
+	bf, err := uints.New[uints.U32](api)
+	if err != nil {
+		return nil, fmt.Errorf("new binary field: %w", err)
+	}
+	sha, err := sha2.New(api)
+	if err != nil {
+		return nil, fmt.Errorf("new sha256: %w", err)
+	}
+	comparator := cmp.NewBoundedComparator(api, big.NewInt(2*(MaxClientDataJSONLen+MaxOriginLen)), false)
+	comparator.AssertIsLessEq(cd.Len, MaxClientDataJSONLen)
+
+	// the bytes are range checked before being hashed and looked up. The
+	// table is padded so that the origin can be read past the end.
+	json := make([]uints.U8, len(cd.JSON))
+	table := logderivlookup.New(api)
+	for i := range cd.JSON {
+		json[i] = bf.ByteValueOf(cd.JSON[i].Val)
+		table.Insert(json[i].Val)
+	}
+	for i := 0; i < MaxOriginLen; i++ {
+		table.Insert(0)
+	}
+	sha.Write(json)
+	digest := sha.FixedLengthSum(cd.Len)
+
+	// assertKey checks that the key is at index, after '{' or ',', and within
+	// the hashed bytes
+	assertKey := func(index frontend.Variable, key []byte, valueLen frontend.Variable) {
+		comparator.AssertIsLessEq(1, index)
+		comparator.AssertIsLessEq(api.Add(index, len(key), valueLen), cd.Len)
+		prev := table.Lookup(api.Sub(index, 1))[0]
+		api.AssertIsEqual(api.Mul(api.Sub(prev, '{'), api.Sub(prev, ',')), 0)
+		for i, b := range key {
+			api.AssertIsEqual(table.Lookup(api.Add(index, i))[0], b)
+		}
+	}
+
+	assertKey(cd.TypeIndex, typeKey, 0)
+
+	// the challenge is the unpadded base64url encoding of its 32 big-endian
+	// bytes, followed by the closing quote
+	assertKey(cd.ChallengeIndex, challengeKey, challengeB64Len+1)
+	start := api.Add(cd.ChallengeIndex, len(challengeKey))
+	api.AssertIsEqual(table.Lookup(api.Add(start, challengeB64Len))[0], '"')
+	alphabet := base64Table(api)
+	var decoded []frontend.Variable
+	for i := 0; i < challengeB64Len; i++ {
+		v := alphabet.Lookup(table.Lookup(api.Add(start, i))[0])[0]
+		bits := api.ToBinary(v, 6)
+		for j := 5; j >= 0; j-- {
+			decoded = append(decoded, bits[j])
+		}
+	}
+	challengeBits := api.ToBinary(challenge, 256)
+	for i := 0; i < 256; i++ {
+		api.AssertIsEqual(decoded[i], challengeBits[255-i])
+	}
+	for _, bit := range decoded[256:] {
+		api.AssertIsEqual(bit, 0)
+	}
+
+	// the origin is read up to its closing quote and its digest must be
+	// allowed
+	comparator.AssertIsLessEq(1, cd.OriginLen)
+	comparator.AssertIsLessEq(cd.OriginLen, MaxOriginLen)
+	assertKey(cd.OriginIndex, originKey, api.Add(cd.OriginLen, 1))
+	start = api.Add(cd.OriginIndex, len(originKey))
+	api.AssertIsEqual(table.Lookup(api.Add(start, cd.OriginLen))[0], '"')
+	origin := make([]frontend.Variable, MaxOriginLen)
+	for i := range origin {
+		b := table.Lookup(api.Add(start, i))[0]
+		origin[i] = api.Select(comparator.IsLess(i, cd.OriginLen), b, 0)
+	}
+	m, err := h.New(api)
+	if err != nil {
+		return nil, err
+	}
+	m.Write(cd.OriginLen)
+	for i := 0; i < MaxOriginLen; i += originChunkLen {
+		var chunk frontend.Variable = 0
+		for _, b := range origin[i : i+originChunkLen] {
+			chunk = api.Add(api.Mul(chunk, 256), b)
+		}
+		m.Write(chunk)
+	}
+	originDigest := m.Sum()
+	var allowed frontend.Variable = 1
+	for _, o := range allowedOrigins {
+		allowed = api.Mul(allowed, api.Sub(originDigest, o))
+	}
+	api.AssertIsEqual(allowed, 0)
+
+	return digest, nil
+}
+
+// base64Table returns a lookup table from bytes to the value of their
+// base64url digit, or 64 for bytes outside of the alphabet.
+func base64Table(api frontend.API) *logderivlookup.Table {
+	const alphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789-_"
+	values := make([]int, 256)
+	for i := range values {
+		values[i] = 64
+	}
+	for i := 0; i < len(alphabet); i++ {
+		values[alphabet[i]] = i
+	}
+	table := logderivlookup.New(api)
+	for _, v := range values {
+		table.Insert(v)
+	}
+	return table
+}
+
+// NewClientData returns the witness of the ClientData for clientDataJSON,
+// with the decoded challenge and the origin it carries.
+func NewClientData(clientDataJSON []byte) (ClientData, []byte, string, error) {
+	var cd ClientData
+	if len(clientDataJSON) > MaxClientDataJSONLen {
+		return cd, nil, "", fmt.Errorf("%w: %d bytes, at most %d are supported", ErrInvalidClientData, len(clientDataJSON), MaxClientDataJSONLen)
+	}
+	typeIndex := findKey(clientDataJSON, typeKey)
+	if typeIndex < 0 {
+		return cd, nil, "", fmt.Errorf("%w: not a webauthn.get assertion", ErrInvalidClientData)
+	}
+	challengeIndex := findKey(clientDataJSON, challengeKey)
+	if challengeIndex < 0 {
+		return cd, nil, "", fmt.Errorf("%w: no challenge", ErrInvalidClientData)
+	}
+	encoded := clientDataJSON[challengeIndex+len(challengeKey):]
+	if len(encoded) <= challengeB64Len || encoded[challengeB64Len] != '"' {
+		return cd, nil, "", fmt.Errorf("%w: challenge is not %d characters", ErrInvalidClientData, challengeB64Len)
+	}
+	challenge, err := base64.RawURLEncoding.Strict().DecodeString(string(encoded[:challengeB64Len]))
+	if err != nil {
+		return cd, nil, "", fmt.Errorf("%w: %v", ErrInvalidClientData, err)
+	}
+	originIndex := findKey(clientDataJSON, originKey)
+	if originIndex < 0 {
+		return cd, nil, "", fmt.Errorf("%w: no origin", ErrInvalidClientData)
+	}
+	value := clientDataJSON[originIndex+len(originKey):]
+	originLen := bytes.IndexByte(value, '"')
+	if originLen < 1 || originLen > MaxOriginLen {
+		return cd, nil, "", fmt.Errorf("%w: origin must be 1 to %d bytes", ErrInvalidOrigin, MaxOriginLen)
+	}
+
+	padded := make([]byte, MaxClientDataJSONLen)
+	copy(padded, clientDataJSON)
+	copy(cd.JSON[:], uints.NewU8Array(padded))
+	cd.Len = len(clientDataJSON)
+	cd.TypeIndex = typeIndex
+	cd.ChallengeIndex = challengeIndex
+	cd.OriginIndex = originIndex
+	cd.OriginLen = originLen
+	return cd, challenge, string(value[:originLen]), nil
+}
+
+// findKey returns the position of the first occurrence of key following '{'
+// or ',', or -1.
+func findKey(json, key []byte) int {
+	for i := 1; i+len(key) <= len(json); i++ {
+		if (json[i-1] == '{' || json[i-1] == ',') && bytes.HasPrefix(json[i:], key) {
+			return i
+		}
+	}
+	return -1
+}
+
+// OriginDigest returns the digest of an origin checked by the assertion
+// circuit version hashing with h: the hash of its length and of its bytes,
+// zero padded to MaxOriginLen and packed by 16 in big-endian field elements.
+func OriginDigest(h Hash, origin string) (fr.Element, error) {
+	if len(origin) < 1 || len(origin) > MaxOriginLen || bytes.ContainsAny([]byte(origin), `"\`) {
+		return fr.Element{}, fmt.Errorf("%w: %q", ErrInvalidOrigin, origin)
+	}
+	padded := make([]byte, MaxOriginLen)
+	copy(padded, origin)
+	elems := make([]fr.Element, 1, 1+MaxOriginLen/originChunkLen)
+	elems[0].SetUint64(uint64(len(origin)))
+	for i := 0; i < MaxOriginLen; i += originChunkLen {
+		var e fr.Element
+		e.SetBytes(padded[i : i+originChunkLen])
+		elems = append(elems, e)
+	}
+	return h.Sum(elems...)
+}
+
+// allowedOriginDigests returns the digests with h of the allowed origins as
+// public inputs of the assertion circuit, repeating the last one to fill every
+// slot.
+func allowedOriginDigests(h Hash, origins []string) ([MaxAllowedOrigins]frontend.Variable, error) {
+	var digests [MaxAllowedOrigins]frontend.Variable
+	if len(origins) == 0 || len(origins) > MaxAllowedOrigins {
+		return digests, fmt.Errorf("%w: got %d allowed origins, expected 1 to %d", ErrInvalidOrigin, len(origins), MaxAllowedOrigins)
+	}
+	for i := range digests {
+		d, err := OriginDigest(h, origins[min(i, len(origins)-1)])
+		if err != nil {
+			return digests, err
+		}
+		digests[i] = d
+	}
+	return digests, nil
+}
@@ -0,0 +1,142 @@
+package zk
+
+import (
+	"crypto/sha256"
+	"strings"
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/std/math/uints"
+	"github.com/consensys/gnark/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type clientDataCircuit struct {
+	ClientData ClientData
+	Challenge  frontend.Variable
+
+	Hash           [32]uints.U8                         `gnark:",public"`
+	AllowedOrigins [MaxAllowedOrigins]frontend.Variable `gnark:",public"`
+
+	hash Hash
+}
+
+func (circuit *clientDataCircuit) Define(api frontend.API) error {
+	bf, err := uints.New[uints.U32](api)
+	if err != nil {
+		return err
+	}
+	hash, err := AssertClientData(api, circuit.hash, circuit.ClientData, circuit.Challenge, circuit.AllowedOrigins[:])
+	if err != nil {
+		return err
+	}
+	for i := range circuit.Hash {
+		bf.ByteAssertEq(hash[i], circuit.Hash[i])
+	}
+	return nil
+}
+
+func TestClientData(t *testing.T) {
+	challenge, err := NewChallenge(HashMiMC, make([]byte, IntentHashLen), 1)
+	require.NoError(t, err)
+
+	circuit := &clientDataCircuit{hash: HashMiMC}
+	newAssignment := func(t *testing.T, clientDataJSON []byte) *clientDataCircuit {
+		cd, signed, origin, err := NewClientData(clientDataJSON)
+		require.NoError(t, err)
+		assert.Equal(t, challenge.Bytes(), signed)
+		assert.Equal(t, "http://localhost:8080", origin)
+		allowed, err := allowedOriginDigests(HashMiMC, testOrigins)
+		require.NoError(t, err)
+		hash := sha256.Sum256(clientDataJSON)
+		assignment := &clientDataCircuit{
+			ClientData:     cd,
+			Challenge:      challenge.Challenge,
+			AllowedOrigins: allowed,
+		}
+		copy(assignment.Hash[:], uints.NewU8Array(hash[:]))
+		return assignment
+	}
+	clientDataJSON := testClientDataJSON(challenge, "http://localhost:8080")
+
+	t.Run("valid", func(t *testing.T) {
+		assignment := newAssignment(t, clientDataJSON)
+		assert.NoError(t, test.IsSolved(circuit, assignment, ecc.BN254.ScalarField()))
+	})
+
+	t.Run("origin digest of each hash", func(t *testing.T) {
+		// the native digests match the circuit's for its hash only
+		for _, h := range []Hash{HashMiMC, HashPoseidon2} {
+			for _, digestHash := range []Hash{HashMiMC, HashPoseidon2} {
+				assignment := newAssignment(t, clientDataJSON)
+				assignment.AllowedOrigins, err = allowedOriginDigests(digestHash, testOrigins)
+				require.NoError(t, err)
+				err := test.IsSolved(&clientDataCircuit{hash: h}, assignment, ecc.BN254.ScalarField())
+				if h == digestHash {
+					assert.NoError(t, err, "%s circuit, %s digests", h, digestHash)
+				} else {
+					assert.Error(t, err, "%s circuit, %s digests", h, digestHash)
+				}
+			}
+		}
+	})
+
+	t.Run("extra fields", func(t *testing.T) {
+		extended := strings.TrimSuffix(string(clientDataJSON), "}") +
+			`,"other_keys_can_be_added_here":"do not compare clientDataJSON against a template. See https://goo.gl/yabPex"}`
+		assignment := newAssignment(t, []byte(extended))
+		assert.NoError(t, test.IsSolved(circuit, assignment, ecc.BN254.ScalarField()))
+	})
+
+	t.Run("another challenge", func(t *testing.T) {
+		other, err := NewChallenge(HashMiMC, make([]byte, IntentHashLen), 2)
+		require.NoError(t, err)
+		assignment := newAssignment(t, clientDataJSON)
+		assignment.Challenge = other.Challenge
+		assert.Error(t, test.IsSolved(circuit, assignment, ecc.BN254.ScalarField()))
+	})
+
+	t.Run("misplaced field", func(t *testing.T) {
+		assignment := newAssignment(t, clientDataJSON)
+		assignment.ClientData.TypeIndex = assignment.ClientData.ChallengeIndex
+		assert.Error(t, test.IsSolved(circuit, assignment, ecc.BN254.ScalarField()))
+	})
+
+	t.Run("origin prefix", func(t *testing.T) {
+		assignment := newAssignment(t, clientDataJSON)
+		assignment.ClientData.OriginLen = len("http://localhost:80")
+		assignment.AllowedOrigins, err = allowedOriginDigests(HashMiMC, []string{"http://localhost:80"})
+		require.NoError(t, err)
+		assert.Error(t, test.IsSolved(circuit, assignment, ecc.BN254.ScalarField()))
+	})
+
+	t.Run("origin not allowed", func(t *testing.T) {
+		assignment := newAssignment(t, clientDataJSON)
+		assignment.AllowedOrigins, err = allowedOriginDigests(HashMiMC, []string{"https://example.com"})
+		require.NoError(t, err)
+		assert.Error(t, test.IsSolved(circuit, assignment, ecc.BN254.ScalarField()))
+	})
+
+	t.Run("invalid client data", func(t *testing.T) {
+		for _, clientDataJSON := range []string{
+			strings.Replace(string(clientDataJSON), "webauthn.get", "webauthn.create", 1),
+			`{"type":"webauthn.get","challenge":"dGVzdA","origin":"http://localhost:8080"}`,
+			`{"type":"webauthn.get","origin":"http://localhost:8080"}`,
+			string(clientDataJSON) + strings.Repeat(" ", MaxClientDataJSONLen),
+		} {
+			_, _, _, err := NewClientData([]byte(clientDataJSON))
+			assert.ErrorIs(t, err, ErrInvalidClientData, clientDataJSON)
+		}
+	})
+
+	t.Run("invalid origins", func(t *testing.T) {
+		for _, origin := range []string{"", `http://a"b`, "https://" + strings.Repeat("a", MaxOriginLen)} {
+			_, err := OriginDigest(HashMiMC, origin)
+			assert.ErrorIs(t, err, ErrInvalidOrigin, origin)
+		}
+		_, err := allowedOriginDigests(HashMiMC, make([]string, MaxAllowedOrigins+1))
+		assert.ErrorIs(t, err, ErrInvalidOrigin)
+	})
+}
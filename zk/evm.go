@@ -0,0 +1,140 @@
+package zk
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	groth16bn254 "github.com/consensys/gnark/backend/groth16/bn254"
+	plonkbn254 "github.com/consensys/gnark/backend/plonk/bn254"
+	"github.com/consensys/gnark/backend/solidity"
+	"golang.org/x/crypto/sha3"
+)
+
+// EVMWordSize is the size of an ABI encoded uint256.
+const EVMWordSize = 32
+
+// ErrTargetMismatch is returned when encoding a proof for a verifier it was
+// not made for.
+var ErrTargetMismatch = errors.New("zk: proof was not made for the target verifier")
+
+// ExportSolidity writes the Solidity verifier contract of a BN254 Groth16 or
+// PLONK verifying key. Groth16 proofs of circuits with BSB22 commitments only
+// verify in the contract if they were made for TargetEVM.
+func ExportSolidity(w io.Writer, vk VerifyingKey) error {
+	v, ok := vk.(solidity.VerifyingKey)
+	if !ok {
+		return ErrUnsupportedCurve
+	}
+	return v.ExportSolidity(w)
+}
+
+// EVMCalldata encodes a call to the contract written by ExportSolidity that
+// checks the proof and its public inputs: verifyProof for Groth16, which
+// reverts for invalid proofs, and Verify for PLONK, which returns whether the
+// proof is valid.
+func EVMCalldata(p *Proof) ([]byte, error) {
+	public, err := p.PublicWitness()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrMalformedProof, err)
+	}
+	inputs := public.Vector().(fr.Vector)
+
+	backend, err := ParseBackend(string(p.Backend))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrMalformedProof, err)
+	}
+	target, err := ParseTarget(string(p.Target))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrMalformedProof, err)
+	}
+	switch backend {
+	case BackendGroth16:
+		var proof groth16bn254.Proof
+		if _, err := proof.ReadFrom(bytes.NewReader(p.Proof)); err != nil {
+			return nil, fmt.Errorf("%w: error decoding proof: %w", ErrMalformedProof, err)
+		}
+		// without commitments, the proof is the same for every verifier
+		if len(proof.Commitments) > 0 && target != TargetEVM {
+			return nil, fmt.Errorf("%w: proof of %s is for %s, not %s", ErrTargetMismatch, p.CircuitID, target, TargetEVM)
+		}
+		return groth16Calldata(&proof, inputs), nil
+	case BackendPlonk:
+		var proof plonkbn254.Proof
+		if _, err := proof.ReadFrom(bytes.NewReader(p.Proof)); err != nil {
+			return nil, fmt.Errorf("%w: error decoding proof: %w", ErrMalformedProof, err)
+		}
+		return plonkCalldata(&proof, inputs), nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnsupportedBackend, backend)
+}
+
+// groth16Calldata encodes
+//
+//	verifyProof(uint256[8] proof, uint256[2n] commitments, uint256[2] commitmentPok, uint256[m] input)
+//
+// where the commitments are left out of the signature for circuits without
+// any. The arrays have a fixed size, so their words follow the selector.
+func groth16Calldata(proof *groth16bn254.Proof, inputs fr.Vector) []byte {
+	types := []string{"uint256[8]"}
+	var words bytes.Buffer
+	a, b, c := encodeG1(&proof.Ar), encodeG2(&proof.Bs), encodeG1(&proof.Krs)
+	words.Write(a[:])
+	words.Write(b[:])
+	words.Write(c[:])
+	if len(proof.Commitments) > 0 {
+		types = append(types, fmt.Sprintf("uint256[%d]", 2*len(proof.Commitments)), "uint256[2]")
+		for i := range proof.Commitments {
+			commitment := encodeG1(&proof.Commitments[i])
+			words.Write(commitment[:])
+		}
+		pok := encodeG1(&proof.CommitmentPok)
+		words.Write(pok[:])
+	}
+	types = append(types, fmt.Sprintf("uint256[%d]", len(inputs)))
+	for i := range inputs {
+		b := inputs[i].Bytes()
+		words.Write(b[:])
+	}
+	return append(evmSelector("verifyProof", types...), words.Bytes()...)
+}
+
+// plonkCalldata encodes Verify(bytes proof, uint256[] public_inputs). Both
+// arguments are dynamic: the head holds their offsets, and each is encoded as
+// its length followed by its words.
+func plonkCalldata(proof *plonkbn254.Proof, inputs fr.Vector) []byte {
+	data := proof.MarshalSolidity()
+	padded := (len(data) + EVMWordSize - 1) / EVMWordSize * EVMWordSize
+
+	calldata := evmSelector("Verify", "bytes", "uint256[]")
+	calldata = appendEVMWord(calldata, 2*EVMWordSize)
+	calldata = appendEVMWord(calldata, uint64(3*EVMWordSize+padded))
+	calldata = appendEVMWord(calldata, uint64(len(data)))
+	calldata = append(calldata, data...)
+	calldata = append(calldata, make([]byte, padded-len(data))...)
+	calldata = appendEVMWord(calldata, uint64(len(inputs)))
+	for i := range inputs {
+		b := inputs[i].Bytes()
+		calldata = append(calldata, b[:]...)
+	}
+	return calldata
+}
+
+// evmSelector returns the first 4 bytes of the Keccak-256 hash of the
+// function's signature.
+func evmSelector(name string, types ...string) []byte {
+	h := sha3.NewLegacyKeccak256()
+	h.Write([]byte(name + "(" + strings.Join(types, ",") + ")"))
+	return h.Sum(nil)[:4]
+}
+
+// appendEVMWord appends v as a big-endian uint256.
+func appendEVMWord(b []byte, v uint64) []byte {
+	var word [EVMWordSize]byte
+	binary.BigEndian.PutUint64(word[EVMWordSize-8:], v)
+	return append(b, word[:]...)
+}
@@ -0,0 +1,259 @@
+package zk
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr/mimc"
+	"github.com/consensys/gnark/frontend"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethclient/simulated"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// The tests below compile the exported contracts with solc, deploy them on a
+// simulated chain and call them with the calldata. They are skipped if solc
+// is not installed.
+
+func TestEVMExport(t *testing.T) {
+	commitDef := CircuitDefinition{
+		ID:  "test-commit",
+		New: func() frontend.Circuit { return &commitCircuit{} },
+	}
+	chain := newEVMChain(t)
+
+	t.Run("groth16", func(t *testing.T) {
+		ks := NewKeyStore(t.TempDir())
+		ks.SetTarget(TargetEVM)
+		keys, err := ks.Setup(commitDef)
+		require.NoError(t, err)
+		proof, err := Prove(keys, &commitCircuit{X: 3, Y: 9})
+		require.NoError(t, err)
+		assert.Equal(t, TargetEVM, proof.Target)
+		require.NoError(t, Verify(keys, proof))
+
+		contract := exportSolidity(t, keys)
+		assert.Contains(t, contract, "uint256[2] calldata commitments,")
+		assert.Contains(t, contract, "uint256[1] calldata input")
+
+		calldata, err := EVMCalldata(proof)
+		require.NoError(t, err)
+		assert.Equal(t, contractSelector(t, contract, "verifyProof"), calldata[:4])
+		verify := deployEVMVerifier(t, chain, BackendGroth16, contract)
+		assert.True(t, verify(calldata))
+
+		t.Run("tampered input", func(t *testing.T) {
+			tampered := bytes.Clone(calldata)
+			tampered[len(tampered)-1] ^= 1
+			assert.False(t, verify(tampered))
+		})
+
+		t.Run("proof for gnark's verifier", func(t *testing.T) {
+			ks := NewKeyStore(t.TempDir())
+			keys, err := ks.Setup(commitDef)
+			require.NoError(t, err)
+			proof, err := Prove(keys, &commitCircuit{X: 3, Y: 9})
+			require.NoError(t, err)
+			_, err = EVMCalldata(proof)
+			assert.ErrorIs(t, err, ErrTargetMismatch)
+
+			// the calldata of a proof using RFC 9380 doesn't verify either
+			proof.Target = TargetEVM
+			calldata, err := EVMCalldata(proof)
+			require.NoError(t, err)
+			verify := deployEVMVerifier(t, chain, BackendGroth16, exportSolidity(t, keys))
+			assert.False(t, verify(calldata))
+		})
+	})
+
+	t.Run("groth16 without commitments", func(t *testing.T) {
+		keys, err := NewKeyStore(t.TempDir()).Setup(preimageCircuitDefinition)
+		require.NoError(t, err)
+		proof, err := Prove(keys, preimageAssignment())
+		require.NoError(t, err)
+
+		contract := exportSolidity(t, keys)
+		assert.NotContains(t, contract, "calldata commitments")
+
+		calldata, err := EVMCalldata(proof)
+		require.NoError(t, err)
+		assert.Equal(t, contractSelector(t, contract, "verifyProof"), calldata[:4])
+		verify := deployEVMVerifier(t, chain, BackendGroth16, contract)
+		assert.True(t, verify(calldata))
+
+		t.Run("tampered input", func(t *testing.T) {
+			tampered := bytes.Clone(calldata)
+			tampered[len(tampered)-1] ^= 1
+			assert.False(t, verify(tampered))
+		})
+	})
+
+	t.Run("plonk", func(t *testing.T) {
+		ks := NewPlonkKeyStore(t.TempDir(), writeTestSRS(t, 1<<10))
+		ks.SetTarget(TargetEVM)
+		keys, err := ks.Setup(commitDef)
+		require.NoError(t, err)
+		proof, err := Prove(keys, &commitCircuit{X: 3, Y: 9})
+		require.NoError(t, err)
+		require.NoError(t, Verify(keys, proof))
+
+		contract := exportSolidity(t, keys)
+		assert.Contains(t, contract, "function Verify(bytes calldata proof, uint256[] calldata public_inputs)")
+
+		calldata, err := EVMCalldata(proof)
+		require.NoError(t, err)
+		assert.Equal(t, evmSelector("Verify", "bytes", "uint256[]"), calldata[:4])
+		verify := deployEVMVerifier(t, chain, BackendPlonk, contract)
+		assert.True(t, verify(calldata))
+
+		t.Run("tampered input", func(t *testing.T) {
+			tampered := bytes.Clone(calldata)
+			tampered[len(tampered)-1] ^= 1
+			assert.False(t, verify(tampered))
+		})
+	})
+}
+
+// preimageAssignment returns a witness of the MiMC preimage circuit.
+func preimageAssignment() frontend.Circuit {
+	var preImage fr.Element
+	preImage.SetUint64(42)
+	b := preImage.Bytes()
+	h := mimc.NewMiMC()
+	h.Write(b[:])
+	return &Circuit{PreImage: preImage, Hash: h.Sum(nil)}
+}
+
+func exportSolidity(t *testing.T, keys *Keys) string {
+	var buf bytes.Buffer
+	require.NoError(t, ExportSolidity(&buf, keys.VerifyingKey))
+	contract := buf.String()
+	assert.Contains(t, contract, "pragma solidity")
+	return contract
+}
+
+// contractSelector returns the selector of the contract's function, from the
+// types of its parameters.
+func contractSelector(t *testing.T, contract, name string) []byte {
+	m := regexp.MustCompile(`function ` + name + `\(([^)]*)\)`).FindStringSubmatch(contract)
+	require.NotNil(t, m, "function %s not found", name)
+	var types []string
+	for _, param := range strings.Split(m[1], ",") {
+		types = append(types, strings.Fields(param)[0])
+	}
+	return evmSelector(name, types...)
+}
+
+// evmChain is a simulated chain with an account funded to deploy contracts.
+type evmChain struct {
+	backend *simulated.Backend
+	key     *ecdsa.PrivateKey
+}
+
+func newEVMChain(t *testing.T) *evmChain {
+	key, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	backend := simulated.NewBackend(types.GenesisAlloc{
+		crypto.PubkeyToAddress(key.PublicKey): {Balance: new(big.Int).Lsh(big.NewInt(1), 100)},
+	})
+	t.Cleanup(func() { backend.Close() })
+	return &evmChain{backend: backend, key: key}
+}
+
+// deploy creates a contract with the bytecode and returns its address.
+func (c *evmChain) deploy(t *testing.T, bytecode []byte) common.Address {
+	ctx := context.Background()
+	client := c.backend.Client()
+	chainID, err := client.ChainID(ctx)
+	require.NoError(t, err)
+	nonce, err := client.PendingNonceAt(ctx, crypto.PubkeyToAddress(c.key.PublicKey))
+	require.NoError(t, err)
+	tip, err := client.SuggestGasTipCap(ctx)
+	require.NoError(t, err)
+	head, err := client.HeaderByNumber(ctx, nil)
+	require.NoError(t, err)
+	tx, err := types.SignNewTx(c.key, types.LatestSignerForChainID(chainID), &types.DynamicFeeTx{
+		ChainID:   chainID,
+		Nonce:     nonce,
+		GasTipCap: tip,
+		GasFeeCap: new(big.Int).Add(tip, new(big.Int).Mul(head.BaseFee, big.NewInt(2))),
+		Gas:       15_000_000,
+		Data:      bytecode,
+	})
+	require.NoError(t, err)
+	require.NoError(t, client.SendTransaction(ctx, tx))
+	c.backend.Commit()
+
+	receipt, err := client.TransactionReceipt(ctx, tx.Hash())
+	require.NoError(t, err)
+	require.Equal(t, types.ReceiptStatusSuccessful, receipt.Status, "contract creation failed")
+	return receipt.ContractAddress
+}
+
+// deployEVMVerifier compiles the verifier contract of the backend, deploys it
+// and returns a function calling it with calldata, which reports whether the
+// proof verified: the Groth16 contract reverts for invalid proofs, and the
+// PLONK contract returns false.
+func deployEVMVerifier(t *testing.T, chain *evmChain, backend Backend, contract string) func(calldata []byte) bool {
+	address := chain.deploy(t, compileSolidity(t, contract))
+	return func(calldata []byte) bool {
+		ret, err := chain.backend.Client().CallContract(context.Background(), ethereum.CallMsg{
+			To:   &address,
+			Data: calldata,
+		}, nil)
+		if err != nil {
+			return false
+		}
+		if backend == BackendPlonk {
+			return len(ret) == EVMWordSize && new(big.Int).SetBytes(ret).Cmp(big.NewInt(1)) == 0
+		}
+		return true
+	}
+}
+
+// compileSolidity compiles the contract with solc and returns its creation
+// bytecode. The test is skipped if solc is not installed.
+func compileSolidity(t *testing.T, contract string) []byte {
+	solc, err := exec.LookPath("solc")
+	if err != nil {
+		t.Skip("solc is not installed")
+	}
+	m := regexp.MustCompile(`(?m)^contract (\w+)`).FindStringSubmatch(contract)
+	require.NotNil(t, m, "contract not found")
+	path := filepath.Join(t.TempDir(), m[1]+".sol")
+	require.NoError(t, os.WriteFile(path, []byte(contract), 0o644))
+
+	out, err := exec.Command(solc, "--optimize", "--combined-json", "bin", path).Output()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		t.Fatalf("error compiling %s: %s", m[1], exitErr.Stderr)
+	}
+	require.NoError(t, err)
+	var compiled struct {
+		Contracts map[string]struct {
+			Bin string `json:"bin"`
+		} `json:"contracts"`
+	}
+	require.NoError(t, json.Unmarshal(out, &compiled))
+	c, ok := compiled.Contracts[path+":"+m[1]]
+	require.True(t, ok, "contract %s not in the output of solc", m[1])
+	bytecode, err := hex.DecodeString(c.Bin)
+	require.NoError(t, err)
+	return bytecode
+}
@@ -0,0 +1,471 @@
+package zk
+
+import (
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math/big"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	"github.com/consensys/gnark/frontend"
+	"github.com/go-webauthn/webauthn/protocol/webauthncbor"
+	"github.com/go-webauthn/webauthn/protocol/webauthncose"
+)
+
+// FixtureVersion is the version of the fixture format. It changes whenever a
+// seed no longer yields the same fixtures.
+const FixtureVersion = 6
+
+const (
+	// FixtureRPID is the relying party ID the fixtures' assertions are for.
+	FixtureRPID = "localhost"
+
+	// FixtureOrigin is the origin of the fixtures' assertions, the only
+	// allowed origin.
+	FixtureOrigin = sampleOrigin
+)
+
+// FixtureCorpus is a set of test vectors derived from a seed, for checking
+// another implementation of the circuits' inputs step by step. Byte strings
+// and scalars are 0x prefixed hex, scalars and field elements zero padded to
+// 32 bytes.
+type FixtureCorpus struct {
+	Version  int       `json:"version"`
+	Seed     string    `json:"seed"`
+	Hash     Hash      `json:"hash"`
+	RPID     string    `json:"rpId"`
+	Origins  []string  `json:"origins"`
+	Fixtures []Fixture `json:"fixtures"`
+}
+
+// Fixture is a login with a fresh credential: the challenge derived for an
+// intent, the assertion an authenticator signs for it and the witnesses of
+// both circuits.
+type Fixture struct {
+	Index      int               `json:"index"`
+	Credential FixtureCredential `json:"credential"`
+	Challenge  FixtureChallenge  `json:"challenge"`
+	Assertion  FixtureAssertion  `json:"assertion"`
+
+	ChallengeWitness FixtureWitness `json:"challengeWitness"`
+	AssertionWitness FixtureWitness `json:"assertionWitness"`
+
+	challenge *ChallengeCircuit
+	assertion *AssertionCircuit
+}
+
+// FixtureCredential is a P-256 key pair, as registered by a WebAuthn
+// authenticator.
+type FixtureCredential struct {
+	PrivateKey string `json:"privateKey"`
+	PublicKeyX string `json:"publicKeyX"`
+	PublicKeyY string `json:"publicKeyY"`
+
+	// PublicKey is the COSE encoded public key stored at registration.
+	PublicKey string `json:"publicKey"`
+
+	// Secret is the credential secret the server draws at registration.
+	Secret string `json:"secret"`
+
+	// Leaf is the credential's leaf in the tree of credentials, committing to
+	// the public key and the secret.
+	Leaf string `json:"leaf"`
+}
+
+// FixtureChallenge is the derivation of a challenge, in the order of
+// ChallengeCircuit:
+//
+//	SeedCommitment = H(Seed)
+//	Challenge = H(SeedCommitment, IntentHashElement, Nonce)
+type FixtureChallenge struct {
+	IntentHash string `json:"intentHash"`
+
+	// IntentHashElement is the intent hash reduced into the scalar field.
+	IntentHashElement string `json:"intentHashElement"`
+	Nonce             uint64 `json:"nonce"`
+	Seed              string `json:"seed"`
+	SeedCommitment    string `json:"seedCommitment"`
+	Challenge         string `json:"challenge"`
+
+	// Encoded is the challenge as it appears in clientDataJSON.
+	Encoded    string            `json:"encoded"`
+	Commitment FixtureCommitment `json:"commitment"`
+}
+
+// FixtureAssertion is an assertion of the challenge and its public parts as
+// committed to by the assertion circuit.
+type FixtureAssertion struct {
+	AuthenticatorData string `json:"authenticatorData"`
+	ClientDataJSON    string `json:"clientDataJSON"`
+	ClientDataHash    string `json:"clientDataHash"`
+
+	// SignedData is authenticatorData || SHA-256(clientDataJSON), and
+	// SignedDataHash the message hash the signature is over.
+	SignedData     string `json:"signedData"`
+	SignedDataHash string `json:"signedDataHash"`
+
+	// Signature is DER encoded, as returned by the authenticator.
+	Signature           string            `json:"signature"`
+	SignatureR          string            `json:"signatureR"`
+	SignatureS          string            `json:"signatureS"`
+	SignatureCommitment FixtureCommitment `json:"signatureCommitment"`
+
+	// OriginDigests are the digests of the allowed origins, as assigned to
+	// the circuit's MaxAllowedOrigins slots.
+	OriginDigests []string `json:"originDigests"`
+
+	// Nullifier is derived from the credential's secret, the intent hash and
+	// the nonce.
+	Nullifier string `json:"nullifier"`
+}
+
+// FixtureCommitment is the opening of a Pedersen commitment and the
+// commitment, both as a point and as encoded by EncodeCommitment.
+type FixtureCommitment struct {
+	Limbs      []string `json:"limbs"`
+	Blinding   string   `json:"blinding"`
+	X          string   `json:"x"`
+	Y          string   `json:"y"`
+	Compressed string   `json:"compressed"`
+}
+
+// FixtureWitness is the witness of a circuit in gnark's binary encoding, with
+// its public inputs and, once proven, its proof. Proofs are randomized, so
+// they differ from one run to the next.
+type FixtureWitness struct {
+	CircuitID    string   `json:"circuitId"`
+	Witness      string   `json:"witness"`
+	PublicInputs []string `json:"publicInputs"`
+	Proof        *Proof   `json:"proof,omitempty"`
+}
+
+// NewFixtureCorpus derives count fixtures from the seed, with challenges
+// derived with h. The same seed always yields the same corpus.
+func NewFixtureCorpus(seed []byte, h Hash, count int) (*FixtureCorpus, error) {
+	corpus := &FixtureCorpus{
+		Version: FixtureVersion,
+		Seed:    fixtureHex(seed),
+		Hash:    h,
+		RPID:    FixtureRPID,
+		Origins: []string{FixtureOrigin},
+	}
+	for i := 0; i < count; i++ {
+		f, err := NewFixture(seed, h, i)
+		if err != nil {
+			return nil, err
+		}
+		corpus.Fixtures = append(corpus.Fixtures, *f)
+	}
+	return corpus, nil
+}
+
+// NewFixture derives the fixture with the given index from the seed. Each
+// random value is read from its own stream, see fixtureStream, so that adding
+// a value to the fixtures leaves the others unchanged.
+func NewFixture(seed []byte, h Hash, index int) (*Fixture, error) {
+	challengeDef, err := ChallengeCircuitDefinitionFor(h)
+	if err != nil {
+		return nil, err
+	}
+	assertionDef, err := AssertionCircuitDefinitionFor(h)
+	if err != nil {
+		return nil, err
+	}
+	stream := func(label string) io.Reader {
+		return &fixtureStream{seed: seed, index: uint32(index), label: label}
+	}
+
+	// credential
+	d, err := fixtureP256Scalar(stream("credential"))
+	if err != nil {
+		return nil, fmt.Errorf("error generating credential key: %w", err)
+	}
+	x, y, err := p256BaseMult(d)
+	if err != nil {
+		return nil, err
+	}
+	credentialPublicKey, err := webauthncbor.Marshal(webauthncose.EC2PublicKeyData{
+		PublicKeyData: webauthncose.PublicKeyData{
+			KeyType:   int64(webauthncose.EllipticKey),
+			Algorithm: int64(webauthncose.AlgES256),
+		},
+		Curve:  int64(webauthncose.P256),
+		XCoord: x.FillBytes(make([]byte, 32)),
+		YCoord: y.FillBytes(make([]byte, 32)),
+	})
+	if err != nil {
+		return nil, err
+	}
+	publicKey, err := ParseCredentialPublicKey(credentialPublicKey)
+	if err != nil {
+		return nil, err
+	}
+	sec, err := rand.Int(stream("credential-secret"), fr.Modulus())
+	if err != nil {
+		return nil, fmt.Errorf("error generating credential secret: %w", err)
+	}
+	var secret fr.Element
+	secret.SetBigInt(sec)
+	leaf, err := CredentialLeaf(h, publicKey, secret)
+	if err != nil {
+		return nil, err
+	}
+
+	// challenge
+	nonce := uint64(index) + 1
+	intentHash := make([]byte, IntentHashLen)
+	if _, err := io.ReadFull(stream("intent"), intentHash); err != nil {
+		return nil, err
+	}
+	challenge, err := newChallenge(stream("challenge"), h, intentHash, nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	// assertion: user present and verified, counting the fixture as the
+	// credential's first use
+	rpIDHash := sha256.Sum256([]byte(FixtureRPID))
+	authenticatorData := append(rpIDHash[:], 0x05, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(authenticatorData[33:], uint32(index)+1)
+	clientDataJSON := []byte(fmt.Sprintf(`{"type":"webauthn.get","challenge":"%s","origin":"%s","crossOrigin":false}`,
+		base64.RawURLEncoding.EncodeToString(challenge.Bytes()), FixtureOrigin))
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	signedData := append(authenticatorData[:len(authenticatorData):len(authenticatorData)], clientDataHash[:]...)
+	signedDataHash := sha256.Sum256(signedData)
+	r, s, err := fixtureSign(stream("signature"), d, signedDataHash[:])
+	if err != nil {
+		return nil, err
+	}
+	signature, err := asn1.Marshal(struct{ R, S *big.Int }{r, s})
+	if err != nil {
+		return nil, err
+	}
+	if !ecdsa.VerifyASN1(publicKey, signedDataHash[:], signature) {
+		return nil, fmt.Errorf("%w: fixture signature does not verify", ErrInvalidSignature)
+	}
+	origins := []string{FixtureOrigin}
+	assertion, sigOpening, err := newAssertionAssignment(stream("signature-commitment"), h, credentialPublicKey, secret, authenticatorData, clientDataJSON, signature, challenge.Opening, origins, intentHash, nonce)
+	if err != nil {
+		return nil, err
+	}
+	nullifier, err := Nullifier(h, secret, intentHash, nonce)
+	if err != nil {
+		return nil, err
+	}
+	var originDigests []string
+	for _, digest := range assertion.AllowedOrigins {
+		originDigests = append(originDigests, fixtureElementHex(digest.(fr.Element)))
+	}
+
+	f := &Fixture{
+		Index: index,
+		Credential: FixtureCredential{
+			PrivateKey: fixtureScalar(d),
+			PublicKeyX: fixtureScalar(x),
+			PublicKeyY: fixtureScalar(y),
+			PublicKey:  fixtureHex(credentialPublicKey),
+			Secret:     fixtureElementHex(secret),
+			Leaf:       fixtureElementHex(leaf),
+		},
+		Challenge: FixtureChallenge{
+			IntentHash:        fixtureHex(intentHash),
+			IntentHashElement: fixtureElementHex(challenge.IntentHash),
+			Nonce:             nonce,
+			Seed:              fixtureElementHex(challenge.Seed),
+			SeedCommitment:    fixtureElementHex(challenge.Commitment),
+			Challenge:         fixtureElementHex(challenge.Challenge),
+			Encoded:           base64.RawURLEncoding.EncodeToString(challenge.Bytes()),
+			Commitment:        fixtureCommitment(challenge.Opening),
+		},
+		Assertion: FixtureAssertion{
+			AuthenticatorData:   fixtureHex(authenticatorData),
+			ClientDataJSON:      string(clientDataJSON),
+			ClientDataHash:      fixtureHex(clientDataHash[:]),
+			SignedData:          fixtureHex(signedData),
+			SignedDataHash:      fixtureHex(signedDataHash[:]),
+			Signature:           fixtureHex(signature),
+			SignatureR:          fixtureScalar(r),
+			SignatureS:          fixtureScalar(s),
+			SignatureCommitment: fixtureCommitment(sigOpening),
+			OriginDigests:       originDigests,
+			Nullifier:           fixtureElementHex(nullifier),
+		},
+		challenge: challenge.Assignment(),
+		assertion: assertion,
+	}
+	if f.ChallengeWitness, err = fixtureWitness(challengeDef.ID, f.challenge); err != nil {
+		return nil, err
+	}
+	if f.AssertionWitness, err = fixtureWitness(assertionDef.ID, f.assertion); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Prove proves the fixture's witnesses of the circuits the keys are for.
+func (f *Fixture) Prove(keys ...*Keys) error {
+	for _, k := range keys {
+		var err error
+		switch k.Manifest.CircuitID {
+		case f.ChallengeWitness.CircuitID:
+			f.ChallengeWitness.Proof, err = Prove(k, f.challenge)
+		case f.AssertionWitness.CircuitID:
+			f.AssertionWitness.Proof, err = Prove(k, f.assertion)
+		default:
+			return fmt.Errorf("fixture %d has no witness of circuit %s", f.Index, k.Manifest.CircuitID)
+		}
+		if err != nil {
+			return fmt.Errorf("error proving fixture %d: %w", f.Index, err)
+		}
+	}
+	return nil
+}
+
+// fixtureStream is the stream of bytes a fixture's value is drawn from: the
+// concatenation of the blocks
+//
+//	SHA-256(len(seed) || seed || index || len(label) || label || counter)
+//
+// for counter = 0, 1, ..., with lengths, the index and the counter encoded as
+// big-endian uint32. Values are drawn from it as from crypto/rand, e.g. with
+// rand.Int, so implementations in other languages should read them from the
+// fixtures rather than derive them.
+type fixtureStream struct {
+	seed    []byte
+	index   uint32
+	label   string
+	counter uint32
+	buf     []byte
+}
+
+func (s *fixtureStream) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		if len(s.buf) == 0 {
+			h := sha256.New()
+			binary.Write(h, binary.BigEndian, uint32(len(s.seed)))
+			h.Write(s.seed)
+			binary.Write(h, binary.BigEndian, s.index)
+			binary.Write(h, binary.BigEndian, uint32(len(s.label)))
+			h.Write([]byte(s.label))
+			binary.Write(h, binary.BigEndian, s.counter)
+			s.buf = h.Sum(nil)
+			s.counter++
+		}
+		c := copy(p[n:], s.buf)
+		s.buf = s.buf[c:]
+		n += c
+	}
+	return n, nil
+}
+
+// fixtureP256Scalar draws a non-zero scalar of P-256.
+func fixtureP256Scalar(rng io.Reader) (*big.Int, error) {
+	n := elliptic.P256().Params().N
+	k, err := rand.Int(rng, new(big.Int).Sub(n, big.NewInt(1)))
+	if err != nil {
+		return nil, err
+	}
+	return k.Add(k, big.NewInt(1)), nil
+}
+
+// fixtureSign signs the hash with the P-256 private key d, as crypto/ecdsa
+// does but with the nonce drawn from rng, so that the signature is
+// reproducible.
+func fixtureSign(rng io.Reader, d *big.Int, hash []byte) (r, s *big.Int, err error) {
+	n := elliptic.P256().Params().N
+	e := new(big.Int).SetBytes(hash)
+	for {
+		k, err := fixtureP256Scalar(rng)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error generating signature nonce: %w", err)
+		}
+		x, _, err := p256BaseMult(k)
+		if err != nil {
+			return nil, nil, err
+		}
+		r = x.Mod(x, n)
+		if r.Sign() == 0 {
+			continue
+		}
+		// s = k⁻¹(e + r·d) mod n
+		s = new(big.Int).Mul(r, d)
+		s.Add(s, e)
+		s.Mul(s, new(big.Int).ModInverse(k, n))
+		s.Mod(s, n)
+		if s.Sign() != 0 {
+			return r, s, nil
+		}
+	}
+}
+
+// p256BaseMult returns the coordinates of k·G on P-256, for 0 < k < n.
+func p256BaseMult(k *big.Int) (x, y *big.Int, err error) {
+	key, err := ecdh.P256().NewPrivateKey(k.FillBytes(make([]byte, 32)))
+	if err != nil {
+		return nil, nil, err
+	}
+	// uncompressed encoding: 0x04 || x || y
+	b := key.PublicKey().Bytes()
+	return new(big.Int).SetBytes(b[1:33]), new(big.Int).SetBytes(b[33:]), nil
+}
+
+func fixtureCommitment(o *Opening) FixtureCommitment {
+	c := o.Commit()
+	fc := FixtureCommitment{
+		Blinding:   fixtureScalar(o.Blinding),
+		X:          fixtureElementHex(c.X),
+		Y:          fixtureElementHex(c.Y),
+		Compressed: "0x" + EncodeCommitment(c),
+	}
+	for _, limb := range o.Limbs {
+		fc.Limbs = append(fc.Limbs, fixtureScalar(limb))
+	}
+	return fc
+}
+
+func fixtureWitness(circuitID string, assignment frontend.Circuit) (FixtureWitness, error) {
+	w, err := frontend.NewWitness(assignment, ecc.BN254.ScalarField())
+	if err != nil {
+		return FixtureWitness{}, fmt.Errorf("error building witness of %s: %w", circuitID, err)
+	}
+	b, err := w.MarshalBinary()
+	if err != nil {
+		return FixtureWitness{}, err
+	}
+	public, err := w.Public()
+	if err != nil {
+		return FixtureWitness{}, err
+	}
+	publicInputs, err := encodePublicInputs(public)
+	if err != nil {
+		return FixtureWitness{}, err
+	}
+	return FixtureWitness{
+		CircuitID:    circuitID,
+		Witness:      fixtureHex(b),
+		PublicInputs: publicInputs,
+	}, nil
+}
+
+func fixtureHex(b []byte) string {
+	return "0x" + hex.EncodeToString(b)
+}
+
+func fixtureScalar(v *big.Int) string {
+	return fixtureHex(v.FillBytes(make([]byte, 32)))
+}
+
+func fixtureElementHex(e fr.Element) string {
+	b := e.Bytes()
+	return fixtureHex(b[:])
+}
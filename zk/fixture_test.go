@@ -0,0 +1,139 @@
+package zk
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFixtures(t *testing.T) {
+	seed := []byte("zk-pass fixtures")
+	corpus, err := NewFixtureCorpus(seed, HashMiMC, 2)
+	require.NoError(t, err)
+	require.Len(t, corpus.Fixtures, 2)
+
+	t.Run("deterministic", func(t *testing.T) {
+		again, err := NewFixtureCorpus(seed, HashMiMC, 2)
+		require.NoError(t, err)
+		assert.Equal(t, fixtureJSON(t, corpus), fixtureJSON(t, again))
+
+		other, err := NewFixtureCorpus([]byte("other seed"), HashMiMC, 2)
+		require.NoError(t, err)
+		assert.NotEqual(t, corpus.Fixtures[0].Credential, other.Fixtures[0].Credential)
+		assert.NotEqual(t, corpus.Fixtures[0].Credential, corpus.Fixtures[1].Credential)
+
+		// a change to the derivation must bump FixtureVersion
+		sum := sha256.Sum256(fixtureJSON(t, corpus))
+		assert.Equal(t, "1dd399d34fefafe8b7b0f4933f1bcf72f019e2709d7d002afff1bbef4b6dcbba", hex.EncodeToString(sum[:]))
+	})
+
+	for _, f := range corpus.Fixtures {
+		t.Run(fmt.Sprintf("fixture %d", f.Index), func(t *testing.T) {
+			x, y := fixtureInt(t, f.Credential.PublicKeyX), fixtureInt(t, f.Credential.PublicKeyY)
+			gx, gy := elliptic.P256().ScalarBaseMult(fixtureBytes(t, f.Credential.PrivateKey))
+			assert.Zero(t, x.Cmp(gx))
+			assert.Zero(t, y.Cmp(gy))
+			publicKey, err := ParseCredentialPublicKey(fixtureBytes(t, f.Credential.PublicKey))
+			require.NoError(t, err)
+			assert.Zero(t, x.Cmp(publicKey.X))
+			secret, err := DecodeCredentialSecret(f.Credential.Secret)
+			require.NoError(t, err)
+			leaf, err := CredentialLeaf(HashMiMC, publicKey, secret)
+			require.NoError(t, err)
+			assert.Equal(t, f.Credential.Leaf, fixtureElementHex(leaf))
+
+			derived, err := DeriveChallenge(HashMiMC, fixtureBytes(t, f.Challenge.SeedCommitment), fixtureBytes(t, f.Challenge.IntentHash), f.Challenge.Nonce)
+			require.NoError(t, err)
+			assert.Equal(t, f.Challenge.Challenge, fixtureElementHex(derived))
+			assert.Equal(t, fixtureBytes(t, f.Challenge.Challenge), fixtureBase64(t, f.Challenge.Encoded))
+			assert.Contains(t, f.Assertion.ClientDataJSON, `"challenge":"`+f.Challenge.Encoded+`"`)
+
+			clientDataHash := sha256.Sum256([]byte(f.Assertion.ClientDataJSON))
+			assert.Equal(t, f.Assertion.ClientDataHash, fixtureHex(clientDataHash[:]))
+			signedData := append(fixtureBytes(t, f.Assertion.AuthenticatorData), clientDataHash[:]...)
+			assert.Equal(t, f.Assertion.SignedData, fixtureHex(signedData))
+			signedDataHash := sha256.Sum256(signedData)
+			assert.Equal(t, f.Assertion.SignedDataHash, fixtureHex(signedDataHash[:]))
+			assert.True(t, ecdsa.VerifyASN1(publicKey, signedDataHash[:], fixtureBytes(t, f.Assertion.Signature)))
+			assert.True(t, ecdsa.Verify(publicKey, signedDataHash[:], fixtureInt(t, f.Assertion.SignatureR), fixtureInt(t, f.Assertion.SignatureS)))
+
+			for _, c := range []FixtureCommitment{f.Challenge.Commitment, f.Assertion.SignatureCommitment} {
+				point, err := DecodeCommitment(strings.TrimPrefix(c.Compressed, "0x"))
+				require.NoError(t, err)
+				assert.Equal(t, c.X, fixtureElementHex(point.X))
+				assert.Equal(t, c.Y, fixtureElementHex(point.Y))
+				o := Opening{Blinding: fixtureInt(t, c.Blinding)}
+				for _, limb := range c.Limbs {
+					o.Limbs = append(o.Limbs, fixtureInt(t, limb))
+				}
+				assert.NoError(t, o.Verify(point))
+			}
+
+			digest, err := OriginDigest(HashMiMC, FixtureOrigin)
+			require.NoError(t, err)
+			assert.Equal(t, fixtureElementHex(digest), f.Assertion.OriginDigests[0])
+			nullifier, err := Nullifier(HashMiMC, secret, fixtureBytes(t, f.Challenge.IntentHash), f.Challenge.Nonce)
+			require.NoError(t, err)
+			assert.Equal(t, f.Assertion.Nullifier, EncodeNullifier(nullifier))
+		})
+	}
+
+	f := corpus.Fixtures[0]
+	t.Run("witnesses", func(t *testing.T) {
+		assert.NoError(t, test.IsSolved(NewChallengeCircuit(HashMiMC), f.challenge, ecc.BN254.ScalarField()))
+		assert.NoError(t, test.IsSolved(NewAssertionCircuit(HashMiMC), f.assertion, ecc.BN254.ScalarField()))
+		assert.Equal(t, f.Challenge.Challenge, f.ChallengeWitness.PublicInputs[3])
+		assert.Contains(t, f.AssertionWitness.PublicInputs, f.Assertion.Nullifier)
+		assert.Contains(t, f.AssertionWitness.PublicInputs, f.Credential.Leaf)
+		assert.NotContains(t, f.AssertionWitness.PublicInputs, f.Credential.Secret)
+	})
+
+	t.Run("proofs", func(t *testing.T) {
+		keys, err := NewKeyStore(t.TempDir()).Setup(ChallengeCircuitDefinition)
+		require.NoError(t, err)
+		require.NoError(t, f.Prove(keys))
+		require.NotNil(t, f.ChallengeWitness.Proof)
+		assert.Nil(t, f.AssertionWitness.Proof)
+		assert.Equal(t, f.ChallengeWitness.PublicInputs, f.ChallengeWitness.Proof.PublicInputs)
+		assert.NoError(t, Verify(keys, f.ChallengeWitness.Proof))
+
+		other, err := NewKeyStore(t.TempDir()).Setup(Poseidon2ChallengeCircuitDefinition)
+		require.NoError(t, err)
+		assert.Error(t, f.Prove(other))
+	})
+}
+
+func fixtureJSON(t *testing.T, corpus *FixtureCorpus) []byte {
+	b, err := json.Marshal(corpus)
+	require.NoError(t, err)
+	return b
+}
+
+func fixtureBytes(t *testing.T, s string) []byte {
+	require.True(t, strings.HasPrefix(s, "0x"), "%q is not 0x prefixed", s)
+	b, err := hex.DecodeString(s[2:])
+	require.NoError(t, err)
+	return b
+}
+
+func fixtureInt(t *testing.T, s string) *big.Int {
+	return new(big.Int).SetBytes(fixtureBytes(t, s))
+}
+
+func fixtureBase64(t *testing.T, s string) []byte {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	require.NoError(t, err)
+	return b
+}
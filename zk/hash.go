@@ -0,0 +1,78 @@
+package zk
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr/mimc"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/std/hash"
+	stdmimc "github.com/consensys/gnark/std/hash/mimc"
+)
+
+// Hash is the algebraic hash a circuit version uses for its commitments,
+// challenge derivation and nullifiers. The native implementation of each hash
+// matches its in-circuit gadget.
+type Hash string
+
+const (
+	// HashMiMC is gnark's MiMC over the BN254 scalar field.
+	HashMiMC Hash = "mimc"
+
+	// HashPoseidon2 is the Poseidon2 sponge over the BN254 scalar field, with
+	// the parameters of the reference implementation.
+	HashPoseidon2 Hash = "poseidon2"
+)
+
+// ErrUnknownHash is returned for hashes other than HashMiMC and HashPoseidon2.
+var ErrUnknownHash = errors.New("zk: unknown hash")
+
+// ParseHash returns the hash with the given name.
+func ParseHash(name string) (Hash, error) {
+	switch h := Hash(strings.ToLower(strings.TrimSpace(name))); h {
+	case HashMiMC, HashPoseidon2:
+		return h, nil
+	}
+	return "", fmt.Errorf("%w: %q", ErrUnknownHash, name)
+}
+
+// Sum computes the native hash of field elements.
+func (h Hash) Sum(elems ...fr.Element) (fr.Element, error) {
+	switch h {
+	case HashMiMC:
+		return mimcHash(elems...), nil
+	case HashPoseidon2:
+		return poseidon2Hash(elems...), nil
+	}
+	return fr.Element{}, fmt.Errorf("%w: %q", ErrUnknownHash, string(h))
+}
+
+// New returns the in-circuit gadget of the hash.
+func (h Hash) New(api frontend.API) (hash.FieldHasher, error) {
+	switch h {
+	case HashMiMC:
+		m, err := stdmimc.NewMiMC(api)
+		if err != nil {
+			return nil, err
+		}
+		return &m, nil
+	case HashPoseidon2:
+		return newPoseidon2Hasher(api), nil
+	}
+	return nil, fmt.Errorf("%w: %q", ErrUnknownHash, string(h))
+}
+
+// mimcHash computes the native MiMC hash of field elements, matching the
+// in-circuit gadget.
+func mimcHash(elems ...fr.Element) fr.Element {
+	h := mimc.NewMiMC()
+	for i := range elems {
+		b := elems[i].Bytes()
+		h.Write(b[:])
+	}
+	var res fr.Element
+	res.SetBytes(h.Sum(nil))
+	return res
+}
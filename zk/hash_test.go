@@ -0,0 +1,141 @@
+package zk
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type hashCircuit struct {
+	In  []frontend.Variable
+	Out frontend.Variable `gnark:",public"`
+
+	hash Hash
+}
+
+func (circuit *hashCircuit) Define(api frontend.API) error {
+	h, err := circuit.hash.New(api)
+	if err != nil {
+		return err
+	}
+	h.Write(circuit.In...)
+	api.AssertIsEqual(h.Sum(), circuit.Out)
+	return nil
+}
+
+type permutationCircuit struct {
+	In  [poseidon2Width]frontend.Variable
+	Out [poseidon2Width]frontend.Variable `gnark:",public"`
+}
+
+func (circuit *permutationCircuit) Define(api frontend.API) error {
+	state := circuit.In
+	poseidon2Permute(api, &state)
+	for i := range state {
+		api.AssertIsEqual(state[i], circuit.Out[i])
+	}
+	return nil
+}
+
+func element(t *testing.T, s string) fr.Element {
+	var e fr.Element
+	_, err := e.SetString(s)
+	require.NoError(t, err)
+	return e
+}
+
+func elements(in ...uint64) []fr.Element {
+	elems := make([]fr.Element, len(in))
+	for i, v := range in {
+		elems[i].SetUint64(v)
+	}
+	return elems
+}
+
+func TestPoseidon2(t *testing.T) {
+	t.Run("round constants", func(t *testing.T) {
+		constants := poseidon2Constants()
+		require.Len(t, constants, poseidon2FullRounds+poseidon2PartialRounds)
+		assert.Equal(t, element(t, "0x1d066a255517b7fd8bddd3a93f7804ef7f8fcde48bb4c37a59a09a1a97052816"), constants[0][0])
+	})
+
+	// test vector of the reference implementation
+	in := elements(0, 1, 2)
+	out := []fr.Element{
+		element(t, "0x0bb61d24daca55eebcb1929a82650f328134334da98ea4f847f760054f4a3033"),
+		element(t, "0x303b6f7c86d043bfcbcc80214f26a30277a15d3f74ca654992defe7ff8d03570"),
+		element(t, "0x1ed25194542b12eef8617361c3ba7c52e660b145994427cc86296242cf766ec8"),
+	}
+
+	t.Run("native permutation", func(t *testing.T) {
+		state := [poseidon2Width]fr.Element(in)
+		poseidon2Permutation(&state)
+		assert.Equal(t, out, state[:])
+	})
+
+	t.Run("permutation gadget", func(t *testing.T) {
+		var assignment permutationCircuit
+		for i := range in {
+			assignment.In[i] = in[i]
+			assignment.Out[i] = out[i]
+		}
+		assert.NoError(t, test.IsSolved(&permutationCircuit{}, &assignment, ecc.BN254.ScalarField()))
+	})
+
+	t.Run("sponge", func(t *testing.T) {
+		for _, tt := range []struct {
+			in  []uint64
+			out string
+		}{
+			{nil, "0x2ed1da00b14d635bd35b88ab49390d5c13c90da7e9e3a5f1ea69cd87a0aa3e82"},
+			{[]uint64{1}, "0x1e4a657b5631309b732f4762fdf6552c266e3b1c6ec84c01b65e88bceac60efb"},
+			{[]uint64{1, 2}, "0x0210752763833e0245ca2554e847d2e5b327da5ae77038be2c525059254e3bfd"},
+			{[]uint64{1, 2, 3}, "0x15c5bbf1c4f643d14fdf940c3d79358b2e9fdaa2d9aee6c42555fe0a9f5ef653"},
+		} {
+			assert.Equal(t, element(t, tt.out), poseidon2Hash(elements(tt.in...)...), "%v", tt.in)
+		}
+	})
+}
+
+func TestHash(t *testing.T) {
+	for _, h := range []Hash{HashMiMC, HashPoseidon2} {
+		t.Run(string(h), func(t *testing.T) {
+			for n := 1; n <= 5; n++ {
+				t.Run(fmt.Sprintf("%d elements", n), func(t *testing.T) {
+					elems := make([]fr.Element, n)
+					for i := range elems {
+						elems[i].SetRandom()
+					}
+					digest, err := h.Sum(elems...)
+					require.NoError(t, err)
+
+					assignment := hashCircuit{In: make([]frontend.Variable, n), Out: digest}
+					for i := range elems {
+						assignment.In[i] = elems[i]
+					}
+					circuit := hashCircuit{In: make([]frontend.Variable, n), hash: h}
+					assert.NoError(t, test.IsSolved(&circuit, &assignment, ecc.BN254.ScalarField()))
+
+					assignment.Out = 0
+					assert.Error(t, test.IsSolved(&circuit, &assignment, ecc.BN254.ScalarField()))
+				})
+			}
+		})
+	}
+
+	t.Run("parse", func(t *testing.T) {
+		h, err := ParseHash(" Poseidon2 ")
+		require.NoError(t, err)
+		assert.Equal(t, HashPoseidon2, h)
+		_, err = ParseHash("sha256")
+		assert.ErrorIs(t, err, ErrUnknownHash)
+		_, err = Hash("sha256").Sum()
+		assert.ErrorIs(t, err, ErrUnknownHash)
+	})
+}
@@ -0,0 +1,532 @@
+package zk
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	kzgbn254 "github.com/consensys/gnark-crypto/ecc/bn254/kzg"
+	"github.com/consensys/gnark/constraint"
+	"github.com/consensys/gnark/frontend"
+)
+
+const (
+	constraintSystemFile = "circuit.r1cs"
+	sparseSystemFile     = "circuit.scs"
+	provingKeyFile       = "proving.key"
+	verifyingKeyFile     = "verifying.key"
+	manifestFile         = "manifest.json"
+)
+
+var (
+	// ErrKeysNotFound is returned when no keys have been written for a circuit.
+	ErrKeysNotFound = errors.New("zk: keys not found")
+
+	// ErrKeyMismatch is returned when stored keys do not belong to the compiled circuit.
+	ErrKeyMismatch = errors.New("zk: keys do not match circuit")
+)
+
+// CircuitDefinition identifies a circuit the server can prove.
+type CircuitDefinition struct {
+	// ID names the circuit and the directory holding its keys. Changing the
+	// circuit's constraints requires a new ID.
+	ID string
+
+	// New returns an empty instance of the circuit, used for compilation.
+	New func() frontend.Circuit
+
+	// Sample returns a valid assignment of the circuit with random inputs,
+	// used to benchmark it.
+	Sample func() (frontend.Circuit, error)
+
+	// Hash is the algebraic hash the circuit derives and commits with, if
+	// any.
+	Hash Hash
+}
+
+// AssertionCircuitDefinition is the ES256 WebAuthn assertion circuit hashing
+// with MiMC.
+var AssertionCircuitDefinition = assertionCircuitDefinition("webauthn-p256-assertion-v6", HashMiMC)
+
+// Poseidon2AssertionCircuitDefinition is the ES256 WebAuthn assertion circuit
+// hashing with Poseidon2.
+var Poseidon2AssertionCircuitDefinition = assertionCircuitDefinition("webauthn-p256-assertion-poseidon2-v2", HashPoseidon2)
+
+func assertionCircuitDefinition(id string, h Hash) CircuitDefinition {
+	return CircuitDefinition{
+		ID:     id,
+		New:    func() frontend.Circuit { return NewAssertionCircuit(h) },
+		Sample: func() (frontend.Circuit, error) { return sampleAssertion(h) },
+		Hash:   h,
+	}
+}
+
+// MembershipCircuitDefinition is the ES256 WebAuthn assertion circuit for an
+// undisclosed credential of the tree of registered credentials, hashing with
+// MiMC.
+var MembershipCircuitDefinition = membershipCircuitDefinition("webauthn-p256-membership-v3", HashMiMC)
+
+// Poseidon2MembershipCircuitDefinition is the ES256 WebAuthn assertion circuit
+// for an undisclosed credential of the tree of registered credentials, hashing
+// with Poseidon2.
+var Poseidon2MembershipCircuitDefinition = membershipCircuitDefinition("webauthn-p256-membership-poseidon2-v2", HashPoseidon2)
+
+func membershipCircuitDefinition(id string, h Hash) CircuitDefinition {
+	return CircuitDefinition{
+		ID:     id,
+		New:    func() frontend.Circuit { return NewMembershipCircuit(h) },
+		Sample: func() (frontend.Circuit, error) { return sampleMembership(h) },
+		Hash:   h,
+	}
+}
+
+// ChallengeCircuitDefinition is the challenge derivation circuit hashing with
+// MiMC.
+var ChallengeCircuitDefinition = challengeCircuitDefinition("challenge-mimc-v4", HashMiMC)
+
+// Poseidon2ChallengeCircuitDefinition is the challenge derivation circuit
+// hashing with Poseidon2.
+var Poseidon2ChallengeCircuitDefinition = challengeCircuitDefinition("challenge-poseidon2-v3", HashPoseidon2)
+
+func challengeCircuitDefinition(id string, h Hash) CircuitDefinition {
+	return CircuitDefinition{
+		ID:     id,
+		New:    func() frontend.Circuit { return NewChallengeCircuit(h) },
+		Sample: func() (frontend.Circuit, error) { return sampleChallenge(h) },
+		Hash:   h,
+	}
+}
+
+// ChallengeCircuitDefinitionFor returns the challenge derivation circuit
+// hashing with h.
+func ChallengeCircuitDefinitionFor(h Hash) (CircuitDefinition, error) {
+	return definitionFor("challenge", h, ChallengeCircuitDefinition, Poseidon2ChallengeCircuitDefinition)
+}
+
+// AssertionCircuitDefinitionFor returns the assertion circuit hashing with h,
+// which proves the assertions of challenges derived with h.
+func AssertionCircuitDefinitionFor(h Hash) (CircuitDefinition, error) {
+	return definitionFor("assertion", h, AssertionCircuitDefinition, Poseidon2AssertionCircuitDefinition)
+}
+
+// MembershipCircuitDefinitionFor returns the membership circuit hashing with
+// h, which proves the assertions of challenges derived with h.
+func MembershipCircuitDefinitionFor(h Hash) (CircuitDefinition, error) {
+	return definitionFor("membership", h, MembershipCircuitDefinition, Poseidon2MembershipCircuitDefinition)
+}
+
+// definitionFor returns the definition hashing with h among the versions of a
+// circuit.
+func definitionFor(circuit string, h Hash, defs ...CircuitDefinition) (CircuitDefinition, error) {
+	for _, def := range defs {
+		if def.Hash == h {
+			return def, nil
+		}
+	}
+	return CircuitDefinition{}, fmt.Errorf("%w: no %s circuit hashes with %q", ErrUnknownHash, circuit, string(h))
+}
+
+// Circuits returns the definitions of every circuit the server proves with.
+func Circuits() []CircuitDefinition {
+	return []CircuitDefinition{
+		AssertionCircuitDefinition,
+		Poseidon2AssertionCircuitDefinition,
+		MembershipCircuitDefinition,
+		Poseidon2MembershipCircuitDefinition,
+		ChallengeCircuitDefinition,
+		Poseidon2ChallengeCircuitDefinition,
+	}
+}
+
+// Compile compiles the circuit over BN254 for the backend.
+func (def CircuitDefinition) Compile(backend Backend) (constraint.ConstraintSystem, error) {
+	ccs, err := backend.compile(def.New())
+	if err != nil {
+		return nil, fmt.Errorf("compile circuit %s: %w", def.ID, err)
+	}
+	return ccs, nil
+}
+
+// Fingerprints holds the hex encoded SHA-256 of each serialized artifact.
+type Fingerprints struct {
+	ConstraintSystem string `json:"constraintSystem"`
+	ProvingKey       string `json:"provingKey"`
+	VerifyingKey     string `json:"verifyingKey"`
+
+	// SRS is the fingerprint of the KZG SRS file the PLONK setup used.
+	SRS string `json:"srs,omitempty"`
+}
+
+// Manifest describes the keys written for a circuit.
+type Manifest struct {
+	CircuitID     string       `json:"circuitId"`
+	Curve         string       `json:"curve"`
+	Backend       Backend      `json:"backend"`
+	NbConstraints int          `json:"nbConstraints"`
+	NbPublic      int          `json:"nbPublic"`
+	Fingerprints  Fingerprints `json:"fingerprints"`
+
+	// Ceremony is the hash of the last contribution of the setup ceremony
+	// the keys were extracted from, if any.
+	Ceremony  string    `json:"ceremony,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// Keys holds the compiled circuit and its keys for the manifest's backend.
+type Keys struct {
+	Manifest         Manifest
+	ConstraintSystem constraint.ConstraintSystem
+	ProvingKey       ProvingKey
+	VerifyingKey     VerifyingKey
+
+	// Target is the verifier the proofs made with the keys are for.
+	Target Target
+}
+
+// KeyStore manages the keys of the server's circuits on disk.
+type KeyStore struct {
+	dir     string
+	backend Backend
+	target  Target
+	srsPath string
+	mu      sync.RWMutex
+	keys    map[string]*Keys
+}
+
+// NewKeyStore returns a Groth16 key store rooted at dir. Keys for each circuit
+// are kept in a sub directory named after the circuit ID.
+func NewKeyStore(dir string) *KeyStore {
+	return &KeyStore{
+		dir:     dir,
+		backend: BackendGroth16,
+		target:  TargetGnark,
+		keys:    make(map[string]*Keys),
+	}
+}
+
+// NewPlonkKeyStore returns a PLONK key store rooted at dir. The setup of each
+// circuit uses the universal KZG SRS read from srsPath, which is only needed
+// when keys have not been written yet.
+func NewPlonkKeyStore(dir, srsPath string) *KeyStore {
+	return &KeyStore{
+		dir:     dir,
+		backend: BackendPlonk,
+		target:  TargetGnark,
+		srsPath: srsPath,
+		keys:    make(map[string]*Keys),
+	}
+}
+
+// Backend returns the proving backend of the key store.
+func (ks *KeyStore) Backend() Backend {
+	return ks.backend
+}
+
+// SetTarget sets the verifier the proofs made with the keys loaded afterwards
+// are for, e.g. TargetEVM for the contracts written by ExportSolidity.
+func (ks *KeyStore) SetTarget(target Target) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	ks.target = target
+}
+
+// Get returns the loaded keys for a circuit.
+func (ks *KeyStore) Get(circuitID string) (*Keys, bool) {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	keys, ok := ks.keys[circuitID]
+	return keys, ok
+}
+
+// Verify checks a proof against the loaded keys of its circuit. The error
+// wraps ErrUnknownKey, ErrMalformedProof or ErrVerificationFailed.
+func (ks *KeyStore) Verify(p *Proof) error {
+	keys, ok := ks.Get(p.CircuitID)
+	if !ok {
+		return fmt.Errorf("%w: no keys loaded for circuit %s", ErrUnknownKey, p.CircuitID)
+	}
+	return Verify(keys, p)
+}
+
+// LoadOrSetup loads the keys of a circuit, running the setup first if no keys
+// have been written yet.
+func (ks *KeyStore) LoadOrSetup(def CircuitDefinition) (*Keys, error) {
+	keys, err := ks.Load(def)
+	if errors.Is(err, ErrKeysNotFound) {
+		return ks.Setup(def)
+	}
+	return keys, err
+}
+
+// Setup compiles the circuit, runs the setup of the key store's backend and
+// writes the constraint system, the keys and their manifest to disk.
+func (ks *KeyStore) Setup(def CircuitDefinition) (*Keys, error) {
+	ccs, err := def.Compile(ks.backend)
+	if err != nil {
+		return nil, err
+	}
+	var srs *kzgbn254.SRS
+	var srsFingerprint string
+	if ks.backend == BackendPlonk {
+		if srs, err = ReadSRS(ks.srsPath); err != nil {
+			return nil, err
+		}
+		if srsFingerprint, err = fingerprintOf(srs.WriteRawTo); err != nil {
+			return nil, err
+		}
+	}
+	pk, vk, err := ks.backend.setup(ccs, srs)
+	if err != nil {
+		return nil, fmt.Errorf("setup circuit %s: %w", def.ID, err)
+	}
+
+	return ks.write(ccs, pk, vk, Manifest{
+		CircuitID:    def.ID,
+		Fingerprints: Fingerprints{SRS: srsFingerprint},
+	})
+}
+
+// Import writes Groth16 keys produced outside of the key store, e.g. by a
+// setup ceremony, for the circuit. ceremony is the hash of the contribution
+// the keys were extracted from.
+func (ks *KeyStore) Import(def CircuitDefinition, pk ProvingKey, vk VerifyingKey, ceremony string) (*Keys, error) {
+	if ks.backend != BackendGroth16 {
+		return nil, fmt.Errorf("%w: keys can only be imported into a %s key store", ErrUnsupportedBackend, BackendGroth16)
+	}
+	ccs, err := def.Compile(ks.backend)
+	if err != nil {
+		return nil, err
+	}
+	if nbPublic := ks.backend.nbPublic(ccs); nbPublicWitness(vk) != nbPublic {
+		return nil, fmt.Errorf("%w: verifying key of %s expects %d public inputs, circuit has %d", ErrKeyMismatch, def.ID, nbPublicWitness(vk), nbPublic)
+	}
+
+	return ks.write(ccs, pk, vk, Manifest{
+		CircuitID: def.ID,
+		Ceremony:  ceremony,
+	})
+}
+
+// write writes the constraint system, the keys and their manifest to disk,
+// completing the given manifest.
+func (ks *KeyStore) write(ccs constraint.ConstraintSystem, pk ProvingKey, vk VerifyingKey, manifest Manifest) (*Keys, error) {
+	dir := ks.circuitDir(manifest.CircuitID)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create key directory: %w", err)
+	}
+
+	var err error
+	manifest.Curve = ecc.BN254.String()
+	manifest.Backend = ks.backend
+	manifest.NbConstraints = ccs.GetNbConstraints()
+	manifest.NbPublic = nbPublicWitness(vk)
+	manifest.CreatedAt = time.Now().UTC()
+	if manifest.Fingerprints.ConstraintSystem, err = writeFile(filepath.Join(dir, ks.constraintSystemFile()), ccs.WriteTo); err != nil {
+		return nil, err
+	}
+	if manifest.Fingerprints.ProvingKey, err = writeFile(filepath.Join(dir, provingKeyFile), pk.WriteRawTo); err != nil {
+		return nil, err
+	}
+	if manifest.Fingerprints.VerifyingKey, err = writeFile(filepath.Join(dir, verifyingKeyFile), vk.WriteRawTo); err != nil {
+		return nil, err
+	}
+
+	// the manifest is written last so that an interrupted setup is not mistaken
+	// for a complete one
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("encode manifest: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, manifestFile), data, 0o644); err != nil {
+		return nil, fmt.Errorf("write manifest: %w", err)
+	}
+
+	keys := &Keys{
+		Manifest:         manifest,
+		ConstraintSystem: ccs,
+		ProvingKey:       pk,
+		VerifyingKey:     vk,
+	}
+	ks.put(keys)
+	return keys, nil
+}
+
+// Load reads the keys of a circuit from disk and validates them against the
+// freshly compiled circuit. It returns ErrKeysNotFound if no keys were written
+// and ErrKeyMismatch if the stored artifacts do not belong to the circuit.
+func (ks *KeyStore) Load(def CircuitDefinition) (*Keys, error) {
+	dir := ks.circuitDir(def.ID)
+	data, err := os.ReadFile(filepath.Join(dir, manifestFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrKeysNotFound, def.ID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read manifest: %w", err)
+	}
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("decode manifest: %w", err)
+	}
+	if manifest.CircuitID != def.ID {
+		return nil, fmt.Errorf("%w: manifest is for circuit %s, expected %s", ErrKeyMismatch, manifest.CircuitID, def.ID)
+	}
+	if manifest.Backend != ks.backend {
+		return nil, fmt.Errorf("%w: keys of %s are for %s, expected %s", ErrKeyMismatch, def.ID, manifest.Backend, ks.backend)
+	}
+
+	ccs, err := def.Compile(ks.backend)
+	if err != nil {
+		return nil, err
+	}
+	fingerprint, err := fingerprintOf(ccs.WriteTo)
+	if err != nil {
+		return nil, err
+	}
+	if fingerprint != manifest.Fingerprints.ConstraintSystem {
+		return nil, fmt.Errorf("%w: circuit %s was modified after its setup", ErrKeyMismatch, def.ID)
+	}
+
+	if err := readFile(filepath.Join(dir, ks.constraintSystemFile()), manifest.Fingerprints.ConstraintSystem, nil); err != nil {
+		return nil, err
+	}
+	// the fingerprints are checked before deserializing, so the keys can be
+	// read without the (slow) subgroup checks
+	pk, vk, err := ks.backend.newKeys()
+	if err != nil {
+		return nil, err
+	}
+	if err := readFile(filepath.Join(dir, provingKeyFile), manifest.Fingerprints.ProvingKey, pk.UnsafeReadFrom); err != nil {
+		return nil, err
+	}
+	if err := readFile(filepath.Join(dir, verifyingKeyFile), manifest.Fingerprints.VerifyingKey, vk.UnsafeReadFrom); err != nil {
+		return nil, err
+	}
+
+	if nbPublic := ks.backend.nbPublic(ccs); nbPublicWitness(vk) != nbPublic {
+		return nil, fmt.Errorf("%w: verifying key of %s expects %d public inputs, circuit has %d", ErrKeyMismatch, def.ID, nbPublicWitness(vk), nbPublic)
+	}
+
+	keys := &Keys{
+		Manifest:         manifest,
+		ConstraintSystem: ccs,
+		ProvingKey:       pk,
+		VerifyingKey:     vk,
+	}
+	ks.put(keys)
+	return keys, nil
+}
+
+// LoadVerifyingKey reads the manifest and the verifying key of a circuit
+// from disk, without its constraint system and proving key, so that proofs
+// of a circuit this build no longer compiles can still be verified. The
+// returned keys can't be used to prove.
+func (ks *KeyStore) LoadVerifyingKey(circuitID string) (*Keys, error) {
+	dir := ks.circuitDir(circuitID)
+	data, err := os.ReadFile(filepath.Join(dir, manifestFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrKeysNotFound, circuitID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read manifest: %w", err)
+	}
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("decode manifest: %w", err)
+	}
+	if manifest.CircuitID != circuitID {
+		return nil, fmt.Errorf("%w: manifest is for circuit %s, expected %s", ErrKeyMismatch, manifest.CircuitID, circuitID)
+	}
+	if manifest.Backend != ks.backend {
+		return nil, fmt.Errorf("%w: keys of %s are for %s, expected %s", ErrKeyMismatch, circuitID, manifest.Backend, ks.backend)
+	}
+	_, vk, err := ks.backend.newKeys()
+	if err != nil {
+		return nil, err
+	}
+	if err := readFile(filepath.Join(dir, verifyingKeyFile), manifest.Fingerprints.VerifyingKey, vk.UnsafeReadFrom); err != nil {
+		return nil, err
+	}
+	if nbPublicWitness(vk) != manifest.NbPublic {
+		return nil, fmt.Errorf("%w: verifying key of %s expects %d public inputs, manifest has %d", ErrKeyMismatch, circuitID, nbPublicWitness(vk), manifest.NbPublic)
+	}
+
+	keys := &Keys{
+		Manifest:     manifest,
+		VerifyingKey: vk,
+	}
+	ks.put(keys)
+	return keys, nil
+}
+
+func (ks *KeyStore) put(keys *Keys) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	keys.Target = ks.target
+	ks.keys[keys.Manifest.CircuitID] = keys
+}
+
+func (ks *KeyStore) circuitDir(circuitID string) string {
+	return filepath.Join(ks.dir, circuitID)
+}
+
+func (ks *KeyStore) constraintSystemFile() string {
+	if ks.backend == BackendPlonk {
+		return sparseSystemFile
+	}
+	return constraintSystemFile
+}
+
+// writeFile serializes an artifact to path and returns its fingerprint.
+func writeFile(path string, writeTo func(io.Writer) (int64, error)) (string, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return "", fmt.Errorf("create %s: %w", path, err)
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := writeTo(io.MultiWriter(f, h)); err != nil {
+		return "", fmt.Errorf("write %s: %w", path, err)
+	}
+	if err := f.Sync(); err != nil {
+		return "", fmt.Errorf("sync %s: %w", path, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// readFile checks the fingerprint of the artifact at path before
+// deserializing it with readFrom, if any.
+func readFile(path, fingerprint string, readFrom func(io.Reader) (int64, error)) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read %s: %w", path, err)
+	}
+	sum := sha256.Sum256(data)
+	if hex.EncodeToString(sum[:]) != fingerprint {
+		return fmt.Errorf("%w: fingerprint of %s does not match the manifest", ErrKeyMismatch, path)
+	}
+	if readFrom == nil {
+		return nil
+	}
+	if _, err := readFrom(bytes.NewReader(data)); err != nil {
+		return fmt.Errorf("decode %s: %w", path, err)
+	}
+	return nil
+}
+
+func fingerprintOf(writeTo func(io.Writer) (int64, error)) (string, error) {
+	h := sha256.New()
+	if _, err := writeTo(h); err != nil {
+		return "", fmt.Errorf("serialize: %w", err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
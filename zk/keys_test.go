@@ -0,0 +1,146 @@
+package zk
+
+import (
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr/mimc"
+	kzgbn254 "github.com/consensys/gnark-crypto/ecc/bn254/kzg"
+	"github.com/consensys/gnark/frontend"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var preimageCircuitDefinition = CircuitDefinition{
+	ID:  "test-mimc-preimage",
+	New: func() frontend.Circuit { return NewCircuit() },
+}
+
+// doublePreimageCircuit is a different circuit registered under the same ID
+// as preimageCircuitDefinition.
+type doublePreimageCircuit struct {
+	PreImage frontend.Variable
+	Hash     frontend.Variable `gnark:",public"`
+}
+
+func (c *doublePreimageCircuit) Define(api frontend.API) error {
+	api.AssertIsEqual(api.Mul(c.PreImage, 2), c.Hash)
+	return nil
+}
+
+func TestKeyStore(t *testing.T) {
+	srsPath := writeTestSRS(t, 1<<10)
+	backends := []struct {
+		backend  Backend
+		newStore func(dir string) *KeyStore
+		ccsFile  string
+	}{
+		{BackendGroth16, NewKeyStore, constraintSystemFile},
+		{BackendPlonk, func(dir string) *KeyStore { return NewPlonkKeyStore(dir, srsPath) }, sparseSystemFile},
+	}
+
+	for _, tt := range backends {
+		t.Run(string(tt.backend), func(t *testing.T) {
+			dir := t.TempDir()
+
+			keys, err := tt.newStore(dir).LoadOrSetup(preimageCircuitDefinition)
+			require.NoError(t, err)
+			assert.Equal(t, preimageCircuitDefinition.ID, keys.Manifest.CircuitID)
+			assert.Equal(t, tt.backend, keys.Manifest.Backend)
+			for _, name := range []string{tt.ccsFile, provingKeyFile, verifyingKeyFile, manifestFile} {
+				assert.FileExists(t, filepath.Join(dir, preimageCircuitDefinition.ID, name))
+			}
+
+			t.Run("loaded keys prove and verify", func(t *testing.T) {
+				ks := tt.newStore(dir)
+				loaded, err := ks.Load(preimageCircuitDefinition)
+				require.NoError(t, err)
+				assert.Equal(t, keys.Manifest.Fingerprints, loaded.Manifest.Fingerprints)
+
+				got, ok := ks.Get(preimageCircuitDefinition.ID)
+				require.True(t, ok)
+				assert.Same(t, loaded, got)
+
+				var preImage fr.Element
+				preImage.SetUint64(42)
+				h := mimc.NewMiMC()
+				b := preImage.Bytes()
+				h.Write(b[:])
+				proof, err := Prove(loaded, &Circuit{PreImage: preImage, Hash: h.Sum(nil)})
+				require.NoError(t, err)
+				assert.Equal(t, tt.backend, proof.Backend)
+				assert.NoError(t, Verify(loaded, proof))
+
+				unknown := *proof
+				unknown.Backend = "stark"
+				assert.ErrorIs(t, Verify(loaded, &unknown), ErrUnsupportedBackend)
+			})
+
+			t.Run("missing keys", func(t *testing.T) {
+				_, err := tt.newStore(t.TempDir()).Load(preimageCircuitDefinition)
+				assert.ErrorIs(t, err, ErrKeysNotFound)
+			})
+
+			t.Run("other backend", func(t *testing.T) {
+				for _, other := range backends {
+					if other.backend == tt.backend {
+						continue
+					}
+					_, err := other.newStore(dir).Load(preimageCircuitDefinition)
+					assert.ErrorIs(t, err, ErrKeyMismatch)
+				}
+			})
+
+			t.Run("modified circuit", func(t *testing.T) {
+				def := CircuitDefinition{
+					ID:  preimageCircuitDefinition.ID,
+					New: func() frontend.Circuit { return &doublePreimageCircuit{} },
+				}
+				_, err := tt.newStore(dir).Load(def)
+				assert.ErrorIs(t, err, ErrKeyMismatch)
+			})
+
+			t.Run("corrupted key", func(t *testing.T) {
+				vkPath := filepath.Join(dir, preimageCircuitDefinition.ID, verifyingKeyFile)
+				data, err := os.ReadFile(vkPath)
+				require.NoError(t, err)
+				data[len(data)-1] ^= 1
+				require.NoError(t, os.WriteFile(vkPath, data, 0o644))
+
+				_, err = tt.newStore(dir).Load(preimageCircuitDefinition)
+				assert.ErrorIs(t, err, ErrKeyMismatch)
+			})
+		})
+	}
+
+	t.Run("plonk setup without srs", func(t *testing.T) {
+		_, err := NewPlonkKeyStore(t.TempDir(), filepath.Join(t.TempDir(), "missing.srs")).Setup(preimageCircuitDefinition)
+		assert.Error(t, err)
+	})
+
+	t.Run("srs too small", func(t *testing.T) {
+		_, err := NewPlonkKeyStore(t.TempDir(), writeTestSRS(t, 16)).Setup(preimageCircuitDefinition)
+		assert.ErrorContains(t, err, "srs is too small")
+	})
+}
+
+// writeTestSRS writes a KZG SRS of the given size derived from a random tau,
+// only fit for tests.
+func writeTestSRS(t *testing.T, size uint64) string {
+	tau, err := rand.Int(rand.Reader, ecc.BN254.ScalarField())
+	require.NoError(t, err)
+	srs, err := kzgbn254.NewSRS(size, tau)
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "kzg.srs")
+	f, err := os.Create(path)
+	require.NoError(t, err)
+	defer f.Close()
+	_, err = srs.WriteTo(f)
+	require.NoError(t, err)
+	return path
+}
@@ -0,0 +1,163 @@
+package zk
+
+import (
+	"fmt"
+
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/std/math/uints"
+)
+
+// rpIDHashLen is the size of the rpIdHash at the start of authenticatorData.
+const rpIDHashLen = 32
+
+// MembershipCircuit proves an assertion as AssertionCircuit does, but for a
+// credential that is not disclosed: the public key and the credential's leaf
+// are private, and the leaf is proven to be in the tree of registered
+// credentials with the public Root (see MerkleTree and CredentialLeaf):
+//
+//	ECDSA-P256.Verify(PublicKey, SHA256(AuthenticatorData || ClientDataHash), Signature) = 1
+//	MerkleRoot(CredentialLeaf(PublicKey, CredentialSecret), LeafIndex, Path) = Root
+//
+// The nullifier is derived from the private secret, so it doesn't disclose
+// the credential either (see Nullifier).
+//
+// AuthenticatorData is private too, since its signature counter tells the
+// credentials apart. Only its rpIdHash and flags are public. A verifier
+// checks the root against the history published by the server, for the tree
+// built with the hash of the circuit version.
+type MembershipCircuit struct {
+	hash Hash
+
+	// private inputs (witnesses)
+	Signature         P256Signature
+	SignatureBlinding frontend.Variable
+	Challenge         frontend.Variable
+	ChallengeBlinding frontend.Variable
+	ClientData        ClientData
+	CredentialSecret  frontend.Variable
+	PublicKey         P256PublicKey
+	AuthenticatorData [AuthenticatorDataLen]uints.U8
+	LeafIndex         frontend.Variable
+	Path              [MerkleDepth]frontend.Variable
+
+	// public inputs
+	RPIDHash       [rpIDHashLen]uints.U8       `gnark:",public"`
+	Flags          uints.U8                    `gnark:",public"`
+	ClientDataHash [ClientDataHashLen]uints.U8 `gnark:",public"`
+
+	SignatureCommitment PedersenCommitment `gnark:",public"`
+	ChallengeCommitment PedersenCommitment `gnark:",public"`
+
+	// AllowedOrigins are the digests of the allowed origins, see OriginDigest.
+	AllowedOrigins [MaxAllowedOrigins]frontend.Variable `gnark:",public"`
+
+	IntentHash frontend.Variable `gnark:",public"`
+	Nonce      frontend.Variable `gnark:",public"`
+	Nullifier  frontend.Variable `gnark:",public"`
+	Root       frontend.Variable `gnark:",public"`
+}
+
+// Define declares the circuit's constraints
+func (circuit *MembershipCircuit) Define(api frontend.API) error {
+	bf, err := uints.New[uints.U32](api)
+	if err != nil {
+		return fmt.Errorf("new binary field: %w", err)
+	}
+
+	leaf, err := circuit.assertion().define(api)
+	if err != nil {
+		return err
+	}
+	for i := range circuit.RPIDHash {
+		bf.ByteAssertEq(circuit.AuthenticatorData[i], circuit.RPIDHash[i])
+	}
+	bf.ByteAssertEq(circuit.AuthenticatorData[rpIDHashLen], circuit.Flags)
+
+	return assertMerkleMembership(api, circuit.hash, circuit.Root, leaf, circuit.LeafIndex, circuit.Path[:])
+}
+
+// assertion returns the assertion the circuit proves.
+func (circuit *MembershipCircuit) assertion() *AssertionCircuit {
+	return &AssertionCircuit{
+		hash:                circuit.hash,
+		Signature:           circuit.Signature,
+		SignatureBlinding:   circuit.SignatureBlinding,
+		Challenge:           circuit.Challenge,
+		ChallengeBlinding:   circuit.ChallengeBlinding,
+		ClientData:          circuit.ClientData,
+		CredentialSecret:    circuit.CredentialSecret,
+		PublicKey:           circuit.PublicKey,
+		AuthenticatorData:   circuit.AuthenticatorData,
+		ClientDataHash:      circuit.ClientDataHash,
+		SignatureCommitment: circuit.SignatureCommitment,
+		ChallengeCommitment: circuit.ChallengeCommitment,
+		AllowedOrigins:      circuit.AllowedOrigins,
+		IntentHash:          circuit.IntentHash,
+		Nonce:               circuit.Nonce,
+		Nullifier:           circuit.Nullifier,
+	}
+}
+
+// NewMembershipCircuit returns a new credential membership circuit hashing
+// with h
+func NewMembershipCircuit(h Hash) *MembershipCircuit {
+	return &MembershipCircuit{hash: h}
+}
+
+// NewMembershipAssignment returns the witness of the membership circuit for
+// the assignment of the assertion circuit, made with the COSE encoded
+// credential public key. The proof must be the Merkle proof of the
+// credential's leaf in the tree built with the hash of the assertion, and the
+// root of its tree is the public root.
+func NewMembershipAssignment(credentialPublicKey []byte, assertion *AssertionCircuit, proof *MerkleProof) (*MembershipCircuit, error) {
+	publicKey, err := ParseCredentialPublicKey(credentialPublicKey)
+	if err != nil {
+		return nil, err
+	}
+	if proof.Hash != assertion.hash {
+		return nil, fmt.Errorf("%w: tree is built with %q, expected %q", ErrInvalidMerkleProof, string(proof.Hash), string(assertion.hash))
+	}
+	secret, ok := assertion.CredentialSecret.(fr.Element)
+	if !ok {
+		return nil, fmt.Errorf("credential secret is not a field element")
+	}
+	leaf, err := CredentialLeaf(assertion.hash, publicKey, secret)
+	if err != nil {
+		return nil, err
+	}
+	if !leaf.Equal(&proof.Leaf) {
+		return nil, fmt.Errorf("%w: leaf %d is not the leaf of the credential", ErrInvalidMerkleProof, proof.Index)
+	}
+	root, err := proof.Root()
+	if err != nil {
+		return nil, err
+	}
+
+	assignment := &MembershipCircuit{
+		hash:                assertion.hash,
+		Signature:           assertion.Signature,
+		SignatureBlinding:   assertion.SignatureBlinding,
+		Challenge:           assertion.Challenge,
+		ChallengeBlinding:   assertion.ChallengeBlinding,
+		ClientData:          assertion.ClientData,
+		CredentialSecret:    assertion.CredentialSecret,
+		PublicKey:           assertion.PublicKey,
+		AuthenticatorData:   assertion.AuthenticatorData,
+		LeafIndex:           proof.Index,
+		Flags:               assertion.AuthenticatorData[rpIDHashLen],
+		ClientDataHash:      assertion.ClientDataHash,
+		SignatureCommitment: assertion.SignatureCommitment,
+		ChallengeCommitment: assertion.ChallengeCommitment,
+		AllowedOrigins:      assertion.AllowedOrigins,
+		IntentHash:          assertion.IntentHash,
+		Nonce:               assertion.Nonce,
+		Nullifier:           assertion.Nullifier,
+		Root:                root,
+	}
+	copy(assignment.RPIDHash[:], assertion.AuthenticatorData[:rpIDHashLen])
+	for i := range proof.Path {
+		assignment.Path[i] = proof.Path[i]
+	}
+	return assignment, nil
+}
@@ -0,0 +1,113 @@
+package zk
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/std/math/uints"
+	"github.com/consensys/gnark/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMembershipCircuit(t *testing.T) {
+	privKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	credentialPublicKey := encodeCOSEKey(t, &privKey.PublicKey)
+
+	authData := make([]byte, AuthenticatorDataLen)
+	_, err = rand.Read(authData)
+	require.NoError(t, err)
+	challenge, err := NewChallenge(HashMiMC, make([]byte, IntentHashLen), 1)
+	require.NoError(t, err)
+	clientDataJSON := testClientDataJSON(challenge, testOrigins[0])
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	msg := sha256.Sum256(append(authData, clientDataHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, privKey, msg[:])
+	require.NoError(t, err)
+	assertion, _, err := NewAssertionAssignment(HashMiMC, credentialPublicKey, testSecret, authData, clientDataJSON, signature, challenge.Opening, testOrigins, testIntentHash, 1)
+	require.NoError(t, err)
+
+	leaf, err := CredentialLeaf(HashMiMC, &privKey.PublicKey, testSecret)
+	require.NoError(t, err)
+	tree := NewMerkleTree(HashMiMC, NewMemoryMerkleStore())
+	_, err = tree.SetLeaf(0, elements(1)[0])
+	require.NoError(t, err)
+	_, err = tree.SetLeaf(1, leaf)
+	require.NoError(t, err)
+	proof, err := tree.Proof(1)
+	require.NoError(t, err)
+
+	assignment, err := NewMembershipAssignment(credentialPublicKey, assertion, proof)
+	require.NoError(t, err)
+
+	t.Run("valid membership", func(t *testing.T) {
+		err := test.IsSolved(NewMembershipCircuit(HashMiMC), assignment, ecc.BN254.ScalarField())
+		assert.NoError(t, err)
+	})
+
+	t.Run("tampered flags", func(t *testing.T) {
+		tampered := *assignment
+		tampered.Flags = uints.NewU8(authData[rpIDHashLen] ^ 1)
+		err := test.IsSolved(NewMembershipCircuit(HashMiMC), &tampered, ecc.BN254.ScalarField())
+		assert.Error(t, err)
+	})
+
+	t.Run("nullifier of another secret", func(t *testing.T) {
+		other, err := Nullifier(HashMiMC, fr.NewElement(8), testIntentHash, 1)
+		require.NoError(t, err)
+		tampered := *assignment
+		tampered.Nullifier = other
+		err = test.IsSolved(NewMembershipCircuit(HashMiMC), &tampered, ecc.BN254.ScalarField())
+		assert.Error(t, err)
+
+		// the secret is committed into the leaf in the tree
+		tampered.CredentialSecret = fr.NewElement(8)
+		err = test.IsSolved(NewMembershipCircuit(HashMiMC), &tampered, ecc.BN254.ScalarField())
+		assert.Error(t, err)
+	})
+
+	t.Run("public inputs", func(t *testing.T) {
+		// neither the credential's leaf nor its secret are public
+		w, err := frontend.NewWitness(assignment, ecc.BN254.ScalarField(), frontend.PublicOnly())
+		require.NoError(t, err)
+		public, err := encodePublicInputs(w)
+		require.NoError(t, err)
+		assert.NotContains(t, public, EncodeNullifier(leaf))
+		assert.NotContains(t, public, EncodeCredentialSecret(testSecret))
+	})
+
+	t.Run("revoked credential", func(t *testing.T) {
+		root, err := tree.SetLeaf(1, elements(0)[0])
+		require.NoError(t, err)
+		tampered := *assignment
+		tampered.Root = root
+		err = test.IsSolved(NewMembershipCircuit(HashMiMC), &tampered, ecc.BN254.ScalarField())
+		assert.Error(t, err)
+	})
+
+	t.Run("proof of another leaf", func(t *testing.T) {
+		other, err := tree.Proof(0)
+		require.NoError(t, err)
+		_, err = NewMembershipAssignment(credentialPublicKey, assertion, other)
+		assert.ErrorIs(t, err, ErrInvalidMerkleProof)
+	})
+
+	t.Run("proof of the tree of another hash", func(t *testing.T) {
+		leaf, err := CredentialLeaf(HashPoseidon2, &privKey.PublicKey, testSecret)
+		require.NoError(t, err)
+		other := NewMerkleTree(HashPoseidon2, NewMemoryMerkleStore())
+		_, err = other.SetLeaf(1, leaf)
+		require.NoError(t, err)
+		proof, err := other.Proof(1)
+		require.NoError(t, err)
+		_, err = NewMembershipAssignment(credentialPublicKey, assertion, proof)
+		assert.ErrorIs(t, err, ErrInvalidMerkleProof)
+	})
+}
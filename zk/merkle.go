@@ -0,0 +1,275 @@
+package zk
+
+import (
+	"crypto/ecdsa"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/std/math/emulated"
+)
+
+// MerkleDepth is the depth of the tree of credentials, which holds up to 2²⁰
+// credentials.
+const MerkleDepth = 20
+
+var (
+	// ErrMerkleTreeFull is returned when setting a leaf past the last one.
+	ErrMerkleTreeFull = errors.New("zk: merkle tree is full")
+
+	// ErrInvalidMerkleProof is returned when a Merkle proof is not for the
+	// given leaf.
+	ErrInvalidMerkleProof = errors.New("zk: invalid merkle proof")
+
+	// ErrInvalidMerkleRoot is returned for roots that are not the 0x prefixed
+	// hex encoding of a field element.
+	ErrInvalidMerkleRoot = errors.New("zk: invalid merkle root")
+)
+
+// CredentialLeaf returns the leaf of a credential in the tree built with h,
+// from its public key and its secret (see NewCredentialSecret):
+//
+//	Leaf = H(X₀, X₁, Y₀, Y₁, Secret)
+//
+// where X₀, X₁ and Y₀, Y₁ are the 128-bit limbs of the coordinates, least
+// significant first. Empty leaves, e.g. of revoked credentials, are 0.
+func CredentialLeaf(h Hash, publicKey *ecdsa.PublicKey, secret fr.Element) (fr.Element, error) {
+	mask := new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), limbBits), big.NewInt(1))
+	var limbs [4]fr.Element
+	for i, coord := range []*big.Int{publicKey.X, publicKey.Y} {
+		limbs[2*i].SetBigInt(new(big.Int).And(coord, mask))
+		limbs[2*i+1].SetBigInt(new(big.Int).Rsh(coord, limbBits))
+	}
+	return h.Sum(append(limbs[:], secret)...)
+}
+
+// credentialLeaf constrains the leaf of a credential hashed with h, from its
+// public key in its canonical form, so that each key has a single leaf for a
+// secret.
+func credentialLeaf(api frontend.API, h Hash, publicKey *P256PublicKey, secret frontend.Variable) (frontend.Variable, error) {
+	coords, err := emulated.NewField[emulated.P256Fp](api)
+	if err != nil {
+		return nil, fmt.Errorf("new coordinate field: %w", err)
+	}
+	hasher, err := h.New(api)
+	if err != nil {
+		return nil, err
+	}
+	hasher.Write(packLimbs(api, coords.ReduceStrict(&publicKey.X))...)
+	hasher.Write(packLimbs(api, coords.ReduceStrict(&publicKey.Y))...)
+	hasher.Write(secret)
+	return hasher.Sum(), nil
+}
+
+// assertMerkleMembership constrains the leaf to be at the index of the tree
+// built with h with the root, the path holding the siblings from the leaf up.
+func assertMerkleMembership(api frontend.API, h Hash, root, leaf, index frontend.Variable, path []frontend.Variable) error {
+	hasher, err := h.New(api)
+	if err != nil {
+		return err
+	}
+	bits := api.ToBinary(index, len(path))
+	node := leaf
+	for i := range path {
+		// the bit is set when the node is a right child
+		left := api.Select(bits[i], path[i], node)
+		right := api.Select(bits[i], node, path[i])
+		hasher.Reset()
+		hasher.Write(left, right)
+		node = hasher.Sum()
+	}
+	api.AssertIsEqual(node, root)
+	return nil
+}
+
+// merkleParent hashes two sibling nodes with h.
+func merkleParent(h Hash, left, right fr.Element) (fr.Element, error) {
+	return h.Sum(left, right)
+}
+
+// merkleZeros holds, for each hash, the root of an empty subtree at each
+// level, from the empty leaf up to the root of the empty tree.
+var merkleZeros = map[Hash]func() ([MerkleDepth + 1]fr.Element, error){
+	HashMiMC:      emptyMerkleSubtrees(HashMiMC),
+	HashPoseidon2: emptyMerkleSubtrees(HashPoseidon2),
+}
+
+func emptyMerkleSubtrees(h Hash) func() ([MerkleDepth + 1]fr.Element, error) {
+	return sync.OnceValues(func() ([MerkleDepth + 1]fr.Element, error) {
+		var zeros [MerkleDepth + 1]fr.Element
+		for i := 1; i < len(zeros); i++ {
+			var err error
+			if zeros[i], err = merkleParent(h, zeros[i-1], zeros[i-1]); err != nil {
+				return zeros, err
+			}
+		}
+		return zeros, nil
+	})
+}
+
+// MerkleStore keeps the non-empty nodes of a Merkle tree. Level 0 holds the
+// leaves and level MerkleDepth the root.
+type MerkleStore interface {
+	// Node returns the node at the index of the level, and false if it is
+	// empty.
+	Node(level int, index uint64) (fr.Element, bool, error)
+
+	// SetNode sets the node at the index of the level.
+	SetNode(level int, index uint64, node fr.Element) error
+}
+
+// MerkleTree is the fixed depth Merkle tree of the credentials, whose nodes are
+// kept by a MerkleStore. Updates are not synchronized: the store must
+// serialize them, e.g. within a database transaction.
+type MerkleTree struct {
+	hash  Hash
+	store MerkleStore
+}
+
+// NewMerkleTree returns the tree built with h whose nodes are kept in the
+// store.
+func NewMerkleTree(h Hash, store MerkleStore) *MerkleTree {
+	return &MerkleTree{hash: h, store: store}
+}
+
+// Hash returns the hash the tree is built with.
+func (t *MerkleTree) Hash() Hash {
+	return t.hash
+}
+
+// node returns the node at the index of the level, or the root of an empty
+// subtree.
+func (t *MerkleTree) node(level int, index uint64) (fr.Element, error) {
+	node, ok, err := t.store.Node(level, index)
+	if err != nil || ok {
+		return node, err
+	}
+	zeros, ok := merkleZeros[t.hash]
+	if !ok {
+		return node, fmt.Errorf("%w: %q", ErrUnknownHash, string(t.hash))
+	}
+	z, err := zeros()
+	return z[level], err
+}
+
+// Root returns the root of the tree.
+func (t *MerkleTree) Root() (fr.Element, error) {
+	return t.node(MerkleDepth, 0)
+}
+
+// SetLeaf sets the leaf at the index, or clears it with the empty leaf 0, and
+// returns the new root.
+func (t *MerkleTree) SetLeaf(index uint64, leaf fr.Element) (fr.Element, error) {
+	if index >= 1<<MerkleDepth {
+		return fr.Element{}, fmt.Errorf("%w: leaf %d is past the last one", ErrMerkleTreeFull, index)
+	}
+	node := leaf
+	for level := 0; level < MerkleDepth; level++ {
+		if err := t.store.SetNode(level, index, node); err != nil {
+			return node, err
+		}
+		sibling, err := t.node(level, index^1)
+		if err != nil {
+			return node, err
+		}
+		if index&1 == 0 {
+			node, err = merkleParent(t.hash, node, sibling)
+		} else {
+			node, err = merkleParent(t.hash, sibling, node)
+		}
+		if err != nil {
+			return node, err
+		}
+		index >>= 1
+	}
+	return node, t.store.SetNode(MerkleDepth, 0, node)
+}
+
+// Proof returns the proof of membership of the leaf at the index.
+func (t *MerkleTree) Proof(index uint64) (*MerkleProof, error) {
+	if index >= 1<<MerkleDepth {
+		return nil, fmt.Errorf("%w: leaf %d is past the last one", ErrMerkleTreeFull, index)
+	}
+	leaf, err := t.node(0, index)
+	if err != nil {
+		return nil, err
+	}
+	p := &MerkleProof{Hash: t.hash, Index: index, Leaf: leaf}
+	for level := range p.Path {
+		if p.Path[level], err = t.node(level, (index>>level)^1); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// MerkleProof proves that a leaf is at an index of the tree.
+type MerkleProof struct {
+	// Hash is the hash the tree is built with.
+	Hash Hash
+
+	Index uint64
+	Leaf  fr.Element
+
+	// Path holds the siblings of the nodes from the leaf up to the root.
+	Path [MerkleDepth]fr.Element
+}
+
+// Root returns the root of the tree the proof is for.
+func (p *MerkleProof) Root() (fr.Element, error) {
+	node := p.Leaf
+	for level := range p.Path {
+		var err error
+		if p.Index>>level&1 == 0 {
+			node, err = merkleParent(p.Hash, node, p.Path[level])
+		} else {
+			node, err = merkleParent(p.Hash, p.Path[level], node)
+		}
+		if err != nil {
+			return node, err
+		}
+	}
+	return node, nil
+}
+
+// NewMemoryMerkleStore returns a MerkleStore keeping the nodes in memory.
+func NewMemoryMerkleStore() MerkleStore {
+	return memoryMerkleStore{}
+}
+
+type merkleNodeKey struct {
+	level int
+	index uint64
+}
+
+type memoryMerkleStore map[merkleNodeKey]fr.Element
+
+func (s memoryMerkleStore) Node(level int, index uint64) (fr.Element, bool, error) {
+	node, ok := s[merkleNodeKey{level, index}]
+	return node, ok, nil
+}
+
+func (s memoryMerkleStore) SetNode(level int, index uint64, node fr.Element) error {
+	s[merkleNodeKey{level, index}] = node
+	return nil
+}
+
+// EncodeMerkleRoot encodes a root as it appears in the public inputs of a
+// proof.
+func EncodeMerkleRoot(root fr.Element) string {
+	b := root.Bytes()
+	return "0x" + hex.EncodeToString(b[:])
+}
+
+// DecodeMerkleRoot decodes a root encoded by EncodeMerkleRoot.
+func DecodeMerkleRoot(s string) (fr.Element, error) {
+	root, err := decodePublicInput(s)
+	if err != nil {
+		return root, fmt.Errorf("%w: %v", ErrInvalidMerkleRoot, err)
+	}
+	return root, nil
+}
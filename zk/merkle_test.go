@@ -0,0 +1,175 @@
+package zk
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/std/math/emulated"
+	"github.com/consensys/gnark/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type merkleCircuit struct {
+	PublicKey P256PublicKey
+	Secret    frontend.Variable
+	LeafIndex frontend.Variable
+	Path      [MerkleDepth]frontend.Variable
+	Root      frontend.Variable `gnark:",public"`
+
+	hash Hash
+}
+
+func (circuit *merkleCircuit) Define(api frontend.API) error {
+	leaf, err := credentialLeaf(api, circuit.hash, &circuit.PublicKey, circuit.Secret)
+	if err != nil {
+		return err
+	}
+	return assertMerkleMembership(api, circuit.hash, circuit.Root, leaf, circuit.LeafIndex, circuit.Path[:])
+}
+
+func TestMerkleTree(t *testing.T) {
+	tree := NewMerkleTree(HashMiMC, NewMemoryMerkleStore())
+	empty, err := tree.Root()
+	require.NoError(t, err)
+	zeros, err := merkleZeros[HashMiMC]()
+	require.NoError(t, err)
+	assert.Equal(t, zeros[MerkleDepth], empty)
+
+	poseidon2Empty, err := NewMerkleTree(HashPoseidon2, NewMemoryMerkleStore()).Root()
+	require.NoError(t, err)
+	assert.NotEqual(t, empty, poseidon2Empty, "each hash has its own tree")
+	_, err = NewMerkleTree("sha3", NewMemoryMerkleStore()).Root()
+	assert.ErrorIs(t, err, ErrUnknownHash)
+
+	leaves := elements(11, 12, 13, 14, 15)
+	var root fr.Element
+	for i := range leaves {
+		root, err = tree.SetLeaf(uint64(i), leaves[i])
+		require.NoError(t, err)
+	}
+	current, err := tree.Root()
+	require.NoError(t, err)
+	assert.Equal(t, root, current)
+
+	for i := range leaves {
+		proof, err := tree.Proof(uint64(i))
+		require.NoError(t, err)
+		assert.Equal(t, leaves[i], proof.Leaf)
+		assert.Equal(t, HashMiMC, proof.Hash)
+		proofRoot, err := proof.Root()
+		require.NoError(t, err)
+		assert.Equal(t, root, proofRoot, "leaf %d", i)
+	}
+
+	t.Run("revoke", func(t *testing.T) {
+		tree := NewMerkleTree(HashMiMC, NewMemoryMerkleStore())
+		before, err := tree.SetLeaf(3, leaves[3])
+		require.NoError(t, err)
+		_, err = tree.SetLeaf(4, leaves[4])
+		require.NoError(t, err)
+		after, err := tree.SetLeaf(4, fr.Element{})
+		require.NoError(t, err)
+		assert.Equal(t, before, after)
+	})
+
+	t.Run("full", func(t *testing.T) {
+		_, err := tree.SetLeaf(1<<MerkleDepth, leaves[0])
+		assert.ErrorIs(t, err, ErrMerkleTreeFull)
+		_, err = tree.Proof(1 << MerkleDepth)
+		assert.ErrorIs(t, err, ErrMerkleTreeFull)
+	})
+}
+
+func TestMerkleMembership(t *testing.T) {
+	for _, h := range []Hash{HashMiMC, HashPoseidon2} {
+		t.Run(string(h), func(t *testing.T) {
+			testMerkleMembership(t, h)
+		})
+	}
+}
+
+// testMerkleMembership checks the native tree built with h against the
+// circuit hashing with h.
+func testMerkleMembership(t *testing.T, h Hash) {
+	privKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	leaf, err := CredentialLeaf(h, &privKey.PublicKey, testSecret)
+	require.NoError(t, err)
+
+	tree := NewMerkleTree(h, NewMemoryMerkleStore())
+	for i, l := range elements(1, 2, 3) {
+		_, err := tree.SetLeaf(uint64(i), l)
+		require.NoError(t, err)
+	}
+	root, err := tree.SetLeaf(5, leaf)
+	require.NoError(t, err)
+	proof, err := tree.Proof(5)
+	require.NoError(t, err)
+
+	assignment := merkleCircuit{
+		PublicKey: P256PublicKey{
+			X: emulated.ValueOf[emulated.P256Fp](privKey.X),
+			Y: emulated.ValueOf[emulated.P256Fp](privKey.Y),
+		},
+		Secret:    testSecret,
+		LeafIndex: proof.Index,
+		Root:      root,
+	}
+	for i := range proof.Path {
+		assignment.Path[i] = proof.Path[i]
+	}
+	circuit := &merkleCircuit{hash: h}
+	assert.NoError(t, test.IsSolved(circuit, &assignment, ecc.BN254.ScalarField()))
+
+	t.Run("tree of another hash", func(t *testing.T) {
+		other := HashPoseidon2
+		if h == other {
+			other = HashMiMC
+		}
+		assert.Error(t, test.IsSolved(&merkleCircuit{hash: other}, &assignment, ecc.BN254.ScalarField()))
+	})
+
+	t.Run("another index", func(t *testing.T) {
+		tampered := assignment
+		tampered.LeafIndex = 4
+		assert.Error(t, test.IsSolved(circuit, &tampered, ecc.BN254.ScalarField()))
+	})
+
+	t.Run("another root", func(t *testing.T) {
+		tampered := assignment
+		tampered.Root, err = tree.SetLeaf(6, leaf)
+		require.NoError(t, err)
+		assert.Error(t, test.IsSolved(circuit, &tampered, ecc.BN254.ScalarField()))
+	})
+
+	t.Run("another key", func(t *testing.T) {
+		other, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		require.NoError(t, err)
+		tampered := assignment
+		tampered.PublicKey = P256PublicKey{
+			X: emulated.ValueOf[emulated.P256Fp](other.X),
+			Y: emulated.ValueOf[emulated.P256Fp](other.Y),
+		}
+		assert.Error(t, test.IsSolved(circuit, &tampered, ecc.BN254.ScalarField()))
+	})
+
+	t.Run("another secret", func(t *testing.T) {
+		tampered := assignment
+		tampered.Secret = 8
+		assert.Error(t, test.IsSolved(circuit, &tampered, ecc.BN254.ScalarField()))
+	})
+
+	t.Run("encoding", func(t *testing.T) {
+		decoded, err := DecodeMerkleRoot(EncodeMerkleRoot(root))
+		require.NoError(t, err)
+		assert.Equal(t, root, decoded)
+		_, err = DecodeMerkleRoot("0xzz")
+		assert.ErrorIs(t, err, ErrInvalidMerkleRoot)
+	})
+}
@@ -0,0 +1,100 @@
+package zk
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	"github.com/consensys/gnark/frontend"
+)
+
+var (
+	// ErrInvalidNullifier is returned for nullifiers that are not the 0x
+	// prefixed hex encoding of a field element.
+	ErrInvalidNullifier = errors.New("zk: invalid nullifier")
+
+	// ErrInvalidCredentialSecret is returned for credential secrets that are
+	// not the 0x prefixed hex encoding of a field element.
+	ErrInvalidCredentialSecret = errors.New("zk: invalid credential secret")
+)
+
+// NewCredentialSecret samples the secret of a credential, which the server
+// draws at registration and keeps. It is committed into the credential's leaf
+// (see CredentialLeaf) and the credential's nullifiers are derived from it.
+func NewCredentialSecret() (fr.Element, error) {
+	var secret fr.Element
+	s, err := rand.Int(rand.Reader, fr.Modulus())
+	if err != nil {
+		return secret, fmt.Errorf("error generating credential secret: %w", err)
+	}
+	secret.SetBigInt(s)
+	return secret, nil
+}
+
+// Nullifier derives the nullifier of an assertion made with the credential
+// whose secret is given, for the intent with the given hash and nonce, with
+// the hash h of the assertion circuit version:
+//
+//	Nullifier = H(Secret, IntentHash, Nonce)
+//
+// The assertion circuit exposes it, so that a verifier can record it and
+// refuse a second proof for the same intent. The secret is private and bound
+// to the credential by its leaf, so that a prover can't pick another
+// nullifier for an intent, while a verifier who knows the public keys can't
+// tell which credential a nullifier belongs to.
+func Nullifier(h Hash, secret fr.Element, intentHash []byte, nonce uint64) (fr.Element, error) {
+	if len(intentHash) != IntentHashLen {
+		return fr.Element{}, fmt.Errorf("%w: expected %d bytes, got %d", ErrInvalidIntentHash, IntentHashLen, len(intentHash))
+	}
+	var i, n fr.Element
+	// the intent hash is reduced into the scalar field, as in the challenge
+	i.SetBytes(intentHash)
+	n.SetUint64(nonce)
+	return h.Sum(secret, i, n)
+}
+
+// assertNullifier constrains the nullifier to be derived with h from the
+// credential secret, the intent hash and the nonce.
+func assertNullifier(api frontend.API, h Hash, nullifier, secret, intentHash, nonce frontend.Variable) error {
+	hasher, err := h.New(api)
+	if err != nil {
+		return err
+	}
+	hasher.Write(secret, intentHash, nonce)
+	api.AssertIsEqual(hasher.Sum(), nullifier)
+	return nil
+}
+
+// EncodeNullifier encodes a nullifier as it appears in the public inputs of
+// a proof.
+func EncodeNullifier(nullifier fr.Element) string {
+	b := nullifier.Bytes()
+	return "0x" + hex.EncodeToString(b[:])
+}
+
+// DecodeNullifier decodes a nullifier encoded by EncodeNullifier.
+func DecodeNullifier(s string) (fr.Element, error) {
+	n, err := decodePublicInput(s)
+	if err != nil {
+		return n, fmt.Errorf("%w: %v", ErrInvalidNullifier, err)
+	}
+	return n, nil
+}
+
+// EncodeCredentialSecret encodes a credential secret as stored by the server.
+func EncodeCredentialSecret(secret fr.Element) string {
+	b := secret.Bytes()
+	return "0x" + hex.EncodeToString(b[:])
+}
+
+// DecodeCredentialSecret decodes a credential secret encoded by
+// EncodeCredentialSecret.
+func DecodeCredentialSecret(s string) (fr.Element, error) {
+	secret, err := decodePublicInput(s)
+	if err != nil {
+		return secret, fmt.Errorf("%w: %v", ErrInvalidCredentialSecret, err)
+	}
+	return secret, nil
+}
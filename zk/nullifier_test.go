@@ -0,0 +1,130 @@
+package zk
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type nullifierCircuit struct {
+	Secret     frontend.Variable
+	IntentHash frontend.Variable
+	Nonce      frontend.Variable
+	Nullifier  frontend.Variable `gnark:",public"`
+
+	hash Hash
+}
+
+func (circuit *nullifierCircuit) Define(api frontend.API) error {
+	return assertNullifier(api, circuit.hash, circuit.Nullifier, circuit.Secret, circuit.IntentHash, circuit.Nonce)
+}
+
+func TestNullifier(t *testing.T) {
+	secret, err := NewCredentialSecret()
+	require.NoError(t, err)
+	intentHash := sha256.Sum256([]byte("transfer 1 SOL"))
+	nullifier, err := Nullifier(HashMiMC, secret, intentHash[:], 1)
+	require.NoError(t, err)
+
+	t.Run("deterministic", func(t *testing.T) {
+		again, err := Nullifier(HashMiMC, secret, intentHash[:], 1)
+		require.NoError(t, err)
+		assert.Equal(t, nullifier, again)
+	})
+
+	t.Run("bound to the secret and the intent", func(t *testing.T) {
+		other, err := Nullifier(HashMiMC, secret, intentHash[:], 2)
+		require.NoError(t, err)
+		assert.NotEqual(t, nullifier, other)
+
+		otherIntent := sha256.Sum256([]byte("transfer 2 SOL"))
+		other, err = Nullifier(HashMiMC, secret, otherIntent[:], 1)
+		require.NoError(t, err)
+		assert.NotEqual(t, nullifier, other)
+
+		otherSecret, err := NewCredentialSecret()
+		require.NoError(t, err)
+		assert.NotEqual(t, secret, otherSecret)
+		other, err = Nullifier(HashMiMC, otherSecret, intentHash[:], 1)
+		require.NoError(t, err)
+		assert.NotEqual(t, nullifier, other)
+	})
+
+	t.Run("not derived from the public key", func(t *testing.T) {
+		// a verifier who knows the public key can't recompute the nullifier:
+		// without the secret, the leaf of the key is not that of the credential
+		privKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		require.NoError(t, err)
+		leaf, err := CredentialLeaf(HashMiMC, &privKey.PublicKey, secret)
+		require.NoError(t, err)
+		for _, guess := range []fr.Element{leaf, {}} {
+			derived, err := Nullifier(HashMiMC, guess, intentHash[:], 1)
+			require.NoError(t, err)
+			assert.NotEqual(t, nullifier, derived)
+		}
+	})
+
+	t.Run("circuit", func(t *testing.T) {
+		var intent fr.Element
+		intent.SetBytes(intentHash[:])
+		nullifiers := make(map[Hash]fr.Element)
+		for _, h := range []Hash{HashMiMC, HashPoseidon2} {
+			var err error
+			nullifiers[h], err = Nullifier(h, secret, intentHash[:], 1)
+			require.NoError(t, err)
+		}
+		assert.NotEqual(t, nullifiers[HashMiMC], nullifiers[HashPoseidon2])
+
+		// the native nullifier matches the circuit's for its hash only
+		for _, h := range []Hash{HashMiMC, HashPoseidon2} {
+			for nullifierHash, nullifier := range nullifiers {
+				assignment := &nullifierCircuit{
+					Secret:     secret,
+					IntentHash: intent,
+					Nonce:      1,
+					Nullifier:  nullifier,
+				}
+				err := test.IsSolved(&nullifierCircuit{hash: h}, assignment, ecc.BN254.ScalarField())
+				if h == nullifierHash {
+					assert.NoError(t, err, "%s circuit, %s nullifier", h, nullifierHash)
+				} else {
+					assert.Error(t, err, "%s circuit, %s nullifier", h, nullifierHash)
+				}
+			}
+		}
+	})
+
+	t.Run("invalid intent hash", func(t *testing.T) {
+		_, err := Nullifier(HashMiMC, secret, intentHash[:16], 1)
+		assert.ErrorIs(t, err, ErrInvalidIntentHash)
+	})
+
+	t.Run("encoding", func(t *testing.T) {
+		encoded := EncodeNullifier(nullifier)
+		assert.Len(t, encoded, 66)
+		decoded, err := DecodeNullifier(encoded)
+		require.NoError(t, err)
+		assert.Equal(t, nullifier, decoded)
+
+		for _, invalid := range []string{"0x01", "0xzz", encoded + "00"} {
+			_, err := DecodeNullifier(invalid)
+			assert.ErrorIs(t, err, ErrInvalidNullifier, invalid)
+		}
+
+		encoded = EncodeCredentialSecret(secret)
+		decoded, err = DecodeCredentialSecret(encoded)
+		require.NoError(t, err)
+		assert.Equal(t, secret, decoded)
+		_, err = DecodeCredentialSecret("0xzz")
+		assert.ErrorIs(t, err, ErrInvalidCredentialSecret)
+	})
+}
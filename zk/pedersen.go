@@ -0,0 +1,289 @@
+package zk
+
+import (
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"sync"
+
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	"github.com/consensys/gnark-crypto/ecc/bn254/twistededwards"
+	tedwards "github.com/consensys/gnark-crypto/ecc/twistededwards"
+	"github.com/consensys/gnark/frontend"
+	stdtwistededwards "github.com/consensys/gnark/std/algebra/native/twistededwards"
+)
+
+const (
+	// limbBits is the size of the limbs committed values are split into. The
+	// limbs are smaller than the order of the curve, so the commitment is
+	// binding for the whole value.
+	limbBits = 128
+
+	// nbPedersenGenerators is the number of value generators, enough for a
+	// 512-bit value such as an ES256 signature.
+	nbPedersenGenerators = 4
+
+	// pedersenDomain separates the derivation of the generators.
+	pedersenDomain = "zk-pass/pedersen/v1"
+)
+
+// ErrInvalidOpening is returned when an opening does not match its commitment.
+var ErrInvalidOpening = errors.New("zk: invalid commitment opening")
+
+// PedersenCommitment is a Pedersen commitment in a circuit, a point of Baby
+// Jubjub, the twisted Edwards curve defined over the BN254 scalar field:
+//
+//	C = m₀·G₀ + … + mₙ·Gₙ + r·H
+//
+// where the mᵢ are the 128-bit limbs of the committed value and r is the
+// blinding factor. The generators are derived by hashing to the curve, so
+// nobody knows their discrete logarithms.
+type PedersenCommitment struct {
+	X, Y frontend.Variable
+}
+
+// AssertPedersenCommitment constrains the commitment to open to the limbs,
+// least significant first, and the blinding factor. The limbs must already be
+// constrained to limbBits bits.
+func AssertPedersenCommitment(api frontend.API, c PedersenCommitment, limbs []frontend.Variable, blinding frontend.Variable) error {
+	if len(limbs) > nbPedersenGenerators {
+		return fmt.Errorf("cannot commit to %d limbs, at most %d", len(limbs), nbPedersenGenerators)
+	}
+	curve, err := stdtwistededwards.NewEdCurve(api, tedwards.BN254)
+	if err != nil {
+		return fmt.Errorf("new twisted edwards curve: %w", err)
+	}
+
+	gens := pedersenGenerators()
+	point := func(p twistededwards.PointAffine) stdtwistededwards.Point {
+		return stdtwistededwards.Point{X: p.X, Y: p.Y}
+	}
+
+	// scalars are multiplied two at a time, padding with the blinding
+	// generator
+	points := []stdtwistededwards.Point{point(gens.h)}
+	scalars := []frontend.Variable{blinding}
+	for i := range limbs {
+		points = append(points, point(gens.g[i]))
+		scalars = append(scalars, limbs[i])
+	}
+	res := stdtwistededwards.Point{X: 0, Y: 1}
+	for i := 0; i < len(points); i += 2 {
+		var p stdtwistededwards.Point
+		if i+1 < len(points) {
+			p = curve.DoubleBaseScalarMul(points[i], points[i+1], scalars[i], scalars[i+1])
+		} else {
+			p = curve.ScalarMul(points[i], scalars[i])
+		}
+		res = curve.Add(res, p)
+	}
+
+	api.AssertIsEqual(res.X, c.X)
+	api.AssertIsEqual(res.Y, c.Y)
+	return nil
+}
+
+// splitLimbs constrains v to limbBits-bit limbs, least significant first.
+func splitLimbs(api frontend.API, v frontend.Variable, nbLimbs int) []frontend.Variable {
+	bits := api.ToBinary(v, nbLimbs*limbBits)
+	limbs := make([]frontend.Variable, nbLimbs)
+	for i := range limbs {
+		limbs[i] = api.FromBinary(bits[i*limbBits : (i+1)*limbBits]...)
+	}
+	return limbs
+}
+
+// Opening is the committed value and the blinding factor of a Pedersen
+// commitment. It must stay with the server: anyone holding it can reveal the
+// committed value.
+type Opening struct {
+	// Limbs are the limbBits-bit limbs of the committed value, least
+	// significant first.
+	Limbs []*big.Int
+
+	// Blinding is the random blinding factor, smaller than the order of the
+	// curve.
+	Blinding *big.Int
+}
+
+// NewOpening splits the value into nbLimbs limbs and samples a fresh blinding
+// factor.
+func NewOpening(value *big.Int, nbLimbs int) (*Opening, error) {
+	return newOpening(rand.Reader, value, nbLimbs)
+}
+
+// newOpening is NewOpening with the blinding factor read from rng.
+func newOpening(rng io.Reader, value *big.Int, nbLimbs int) (*Opening, error) {
+	if nbLimbs > nbPedersenGenerators || value.Sign() < 0 || value.BitLen() > nbLimbs*limbBits {
+		return nil, fmt.Errorf("%w: value does not fit %d limbs", ErrInvalidOpening, nbLimbs)
+	}
+	curve := twistededwards.GetEdwardsCurve()
+	blinding, err := rand.Int(rng, &curve.Order)
+	if err != nil {
+		return nil, fmt.Errorf("error generating blinding factor: %w", err)
+	}
+
+	mask := new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), limbBits), big.NewInt(1))
+	o := &Opening{Blinding: blinding}
+	for i := 0; i < nbLimbs; i++ {
+		limb := new(big.Int).Rsh(value, uint(i*limbBits))
+		o.Limbs = append(o.Limbs, limb.And(limb, mask))
+	}
+	return o, nil
+}
+
+// NewSignatureOpening opens a commitment to the ES256 signature (r, s), as
+// committed by the assertion circuit: r and s are reduced modulo the order of
+// P-256 and committed as s·2²⁵⁶ + r.
+func NewSignatureOpening(r, s *big.Int) (*Opening, error) {
+	return newSignatureOpening(rand.Reader, r, s)
+}
+
+func newSignatureOpening(rng io.Reader, r, s *big.Int) (*Opening, error) {
+	if r.Sign() < 0 || s.Sign() < 0 {
+		return nil, fmt.Errorf("%w: negative signature", ErrInvalidOpening)
+	}
+	n := elliptic.P256().Params().N
+	value := new(big.Int).Mod(s, n)
+	value.Lsh(value, 256).Or(value, new(big.Int).Mod(r, n))
+	return newOpening(rng, value, 4)
+}
+
+// NewChallengeOpening opens a commitment to the challenge, as committed by
+// the challenge and assertion circuits.
+func NewChallengeOpening(challenge fr.Element) (*Opening, error) {
+	return newChallengeOpening(rand.Reader, challenge)
+}
+
+func newChallengeOpening(rng io.Reader, challenge fr.Element) (*Opening, error) {
+	var value big.Int
+	challenge.BigInt(&value)
+	return newOpening(rng, &value, 2)
+}
+
+// Value returns the committed value.
+func (o *Opening) Value() *big.Int {
+	value := new(big.Int)
+	for i := len(o.Limbs) - 1; i >= 0; i-- {
+		value.Lsh(value, limbBits).Add(value, o.Limbs[i])
+	}
+	return value
+}
+
+// Commit computes the commitment.
+func (o *Opening) Commit() twistededwards.PointAffine {
+	gens := pedersenGenerators()
+	var c twistededwards.PointAffine
+	c.ScalarMultiplication(&gens.h, o.Blinding)
+	for i := range o.Limbs {
+		var p twistededwards.PointAffine
+		p.ScalarMultiplication(&gens.g[i], o.Limbs[i])
+		c.Add(&c, &p)
+	}
+	return c
+}
+
+// Verify checks that the opening matches the commitment.
+func (o *Opening) Verify(c twistededwards.PointAffine) error {
+	if len(o.Limbs) > nbPedersenGenerators {
+		return fmt.Errorf("%w: too many limbs", ErrInvalidOpening)
+	}
+	if commitment := o.Commit(); !commitment.Equal(&c) {
+		return ErrInvalidOpening
+	}
+	return nil
+}
+
+// Assignment returns the commitment as assigned to a circuit.
+func (o *Opening) Assignment() PedersenCommitment {
+	c := o.Commit()
+	return PedersenCommitment{X: c.X, Y: c.Y}
+}
+
+// EncodeCommitment returns the hex encoded compressed commitment.
+func EncodeCommitment(c twistededwards.PointAffine) string {
+	b := c.Bytes()
+	return hex.EncodeToString(b[:])
+}
+
+// DecodeCommitment decodes a commitment encoded by EncodeCommitment.
+func DecodeCommitment(s string) (twistededwards.PointAffine, error) {
+	var c twistededwards.PointAffine
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return c, fmt.Errorf("error decoding commitment: %w", err)
+	}
+	if _, err := c.SetBytes(b); err != nil {
+		return c, fmt.Errorf("error decoding commitment: %w", err)
+	}
+	return c, nil
+}
+
+type generators struct {
+	g [nbPedersenGenerators]twistededwards.PointAffine
+	h twistededwards.PointAffine
+}
+
+var (
+	generatorsOnce sync.Once
+	generatorsSet  generators
+)
+
+// pedersenGenerators returns the generators of the commitments: G₀…Gₙ for the
+// value and H for the blinding factor.
+func pedersenGenerators() *generators {
+	generatorsOnce.Do(func() {
+		for i := range generatorsSet.g {
+			generatorsSet.g[i] = hashToCurve(uint32(i))
+		}
+		generatorsSet.h = hashToCurve(nbPedersenGenerators)
+	})
+	return &generatorsSet
+}
+
+// hashToCurve derives a point of the prime order subgroup by try-and-increment:
+// the y coordinate is hashed from the index and a counter until it is on the
+// curve, then the cofactor is cleared.
+func hashToCurve(index uint32) twistededwards.PointAffine {
+	curve := twistededwards.GetEdwardsCurve()
+	var one fr.Element
+	one.SetOne()
+
+	for counter := uint32(0); ; counter++ {
+		var buf [8]byte
+		binary.BigEndian.PutUint32(buf[:4], index)
+		binary.BigEndian.PutUint32(buf[4:], counter)
+		digest := sha256.Sum256(append([]byte(pedersenDomain), buf[:]...))
+
+		// x² = (1 - y²) / (a - d·y²)
+		var p twistededwards.PointAffine
+		p.Y.SetBytes(digest[:])
+		var num, den fr.Element
+		num.Square(&p.Y)
+		den.Mul(&num, &curve.D)
+		num.Sub(&one, &num)
+		den.Sub(&curve.A, &den)
+		if den.IsZero() {
+			continue
+		}
+		p.X.Div(&num, &den)
+		if p.X.Legendre() != 1 {
+			continue
+		}
+		p.X.Sqrt(&p.X)
+
+		var cofactor big.Int
+		curve.Cofactor.BigInt(&cofactor)
+		p.ScalarMultiplication(&p, &cofactor)
+		if p.IsZero() {
+			continue
+		}
+		return p
+	}
+}
@@ -0,0 +1,79 @@
+package zk
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark-crypto/ecc/bn254/twistededwards"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type commitmentCircuit struct {
+	Value      frontend.Variable
+	Blinding   frontend.Variable
+	Commitment PedersenCommitment `gnark:",public"`
+}
+
+func (circuit *commitmentCircuit) Define(api frontend.API) error {
+	return AssertPedersenCommitment(api, circuit.Commitment, splitLimbs(api, circuit.Value, 2), circuit.Blinding)
+}
+
+func TestPedersenCommitment(t *testing.T) {
+	value, _ := new(big.Int).SetString("123456789abcdef0123456789abcdef0123456789abcdef", 16)
+	opening, err := NewOpening(value, 2)
+	require.NoError(t, err)
+	assert.Equal(t, value, opening.Value())
+
+	t.Run("circuit matches native commitment", func(t *testing.T) {
+		assignment := &commitmentCircuit{
+			Value:      value,
+			Blinding:   opening.Blinding,
+			Commitment: opening.Assignment(),
+		}
+		assert.NoError(t, test.IsSolved(&commitmentCircuit{}, assignment, ecc.BN254.ScalarField()))
+
+		assignment.Value = new(big.Int).Add(value, big.NewInt(1))
+		assert.Error(t, test.IsSolved(&commitmentCircuit{}, assignment, ecc.BN254.ScalarField()))
+	})
+
+	t.Run("hiding", func(t *testing.T) {
+		other, err := NewOpening(value, 2)
+		require.NoError(t, err)
+		c1, c2 := opening.Commit(), other.Commit()
+		assert.False(t, c1.Equal(&c2))
+	})
+
+	t.Run("verify opening", func(t *testing.T) {
+		c, err := DecodeCommitment(EncodeCommitment(opening.Commit()))
+		require.NoError(t, err)
+		assert.NoError(t, opening.Verify(c))
+
+		tampered := *opening
+		tampered.Blinding = new(big.Int).Add(opening.Blinding, big.NewInt(1))
+		assert.ErrorIs(t, tampered.Verify(c), ErrInvalidOpening)
+	})
+
+	t.Run("value too large", func(t *testing.T) {
+		_, err := NewOpening(new(big.Int).Lsh(big.NewInt(1), 2*limbBits), 2)
+		assert.ErrorIs(t, err, ErrInvalidOpening)
+	})
+}
+
+func TestPedersenGenerators(t *testing.T) {
+	gens := pedersenGenerators()
+	order := twistededwards.GetEdwardsCurve().Order
+	points := append(gens.g[:], gens.h)
+	for i, p := range points {
+		assert.True(t, p.IsOnCurve(), "generator %d is not on the curve", i)
+		var q twistededwards.PointAffine
+		q.ScalarMultiplication(&p, &order)
+		assert.True(t, q.IsZero(), "generator %d is not in the subgroup", i)
+		for _, other := range points[:i] {
+			assert.False(t, p.Equal(&other), "generator %d is repeated", i)
+		}
+	}
+}
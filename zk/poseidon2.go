@@ -0,0 +1,229 @@
+package zk
+
+import (
+	"math/big"
+	"sync"
+
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	"github.com/consensys/gnark/frontend"
+)
+
+// Poseidon2 parameters for the BN254 scalar field, as in the reference
+// implementation of the Poseidon2 paper: a width 3 permutation with the x⁵
+// S-box, 8 full rounds and 56 partial rounds.
+const (
+	poseidon2Width         = 3
+	poseidon2Rate          = 2
+	poseidon2FullRounds    = 8
+	poseidon2PartialRounds = 56
+)
+
+// poseidon2InternalDiag is the diagonal of the internal matrix minus the
+// identity: the internal layer maps x to (x₀+Σ, x₁+Σ, 2·x₂+Σ).
+var poseidon2InternalDiag = [poseidon2Width]uint64{1, 1, 2}
+
+// poseidon2Constants returns the round constants of the permutation, one per
+// state element in the full rounds and one for the first element in the
+// partial rounds.
+var poseidon2Constants = sync.OnceValue(func() [][]fr.Element {
+	g := newGrainLFSR(fr.Bits, poseidon2Width, poseidon2FullRounds, poseidon2PartialRounds)
+	constants := make([][]fr.Element, poseidon2FullRounds+poseidon2PartialRounds)
+	for r := range constants {
+		n := poseidon2Width
+		if isPartialRound(r) {
+			n = 1
+		}
+		constants[r] = make([]fr.Element, n)
+		for i := range constants[r] {
+			constants[r][i] = g.element()
+		}
+	}
+	return constants
+})
+
+func isPartialRound(r int) bool {
+	return r >= poseidon2FullRounds/2 && r < poseidon2FullRounds/2+poseidon2PartialRounds
+}
+
+// grainLFSR generates the round constants as specified by the Poseidon paper,
+// from the 80-bit state encoding the field and the permutation parameters.
+type grainLFSR struct {
+	state []byte
+	bits  int
+}
+
+func newGrainLFSR(fieldBits, width, fullRounds, partialRounds int) *grainLFSR {
+	g := &grainLFSR{state: make([]byte, 0, 80), bits: fieldBits}
+	for _, f := range []struct{ v, len int }{
+		{1, 2}, // prime field
+		{0, 4}, // x^α S-box
+		{fieldBits, 12},
+		{width, 12},
+		{fullRounds, 10},
+		{partialRounds, 10},
+	} {
+		for i := f.len - 1; i >= 0; i-- {
+			g.state = append(g.state, byte(f.v>>i&1))
+		}
+	}
+	for len(g.state) < 80 {
+		g.state = append(g.state, 1)
+	}
+	for i := 0; i < 160; i++ {
+		g.next()
+	}
+	return g
+}
+
+func (g *grainLFSR) next() byte {
+	s := g.state
+	b := s[62] ^ s[51] ^ s[38] ^ s[23] ^ s[13] ^ s[0]
+	g.state = append(s[1:], b)
+	return b
+}
+
+// bit returns the next output of the self-shrinking generator.
+func (g *grainLFSR) bit() byte {
+	for {
+		if g.next() == 1 {
+			return g.next()
+		}
+		g.next()
+	}
+}
+
+// element samples field elements by rejection.
+func (g *grainLFSR) element() fr.Element {
+	for {
+		v := new(big.Int)
+		for i := 0; i < g.bits; i++ {
+			v.Lsh(v, 1)
+			v.SetBit(v, 0, uint(g.bit()))
+		}
+		if v.Cmp(fr.Modulus()) < 0 {
+			var e fr.Element
+			e.SetBigInt(v)
+			return e
+		}
+	}
+}
+
+// poseidon2Permutation applies the Poseidon2 permutation to the state.
+func poseidon2Permutation(state *[poseidon2Width]fr.Element) {
+	sbox := func(x *fr.Element) {
+		var x4 fr.Element
+		x4.Square(x).Square(&x4)
+		x.Mul(x, &x4)
+	}
+	external := func() {
+		var sum fr.Element
+		sum.Add(&state[0], &state[1]).Add(&sum, &state[2])
+		for i := range state {
+			state[i].Add(&state[i], &sum)
+		}
+	}
+	internal := func() {
+		var sum fr.Element
+		sum.Add(&state[0], &state[1]).Add(&sum, &state[2])
+		for i := range state {
+			var d fr.Element
+			d.SetUint64(poseidon2InternalDiag[i])
+			state[i].Mul(&state[i], &d).Add(&state[i], &sum)
+		}
+	}
+
+	external()
+	for r, rc := range poseidon2Constants() {
+		if isPartialRound(r) {
+			state[0].Add(&state[0], &rc[0])
+			sbox(&state[0])
+			internal()
+			continue
+		}
+		for i := range state {
+			state[i].Add(&state[i], &rc[i])
+			sbox(&state[i])
+		}
+		external()
+	}
+}
+
+// poseidon2Hash hashes field elements with the Poseidon2 sponge: the capacity
+// element starts as the number of elements times 2⁶⁴, the elements are
+// absorbed two at a time, zero padded to at least one block, and the first
+// state element is squeezed.
+func poseidon2Hash(elems ...fr.Element) fr.Element {
+	var state [poseidon2Width]fr.Element
+	state[poseidon2Rate].SetUint64(uint64(len(elems)))
+	state[poseidon2Rate].Mul(&state[poseidon2Rate], new(fr.Element).SetBigInt(new(big.Int).Lsh(big.NewInt(1), 64)))
+	for i := 0; i == 0 || i < len(elems); i += poseidon2Rate {
+		for j := 0; j < poseidon2Rate && i+j < len(elems); j++ {
+			state[j].Add(&state[j], &elems[i+j])
+		}
+		poseidon2Permutation(&state)
+	}
+	return state[0]
+}
+
+// poseidon2Hasher is the in-circuit Poseidon2 sponge, matching poseidon2Hash.
+type poseidon2Hasher struct {
+	api  frontend.API
+	data []frontend.Variable
+}
+
+func newPoseidon2Hasher(api frontend.API) *poseidon2Hasher {
+	return &poseidon2Hasher{api: api}
+}
+
+func (h *poseidon2Hasher) Write(data ...frontend.Variable) {
+	h.data = append(h.data, data...)
+}
+
+func (h *poseidon2Hasher) Reset() {
+	h.data = nil
+}
+
+func (h *poseidon2Hasher) Sum() frontend.Variable {
+	api := h.api
+	state := [poseidon2Width]frontend.Variable{0, 0, new(big.Int).Lsh(big.NewInt(int64(len(h.data))), 64)}
+	for i := 0; i == 0 || i < len(h.data); i += poseidon2Rate {
+		for j := 0; j < poseidon2Rate && i+j < len(h.data); j++ {
+			state[j] = api.Add(state[j], h.data[i+j])
+		}
+		poseidon2Permute(api, &state)
+	}
+	return state[0]
+}
+
+// poseidon2Permute constrains the Poseidon2 permutation of the state.
+func poseidon2Permute(api frontend.API, state *[poseidon2Width]frontend.Variable) {
+	sbox := func(x frontend.Variable) frontend.Variable {
+		x2 := api.Mul(x, x)
+		return api.Mul(x, api.Mul(x2, x2))
+	}
+	external := func() {
+		sum := api.Add(state[0], state[1], state[2])
+		for i := range state {
+			state[i] = api.Add(state[i], sum)
+		}
+	}
+	internal := func() {
+		sum := api.Add(state[0], state[1], state[2])
+		for i := range state {
+			state[i] = api.Add(api.Mul(state[i], poseidon2InternalDiag[i]), sum)
+		}
+	}
+
+	external()
+	for r, rc := range poseidon2Constants() {
+		if isPartialRound(r) {
+			state[0] = sbox(api.Add(state[0], &rc[0]))
+			internal()
+			continue
+		}
+		for i := range state {
+			state[i] = sbox(api.Add(state[i], &rc[i]))
+		}
+		external()
+	}
+}
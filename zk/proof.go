@@ -0,0 +1,165 @@
+package zk
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	"github.com/consensys/gnark/backend/witness"
+	"github.com/consensys/gnark/frontend"
+)
+
+var (
+	// ErrUnknownKey is returned when no loaded verifying key matches the
+	// proof's circuit, backend and key fingerprint.
+	ErrUnknownKey = errors.New("zk: unknown verifying key")
+
+	// ErrMalformedProof is returned when the proof or its public inputs cannot
+	// be decoded for the circuit.
+	ErrMalformedProof = errors.New("zk: malformed proof or public inputs")
+
+	// ErrVerificationFailed is returned when a well-formed proof does not
+	// verify, i.e. the pairing check failed.
+	ErrVerificationFailed = errors.New("zk: proof verification failed")
+)
+
+// Proof is a serialized proof together with everything needed to check it
+// against the published verifying key of its circuit.
+type Proof struct {
+	CircuitID string `json:"circuitId"`
+
+	// Backend is the proving system that produced the proof. Proofs without
+	// a backend are Groth16 proofs.
+	Backend Backend `json:"backend"`
+
+	// Target is the verifier the proof was made for. Proofs without a target
+	// are for gnark's verifier.
+	Target Target `json:"target,omitempty"`
+
+	// Proof is the gnark (compressed) serialization of the proof.
+	Proof []byte `json:"proof"`
+
+	// PublicInputs are the hex encoded public inputs, in circuit order.
+	PublicInputs []string `json:"publicInputs"`
+
+	// VerifyingKeyHash is the SHA-256 fingerprint of the verifying key.
+	VerifyingKeyHash string `json:"verifyingKeyHash"`
+}
+
+// Prove solves the circuit for the given assignment and proves it with the
+// circuit's keys.
+func Prove(keys *Keys, assignment frontend.Circuit) (*Proof, error) {
+	w, err := frontend.NewWitness(assignment, ecc.BN254.ScalarField())
+	if err != nil {
+		return nil, fmt.Errorf("error building witness: %w", err)
+	}
+	return ProveWitness(keys, w)
+}
+
+// ProveWitness proves a full witness of the circuit, e.g. one serialized with
+// MarshalBinary to be proven later.
+func ProveWitness(keys *Keys, w witness.Witness) (*Proof, error) {
+	proof, err := keys.Manifest.Backend.prove(keys.ConstraintSystem, keys.ProvingKey, w, keys.Target)
+	if err != nil {
+		return nil, fmt.Errorf("error proving circuit %s: %w", keys.Manifest.CircuitID, err)
+	}
+
+	public, err := w.Public()
+	if err != nil {
+		return nil, fmt.Errorf("error extracting public witness: %w", err)
+	}
+	publicInputs, err := encodePublicInputs(public)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Proof{
+		CircuitID:        keys.Manifest.CircuitID,
+		Backend:          keys.Manifest.Backend,
+		Target:           keys.Target,
+		Proof:            proof,
+		PublicInputs:     publicInputs,
+		VerifyingKeyHash: keys.Manifest.Fingerprints.VerifyingKey,
+	}, nil
+}
+
+// Verify checks the proof against the circuit's verifying key, with the
+// backend and for the target recorded in the proof. The error wraps ErrUnknownKey,
+// ErrMalformedProof or ErrVerificationFailed.
+func Verify(keys *Keys, p *Proof) error {
+	if p.CircuitID != keys.Manifest.CircuitID {
+		return fmt.Errorf("%w: proof is for circuit %s, not %s", ErrUnknownKey, p.CircuitID, keys.Manifest.CircuitID)
+	}
+	backend, err := ParseBackend(string(p.Backend))
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrMalformedProof, err)
+	}
+	if backend != keys.Manifest.Backend {
+		return fmt.Errorf("%w: proof is a %s proof, keys of %s are for %s", ErrUnknownKey, backend, p.CircuitID, keys.Manifest.Backend)
+	}
+	target, err := ParseTarget(string(p.Target))
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrMalformedProof, err)
+	}
+	if p.VerifyingKeyHash != "" && p.VerifyingKeyHash != keys.Manifest.Fingerprints.VerifyingKey {
+		return fmt.Errorf("%w: verifying key %s of %s is not loaded", ErrUnknownKey, p.VerifyingKeyHash, p.CircuitID)
+	}
+	if len(p.PublicInputs) != keys.Manifest.NbPublic {
+		return fmt.Errorf("%w: got %d public inputs, circuit %s has %d", ErrMalformedProof, len(p.PublicInputs), p.CircuitID, keys.Manifest.NbPublic)
+	}
+	public, err := p.PublicWitness()
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrMalformedProof, err)
+	}
+	return backend.verify(keys.VerifyingKey, p.Proof, public, target)
+}
+
+// PublicWitness decodes the public inputs of the proof.
+func (p *Proof) PublicWitness() (witness.Witness, error) {
+	values := make(chan any, len(p.PublicInputs))
+	for i, input := range p.PublicInputs {
+		e, err := decodePublicInput(input)
+		if err != nil {
+			return nil, fmt.Errorf("error decoding public input %d: %w", i, err)
+		}
+		values <- e
+	}
+	close(values)
+
+	w, err := witness.New(ecc.BN254.ScalarField())
+	if err != nil {
+		return nil, err
+	}
+	if err := w.Fill(len(p.PublicInputs), 0, values); err != nil {
+		return nil, fmt.Errorf("error filling public witness: %w", err)
+	}
+	return w, nil
+}
+
+// encodePublicInputs hex encodes the values of a public witness.
+func encodePublicInputs(public witness.Witness) ([]string, error) {
+	inputs, ok := public.Vector().(fr.Vector)
+	if !ok {
+		return nil, fmt.Errorf("witness is not over %s", ecc.BN254)
+	}
+	publicInputs := make([]string, len(inputs))
+	for i := range inputs {
+		b := inputs[i].Bytes()
+		publicInputs[i] = "0x" + hex.EncodeToString(b[:])
+	}
+	return publicInputs, nil
+}
+
+// decodePublicInput decodes a public input encoded by encodePublicInputs.
+func decodePublicInput(input string) (fr.Element, error) {
+	var e fr.Element
+	b, err := hex.DecodeString(strings.TrimPrefix(input, "0x"))
+	if err != nil {
+		return e, err
+	}
+	err = e.SetBytesCanonical(b)
+	return e, err
+}
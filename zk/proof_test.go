@@ -0,0 +1,103 @@
+package zk
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr/mimc"
+	"github.com/consensys/gnark/frontend"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerify(t *testing.T) {
+	ks := NewKeyStore(t.TempDir())
+	keys, err := ks.Setup(preimageCircuitDefinition)
+	require.NoError(t, err)
+
+	var preImage fr.Element
+	preImage.SetUint64(42)
+	h := mimc.NewMiMC()
+	b := preImage.Bytes()
+	h.Write(b[:])
+	proof, err := Prove(keys, &Circuit{PreImage: preImage, Hash: h.Sum(nil)})
+	require.NoError(t, err)
+	require.NoError(t, ks.Verify(proof))
+
+	tests := []struct {
+		name   string
+		tamper func(p *Proof)
+		err    error
+	}{
+		{
+			name:   "unknown circuit",
+			tamper: func(p *Proof) { p.CircuitID = "unknown" },
+			err:    ErrUnknownKey,
+		},
+		{
+			name:   "other verifying key",
+			tamper: func(p *Proof) { p.VerifyingKeyHash = "00" },
+			err:    ErrUnknownKey,
+		},
+		{
+			name:   "other backend",
+			tamper: func(p *Proof) { p.Backend = BackendPlonk },
+			err:    ErrUnknownKey,
+		},
+		{
+			name:   "missing public input",
+			tamper: func(p *Proof) { p.PublicInputs = nil },
+			err:    ErrMalformedProof,
+		},
+		{
+			name:   "invalid public input",
+			tamper: func(p *Proof) { p.PublicInputs = []string{"0xzz"} },
+			err:    ErrMalformedProof,
+		},
+		{
+			name:   "truncated proof",
+			tamper: func(p *Proof) { p.Proof = p.Proof[:len(p.Proof)/2] },
+			err:    ErrMalformedProof,
+		},
+		{
+			name:   "short public input",
+			tamper: func(p *Proof) { p.PublicInputs = []string{"0x00"} },
+			err:    ErrMalformedProof,
+		},
+		{
+			name:   "pairing check",
+			tamper: func(p *Proof) { p.PublicInputs = []string{fmt.Sprintf("0x%064x", 7)} },
+			err:    ErrVerificationFailed,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tampered := *proof
+			tt.tamper(&tampered)
+			assert.ErrorIs(t, ks.Verify(&tampered), tt.err)
+		})
+	}
+}
+
+// TestVerifyCommitment proves a circuit with a BSB22 commitment, as the
+// assertion circuit's emulated arithmetic has, whose Groth16 verifying key
+// counts an input the verifier derives from the proof.
+func TestVerifyCommitment(t *testing.T) {
+	ks := NewKeyStore(t.TempDir())
+	keys, err := ks.Setup(CircuitDefinition{
+		ID:  "test-commitment",
+		New: func() frontend.Circuit { return &commitCircuit{} },
+	})
+	require.NoError(t, err)
+	// the verifier derives the commitment's input from the proof
+	assert.Equal(t, 1, keys.Manifest.NbPublic)
+
+	proof, err := Prove(keys, &commitCircuit{X: 3, Y: 9})
+	require.NoError(t, err)
+	require.Len(t, proof.PublicInputs, 1)
+	require.NoError(t, Verify(keys, proof))
+
+	proof.PublicInputs = []string{fmt.Sprintf("0x%064x", 4)}
+	assert.ErrorIs(t, Verify(keys, proof), ErrVerificationFailed)
+}
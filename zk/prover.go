@@ -0,0 +1,232 @@
+package zk
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark/backend/witness"
+	"github.com/consensys/gnark/frontend"
+)
+
+var (
+	// ErrProverBusy is returned when the prover's queue is full. The caller
+	// should retry after Prover.RetryAfter.
+	ErrProverBusy = errors.New("zk: prover is busy")
+
+	// ErrProverClosed is returned for jobs submitted to, or left queued in, a
+	// closed prover.
+	ErrProverClosed = errors.New("zk: prover is closed")
+
+	// ErrProverTimeout is returned when a job runs for longer than the
+	// prover's timeout.
+	ErrProverTimeout = errors.New("zk: proving timed out")
+)
+
+// Prover runs proving jobs on a fixed number of workers, so that concurrent
+// logins don't exhaust the CPU and memory of the instance. Jobs wait in a
+// bounded queue until a worker picks them up.
+//
+// A job is abandoned when its context is done or, unless it was submitted with
+// SubmitWait, it times out: the caller returns right away, and a job still
+// queued is dropped before any work is done. gnark can't interrupt a proof
+// once it started, so the worker stays busy until it completes and its result
+// is discarded.
+type Prover struct {
+	queue   chan *proverJob
+	workers int
+	timeout time.Duration
+	stop    chan struct{}
+	wg      sync.WaitGroup
+
+	mu       sync.Mutex
+	closed   bool
+	duration time.Duration // moving average of the job durations
+}
+
+type proverJob struct {
+	ctx     context.Context
+	run     func() error
+	started chan struct{}
+	done    chan error
+}
+
+// NewProver starts a prover with the given number of workers and queue size.
+// Jobs running for longer than timeout are abandoned; a zero timeout lets
+// jobs run until their context is done. The timeout bounds the requests
+// proving inline, and doesn't apply to SubmitWait.
+func NewProver(workers, queueSize int, timeout time.Duration) *Prover {
+	p := &Prover{
+		queue:   make(chan *proverJob, queueSize),
+		workers: max(workers, 1),
+		timeout: timeout,
+		stop:    make(chan struct{}),
+	}
+	for range p.workers {
+		p.wg.Add(1)
+		go p.work()
+	}
+	return p
+}
+
+// Prove solves the circuit for the assignment and proves it with the keys on
+// a worker. It fails with ErrProverBusy without waiting if the queue is full.
+func (p *Prover) Prove(ctx context.Context, keys *Keys, assignment frontend.Circuit) (*Proof, error) {
+	w, err := frontend.NewWitness(assignment, ecc.BN254.ScalarField())
+	if err != nil {
+		return nil, fmt.Errorf("error building witness: %w", err)
+	}
+	return p.ProveWitness(ctx, keys, w)
+}
+
+// ProveWitness proves the full witness with the keys on a worker. It fails
+// with ErrProverBusy without waiting if the queue is full.
+func (p *Prover) ProveWitness(ctx context.Context, keys *Keys, w witness.Witness) (*Proof, error) {
+	var proof *Proof
+	err := p.Submit(ctx, func() (err error) {
+		proof, err = ProveWitness(keys, w)
+		return err
+	})
+	return proof, err
+}
+
+// Submit runs the job on a worker and returns its error. It fails with
+// ErrProverBusy without waiting if the queue is full, with the context's
+// error if it is done first, and with ErrProverTimeout if the job runs for
+// too long.
+func (p *Prover) Submit(ctx context.Context, run func() error) error {
+	job, err := p.enqueue(ctx, run, false)
+	if err != nil {
+		return err
+	}
+	return p.wait(job, p.timeout)
+}
+
+// SubmitWait runs the job as Submit does, but waits for room in the queue
+// instead of failing with ErrProverBusy, and for the job to complete however
+// long it runs. It suits background jobs, whose callers have nothing better to
+// do: giving up on a job that keeps its worker busy would only fail it.
+func (p *Prover) SubmitWait(ctx context.Context, run func() error) error {
+	job, err := p.enqueue(ctx, run, true)
+	if err != nil {
+		return err
+	}
+	return p.wait(job, 0)
+}
+
+// RetryAfter estimates how long until the queue has room again, from the
+// number of queued jobs and the average time a job takes.
+func (p *Prover) RetryAfter() time.Duration {
+	p.mu.Lock()
+	duration := p.duration
+	p.mu.Unlock()
+	retryAfter := duration * time.Duration(len(p.queue)) / time.Duration(p.workers)
+	return max(retryAfter, time.Second)
+}
+
+// Close stops the workers once their current job is done. Queued jobs fail
+// with ErrProverClosed.
+func (p *Prover) Close() {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return
+	}
+	p.closed = true
+	close(p.stop)
+	p.mu.Unlock()
+	p.wg.Wait()
+}
+
+func (p *Prover) enqueue(ctx context.Context, run func() error, block bool) (*proverJob, error) {
+	job := &proverJob{
+		ctx:     ctx,
+		run:     run,
+		started: make(chan struct{}),
+		done:    make(chan error, 1),
+	}
+
+	p.mu.Lock()
+	closed := p.closed
+	p.mu.Unlock()
+	if closed {
+		return nil, ErrProverClosed
+	}
+
+	select {
+	case p.queue <- job:
+		return job, nil
+	default:
+	}
+	if !block {
+		return nil, fmt.Errorf("%w: %d jobs queued", ErrProverBusy, cap(p.queue))
+	}
+	select {
+	case p.queue <- job:
+		return job, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-p.stop:
+		return nil, ErrProverClosed
+	}
+}
+
+// wait waits for the job to complete, until its context is done, it runs for
+// longer than a non-zero timeout or the prover is closed before it started.
+func (p *Prover) wait(job *proverJob, timeout time.Duration) error {
+	select {
+	case <-job.started:
+	case <-job.ctx.Done():
+		return job.ctx.Err()
+	case <-p.stop:
+		return ErrProverClosed
+	}
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	select {
+	case err := <-job.done:
+		return err
+	case <-job.ctx.Done():
+		return job.ctx.Err()
+	case <-expired:
+		return fmt.Errorf("%w after %s", ErrProverTimeout, timeout)
+	}
+}
+
+func (p *Prover) work() {
+	defer p.wg.Done()
+	for {
+		select {
+		case <-p.stop:
+			return
+		case job := <-p.queue:
+			// the caller is gone, don't start the work
+			if job.ctx.Err() != nil {
+				continue
+			}
+			close(job.started)
+			start := time.Now()
+			job.done <- job.run()
+			p.observe(time.Since(start))
+		}
+	}
+}
+
+// observe updates the moving average of the job durations.
+func (p *Prover) observe(d time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.duration == 0 {
+		p.duration = d
+		return
+	}
+	p.duration = (3*p.duration + d) / 4
+}
@@ -0,0 +1,149 @@
+package zk
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProver(t *testing.T) {
+	t.Run("prove", func(t *testing.T) {
+		keys, err := NewKeyStore(t.TempDir()).Setup(preimageCircuitDefinition)
+		require.NoError(t, err)
+		p := NewProver(1, 1, time.Minute)
+		defer p.Close()
+		proof, err := p.Prove(context.Background(), keys, preimageAssignment())
+		require.NoError(t, err)
+		assert.NoError(t, Verify(keys, proof))
+	})
+
+	t.Run("bounded workers", func(t *testing.T) {
+		p := NewProver(2, 8, 0)
+		defer p.Close()
+		var running, peak atomic.Int32
+		errs := make(chan error, 8)
+		for range 8 {
+			go func() {
+				errs <- p.SubmitWait(context.Background(), func() error {
+					n := running.Add(1)
+					defer running.Add(-1)
+					for {
+						old := peak.Load()
+						if n <= old || peak.CompareAndSwap(old, n) {
+							break
+						}
+					}
+					time.Sleep(10 * time.Millisecond)
+					return nil
+				})
+			}()
+		}
+		for range 8 {
+			require.NoError(t, <-errs)
+		}
+		assert.EqualValues(t, 2, peak.Load())
+	})
+
+	t.Run("full queue", func(t *testing.T) {
+		p := NewProver(1, 1, 0)
+		defer p.Close()
+		release := make(chan struct{})
+		started := make(chan struct{})
+		go p.Submit(context.Background(), func() error {
+			close(started)
+			<-release
+			return nil
+		})
+		<-started
+		queued := make(chan error)
+		go func() { queued <- p.Submit(context.Background(), func() error { return nil }) }()
+		require.Eventually(t, func() bool { return len(p.queue) == 1 }, time.Second, time.Millisecond)
+
+		err := p.Submit(context.Background(), func() error { return nil })
+		assert.ErrorIs(t, err, ErrProverBusy)
+		assert.GreaterOrEqual(t, p.RetryAfter(), time.Second)
+
+		close(release)
+		assert.NoError(t, <-queued)
+	})
+
+	t.Run("cancelled while queued", func(t *testing.T) {
+		p := NewProver(1, 1, 0)
+		defer p.Close()
+		release := make(chan struct{})
+		started := make(chan struct{})
+		go p.Submit(context.Background(), func() error {
+			close(started)
+			<-release
+			return nil
+		})
+		<-started
+
+		ctx, cancel := context.WithCancel(context.Background())
+		var ran atomic.Bool
+		errs := make(chan error)
+		go func() {
+			errs <- p.Submit(ctx, func() error {
+				ran.Store(true)
+				return nil
+			})
+		}()
+		require.Eventually(t, func() bool { return len(p.queue) == 1 }, time.Second, time.Millisecond)
+		cancel()
+		assert.ErrorIs(t, <-errs, context.Canceled)
+
+		close(release)
+		require.NoError(t, p.SubmitWait(context.Background(), func() error { return nil }))
+		assert.False(t, ran.Load())
+	})
+
+	t.Run("cancelled while running", func(t *testing.T) {
+		p := NewProver(1, 1, 0)
+		defer p.Close()
+		release := make(chan struct{})
+		defer close(release)
+		ctx, cancel := context.WithCancel(context.Background())
+		errs := make(chan error)
+		go func() {
+			errs <- p.Submit(ctx, func() error {
+				cancel()
+				<-release
+				return nil
+			})
+		}()
+		assert.ErrorIs(t, <-errs, context.Canceled)
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		p := NewProver(1, 1, 10*time.Millisecond)
+		defer p.Close()
+		release := make(chan struct{})
+		defer close(release)
+		err := p.Submit(context.Background(), func() error {
+			<-release
+			return nil
+		})
+		assert.ErrorIs(t, err, ErrProverTimeout)
+	})
+
+	t.Run("background jobs don't time out", func(t *testing.T) {
+		p := NewProver(1, 1, 10*time.Millisecond)
+		defer p.Close()
+		err := p.SubmitWait(context.Background(), func() error {
+			time.Sleep(50 * time.Millisecond)
+			return nil
+		})
+		assert.NoError(t, err)
+	})
+
+	t.Run("closed", func(t *testing.T) {
+		p := NewProver(1, 1, 0)
+		p.Close()
+		err := p.Submit(context.Background(), func() error { return nil })
+		assert.ErrorIs(t, err, ErrProverClosed)
+	})
+}
@@ -0,0 +1,249 @@
+package zk
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// CircuitStatus is the lifecycle stage of a circuit version.
+type CircuitStatus string
+
+const (
+	// StatusActive circuits are used for new proofs.
+	StatusActive CircuitStatus = "active"
+
+	// StatusDeprecated circuits still prove, e.g. jobs queued before a
+	// migration, but are being replaced.
+	StatusDeprecated CircuitStatus = "deprecated"
+
+	// StatusRetired circuits no longer prove. Their proofs still verify.
+	StatusRetired CircuitStatus = "retired"
+)
+
+var (
+	// ErrCircuitRetired is returned when proving with a retired circuit, or
+	// with a circuit whose proving key is not loaded.
+	ErrCircuitRetired = errors.New("zk: circuit is retired")
+
+	// ErrInvalidStatus is returned for unknown circuit statuses.
+	ErrInvalidStatus = errors.New("zk: invalid circuit status")
+)
+
+// ParseCircuitStatus returns the circuit status with the given name.
+func ParseCircuitStatus(name string) (CircuitStatus, error) {
+	switch status := CircuitStatus(strings.ToLower(strings.TrimSpace(name))); status {
+	case StatusActive, StatusDeprecated, StatusRetired:
+		return status, nil
+	}
+	return "", fmt.Errorf("%w: %q", ErrInvalidStatus, name)
+}
+
+// CircuitInfo describes a registered circuit version.
+type CircuitInfo struct {
+	ID       string        `json:"id"`
+	Status   CircuitStatus `json:"status"`
+	Manifest Manifest      `json:"manifest"`
+
+	// CanProve is false for retired circuits and for circuits registered
+	// with their verifying key only.
+	CanProve bool `json:"canProve"`
+}
+
+// Registry maps circuit IDs to their definition, keys and status, so that
+// several versions of a circuit can be loaded side by side: the active
+// version proves new statements while the proofs of older versions still
+// verify against their own keys.
+type Registry struct {
+	keyStore *KeyStore
+	mu       sync.RWMutex
+	circuits map[string]*registeredCircuit
+}
+
+type registeredCircuit struct {
+	def    *CircuitDefinition // nil when only the verifying key is loaded
+	keys   *Keys
+	status CircuitStatus
+}
+
+// NewRegistry returns an empty registry keeping its keys in the key store.
+func NewRegistry(keyStore *KeyStore) *Registry {
+	return &Registry{
+		keyStore: keyStore,
+		circuits: make(map[string]*registeredCircuit),
+	}
+}
+
+// KeyStore returns the key store holding the keys of the registry.
+func (r *Registry) KeyStore() *KeyStore {
+	return r.keyStore
+}
+
+// Register loads the keys of a circuit this build compiles. Active and
+// deprecated circuits run their setup if no keys were written yet. Retired
+// circuits must already have keys.
+func (r *Registry) Register(def CircuitDefinition, status CircuitStatus) (*Keys, error) {
+	if _, err := ParseCircuitStatus(string(status)); err != nil {
+		return nil, err
+	}
+	var keys *Keys
+	var err error
+	if status == StatusRetired {
+		keys, err = r.keyStore.Load(def)
+	} else {
+		keys, err = r.keyStore.LoadOrSetup(def)
+	}
+	if err != nil {
+		return nil, err
+	}
+	r.put(def.ID, &registeredCircuit{def: &def, keys: keys, status: status})
+	return keys, nil
+}
+
+// RegisterVerifyingKey loads the verifying key of a circuit version this
+// build no longer compiles, e.g. the previous version during a migration, so
+// that its proofs still verify. Such circuits can't be active.
+func (r *Registry) RegisterVerifyingKey(circuitID string, status CircuitStatus) (*Keys, error) {
+	if _, err := ParseCircuitStatus(string(status)); err != nil {
+		return nil, err
+	}
+	if status == StatusActive {
+		return nil, fmt.Errorf("%w: circuit %s has no proving key and can't be %s", ErrInvalidStatus, circuitID, status)
+	}
+	keys, err := r.keyStore.LoadVerifyingKey(circuitID)
+	if err != nil {
+		return nil, err
+	}
+	r.put(circuitID, &registeredCircuit{keys: keys, status: status})
+	return keys, nil
+}
+
+func (r *Registry) put(circuitID string, c *registeredCircuit) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.circuits[circuitID] = c
+}
+
+// SetStatus changes the status of a registered circuit.
+func (r *Registry) SetStatus(circuitID string, status CircuitStatus) error {
+	if _, err := ParseCircuitStatus(string(status)); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	c, ok := r.circuits[circuitID]
+	if !ok {
+		return fmt.Errorf("%w: circuit %s is not registered", ErrUnknownKey, circuitID)
+	}
+	if c.def == nil && status == StatusActive {
+		return fmt.Errorf("%w: circuit %s has no proving key and can't be %s", ErrInvalidStatus, circuitID, status)
+	}
+	c.status = status
+	return nil
+}
+
+// Get returns the keys of a registered circuit, whatever its status.
+func (r *Registry) Get(circuitID string) (*Keys, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	c, ok := r.circuits[circuitID]
+	if !ok {
+		return nil, false
+	}
+	return c.keys, true
+}
+
+// Circuit describes a registered circuit.
+func (r *Registry) Circuit(circuitID string) (CircuitInfo, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	c, ok := r.circuits[circuitID]
+	if !ok {
+		return CircuitInfo{}, false
+	}
+	return c.info(circuitID), true
+}
+
+// Circuits describes every registered circuit, ordered by ID.
+func (r *Registry) Circuits() []CircuitInfo {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	infos := make([]CircuitInfo, 0, len(r.circuits))
+	for id, c := range r.circuits {
+		infos = append(infos, c.info(id))
+	}
+	slices.SortFunc(infos, func(a, b CircuitInfo) int { return strings.Compare(a.ID, b.ID) })
+	return infos
+}
+
+// Provable returns the IDs of the circuits that can prove, ordered by ID.
+func (r *Registry) Provable() []string {
+	var ids []string
+	for _, info := range r.Circuits() {
+		if info.CanProve {
+			ids = append(ids, info.ID)
+		}
+	}
+	return ids
+}
+
+func (c *registeredCircuit) info(circuitID string) CircuitInfo {
+	return CircuitInfo{
+		ID:       circuitID,
+		Status:   c.status,
+		Manifest: c.keys.Manifest,
+		CanProve: c.def != nil && c.status != StatusRetired,
+	}
+}
+
+// ProvingKeys returns the keys to prove with the circuit. It returns
+// ErrUnknownKey for circuits that are not registered and ErrCircuitRetired
+// for circuits that can't prove.
+func (r *Registry) ProvingKeys(circuitID string) (*Keys, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	c, ok := r.circuits[circuitID]
+	if !ok {
+		return nil, fmt.Errorf("%w: circuit %s is not registered", ErrUnknownKey, circuitID)
+	}
+	if c.status == StatusRetired {
+		return nil, fmt.Errorf("%w: %s", ErrCircuitRetired, circuitID)
+	}
+	if c.def == nil {
+		return nil, fmt.Errorf("%w: only the verifying key of %s is loaded", ErrCircuitRetired, circuitID)
+	}
+	return c.keys, nil
+}
+
+// Verify checks a proof against the keys of its circuit, whatever its status.
+// The error wraps ErrUnknownKey, ErrMalformedProof or ErrVerificationFailed.
+func (r *Registry) Verify(p *Proof) error {
+	keys, ok := r.Get(p.CircuitID)
+	if !ok {
+		return fmt.Errorf("%w: no keys loaded for circuit %s", ErrUnknownKey, p.CircuitID)
+	}
+	return Verify(keys, p)
+}
+
+// ParseCircuitStatuses parses a comma separated list of circuit statuses,
+// e.g. "webauthn-p256-assertion-v2=deprecated,challenge-mimc-v1=retired".
+func ParseCircuitStatuses(list string) (map[string]CircuitStatus, error) {
+	statuses := make(map[string]CircuitStatus)
+	for _, entry := range strings.Split(list, ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		id, name, ok := strings.Cut(entry, "=")
+		if !ok || strings.TrimSpace(id) == "" {
+			return nil, fmt.Errorf("%w: expected <circuit id>=<status>, got %q", ErrInvalidStatus, entry)
+		}
+		status, err := ParseCircuitStatus(name)
+		if err != nil {
+			return nil, err
+		}
+		statuses[strings.TrimSpace(id)] = status
+	}
+	return statuses, nil
+}
@@ -0,0 +1,97 @@
+package zk
+
+import (
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr/mimc"
+	"github.com/consensys/gnark/frontend"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var doublePreimageCircuitDefinition = CircuitDefinition{
+	ID:  "test-double-preimage",
+	New: func() frontend.Circuit { return &doublePreimageCircuit{} },
+}
+
+func TestRegistry(t *testing.T) {
+	dir := t.TempDir()
+
+	// the previous build proves with the first version of the circuit
+	previous := NewRegistry(NewKeyStore(dir))
+	keys, err := previous.Register(preimageCircuitDefinition, StatusActive)
+	require.NoError(t, err)
+	var preImage fr.Element
+	preImage.SetUint64(42)
+	h := mimc.NewMiMC()
+	b := preImage.Bytes()
+	h.Write(b[:])
+	oldProof, err := Prove(keys, &Circuit{PreImage: preImage, Hash: h.Sum(nil)})
+	require.NoError(t, err)
+
+	// the next build only compiles the second version, and keeps verifying
+	// the proofs of the first
+	registry := NewRegistry(NewKeyStore(dir))
+	keys, err = registry.Register(doublePreimageCircuitDefinition, StatusActive)
+	require.NoError(t, err)
+	_, err = registry.RegisterVerifyingKey(preimageCircuitDefinition.ID, StatusDeprecated)
+	require.NoError(t, err)
+	newProof, err := Prove(keys, &doublePreimageCircuit{PreImage: 3, Hash: 6})
+	require.NoError(t, err)
+
+	assert.NoError(t, registry.Verify(oldProof))
+	assert.NoError(t, registry.Verify(newProof))
+	assert.Equal(t, []string{doublePreimageCircuitDefinition.ID}, registry.Provable())
+	infos := registry.Circuits()
+	require.Len(t, infos, 2)
+	assert.Equal(t, doublePreimageCircuitDefinition.ID, infos[0].ID)
+	assert.Equal(t, StatusDeprecated, infos[1].Status)
+	assert.False(t, infos[1].CanProve)
+
+	t.Run("verifying key only", func(t *testing.T) {
+		_, err := registry.ProvingKeys(preimageCircuitDefinition.ID)
+		assert.ErrorIs(t, err, ErrCircuitRetired)
+		assert.ErrorIs(t, registry.SetStatus(preimageCircuitDefinition.ID, StatusActive), ErrInvalidStatus)
+		_, err = registry.RegisterVerifyingKey(preimageCircuitDefinition.ID, StatusActive)
+		assert.ErrorIs(t, err, ErrInvalidStatus)
+	})
+
+	t.Run("retired circuit", func(t *testing.T) {
+		require.NoError(t, registry.SetStatus(doublePreimageCircuitDefinition.ID, StatusRetired))
+		_, err := registry.ProvingKeys(doublePreimageCircuitDefinition.ID)
+		assert.ErrorIs(t, err, ErrCircuitRetired)
+		assert.NoError(t, registry.Verify(newProof))
+
+		require.NoError(t, registry.SetStatus(doublePreimageCircuitDefinition.ID, StatusActive))
+		_, err = registry.ProvingKeys(doublePreimageCircuitDefinition.ID)
+		assert.NoError(t, err)
+	})
+
+	t.Run("retired circuits are not set up", func(t *testing.T) {
+		_, err := NewRegistry(NewKeyStore(t.TempDir())).Register(preimageCircuitDefinition, StatusRetired)
+		assert.ErrorIs(t, err, ErrKeysNotFound)
+	})
+
+	t.Run("unknown circuit", func(t *testing.T) {
+		_, err := registry.ProvingKeys("unknown")
+		assert.ErrorIs(t, err, ErrUnknownKey)
+		unknown := *newProof
+		unknown.CircuitID = "unknown"
+		assert.ErrorIs(t, registry.Verify(&unknown), ErrUnknownKey)
+	})
+}
+
+func TestParseCircuitStatuses(t *testing.T) {
+	statuses, err := ParseCircuitStatuses(" webauthn-p256-assertion-v2=Deprecated, challenge-mimc-v1=retired,")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]CircuitStatus{
+		"webauthn-p256-assertion-v2": StatusDeprecated,
+		"challenge-mimc-v1":          StatusRetired,
+	}, statuses)
+
+	for _, list := range []string{"webauthn-p256-assertion-v2", "=active", "challenge-mimc-v1=gone"} {
+		_, err := ParseCircuitStatuses(list)
+		assert.ErrorIs(t, err, ErrInvalidStatus, list)
+	}
+}
@@ -0,0 +1,104 @@
+package zk
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	"github.com/go-webauthn/webauthn/protocol/webauthncbor"
+	"github.com/go-webauthn/webauthn/protocol/webauthncose"
+)
+
+// sampleOrigin is the origin of the sample assertions.
+const sampleOrigin = "https://localhost"
+
+// sampleChallenge derives a challenge for a random intent hash with h.
+func sampleChallenge(h Hash) (*ChallengeCircuit, error) {
+	intentHash := make([]byte, IntentHashLen)
+	if _, err := rand.Read(intentHash); err != nil {
+		return nil, err
+	}
+	challenge, err := NewChallenge(h, intentHash, 1)
+	if err != nil {
+		return nil, err
+	}
+	return challenge.Assignment(), nil
+}
+
+// sampleAssertion signs an assertion of a fresh challenge derived with h with
+// a fresh credential, as an authenticator would.
+func sampleAssertion(h Hash) (*AssertionCircuit, error) {
+	_, assignment, err := signSampleAssertion(h)
+	return assignment, err
+}
+
+// sampleMembership signs an assertion with a fresh credential, the only leaf
+// of a tree built with h.
+func sampleMembership(h Hash) (*MembershipCircuit, error) {
+	credentialPublicKey, assertion, err := signSampleAssertion(h)
+	if err != nil {
+		return nil, err
+	}
+	leaf, ok := assertion.CredentialLeaf.(fr.Element)
+	if !ok {
+		return nil, fmt.Errorf("credential leaf is not a field element")
+	}
+	tree := NewMerkleTree(h, NewMemoryMerkleStore())
+	if _, err := tree.SetLeaf(0, leaf); err != nil {
+		return nil, err
+	}
+	proof, err := tree.Proof(0)
+	if err != nil {
+		return nil, err
+	}
+	return NewMembershipAssignment(credentialPublicKey, assertion, proof)
+}
+
+// signSampleAssertion signs an assertion of a fresh challenge derived with h
+// with a fresh credential and returns the COSE encoded credential public key
+// with the assignment of the assertion circuit hashing with h.
+func signSampleAssertion(h Hash) ([]byte, *AssertionCircuit, error) {
+	privKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	credentialPublicKey, err := webauthncbor.Marshal(webauthncose.EC2PublicKeyData{
+		PublicKeyData: webauthncose.PublicKeyData{
+			KeyType:   int64(webauthncose.EllipticKey),
+			Algorithm: int64(webauthncose.AlgES256),
+		},
+		Curve:  int64(webauthncose.P256),
+		XCoord: privKey.X.FillBytes(make([]byte, 32)),
+		YCoord: privKey.Y.FillBytes(make([]byte, 32)),
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	authenticatorData := make([]byte, AuthenticatorDataLen)
+	if _, err := rand.Read(authenticatorData); err != nil {
+		return nil, nil, err
+	}
+	challenge, err := NewChallenge(h, make([]byte, IntentHashLen), 1)
+	if err != nil {
+		return nil, nil, err
+	}
+	clientDataJSON := []byte(fmt.Sprintf(`{"type":"webauthn.get","challenge":"%s","origin":"%s","crossOrigin":false}`,
+		base64.RawURLEncoding.EncodeToString(challenge.Bytes()), sampleOrigin))
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	msg := sha256.Sum256(append(authenticatorData, clientDataHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, privKey, msg[:])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	secret, err := NewCredentialSecret()
+	if err != nil {
+		return nil, nil, err
+	}
+	assignment, _, err := NewAssertionAssignment(h, credentialPublicKey, secret, authenticatorData, clientDataJSON, signature, challenge.Opening, []string{sampleOrigin}, make([]byte, IntentHashLen), 1)
+	return credentialPublicKey, assignment, err
+}
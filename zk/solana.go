@@ -0,0 +1,291 @@
+package zk
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"text/template"
+
+	curve "github.com/consensys/gnark-crypto/ecc/bn254"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr/hash_to_field"
+	"github.com/consensys/gnark/backend/groth16"
+	groth16bn254 "github.com/consensys/gnark/backend/groth16/bn254"
+	"github.com/consensys/gnark/backend/witness"
+	"github.com/consensys/gnark/constraint"
+)
+
+// Sizes of the encodings expected by the alt_bn128 syscalls.
+const (
+	SolanaG1Size     = 64
+	SolanaG2Size     = 128
+	SolanaScalarSize = 32
+)
+
+// ErrUnsupportedCurve is returned when exporting proofs or keys not over BN254.
+var ErrUnsupportedCurve = errors.New("zk: only BN254 Groth16 proofs can be exported")
+
+// SolanaProof is a Groth16 proof in the layout of groth16-solana style
+// verifiers: A is negated so the verifier can run a single pairing check, and
+// every coordinate is a big-endian field element.
+type SolanaProof struct {
+	A [SolanaG1Size]byte
+	B [SolanaG2Size]byte
+	C [SolanaG1Size]byte
+
+	// Commitments and CommitmentPok are only set for circuits using BSB22
+	// commitments, e.g. those with emulated arithmetic. Plain groth16-solana
+	// verifiers cannot check these proofs; see SolanaVerifyingKey.
+	Commitments   [][SolanaG1Size]byte
+	CommitmentPok [SolanaG1Size]byte
+}
+
+// NewSolanaProof encodes a BN254 Groth16 proof for a Solana verifier.
+func NewSolanaProof(proof groth16.Proof) (*SolanaProof, error) {
+	p, ok := proof.(*groth16bn254.Proof)
+	if !ok {
+		return nil, ErrUnsupportedCurve
+	}
+
+	var negA curve.G1Affine
+	negA.Neg(&p.Ar)
+
+	sp := &SolanaProof{
+		A: encodeG1(&negA),
+		B: encodeG2(&p.Bs),
+		C: encodeG1(&p.Krs),
+	}
+	if len(p.Commitments) > 0 {
+		sp.Commitments = make([][SolanaG1Size]byte, len(p.Commitments))
+		for i := range p.Commitments {
+			sp.Commitments[i] = encodeG1(&p.Commitments[i])
+		}
+		sp.CommitmentPok = encodeG1(&p.CommitmentPok)
+	}
+	return sp, nil
+}
+
+// Bytes returns A || B || C, followed by the commitments and their proof of
+// knowledge if the proof has any.
+func (p *SolanaProof) Bytes() []byte {
+	var buf bytes.Buffer
+	buf.Write(p.A[:])
+	buf.Write(p.B[:])
+	buf.Write(p.C[:])
+	if len(p.Commitments) > 0 {
+		for i := range p.Commitments {
+			buf.Write(p.Commitments[i][:])
+		}
+		buf.Write(p.CommitmentPok[:])
+	}
+	return buf.Bytes()
+}
+
+// SolanaPublicInputs encodes the public witness as big-endian 32 byte field
+// elements. For circuits with BSB22 commitments, the public inputs derived
+// from each commitment are appended, so the result always has one entry per
+// element of the verifying key's IC after the first.
+func SolanaPublicInputs(vk VerifyingKey, proof groth16.Proof, publicWitness witness.Witness) ([][SolanaScalarSize]byte, error) {
+	v, ok := vk.(*groth16bn254.VerifyingKey)
+	if !ok {
+		return nil, ErrUnsupportedCurve
+	}
+	p, ok := proof.(*groth16bn254.Proof)
+	if !ok {
+		return nil, ErrUnsupportedCurve
+	}
+	public, ok := publicWitness.Vector().(fr.Vector)
+	if !ok {
+		return nil, ErrUnsupportedCurve
+	}
+	if len(p.Commitments) != len(v.PublicAndCommitmentCommitted) {
+		return nil, fmt.Errorf("proof has %d commitments, verifying key expects %d", len(p.Commitments), len(v.PublicAndCommitmentCommitted))
+	}
+
+	inputs := make([]fr.Element, len(public), len(public)+len(p.Commitments))
+	copy(inputs, public)
+	for i := range v.PublicAndCommitmentCommitted {
+		for _, j := range v.PublicAndCommitmentCommitted[i] {
+			if j < 1 || j > len(public) {
+				return nil, fmt.Errorf("commitment %d refers to public input %d out of %d", i, j, len(public))
+			}
+		}
+		inputs = append(inputs, commitmentChallenge(&p.Commitments[i], public, v.PublicAndCommitmentCommitted[i]))
+	}
+	if len(inputs) != len(v.G1.K)-1 {
+		return nil, fmt.Errorf("got %d public inputs, verifying key expects %d", len(inputs), len(v.G1.K)-1)
+	}
+
+	res := make([][SolanaScalarSize]byte, len(inputs))
+	for i := range inputs {
+		res[i] = inputs[i].Bytes()
+	}
+	return res, nil
+}
+
+// commitmentChallenge derives the public input bound to a BSB22 commitment,
+// as done by the gnark verifier: the hash to field of the uncompressed
+// commitment and the public inputs it commits to.
+func commitmentChallenge(commitment *curve.G1Affine, public fr.Vector, committed []int) fr.Element {
+	h := hash_to_field.New([]byte(constraint.CommitmentDst))
+	h.Write(commitment.Marshal())
+	for _, j := range committed {
+		b := public[j-1].Bytes()
+		h.Write(b[:])
+	}
+	var res fr.Element
+	res.SetBytes(h.Sum(nil)[:fr.Bytes])
+	return res
+}
+
+// SolanaCommitmentKey is the verifying key of a BSB22 commitment.
+type SolanaCommitmentKey struct {
+	G         [SolanaG2Size]byte
+	GSigmaNeg [SolanaG2Size]byte
+}
+
+// SolanaVerifyingKey is a Groth16 verifying key in the layout of
+// groth16-solana's Groth16Verifyingkey.
+type SolanaVerifyingKey struct {
+	Alpha [SolanaG1Size]byte
+	Beta  [SolanaG2Size]byte
+	Gamma [SolanaG2Size]byte
+	Delta [SolanaG2Size]byte
+	IC    [][SolanaG1Size]byte
+
+	// CommitmentKeys and PublicAndCommitmentCommitted are only set for
+	// circuits with BSB22 commitments. Their verifiers must, in addition to
+	// the groth16-solana pairing check:
+	//   - derive the commitment public inputs (see SolanaPublicInputs),
+	//   - add the commitments to the prepared public inputs,
+	//   - check e(commitment, GSigmaNeg) * e(pok, G) == 1.
+	CommitmentKeys               []SolanaCommitmentKey
+	PublicAndCommitmentCommitted [][]int
+}
+
+// NewSolanaVerifyingKey encodes a BN254 Groth16 verifying key for a Solana verifier.
+func NewSolanaVerifyingKey(vk VerifyingKey) (*SolanaVerifyingKey, error) {
+	v, ok := vk.(*groth16bn254.VerifyingKey)
+	if !ok {
+		return nil, ErrUnsupportedCurve
+	}
+
+	svk := &SolanaVerifyingKey{
+		Alpha:                        encodeG1(&v.G1.Alpha),
+		Beta:                         encodeG2(&v.G2.Beta),
+		Gamma:                        encodeG2(&v.G2.Gamma),
+		Delta:                        encodeG2(&v.G2.Delta),
+		IC:                           make([][SolanaG1Size]byte, len(v.G1.K)),
+		PublicAndCommitmentCommitted: v.PublicAndCommitmentCommitted,
+	}
+	for i := range v.G1.K {
+		svk.IC[i] = encodeG1(&v.G1.K[i])
+	}
+	for i := range v.CommitmentKeys {
+		svk.CommitmentKeys = append(svk.CommitmentKeys, SolanaCommitmentKey{
+			G:         encodeG2(&v.CommitmentKeys[i].G),
+			GSigmaNeg: encodeG2(&v.CommitmentKeys[i].GSigma),
+		})
+	}
+	return svk, nil
+}
+
+// NbPublicInputs returns the number of public inputs the verifier expects,
+// including those derived from commitments.
+func (vk *SolanaVerifyingKey) NbPublicInputs() int {
+	return len(vk.IC) - 1
+}
+
+var rustVerifyingKeyTemplate = template.Must(template.New("vk").Funcs(template.FuncMap{
+	"bytes": func(v any) string {
+		var b []byte
+		switch v := v.(type) {
+		case [SolanaG1Size]byte:
+			b = v[:]
+		case [SolanaG2Size]byte:
+			b = v[:]
+		}
+		s := make([]string, len(b))
+		for i := range b {
+			s[i] = fmt.Sprintf("%d", b[i])
+		}
+		return strings.Join(s, ", ")
+	},
+	"ints": func(v []int) string {
+		s := make([]string, len(v))
+		for i := range v {
+			s[i] = fmt.Sprintf("%d", v[i])
+		}
+		return strings.Join(s, ", ")
+	},
+}).Parse(`// Code generated by zk-pass. DO NOT EDIT.
+// Groth16 verifying key of circuit {{ .CircuitID }}.
+
+use groth16_solana::groth16::Groth16Verifyingkey;
+
+pub const {{ .Name }}: Groth16Verifyingkey = Groth16Verifyingkey {
+    nr_pubinputs: {{ .Key.NbPublicInputs }},
+    vk_alpha_g1: [{{ bytes .Key.Alpha }}],
+    vk_beta_g2: [{{ bytes .Key.Beta }}],
+    vk_gamme_g2: [{{ bytes .Key.Gamma }}],
+    vk_delta_g2: [{{ bytes .Key.Delta }}],
+    vk_ic: &[
+{{- range .Key.IC }}
+        [{{ bytes . }}],
+{{- end }}
+    ],
+};
+{{- if .Key.CommitmentKeys }}
+
+/// BSB22 commitment keys (G, -σG) of the circuit. Proofs carry one commitment
+/// per key followed by a proof of knowledge, which the verifier must check
+/// with e(commitment, -σG) * e(pok, G) == 1. The commitments are added to the
+/// prepared public inputs.
+pub const {{ .Name }}_COMMITMENT_KEYS: [[[u8; 128]; 2]; {{ len .Key.CommitmentKeys }}] = [
+{{- range .Key.CommitmentKeys }}
+    [
+        [{{ bytes .G }}],
+        [{{ bytes .GSigmaNeg }}],
+    ],
+{{- end }}
+];
+
+/// Indexes (starting at 1) of the public inputs hashed with each commitment
+/// to derive the trailing public inputs.
+pub const {{ .Name }}_PUBLIC_AND_COMMITMENT_COMMITTED: [&[usize]; {{ len .Key.PublicAndCommitmentCommitted }}] = [
+{{- range .Key.PublicAndCommitmentCommitted }}
+    &[{{ ints . }}],
+{{- end }}
+];
+{{- end }}
+`))
+
+// WriteRust writes the verifying key as a Rust constants file for
+// groth16-solana, declaring the constant name.
+func (vk *SolanaVerifyingKey) WriteRust(w io.Writer, circuitID, name string) error {
+	return rustVerifyingKeyTemplate.Execute(w, struct {
+		CircuitID string
+		Name      string
+		Key       *SolanaVerifyingKey
+	}{circuitID, name, vk})
+}
+
+func encodeG1(p *curve.G1Affine) (res [SolanaG1Size]byte) {
+	x, y := p.X.Bytes(), p.Y.Bytes()
+	copy(res[:32], x[:])
+	copy(res[32:], y[:])
+	return res
+}
+
+// encodeG2 follows EIP-197: the imaginary part of each coordinate comes first.
+func encodeG2(p *curve.G2Affine) (res [SolanaG2Size]byte) {
+	xA1, xA0 := p.X.A1.Bytes(), p.X.A0.Bytes()
+	yA1, yA0 := p.Y.A1.Bytes(), p.Y.A0.Bytes()
+	copy(res[:32], xA1[:])
+	copy(res[32:64], xA0[:])
+	copy(res[64:96], yA1[:])
+	copy(res[96:], yA0[:])
+	return res
+}
@@ -0,0 +1,209 @@
+package zk
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	curve "github.com/consensys/gnark-crypto/ecc/bn254"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr/mimc"
+	"github.com/consensys/gnark/backend/groth16"
+	groth16bn254 "github.com/consensys/gnark/backend/groth16/bn254"
+	"github.com/consensys/gnark/backend/witness"
+	"github.com/consensys/gnark/frontend"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// commitCircuit uses a BSB22 commitment over a public and a private input.
+type commitCircuit struct {
+	X frontend.Variable
+	Y frontend.Variable `gnark:",public"`
+}
+
+func (c *commitCircuit) Define(api frontend.API) error {
+	commitment, err := api.(frontend.Committer).Commit(c.X, c.Y)
+	if err != nil {
+		return err
+	}
+	api.AssertIsDifferent(commitment, 0)
+	api.AssertIsEqual(api.Mul(c.X, c.X), c.Y)
+	return nil
+}
+
+func TestSolanaExport(t *testing.T) {
+	var preImage fr.Element
+	preImage.SetUint64(42)
+	b := preImage.Bytes()
+	h := mimc.NewMiMC()
+	h.Write(b[:])
+
+	tests := []struct {
+		name        string
+		def         CircuitDefinition
+		assignment  frontend.Circuit
+		commitments int
+	}{
+		{
+			name:       "without commitments",
+			def:        preimageCircuitDefinition,
+			assignment: &Circuit{PreImage: preImage, Hash: h.Sum(nil)},
+		},
+		{
+			name: "with commitments",
+			def: CircuitDefinition{
+				ID:  "test-commit",
+				New: func() frontend.Circuit { return &commitCircuit{} },
+			},
+			assignment:  &commitCircuit{X: 3, Y: 9},
+			commitments: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keys, err := NewKeyStore(t.TempDir()).Setup(tt.def)
+			require.NoError(t, err)
+			w, err := frontend.NewWitness(tt.assignment, ecc.BN254.ScalarField())
+			require.NoError(t, err)
+			public, err := w.Public()
+			require.NoError(t, err)
+			proof, err := groth16.Prove(keys.ConstraintSystem, keys.ProvingKey.(groth16.ProvingKey), w)
+			require.NoError(t, err)
+			require.NoError(t, groth16.Verify(proof, keys.VerifyingKey.(groth16.VerifyingKey), public))
+
+			sp, err := NewSolanaProof(proof)
+			require.NoError(t, err)
+			assert.Len(t, sp.Commitments, tt.commitments)
+			size := 2*SolanaG1Size + SolanaG2Size
+			if tt.commitments > 0 {
+				size += SolanaG1Size * (tt.commitments + 1)
+			}
+			assert.Len(t, sp.Bytes(), size)
+			inputs, err := SolanaPublicInputs(keys.VerifyingKey, proof, public)
+			require.NoError(t, err)
+			svk, err := NewSolanaVerifyingKey(keys.VerifyingKey)
+			require.NoError(t, err)
+			assert.Equal(t, svk.NbPublicInputs(), len(inputs))
+
+			t.Run("decoded proof verifies with gnark", func(t *testing.T) {
+				assert.NoError(t, groth16.Verify(decodeSolanaProof(t, sp), keys.VerifyingKey.(groth16.VerifyingKey), public))
+			})
+
+			t.Run("exported bytes pass the alt_bn128 pairing check", func(t *testing.T) {
+				assert.True(t, verifySolana(t, svk, sp, inputs))
+			})
+
+			t.Run("tampered input fails both verifiers", func(t *testing.T) {
+				tampered := append([][SolanaScalarSize]byte{}, inputs...)
+				tampered[0][SolanaScalarSize-1] ^= 1
+				assert.False(t, verifySolana(t, svk, sp, tampered))
+
+				var e fr.Element
+				require.NoError(t, e.SetBytesCanonical(tampered[0][:]))
+				vector := append(fr.Vector{}, public.Vector().(fr.Vector)...)
+				vector[0] = e
+				tamperedPublic, err := witness.New(ecc.BN254.ScalarField())
+				require.NoError(t, err)
+				values := make(chan any, len(vector))
+				for i := range vector {
+					values <- vector[i]
+				}
+				close(values)
+				require.NoError(t, tamperedPublic.Fill(len(vector), 0, values))
+				assert.Error(t, groth16.Verify(proof, keys.VerifyingKey.(groth16.VerifyingKey), tamperedPublic))
+			})
+
+			t.Run("rust verifying key", func(t *testing.T) {
+				var buf bytes.Buffer
+				require.NoError(t, svk.WriteRust(&buf, tt.def.ID, "VERIFYINGKEY"))
+				rust := buf.String()
+				assert.Contains(t, rust, "pub const VERIFYINGKEY: Groth16Verifyingkey")
+				assert.Contains(t, rust, fmt.Sprintf("nr_pubinputs: %d,", len(inputs)))
+				assert.Contains(t, rust, fmt.Sprintf("vk_alpha_g1: [%d, %d,", svk.Alpha[0], svk.Alpha[1]))
+				if tt.commitments > 0 {
+					assert.Contains(t, rust, "pub const VERIFYINGKEY_COMMITMENT_KEYS: [[[u8; 128]; 2]; 1]")
+				} else {
+					assert.NotContains(t, rust, "COMMITMENT_KEYS")
+				}
+			})
+		})
+	}
+}
+
+// verifySolana checks the exported proof the way a groth16-solana verifier
+// does with the alt_bn128 syscalls, extended with the BSB22 commitment checks.
+func verifySolana(t *testing.T, vk *SolanaVerifyingKey, proof *SolanaProof, inputs [][SolanaScalarSize]byte) bool {
+	require.LessOrEqual(t, len(proof.Commitments), 1, "folded commitment proofs are not supported")
+	require.Len(t, inputs, vk.NbPublicInputs())
+
+	var prepared curve.G1Jac
+	ic := decodeG1(t, vk.IC[0])
+	prepared.FromAffine(&ic)
+	for i := range inputs {
+		var e fr.Element
+		if err := e.SetBytesCanonical(inputs[i][:]); err != nil {
+			return false
+		}
+		ic := decodeG1(t, vk.IC[i+1])
+		var term curve.G1Affine
+		term.ScalarMultiplication(&ic, e.BigInt(new(big.Int)))
+		prepared.AddMixed(&term)
+	}
+	for i := range proof.Commitments {
+		commitment := decodeG1(t, proof.Commitments[i])
+		prepared.AddMixed(&commitment)
+
+		ok, err := curve.PairingCheck(
+			[]curve.G1Affine{commitment, decodeG1(t, proof.CommitmentPok)},
+			[]curve.G2Affine{decodeG2(t, vk.CommitmentKeys[i].GSigmaNeg), decodeG2(t, vk.CommitmentKeys[i].G)},
+		)
+		require.NoError(t, err)
+		if !ok {
+			return false
+		}
+	}
+	var preparedAff curve.G1Affine
+	preparedAff.FromJacobian(&prepared)
+
+	ok, err := curve.PairingCheck(
+		[]curve.G1Affine{decodeG1(t, proof.A), preparedAff, decodeG1(t, proof.C), decodeG1(t, vk.Alpha)},
+		[]curve.G2Affine{decodeG2(t, proof.B), decodeG2(t, vk.Gamma), decodeG2(t, vk.Delta), decodeG2(t, vk.Beta)},
+	)
+	require.NoError(t, err)
+	return ok
+}
+
+func decodeSolanaProof(t *testing.T, sp *SolanaProof) groth16.Proof {
+	var proof groth16bn254.Proof
+	negA := decodeG1(t, sp.A)
+	proof.Ar.Neg(&negA)
+	proof.Bs = decodeG2(t, sp.B)
+	proof.Krs = decodeG1(t, sp.C)
+	for i := range sp.Commitments {
+		proof.Commitments = append(proof.Commitments, decodeG1(t, sp.Commitments[i]))
+	}
+	if len(sp.Commitments) > 0 {
+		proof.CommitmentPok = decodeG1(t, sp.CommitmentPok)
+	}
+	return &proof
+}
+
+func decodeG1(t *testing.T, b [SolanaG1Size]byte) (p curve.G1Affine) {
+	require.NoError(t, p.X.SetBytesCanonical(b[:32]))
+	require.NoError(t, p.Y.SetBytesCanonical(b[32:]))
+	require.True(t, p.IsOnCurve())
+	return p
+}
+
+func decodeG2(t *testing.T, b [SolanaG2Size]byte) (p curve.G2Affine) {
+	require.NoError(t, p.X.A1.SetBytesCanonical(b[:32]))
+	require.NoError(t, p.X.A0.SetBytesCanonical(b[32:64]))
+	require.NoError(t, p.Y.A1.SetBytesCanonical(b[64:96]))
+	require.NoError(t, p.Y.A0.SetBytesCanonical(b[96:]))
+	require.True(t, p.IsOnCurve())
+	return p
+}
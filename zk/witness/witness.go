@@ -0,0 +1,197 @@
+// Package witness builds the witness of the WebAuthn assertion circuit, and of
+// the membership circuit for anonymous logins, from the credential and the
+// assertion verified by go-webauthn, and the intent the challenge was derived
+// for.
+package witness
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	"github.com/consensys/gnark/backend/witness"
+	"github.com/consensys/gnark/frontend"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/olawolu/zk-pass/zk"
+)
+
+// maxSignatureLen is the size of the longest DER encoded ES256 signature.
+const maxSignatureLen = 72
+
+var (
+	// ErrMissingInput is returned when the credential, the assertion or the
+	// challenge opening is nil.
+	ErrMissingInput = errors.New("witness: missing input")
+
+	// ErrOversizedInput is returned when an input is larger than the
+	// assertion circuit supports.
+	ErrOversizedInput = errors.New("witness: input too large")
+
+	// ErrMalformedInput is returned when an input can't be encoded in the
+	// assertion circuit.
+	ErrMalformedInput = errors.New("witness: malformed input")
+
+	// ErrIntentMismatch is returned when the assertion does not sign the
+	// challenge derived for the intent, or the assertion is not made with
+	// the credential.
+	ErrIntentMismatch = errors.New("witness: assertion does not match intent")
+)
+
+// Intent is the transaction intent a login challenge was derived for, as
+// described by zk.ChallengeCircuit.
+type Intent struct {
+	// Hash is the hash the challenge was derived with.
+	Hash zk.Hash
+
+	IntentHash []byte
+	Nonce      uint64
+
+	// Commitment is the commitment to the server's randomness the challenge
+	// was derived from, in 32 big-endian bytes.
+	Commitment []byte
+
+	// Challenge opens the Pedersen commitment Cc to the challenge.
+	Challenge *zk.Opening
+}
+
+// Witness is the witness of the assertion circuit for a login.
+type Witness struct {
+	Assignment *zk.AssertionCircuit
+	Full       witness.Witness
+	Public     witness.Witness
+
+	// SignatureOpening opens the Pedersen commitment Cσ to the signature,
+	// which is a public input.
+	SignatureOpening *zk.Opening
+
+	// Nullifier is the nullifier of the intent exposed by the proof.
+	Nullifier fr.Element
+}
+
+// New returns the witness of the assertion circuit for an assertion made with
+// the credential, signing the challenge derived for the intent, from one of
+// the allowed origins. The circuit hashes with the hash the challenge was
+// derived with, and the nullifier is derived from the credential's secret
+// and the intent. The assertion is expected to have been verified, e.g. by
+// webauthn.ValidateLogin. The error wraps ErrMissingInput, ErrOversizedInput,
+// ErrMalformedInput or ErrIntentMismatch.
+func New(credential *webauthn.Credential, secret fr.Element, assertion *protocol.ParsedCredentialAssertionData, intent Intent, origins []string) (*Witness, error) {
+	if credential == nil || assertion == nil || intent.Challenge == nil {
+		return nil, ErrMissingInput
+	}
+	response := assertion.Raw.AssertionResponse
+	for _, input := range []struct {
+		name string
+		len  int
+		max  int
+	}{
+		{"authenticatorData", len(response.AuthenticatorData), zk.AuthenticatorDataLen},
+		{"clientDataJSON", len(response.ClientDataJSON), zk.MaxClientDataJSONLen},
+		{"signature", len(response.Signature), maxSignatureLen},
+		{"allowed origins", len(origins), zk.MaxAllowedOrigins},
+	} {
+		if input.len > input.max {
+			return nil, fmt.Errorf("%w: %s has length %d, at most %d is supported", ErrOversizedInput, input.name, input.len, input.max)
+		}
+	}
+
+	if !bytes.Equal(credential.ID, assertion.RawID) {
+		return nil, fmt.Errorf("%w: assertion is made with credential %s, not %s", ErrIntentMismatch,
+			base64.RawURLEncoding.EncodeToString(assertion.RawID), base64.RawURLEncoding.EncodeToString(credential.ID))
+	}
+	challenge, err := zk.DeriveChallenge(intent.Hash, intent.Commitment, intent.IntentHash, intent.Nonce)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrMalformedInput, err)
+	}
+	if intent.Challenge.Value().Cmp(challenge.BigInt(new(big.Int))) != 0 {
+		return nil, fmt.Errorf("%w: challenge opening is not the challenge derived for the intent", ErrIntentMismatch)
+	}
+	challengeBytes := challenge.Bytes()
+	if assertion.Response.CollectedClientData.Challenge != base64.RawURLEncoding.EncodeToString(challengeBytes[:]) {
+		return nil, fmt.Errorf("%w: assertion signs another challenge", ErrIntentMismatch)
+	}
+
+	assignment, signatureOpening, err := zk.NewAssertionAssignment(
+		intent.Hash,
+		credential.PublicKey,
+		secret,
+		response.AuthenticatorData,
+		response.ClientDataJSON,
+		response.Signature,
+		intent.Challenge,
+		origins,
+		intent.IntentHash,
+		intent.Nonce,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrMalformedInput, err)
+	}
+	nullifier, ok := assignment.Nullifier.(fr.Element)
+	if !ok {
+		return nil, fmt.Errorf("%w: nullifier is not a field element", ErrMalformedInput)
+	}
+	full, err := frontend.NewWitness(assignment, ecc.BN254.ScalarField())
+	if err != nil {
+		return nil, fmt.Errorf("error building witness: %w", err)
+	}
+	public, err := full.Public()
+	if err != nil {
+		return nil, fmt.Errorf("error extracting public witness: %w", err)
+	}
+	return &Witness{
+		Assignment:       assignment,
+		Full:             full,
+		Public:           public,
+		SignatureOpening: signatureOpening,
+		Nullifier:        nullifier,
+	}, nil
+}
+
+// MembershipWitness is the witness of the membership circuit for a login.
+type MembershipWitness struct {
+	Assignment *zk.MembershipCircuit
+	Full       witness.Witness
+	Public     witness.Witness
+
+	// Root is the root of the tree of credentials the proof is for.
+	Root fr.Element
+}
+
+// Membership returns the witness of the membership circuit for the login, so
+// that the proof does not disclose the credential: it proves instead that the
+// credential is the leaf of the Merkle proof, which must be of the tree built
+// with the hash of the intent. The error wraps
+// ErrMissingInput, or ErrMalformedInput if the Merkle proof is not for the
+// credential.
+func (w *Witness) Membership(credential *webauthn.Credential, proof *zk.MerkleProof) (*MembershipWitness, error) {
+	if credential == nil || proof == nil {
+		return nil, ErrMissingInput
+	}
+	assignment, err := zk.NewMembershipAssignment(credential.PublicKey, w.Assignment, proof)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrMalformedInput, err)
+	}
+	full, err := frontend.NewWitness(assignment, ecc.BN254.ScalarField())
+	if err != nil {
+		return nil, fmt.Errorf("error building witness: %w", err)
+	}
+	public, err := full.Public()
+	if err != nil {
+		return nil, fmt.Errorf("error extracting public witness: %w", err)
+	}
+	root, err := proof.Root()
+	if err != nil {
+		return nil, err
+	}
+	return &MembershipWitness{
+		Assignment: assignment,
+		Full:       full,
+		Public:     public,
+		Root:       root,
+	}, nil
+}
@@ -0,0 +1,240 @@
+package witness
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark/test"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/protocol/webauthncbor"
+	"github.com/go-webauthn/webauthn/protocol/webauthncose"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/olawolu/zk-pass/zk"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const origin = "http://localhost:8080"
+
+func TestNew(t *testing.T) {
+	privKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	credential := newCredential(t, privKey)
+	secret, err := zk.NewCredentialSecret()
+	require.NoError(t, err)
+	intentHash := sha256.Sum256([]byte("transfer 1 SOL"))
+	challenge, err := zk.NewChallenge(zk.HashMiMC, intentHash[:], 7)
+	require.NoError(t, err)
+	commitment := challenge.Commitment.Bytes()
+	intent := Intent{
+		Hash:       zk.HashMiMC,
+		IntentHash: intentHash[:],
+		Nonce:      7,
+		Commitment: commitment[:],
+		Challenge:  challenge.Opening,
+	}
+	clientDataJSON := fmt.Sprintf(`{"type":"webauthn.get","challenge":"%s","origin":"%s","crossOrigin":false}`,
+		base64.RawURLEncoding.EncodeToString(challenge.Bytes()), origin)
+	assertion := newAssertion(t, privKey, credential.ID, clientDataJSON)
+
+	t.Run("valid assertion", func(t *testing.T) {
+		w, err := New(credential, secret, assertion, intent, []string{origin})
+		require.NoError(t, err)
+		assert.NoError(t, test.IsSolved(zk.NewAssertionCircuit(zk.HashMiMC), w.Assignment, ecc.BN254.ScalarField()))
+
+		public, err := w.Full.Public()
+		require.NoError(t, err)
+		assert.Equal(t, public.Vector(), w.Public.Vector())
+		assert.Equal(t, w.SignatureOpening.Assignment(), w.Assignment.SignatureCommitment)
+
+		nullifier, err := zk.Nullifier(zk.HashMiMC, secret, intentHash[:], 7)
+		require.NoError(t, err)
+		assert.Equal(t, nullifier, w.Nullifier)
+		assert.Equal(t, nullifier, w.Assignment.Nullifier)
+
+		// the nullifier is derived from the secret, not the public key
+		other, err := zk.NewCredentialSecret()
+		require.NoError(t, err)
+		w, err = New(credential, other, assertion, intent, []string{origin})
+		require.NoError(t, err)
+		assert.NotEqual(t, nullifier, w.Nullifier)
+	})
+
+	t.Run("poseidon2 challenge", func(t *testing.T) {
+		challenge, err := zk.NewChallenge(zk.HashPoseidon2, intentHash[:], 7)
+		require.NoError(t, err)
+		commitment := challenge.Commitment.Bytes()
+		intent := Intent{
+			Hash:       zk.HashPoseidon2,
+			IntentHash: intentHash[:],
+			Nonce:      7,
+			Commitment: commitment[:],
+			Challenge:  challenge.Opening,
+		}
+		clientDataJSON := fmt.Sprintf(`{"type":"webauthn.get","challenge":"%s","origin":"%s"}`,
+			base64.RawURLEncoding.EncodeToString(challenge.Bytes()), origin)
+		assertion := newAssertion(t, privKey, credential.ID, clientDataJSON)
+		w, err := New(credential, secret, assertion, intent, []string{origin})
+		require.NoError(t, err)
+		// the circuit hashes as the challenge was derived
+		assert.NoError(t, test.IsSolved(zk.NewAssertionCircuit(zk.HashPoseidon2), w.Assignment, ecc.BN254.ScalarField()))
+		nullifier, err := zk.Nullifier(zk.HashPoseidon2, secret, intentHash[:], 7)
+		require.NoError(t, err)
+		assert.Equal(t, nullifier, w.Nullifier)
+
+		// the challenge is derived with the hash of the intent
+		intent.Hash = zk.HashMiMC
+		_, err = New(credential, secret, assertion, intent, []string{origin})
+		assert.ErrorIs(t, err, ErrIntentMismatch)
+	})
+
+	t.Run("membership", func(t *testing.T) {
+		w, err := New(credential, secret, assertion, intent, []string{origin})
+		require.NoError(t, err)
+		publicKey, err := zk.ParseCredentialPublicKey(credential.PublicKey)
+		require.NoError(t, err)
+		leaf, err := zk.CredentialLeaf(zk.HashMiMC, publicKey, secret)
+		require.NoError(t, err)
+		tree := zk.NewMerkleTree(zk.HashMiMC, zk.NewMemoryMerkleStore())
+		root, err := tree.SetLeaf(3, leaf)
+		require.NoError(t, err)
+		proof, err := tree.Proof(3)
+		require.NoError(t, err)
+
+		m, err := w.Membership(credential, proof)
+		require.NoError(t, err)
+		assert.Equal(t, root, m.Root)
+		assert.NoError(t, test.IsSolved(zk.NewMembershipCircuit(zk.HashMiMC), m.Assignment, ecc.BN254.ScalarField()))
+
+		proof, err = tree.Proof(2)
+		require.NoError(t, err)
+		_, err = w.Membership(credential, proof)
+		assert.ErrorIs(t, err, ErrMalformedInput)
+		assert.ErrorIs(t, err, zk.ErrInvalidMerkleProof)
+		_, err = w.Membership(credential, nil)
+		assert.ErrorIs(t, err, ErrMissingInput)
+
+		// the leaf commits to the credential's secret
+		other, err := zk.NewCredentialSecret()
+		require.NoError(t, err)
+		leaf, err = zk.CredentialLeaf(zk.HashMiMC, publicKey, other)
+		require.NoError(t, err)
+		_, err = tree.SetLeaf(3, leaf)
+		require.NoError(t, err)
+		proof, err = tree.Proof(3)
+		require.NoError(t, err)
+		_, err = w.Membership(credential, proof)
+		assert.ErrorIs(t, err, zk.ErrInvalidMerkleProof)
+	})
+
+	t.Run("missing input", func(t *testing.T) {
+		_, err := New(nil, secret, assertion, intent, []string{origin})
+		assert.ErrorIs(t, err, ErrMissingInput)
+		_, err = New(credential, secret, assertion, Intent{}, []string{origin})
+		assert.ErrorIs(t, err, ErrMissingInput)
+	})
+
+	t.Run("oversized input", func(t *testing.T) {
+		long := strings.TrimSuffix(clientDataJSON, "}") + `,"extra":"` + strings.Repeat("a", zk.MaxClientDataJSONLen) + `"}`
+		_, err := New(credential, secret, newAssertion(t, privKey, credential.ID, long), intent, []string{origin})
+		assert.ErrorIs(t, err, ErrOversizedInput)
+
+		_, err = New(credential, secret, assertion, intent, make([]string, zk.MaxAllowedOrigins+1))
+		assert.ErrorIs(t, err, ErrOversizedInput)
+	})
+
+	t.Run("malformed input", func(t *testing.T) {
+		other := *credential
+		other.PublicKey = []byte{0xa0}
+		_, err := New(&other, secret, assertion, intent, []string{origin})
+		assert.ErrorIs(t, err, ErrMalformedInput)
+		assert.ErrorIs(t, err, zk.ErrUnsupportedKey)
+
+		_, err = New(credential, secret, assertion, intent, []string{"https://example.com"})
+		assert.ErrorIs(t, err, ErrMalformedInput)
+		assert.ErrorIs(t, err, zk.ErrInvalidOrigin)
+
+		tampered := intent
+		tampered.IntentHash = intentHash[:16]
+		_, err = New(credential, secret, assertion, tampered, []string{origin})
+		assert.ErrorIs(t, err, ErrMalformedInput)
+
+		tampered = intent
+		tampered.Hash = ""
+		_, err = New(credential, secret, assertion, tampered, []string{origin})
+		assert.ErrorIs(t, err, ErrMalformedInput)
+		assert.ErrorIs(t, err, zk.ErrUnknownHash)
+	})
+
+	t.Run("intent mismatch", func(t *testing.T) {
+		tampered := intent
+		tampered.Nonce = 8
+		_, err := New(credential, secret, assertion, tampered, []string{origin})
+		assert.ErrorIs(t, err, ErrIntentMismatch)
+
+		other := *credential
+		other.ID = []byte("another credential")
+		_, err = New(&other, secret, assertion, intent, []string{origin})
+		assert.ErrorIs(t, err, ErrIntentMismatch)
+
+		otherChallenge, err := zk.NewChallenge(zk.HashMiMC, intentHash[:], 7)
+		require.NoError(t, err)
+		signed := strings.Replace(clientDataJSON,
+			base64.RawURLEncoding.EncodeToString(challenge.Bytes()),
+			base64.RawURLEncoding.EncodeToString(otherChallenge.Bytes()), 1)
+		_, err = New(credential, secret, newAssertion(t, privKey, credential.ID, signed), intent, []string{origin})
+		assert.ErrorIs(t, err, ErrIntentMismatch)
+	})
+}
+
+// newCredential returns the credential of an ES256 key as stored at
+// registration.
+func newCredential(t *testing.T, privKey *ecdsa.PrivateKey) *webauthn.Credential {
+	publicKey, err := webauthncbor.Marshal(webauthncose.EC2PublicKeyData{
+		PublicKeyData: webauthncose.PublicKeyData{
+			KeyType:   int64(webauthncose.EllipticKey),
+			Algorithm: int64(webauthncose.AlgES256),
+		},
+		Curve:  int64(webauthncose.P256),
+		XCoord: privKey.X.FillBytes(make([]byte, 32)),
+		YCoord: privKey.Y.FillBytes(make([]byte, 32)),
+	})
+	require.NoError(t, err)
+	return &webauthn.Credential{ID: []byte("credential"), PublicKey: publicKey}
+}
+
+// newAssertion signs the client data as an authenticator would and parses the
+// response as the server does.
+func newAssertion(t *testing.T, privKey *ecdsa.PrivateKey, credentialID []byte, clientDataJSON string) *protocol.ParsedCredentialAssertionData {
+	rpIDHash := sha256.Sum256([]byte("localhost"))
+	authData := append(rpIDHash[:], 0x05, 0, 0, 0, 1) // user present and verified, sign count 1
+	clientDataHash := sha256.Sum256([]byte(clientDataJSON))
+	msg := sha256.Sum256(append(bytes.Clone(authData), clientDataHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, privKey, msg[:])
+	require.NoError(t, err)
+
+	encode := base64.RawURLEncoding.EncodeToString
+	body, err := json.Marshal(map[string]any{
+		"id":    encode(credentialID),
+		"rawId": encode(credentialID),
+		"type":  "public-key",
+		"response": map[string]string{
+			"clientDataJSON":    encode([]byte(clientDataJSON)),
+			"authenticatorData": encode(authData),
+			"signature":         encode(signature),
+		},
+	})
+	require.NoError(t, err)
+	assertion, err := protocol.ParseCredentialRequestResponseBody(bytes.NewReader(body))
+	require.NoError(t, err)
+	return assertion
+}